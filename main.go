@@ -2,28 +2,629 @@ package main
 
 import (
 	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/astjson"
+	"github.com/petelliott/logiko/phdl/bdd"
+	"github.com/petelliott/logiko/phdl/blif"
+	"github.com/petelliott/logiko/phdl/bmc"
 	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/dot"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/equiv"
+	"github.com/petelliott/logiko/phdl/format"
+	"github.com/petelliott/logiko/phdl/logisim"
+	"github.com/petelliott/logiko/phdl/lsp"
+	"github.com/petelliott/logiko/phdl/repl"
+	"github.com/petelliott/logiko/phdl/schematic"
+	"github.com/petelliott/logiko/phdl/tui"
+	"github.com/petelliott/logiko/phdl/yosys"
+	"github.com/petelliott/logiko/phdl/verilog"
+	"github.com/petelliott/logiko/phdl/vhdl"
+	"github.com/petelliott/logiko/phdl/web"
 	"os"
 	"fmt"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"bytes"
+	"sort"
+	"strings"
+	"strconv"
 )
 
-func main() {
-	ptree := &phdl.File{}
-	err := phdl.Parser.Parse(os.Stdin, ptree)
+func writeDot(ast *phdl.AstFile, args []string) error {
+	if len(args) == 0 {
+		return dot.WriteFile(os.Stdout, ast)
+	}
+
+	block, ok := ast.Blocks[args[0]]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", args[0])
+	}
+	opts := dot.Options{}
+	if len(args) > 1 {
+		depth, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid depth '%s'", args[1])
+		}
+		opts.Depth = depth
+	}
+	return opts.WriteBlock(os.Stdout, block)
+}
+
+func writeSchematic(ast *phdl.AstFile, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("svg: expected block name")
+	}
+	block, ok := ast.Blocks[args[0]]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", args[0])
+	}
+	return schematic.WriteBlock(os.Stdout, block)
+}
+
+func writeBlif(ast *phdl.AstFile, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("blif: expected block name")
+	}
+	block, ok := ast.Blocks[args[0]]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", args[0])
+	}
+	return blif.Write(os.Stdout, block)
+}
+
+// exhaust tests a block for every combination of its args against another
+// block or a golden file, or records a golden file of it
+func exhaust(ast *phdl.AstFile, args []string) error {
+	flags := flag.NewFlagSet("exhaust", flag.ContinueOnError)
+	opts := elab.ExhaustiveOptions{}
+	flags.IntVar(&opts.MaxInputWidth, "max", elab.DefaultMaxInputWidth, "widest total input tested")
+	flags.IntVar(&opts.Limit, "n", 10, "mismatches reported before stopping, or all if 0")
+	golden := flags.String("golden", "", "golden file to compare against")
+	record := flags.String("record", "", "golden file to record the block to")
+	err := flags.Parse(args)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	} else if flags.NArg() == 0 {
+		return fmt.Errorf("exhaust: expected block name")
 	}
-	ast, err := phdl.CompileFile(ptree)
+
+	block, ok := ast.Blocks[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", flags.Arg(0))
+	}
+
+	if *record != "" {
+		file, err := os.Create(*record)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return opts.WriteGolden(file, block)
+	}
+
+	var ref elab.Reference
+	if *golden != "" && flags.NArg() == 1 {
+		file, err := os.Open(*golden)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		ref, err = elab.ReadGolden(file)
+		if err != nil {
+			return err
+		}
+	} else if *golden == "" && flags.NArg() == 2 {
+		refBlock, ok := ast.Blocks[flags.Arg(1)]
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", flags.Arg(1))
+		}
+		ref, err = elab.BlockReference(block, refBlock)
+		if err != nil {
+			return err
+		}
+	} else {
+		return fmt.Errorf("exhaust: expected a reference block or -golden file")
+	}
+
+	mismatches, err := opts.Exhaustive(block, ref)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	}
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+	if opts.Limit != 0 && len(mismatches) == opts.Limit {
+		return fmt.Errorf("stopped after %v mismatches", len(mismatches))
+	} else if len(mismatches) != 0 {
+		return fmt.Errorf("%v mismatches", len(mismatches))
+	}
+	return nil
+}
+
+// checkEquiv proves that two blocks are equivalent, or prints a
+// counterexample
+func checkEquiv(ast *phdl.AstFile, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("equiv: expected two block names")
+	}
+	blocks := make([]*phdl.AstBlock, 2)
+	for idx, name := range args {
+		block, ok := ast.Blocks[name]
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", name)
+		}
+		blocks[idx] = block
+	}
+
+	ce, err := equiv.Check(blocks[0], blocks[1])
+	if err != nil {
+		return err
+	} else if ce != nil {
+		fmt.Println(ce)
+		return fmt.Errorf("'%s' and '%s' are not equivalent", args[0], args[1])
+	}
+	fmt.Printf("'%s' and '%s' are equivalent\n", args[0], args[1])
+	return nil
+}
+
+// compareBDD compares two blocks by their BDDs, printing a counterexample
+// and the number of args for which they differ
+func compareBDD(ast *phdl.AstFile, args []string) error {
+	flags := flag.NewFlagSet("bddequiv", flag.ContinueOnError)
+	order := flags.String("order", "auto", "variable order: auto, interleaved, dfs or declared")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	} else if flags.NArg() != 2 {
+		return fmt.Errorf("bddequiv: expected two block names")
+	}
+	blocks := make([]*phdl.AstBlock, 2)
+	for idx, name := range flags.Args() {
+		block, ok := ast.Blocks[name]
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", name)
+		}
+		blocks[idx] = block
+	}
+
+	ce, count, err := bdd.Compare(blocks[0], blocks[1], *order)
+	if err != nil {
+		return err
+	} else if ce != nil {
+		fmt.Println(ce)
+		return fmt.Errorf("'%s' and '%s' differ for %v args", blocks[0].Name, blocks[1].Name, count)
+	}
+	fmt.Printf("'%s' and '%s' are equivalent\n", blocks[0].Name, blocks[1].Name)
+	return nil
+}
+
+// writeSOP prints the rets of a block as minimized sums of products
+func writeSOP(ast *phdl.AstFile, args []string) error {
+	flags := flag.NewFlagSet("sop", flag.ContinueOnError)
+	order := flags.String("order", "auto", "variable order: auto, interleaved, dfs or declared")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	} else if flags.NArg() == 0 || flags.NArg() > 2 {
+		return fmt.Errorf("sop: expected block name and optional ret")
+	}
+	block, ok := ast.Blocks[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", flags.Arg(0))
+	}
+
+	f, err := bdd.Build(*order, block)
+	if err != nil {
+		return err
+	}
+	return f.WriteSOP(os.Stdout, flags.Arg(1))
+}
+
+// runTests runs the named tests, or every test, printing their failures
+func runTests(ast *phdl.AstFile, args []string) error {
+	names := args
+	if len(names) == 0 {
+		for name := range ast.Tests {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	failed := 0
+	for _, name := range names {
+		test, ok := ast.Tests[name]
+		if !ok {
+			return fmt.Errorf("test '%s' not defined", name)
+		}
+		_, failures, err := elab.RunTest(test)
+		if err != nil {
+			return fmt.Errorf("test '%s': %s", name, err)
+		}
+		for _, failure := range failures {
+			fmt.Printf("test '%s': %s\n", name, failure.Error())
+		}
+		if len(failures) != 0 {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%v of %v tests failed", failed, len(names))
+	}
+	fmt.Printf("%v tests passed\n", len(names))
+	return nil
+}
+
+// checkAsserts model checks the asserts of a block for k cycles, writing a
+// failing trace as a VCD and a test if asked to
+func checkAsserts(ast *phdl.AstFile, args []string) error {
+	flags := flag.NewFlagSet("bmc", flag.ContinueOnError)
+	k := flags.Int("k", 20, "cycles checked from reset")
+	vcd := flags.String("vcd", "", "file to write a failing trace to as a VCD")
+	test := flags.String("test", "", "file to write a failing trace to as a test")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	} else if flags.NArg() != 1 {
+		return fmt.Errorf("bmc: expected block name")
+	}
+	block, ok := ast.Blocks[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("block '%s' not defined", flags.Arg(0))
+	}
+
+	trace, err := bmc.Check(block, *k)
+	if err != nil {
+		return err
+	} else if trace == nil {
+		fmt.Printf("the asserts of '%s' hold for %v cycles\n", block.Name, *k)
+		return nil
+	}
+
+	for _, failure := range trace.Failed {
+		fmt.Printf("%s in cycle %v\n", failure.Error(), trace.Cycles()-1)
+	}
+	for cycle, values := range trace.Args {
+		args := make([]string, len(values))
+		for idx, value := range values {
+			args[idx] = fmt.Sprintf("%s=%s", block.Args[idx].Name,
+				block.Args[idx].Format(int64(value)))
+		}
+		fmt.Printf("cycle %v: %s\n", cycle, strings.Join(args, " "))
+	}
+
+	if *vcd != "" {
+		file, err := os.Create(*vcd)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		err = trace.WriteVCD(file)
+		if err != nil {
+			return err
+		}
+	}
+	if *test != "" {
+		file, err := os.Create(*test)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		err = trace.WriteTest(file, block.Name+"_bmc")
+		if err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("an assert of '%s' fails after %v cycles", block.Name, trace.Cycles())
+}
+
+// formatFiles formats PHDL files, or stdin if there are none. It reports
+// whether it listed any files that were not formatted.
+func formatFiles(args []string) (bool, error) {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	list := flags.Bool("l", false, "list files that are not formatted")
+	write := flags.Bool("w", false, "write formatted source to the files")
+	err := flags.Parse(args)
+	if err != nil {
+		return false, err
+	}
+
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return false, err
+		}
+		out, err := format.Source(src)
+		if err != nil {
+			return false, err
+		}
+		_, err = os.Stdout.Write(out)
+		return false, err
+	}
+
+	listed := false
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return false, err
+		}
+		out, err := format.Source(src)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", name, err)
+		}
+
+		changed := !bytes.Equal(src, out)
+		if *list && changed {
+			fmt.Println(name)
+			listed = true
+		}
+		if *write && changed {
+			info, err := os.Stat(name)
+			if err != nil {
+				return false, err
+			}
+			err = ioutil.WriteFile(name, out, info.Mode())
+			if err != nil {
+				return false, err
+			}
+		}
+		if !*list && !*write {
+			os.Stdout.Write(out)
+		}
+	}
+	return listed, nil
+}
+
+// openBlock compiles a file, and gets a block of it
+func openBlock(path, name string) (*phdl.AstBlock, error) {
+	ast, err := checks.CompilePath(path)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := ast.Blocks[name]
+	if !ok {
+		return nil, fmt.Errorf("block '%s' not defined", name)
+	}
+	return block, nil
+}
+
+// simulate runs a simulation REPL of a block of a file
+func simulate(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("sim: expected file and block name")
+	}
+	block, err := openBlock(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return repl.Run(os.Stdin, os.Stdout, block)
+}
+
+// simulateTUI runs a terminal UI simulation of a block of a file, showing
+// any other signals given
+func simulateTUI(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("tui: expected file and block name")
+	}
+	block, err := openBlock(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return tui.Run(block, args[2:])
+}
+
+// serve serves the web simulator of a file until it is interrupted
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	} else if flags.NArg() != 1 {
+		return fmt.Errorf("serve: expected file")
+	}
+
+	_, err = os.Stat(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving %s on http://%s\n", flags.Arg(0), *addr)
+	return http.ListenAndServe(*addr, web.Handler(flags.Arg(0)))
+}
+
+// importers read designs in other formats, by the name of the format
+var importers = map[string]func(io.Reader) (*phdl.AstFile, error){
+	"verilog": verilog.Import,
+	"yosys":   yosys.Import,
+	"blif":    blif.Import,
+	"logisim": logisim.Import,
+}
+
+// importFile reads a design in another format from stdin, and writes it as
+// PHDL source
+func importFile(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("import: expected format")
+	}
+	imp, ok := importers[args[0]]
+	if !ok {
+		return fmt.Errorf("import: unknown format '%s'", args[0])
+	}
+
+	ast, err := imp(os.Stdin)
+	if err != nil {
+		return err
 	}
 	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		return err
+	}
+	return format.FprintFile(os.Stdout, ast)
+}
+
+// usage: logiko [command] < file.phdl
+//        logiko fmt [-l] [-w] [file...]
+//        logiko lsp
+//        logiko sim file.phdl block
+//        logiko tui file.phdl block [signal...]
+//        logiko serve [-addr localhost:8080] file.phdl
+//        logiko import verilog < file.v
+//        logiko import yosys < netlist.json
+//        logiko import blif < file.blif
+//        logiko import logisim < file.circ
+//
+// with no command the compiled AST is printed. commands:
+//   verilog   write a verilog module for every block
+//   vhdl      write a VHDL entity for every block, and bench for every test
+//   dot [block [depth]]
+//             write a graphviz graph for every block, or one block with
+//             sub-blocks drawn inline to depth
+//   svg block write an SVG schematic of a block
+//   yosys [top]
+//             write a yosys JSON netlist of every block
+//   blif block
+//             write a flattened BLIF model of a block
+//   logisim [main]
+//             write a Logisim-evolution project with a circuit for every block
+//   json      write the compiled AST as JSON
+//   exhaust [-max 16] [-n 10] [-golden file | -record file] block [ref]
+//             test a combinational block for every combination of its args
+//             against the block ref or a golden file, reporting the first n
+//             mismatches, or record a golden file of the block
+//   equiv a b prove that two combinational blocks are equivalent, or print
+//             args for which they differ
+//   bddequiv [-order auto] a b
+//             compare two combinational blocks by their BDDs, printing args
+//             for which they differ and how many there are
+//   sop [-order auto] block [ret]
+//             print each bit of the rets of a combinational block as a
+//             minimized sum of products of the bits of its args
+//   test [name...]
+//             run the named tests, or every test, printing failing vectors
+//             and asserts
+//   bmc [-k 20] [-vcd file] [-test file] block
+//             search for args that make an assert of a block fail within k
+//             cycles of reset, printing the shortest failing trace, and
+//             writing it as a VCD or a test that reproduces it
+//
+// fmt formats files, or stdin, writing the result to stdout. -w rewrites the
+// files in place, and -l lists the files that are not formatted, exiting
+// with status 1 if there are any, to check formatting in CI.
+//
+// lsp runs a language server on stdin and stdout.
+//
+// sim simulates a block interactively, reading commands from stdin.
+//
+// tui simulates a block live in the terminal, showing its ports, the internal
+// signals given, such as half0.nc, and a waveform of recent cycles.
+//
+// serve serves a simulator of a file to the browser, with its schematics,
+// live waveforms, and test results.
+//
+// import reads a design in another format from stdin, and writes it as PHDL
+// source.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		listed, err := formatFiles(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		} else if listed {
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		err := lsp.Serve(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "sim" {
+		err := simulate(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "tui" {
+		err := simulateTUI(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "serve" {
+		err := serve(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	} else if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := importFile(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ast, err := checks.Compile(os.Stdin)
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Println(ast)
 		return
 	}
 
-	fmt.Println(ast)
+	switch os.Args[1] {
+	case "verilog":
+		err = verilog.WriteFile(os.Stdout, ast)
+	case "vhdl":
+		err = vhdl.WriteFile(os.Stdout, ast)
+	case "dot":
+		err = writeDot(ast, os.Args[2:])
+	case "svg":
+		err = writeSchematic(ast, os.Args[2:])
+	case "yosys":
+		top := ""
+		if len(os.Args) > 2 {
+			top = os.Args[2]
+		}
+		err = yosys.Write(os.Stdout, ast, top)
+	case "blif":
+		err = writeBlif(ast, os.Args[2:])
+	case "logisim":
+		main := ""
+		if len(os.Args) > 2 {
+			main = os.Args[2]
+		}
+		err = logisim.Write(os.Stdout, ast, main)
+	case "json":
+		err = astjson.Encode(os.Stdout, ast)
+	case "exhaust":
+		err = exhaust(ast, os.Args[2:])
+	case "equiv":
+		err = checkEquiv(ast, os.Args[2:])
+	case "bddequiv":
+		err = compareBDD(ast, os.Args[2:])
+	case "sop":
+		err = writeSOP(ast, os.Args[2:])
+	case "test":
+		err = runTests(ast, os.Args[2:])
+	case "bmc":
+		err = checkAsserts(ast, os.Args[2:])
+	default:
+		err = fmt.Errorf("unknown command '%s'", os.Args[1])
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
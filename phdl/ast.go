@@ -4,11 +4,14 @@ import (
 	"strconv"
 	"fmt"
 	"errors"
+	"math/bits"
 )
 
 type AstFile struct {
-	Blocks map[string]*AstBlock
-	Tests  map[string]*AstTest
+	Blocks  map[string]*AstBlock
+	Tests   map[string]*AstTest
+	Bundles map[string]*AstBundle
+	Enums   map[string]*AstEnum
 }
 
 func (af AstFile) String() string {
@@ -20,18 +23,16 @@ func (af AstFile) String() string {
 }
 
 func CompileFile(file *File) (*AstFile, error) {
-	astfile := &AstFile{make(map[string]*AstBlock), make(map[string]*AstTest)}
+	astfile := &AstFile{
+		Blocks:  make(map[string]*AstBlock),
+		Tests:   make(map[string]*AstTest),
+		Bundles: make(map[string]*AstBundle),
+		Enums:   make(map[string]*AstEnum),
+	}
 	var err error
 
 	for _, ablock := range file.Blocks {
-		if ablock.Block != nil {
-			astfile.Blocks[ablock.Block.Ident.Value], err = CompileBlock(astfile,
-				ablock.Block)
-		} else {
-			astfile.Tests[ablock.TestBlock.Ident.Value], err = CompileTestBlock(astfile,
-				ablock.TestBlock)
-		}
-
+		err = CompileAnyBlock(astfile, ablock)
 		if err != nil {
 			break
 		}
@@ -40,12 +41,66 @@ func CompileFile(file *File) (*AstFile, error) {
 	return astfile, err
 }
 
+// CompileAnyBlock compiles a top level declaration into astfile. It is not
+// added to astfile if it has an error.
+func CompileAnyBlock(astfile *AstFile, ablock *AnyBlock) error {
+	if ablock.Block != nil {
+		block, err := CompileBlock(astfile, ablock.Block)
+		if err != nil {
+			return err
+		}
+		astfile.Blocks[block.Name] = block
+	} else if ablock.Bundle != nil {
+		bundle, err := CompileBundle(astfile, ablock.Bundle)
+		if err != nil {
+			return err
+		}
+		astfile.Bundles[bundle.Name] = bundle
+	} else if ablock.Enum != nil {
+		enum, err := CompileEnum(astfile, ablock.Enum)
+		if err != nil {
+			return err
+		}
+		astfile.Enums[enum.Name] = enum
+	} else if ablock.Memory != nil {
+		block, err := CompileMemory(astfile, ablock.Memory)
+		if err != nil {
+			return err
+		}
+		astfile.Blocks[block.Name] = block
+	} else if ablock.TableBlock != nil {
+		block, err := CompileTable(astfile, ablock.TableBlock)
+		if err != nil {
+			return err
+		}
+		astfile.Blocks[block.Name] = block
+	} else if ablock.Extern != nil {
+		block, err := CompileExtern(astfile, ablock.Extern)
+		if err != nil {
+			return err
+		}
+		astfile.Blocks[block.Name] = block
+	} else {
+		test, err := CompileTestBlock(astfile, ablock.TestBlock)
+		if err != nil {
+			return err
+		}
+		astfile.Tests[test.Name] = test
+	}
+	return nil
+}
+
 type AstBlock struct {
 	Name   string
 	Args   []*AstConn
 	Rets   []*AstConn
 	Vars map[string]*AstConn
 	Stmts []*AstStmt
+	Asserts []*AstAssert
+	Mem   *AstMem // non-nil for memory primitives
+	Table *AstTable // non-nil for table blocks
+	Builtin bool
+	Extern  bool // implemented by a component registered with the simulator
 }
 
 func (ab AstBlock) String() string {
@@ -59,10 +114,65 @@ func (ab AstBlock) String() string {
 	)
 }
 
-func declToConn(d *Declaration) (*AstConn, error) {
-	width, err := strconv.Atoi(d.Type[1:])
-	conn := &AstConn{d.Ident.Value, width}
-	return conn, err
+// AssertNames names the asserts of a block by their order, e.g. assert1 for
+// the second
+func (ab AstBlock) AssertNames() []string {
+	names := make([]string, len(ab.Asserts))
+	for idx := range ab.Asserts {
+		names[idx] = fmt.Sprintf("assert%d", idx)
+	}
+	return names
+}
+
+// Sequential checks if a block contains a RAM or register, and so depends on
+// the clock
+func (ab AstBlock) Sequential() bool {
+	if ab.Mem != nil {
+		return ab.Mem.Kind != "rom"
+	}
+	for _, stmt := range ab.Stmts {
+		if stmt.Op.Sequential() {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceNames names the instance created by each statement, after its
+// block and the number of earlier statements using the same block, e.g. the
+// second use of 'adder' in a block is 'adder1'
+func (ab AstBlock) InstanceNames() []string {
+	names := make([]string, len(ab.Stmts))
+	count := make(map[string]int)
+	for idx, stmt := range ab.Stmts {
+		names[idx] = fmt.Sprintf("%s%d", stmt.Op.Name, count[stmt.Op.Name])
+		count[stmt.Op.Name]++
+	}
+	return names
+}
+
+func declToConn(astfile *AstFile, d *Declaration) (*AstConn, error) {
+	conn, err := resolveType(astfile, d.Type)
+	if err != nil {
+		return nil, err
+	}
+	conn.Name = d.Ident.Value
+	return conn, nil
+}
+
+// resolveType gets an unnamed conn with the width of a dN type, or of the
+// bundle or enum it names
+func resolveType(astfile *AstFile, typ string) (*AstConn, error) {
+	if width, err := strconv.Atoi(typ[1:]); typ[0] == 'd' && err == nil {
+		return &AstConn{Width: width}, nil
+	}
+
+	if bundle, ok := astfile.Bundles[typ]; ok {
+		return &AstConn{Width: bundle.Width, Bundle: bundle}, nil
+	} else if enum, ok := astfile.Enums[typ]; ok {
+		return &AstConn{Width: enum.Width, Enum: enum}, nil
+	}
+	return nil, fmt.Errorf("type '%s' not defined", typ)
 }
 
 func CompileBlock(astfile *AstFile, block *Block) (*AstBlock, error) {
@@ -72,10 +182,11 @@ func CompileBlock(astfile *AstFile, block *Block) (*AstBlock, error) {
 		Rets: make([]*AstConn, 0),
 		Vars: make(map[string]*AstConn, 0),
 		Stmts: make([]*AstStmt, 0),
+		Asserts: make([]*AstAssert, 0),
 	}
 
 	for _, arg := range block.Args {
-		conn, err := declToConn(arg)
+		conn, err := declToConn(astfile, arg)
 		if err != nil {
 			return nil, err
 		}
@@ -84,7 +195,7 @@ func CompileBlock(astfile *AstFile, block *Block) (*AstBlock, error) {
 	}
 
 	for _, ret := range block.Rets {
-		conn, err := declToConn(ret)
+		conn, err := declToConn(astfile, ret)
 		if err != nil {
 			return nil, err
 		}
@@ -100,12 +211,53 @@ func CompileBlock(astfile *AstFile, block *Block) (*AstBlock, error) {
 		ablock.Stmts = append(ablock.Stmts, astmt)
 	}
 
+	for _, assert := range block.Asserts {
+		aassert, err := CompileAssert(astfile, ablock, assert)
+		if err != nil {
+			return nil, err
+		}
+		ablock.Asserts = append(ablock.Asserts, aassert)
+	}
+
+	return ablock, nil
+}
+
+func CompileExtern(astfile *AstFile, extern *Extern) (*AstBlock, error) {
+	ablock := &AstBlock{
+		Name: extern.Ident.Value,
+		Args: make([]*AstConn, 0),
+		Rets: make([]*AstConn, 0),
+		Vars: make(map[string]*AstConn, 0),
+		Stmts: make([]*AstStmt, 0),
+		Extern: true,
+	}
+
+	for _, arg := range extern.Args {
+		conn, err := declToConn(astfile, arg)
+		if err != nil {
+			return nil, fmt.Errorf("CompileExtern: %s", err)
+		}
+		ablock.Vars[arg.Ident.Value] = conn
+		ablock.Args = append(ablock.Args, conn)
+	}
+
+	for _, ret := range extern.Rets {
+		conn, err := declToConn(astfile, ret)
+		if err != nil {
+			return nil, fmt.Errorf("CompileExtern: %s", err)
+		}
+		ablock.Vars[ret.Ident.Value] = conn
+		ablock.Rets = append(ablock.Rets, conn)
+	}
+
 	return ablock, nil
 }
 
 type AstConn struct {
-	Name  string
-	Width int
+	Name   string
+	Width  int
+	Bundle *AstBundle // nil indicates a plain dN conn
+	Enum   *AstEnum   // nil indicates a plain dN conn
 }
 
 func (ac AstConn) HasType() bool {
@@ -113,6 +265,21 @@ func (ac AstConn) HasType() bool {
 }
 
 func (ac AstConn) String() string {
+	if ac.Bundle != nil {
+		return fmt.Sprintf(
+			"(%v %v %v)",
+			ac.Name,
+			ac.Width,
+			ac.Bundle.Name,
+		)
+	} else if ac.Enum != nil {
+		return fmt.Sprintf(
+			"(%v %v %v)",
+			ac.Name,
+			ac.Width,
+			ac.Enum.Name,
+		)
+	}
 	return fmt.Sprintf(
 		"(%v %v)",
 		ac.Name,
@@ -120,6 +287,251 @@ func (ac AstConn) String() string {
 	)
 }
 
+// Format renders a value of the conn, using the symbolic name for values of
+// enum typed conns
+func (ac AstConn) Format(value int64) string {
+	if ac.Enum != nil {
+		if sym := ac.Enum.Symbol(value); sym != nil {
+			return sym.Name
+		}
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// AstBundle is a named group of fields, flattened into consecutive bit
+// ranges. The first field occupies the lowest bits.
+type AstBundle struct {
+	Name   string
+	Fields []*AstField
+	Width  int
+}
+
+func (ab AstBundle) String() string {
+	return fmt.Sprintf(
+		"(%v %v %v)",
+		ab.Name,
+		ab.Width,
+		ab.Fields,
+	)
+}
+
+// Field gets a field by name, or nil if it does not exist
+func (ab *AstBundle) Field(name string) *AstField {
+	for _, field := range ab.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+type AstField struct {
+	Name   string
+	Lo     int
+	Width  int
+	Bundle *AstBundle // nil unless the field is itself a bundle
+	Enum   *AstEnum   // nil unless the field is an enum
+}
+
+func (af AstField) String() string {
+	return fmt.Sprintf(
+		"(%v %v %v)",
+		af.Name,
+		af.Lo,
+		af.Width,
+	)
+}
+
+func CompileBundle(astfile *AstFile, bundle *Bundle) (*AstBundle, error) {
+	abundle := &AstBundle{
+		Name: bundle.Ident.Value,
+		Fields: make([]*AstField, 0),
+	}
+
+	for _, decl := range bundle.Fields {
+		if abundle.Field(decl.Ident.Value) != nil {
+			return nil, fmt.Errorf("CompileBundle: duplicate field '%s' in '%s'",
+				decl.Ident.Value, abundle.Name)
+		}
+
+		typ, err := resolveType(astfile, decl.Type)
+		if err != nil {
+			return nil, fmt.Errorf("CompileBundle: %s", err)
+		} else if typ.Width == 0 {
+			return nil, fmt.Errorf("CompileBundle: field '%s' of '%s' has no width",
+				decl.Ident.Value, abundle.Name)
+		}
+
+		abundle.Fields = append(abundle.Fields, &AstField{
+			Name: decl.Ident.Value,
+			Lo: abundle.Width,
+			Width: typ.Width,
+			Bundle: typ.Bundle,
+			Enum: typ.Enum,
+		})
+		abundle.Width += typ.Width
+	}
+
+	return abundle, nil
+}
+
+// AstEnum is a named set of symbolic values, and their encodings
+type AstEnum struct {
+	Name     string
+	Encoding string
+	Width    int
+	Values   []*AstEnumValue
+}
+
+func (ae AstEnum) String() string {
+	return fmt.Sprintf(
+		"(%v %v %v %v)",
+		ae.Name,
+		ae.Encoding,
+		ae.Width,
+		ae.Values,
+	)
+}
+
+// Value gets a value by name, or nil if it does not exist
+func (ae *AstEnum) Value(name string) *AstEnumValue {
+	for _, value := range ae.Values {
+		if value.Name == name {
+			return value
+		}
+	}
+	return nil
+}
+
+// Symbol gets the value with the encoding value, or nil if there is none
+func (ae *AstEnum) Symbol(value int64) *AstEnumValue {
+	for _, v := range ae.Values {
+		if v.Value == value {
+			return v
+		}
+	}
+	return nil
+}
+
+type AstEnumValue struct {
+	Name  string
+	Value int64
+	Enum  *AstEnum
+}
+
+func (aev AstEnumValue) String() string {
+	return fmt.Sprintf(
+		"(%v %v)",
+		aev.Name,
+		aev.Value,
+	)
+}
+
+// encodings maps the name of an enum encoding to the value of the ith symbol
+var encodings = map[string]func(i int64) int64{
+	"binary": func(i int64) int64 { return i },
+	"gray":   func(i int64) int64 { return i ^ (i >> 1) },
+	"onehot": func(i int64) int64 { return 1 << uint(i) },
+}
+
+func CompileEnum(astfile *AstFile, enum *Enum) (*AstEnum, error) {
+	aenum := &AstEnum{
+		Name: enum.Ident.Value,
+		Encoding: "binary",
+		Values: make([]*AstEnumValue, 0),
+	}
+
+	for _, value := range enum.Values {
+		if value.Value != "" {
+			aenum.Encoding = "explicit"
+		}
+	}
+
+	if enum.Encoding != nil {
+		if aenum.Encoding == "explicit" {
+			return nil, fmt.Errorf(
+				"CompileEnum: '%s' has both an encoding and explicit values",
+				aenum.Name)
+		} else if _, ok := encodings[enum.Encoding.Value]; !ok {
+			return nil, fmt.Errorf("CompileEnum: unknown encoding '%s'",
+				enum.Encoding.Value)
+		}
+		aenum.Encoding = enum.Encoding.Value
+	}
+
+	next := int64(0)
+	for i, value := range enum.Values {
+		if aenum.Value(value.Ident.Value) != nil {
+			return nil, fmt.Errorf("CompileEnum: duplicate value '%s' in '%s'",
+				value.Ident.Value, aenum.Name)
+		}
+
+		v := next
+		if aenum.Encoding != "explicit" {
+			v = encodings[aenum.Encoding](int64(i))
+		} else if value.Value != "" {
+			var err error
+			v, err = strconv.ParseInt(value.Value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("CompileEnum: %s", err)
+			} else if v < 0 {
+				return nil, fmt.Errorf("CompileEnum: value '%s' is negative",
+					value.Ident.Value)
+			}
+		}
+		next = v + 1
+
+		if aenum.Symbol(v) != nil {
+			return nil, fmt.Errorf(
+				"CompileEnum: '%s' and '%s' have the same encoding (%v)",
+				aenum.Symbol(v).Name, value.Ident.Value, v)
+		}
+
+		aenum.Values = append(aenum.Values, &AstEnumValue{
+			Name: value.Ident.Value,
+			Value: v,
+			Enum: aenum,
+		})
+		if l := bits.Len64(uint64(v)); l > aenum.Width {
+			aenum.Width = l
+		}
+	}
+
+	if aenum.Width == 0 {
+		aenum.Width = 1
+	}
+
+	if enum.Type != "" {
+		width, err := strconv.Atoi(enum.Type[1:])
+		if err != nil {
+			return nil, fmt.Errorf("CompileEnum: %s", err)
+		} else if width < aenum.Width {
+			return nil, fmt.Errorf("CompileEnum: values of '%s' do not fit in %s",
+				aenum.Name, enum.Type)
+		}
+		aenum.Width = width
+	}
+
+	return aenum, nil
+}
+
+// enumValue finds an enum value by its unqualified name. It is an error for
+// the name to belong to more than one enum.
+func (af *AstFile) enumValue(name string) (*AstEnumValue, error) {
+	var found *AstEnumValue
+	for _, enum := range af.Enums {
+		if v := enum.Value(name); v != nil {
+			if found != nil {
+				return nil, fmt.Errorf(
+					"'%s' is ambiguous between enums '%s' and '%s'",
+					name, found.Enum.Name, enum.Name)
+			}
+			found = v
+		}
+	}
+	return found, nil
+}
+
 type AstStmt struct {
 	Args []*AstExpr
 	Op   *AstBlock
@@ -136,7 +548,7 @@ func (as AstStmt) String() string {
 }
 
 func CompileStmt(astfile *AstFile, block *AstBlock, stmt *Statement) (*AstStmt, error) {
-	op, ok := astfile.Blocks[stmt.Ident.Value]
+	op, ok := astfile.lookupBlock(stmt.Ident.Value)
 	if !ok {
 		return nil, fmt.Errorf("block '%s' not defined", stmt.Ident.Value)
 	}
@@ -148,7 +560,7 @@ func CompileStmt(astfile *AstFile, block *AstBlock, stmt *Statement) (*AstStmt,
 	}
 
 	for _, arg := range stmt.Args {
-		expr, err := CompileExpr(block, arg)
+		expr, err := CompileExpr(astfile, block, arg)
 		if err != nil {
 			return nil, fmt.Errorf("CompileStmt: %s", err)
 		}
@@ -156,7 +568,7 @@ func CompileStmt(astfile *AstFile, block *AstBlock, stmt *Statement) (*AstStmt,
 	}
 
 	for _, ret := range stmt.Rets {
-		expr, err := CompileExpr(block, ret)
+		expr, err := CompileExpr(astfile, block, ret)
 		if err != nil {
 			return nil, fmt.Errorf("CompileStmt: %s", err)
 		}
@@ -166,19 +578,168 @@ func CompileStmt(astfile *AstFile, block *AstBlock, stmt *Statement) (*AstStmt,
 	return astmt, nil
 }
 
+// AstAssert is a property of a block: the d1 ret of Op, given Args, is 1 in
+// every cycle. Each arg is sampled Delays cycles earlier, so the assert is
+// only checked from the cycle of its longest delay.
+type AstAssert struct {
+	Args   []*AstExpr
+	Delays []int
+	Op     *AstBlock
+	Line   int
+}
+
+func (aa AstAssert) String() string {
+	return fmt.Sprintf(
+		"(assert %v %v %v)",
+		aa.Op.Name,
+		aa.Args,
+		aa.Delays,
+	)
+}
+
+// From gets the first cycle the assert is checked in
+func (aa AstAssert) From() int {
+	from := 0
+	for _, delay := range aa.Delays {
+		if delay > from {
+			from = delay
+		}
+	}
+	return from
+}
+
+func CompileAssert(astfile *AstFile, block *AstBlock, assert *Assert) (*AstAssert, error) {
+	op, ok := astfile.lookupBlock(assert.Ident.Value)
+	if !ok {
+		return nil, fmt.Errorf("block '%s' not defined", assert.Ident.Value)
+	}
+
+	aassert := &AstAssert{
+		Args: make([]*AstExpr, 0),
+		Delays: make([]int, 0),
+		Op: op,
+		Line: assert.Pos.Line,
+	}
+
+	for _, arg := range assert.Args {
+		expr, err := CompileExpr(astfile, block, arg.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("CompileAssert: %s", err)
+		}
+
+		delay := 0
+		if arg.Delay != "" {
+			delay, err = strconv.Atoi(arg.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("CompileAssert: %s", err)
+			} else if delay < 0 {
+				return nil, fmt.Errorf("CompileAssert: delay %v is negative", delay)
+			}
+		}
+		aassert.Args = append(aassert.Args, expr)
+		aassert.Delays = append(aassert.Delays, delay)
+	}
+
+	return aassert, nil
+}
+
 type AstExpr struct {
 	Literal int64
 	Conn    *AstConn // nil indicates Literal expr
 	Lo      int      // -1 indicates no index
 	Hi      int
+	Fields  []string // field accesses not yet flattened into Lo and Hi
+	Enum    *AstEnum // non-nil for symbolic literals
 }
 
 func (ae AstExpr) HasIndex() bool {
 	return ae.Lo != -1
 }
 
+// JoinBits gets a single expr for d1 exprs, least significant first, if they
+// are consecutive bits of one conn or all literals. Otherwise it gives nil.
+func JoinBits(exprs []*AstExpr) *AstExpr {
+	if len(exprs) == 0 {
+		return nil
+	} else if exprs[0].Conn == nil {
+		var value int64
+		for i, expr := range exprs {
+			if expr.Conn != nil || i >= 64 {
+				return nil
+			}
+			value |= expr.Literal << uint(i)
+		}
+		return &AstExpr{Literal: value, Lo: -1}
+	}
+
+	conn := exprs[0].Conn
+	lo := 0
+	if exprs[0].HasIndex() {
+		lo = exprs[0].Lo
+	}
+	for i, expr := range exprs {
+		if expr.Conn != conn || (expr.HasIndex() && expr.Lo != lo+i) ||
+			(!expr.HasIndex() && lo+i != 0) {
+			return nil
+		}
+	}
+	if lo == 0 && len(exprs) == conn.Width {
+		return &AstExpr{Conn: conn, Lo: -1}
+	}
+	return &AstExpr{Conn: conn, Lo: lo, Hi: lo + len(exprs) - 1}
+}
+
+// ResolveFields flattens the field accesses of an expr on a bundle typed conn
+// into a bit range of the conn. An index after the fields is relative to the
+// last field.
+func (ae *AstExpr) ResolveFields() error {
+	if len(ae.Fields) == 0 {
+		return nil
+	}
+
+	bundle := ae.Conn.Bundle
+	lo := 0
+	width := ae.Conn.Width
+	path := ae.Conn.Name
+	for _, name := range ae.Fields {
+		if bundle == nil {
+			return fmt.Errorf("'%s' (d%v) is not a bundle", path, width)
+		}
+		field := bundle.Field(name)
+		if field == nil {
+			return fmt.Errorf("bundle '%s' has no field '%s'", bundle.Name, name)
+		}
+		lo += field.Lo
+		width = field.Width
+		bundle = field.Bundle
+		path += "." + name
+	}
+
+	if ae.HasIndex() {
+		if ae.Hi >= width {
+			return fmt.Errorf(
+				"attempting to get range [%v..%v] (d%v) of '%v' (d%v)",
+				ae.Lo, ae.Hi, (ae.Hi-ae.Lo+1), path, width)
+		}
+		ae.Lo += lo
+		ae.Hi += lo
+	} else {
+		ae.Lo = lo
+		ae.Hi = lo + width - 1
+	}
+	ae.Fields = nil
+	return nil
+}
+
 func (ae AstExpr) String() string {
-	if ae.Conn == nil {
+	if ae.Enum != nil {
+		return fmt.Sprintf(
+			"(%v %v %v)",
+			ae.Enum.Symbol(ae.Literal).Name,
+			ae.Lo,
+			ae.Hi,
+		)
+	} else if ae.Conn == nil {
 		return fmt.Sprintf(
 			"(%v %v %v)",
 			ae.Literal,
@@ -195,20 +756,36 @@ func (ae AstExpr) String() string {
 	}
 }
 
-func CompileExpr(block *AstBlock, expr *Expr) (*AstExpr, error) {
+func CompileExpr(astfile *AstFile, block *AstBlock, expr *Expr) (*AstExpr, error) {
 	var conn *AstConn
+	var sym *AstEnumValue
 	if expr.Ident == nil {
 		conn = nil
 	} else if c, ok := block.Vars[expr.Ident.Value]; ok {
 		conn = c
+	} else if enum, ok := astfile.Enums[expr.Ident.Value]; ok {
+		// qualified enum value
+		if len(expr.Fields) != 1 {
+			return nil, fmt.Errorf("CompileExpr: expected value of enum '%s'",
+				enum.Name)
+		} else if sym = enum.Value(expr.Fields[0].Value); sym == nil {
+			return nil, fmt.Errorf("CompileExpr: enum '%s' has no value '%s'",
+				enum.Name, expr.Fields[0].Value)
+		}
+	} else if v, err := astfile.enumValue(expr.Ident.Value); err != nil {
+		return nil, fmt.Errorf("CompileExpr: %s", err)
+	} else if v != nil && len(expr.Fields) == 0 {
+		sym = v
 	} else {
 		// 0 represents unknown width
-		conn = &AstConn{expr.Ident.Value, 0}
+		conn = &AstConn{Name: expr.Ident.Value}
 		block.Vars[conn.Name] = conn
 	}
 
 	var lit int64
-	if conn == nil {
+	if sym != nil {
+		lit = sym.Value
+	} else if conn == nil {
 		var err error
 		// TODO: custom string literal parsing
 		lit, err = strconv.ParseInt(expr.Literal, 0, 64)
@@ -240,13 +817,35 @@ func CompileExpr(block *AstBlock, expr *Expr) (*AstExpr, error) {
 		}
 	}
 
-	return &AstExpr{
+	aexpr := &AstExpr{
 		Literal: lit,
 		Conn: conn,
 		Lo: lo,
 		Hi: hi,
-	}, nil
+	}
+
+	if sym != nil {
+		if aexpr.HasIndex() {
+			return nil, fmt.Errorf("CompileExpr: cannot index enum value '%s'",
+				sym.Name)
+		}
+		aexpr.Enum = sym.Enum
+		return aexpr, nil
+	}
+
+	for _, field := range expr.Fields {
+		aexpr.Fields = append(aexpr.Fields, field.Value)
+	}
+
+	// fields of untyped conns are flattened during type checking
+	if conn != nil && conn.HasType() {
+		err := aexpr.ResolveFields()
+		if err != nil {
+			return nil, fmt.Errorf("CompileExpr: %s", err)
+		}
+	}
 
+	return aexpr, nil
 }
 
 type AstTest struct {
@@ -265,7 +864,7 @@ func (at AstTest) String() string {
 }
 
 func CompileTestBlock(file *AstFile, test *TestBlock) (*AstTest, error) {
-	block, ok := file.Blocks[test.Block.Value]
+	block, ok := file.lookupBlock(test.Block.Value)
 	if !ok {
 		return nil, fmt.Errorf("CompileTest: block '%s' is not defined",
 			test.Block.Value)
@@ -277,7 +876,7 @@ func CompileTestBlock(file *AstFile, test *TestBlock) (*AstTest, error) {
 	}
 
 	for _, stmt := range test.Stmts {
-		astmt, err := CompileTestStmt(atest, stmt)
+		astmt, err := CompileTestStmt(file, atest, stmt)
 		if err != nil {
 			return nil, fmt.Errorf("CompileTest: %s", err)
 		}
@@ -300,7 +899,7 @@ func (ats AstTestStmt) String() string {
 	)
 }
 
-func CompileTestStmt(test *AstTest, stmt *TestStmt) (*AstTestStmt, error) {
+func CompileTestStmt(file *AstFile, test *AstTest, stmt *TestStmt) (*AstTestStmt, error) {
 	fakeblock := &AstBlock{Vars: make(map[string]*AstConn)}
 
 	atstmt := &AstTestStmt{
@@ -308,7 +907,7 @@ func CompileTestStmt(test *AstTest, stmt *TestStmt) (*AstTestStmt, error) {
 		Rets: make([]*AstExpr, 0),
 	}
 	for _, arg := range stmt.Args {
-		expr, err := CompileExpr(fakeblock, arg)
+		expr, err := CompileExpr(file, fakeblock, arg)
 		if err != nil {
 			return nil, fmt.Errorf("CompileTestStmt: %s", err)
 		} else if expr.Conn != nil {
@@ -319,7 +918,7 @@ func CompileTestStmt(test *AstTest, stmt *TestStmt) (*AstTestStmt, error) {
 	}
 
 	for _, ret := range stmt.Rets {
-		expr, err := CompileExpr(fakeblock, ret)
+		expr, err := CompileExpr(file, fakeblock, ret)
 		if err != nil {
 			return nil, fmt.Errorf("CompileTestStmt: %s", err)
 		} else if expr.Conn != nil {
@@ -3,6 +3,7 @@ package phdl
 import (
 	"github.com/alecthomas/participle"
 	"testing"
+	"fmt"
 )
 
 
@@ -130,6 +131,51 @@ func TestCompileStmt(t *testing.T) {
 	}
 }
 
+func TestCompileAssert(t *testing.T) {
+	Parser := participle.MustBuild(
+		&Assert{},
+		participle.Lexer(Lexer),
+		participle.Elide("Whitespace", "OneLineComment", "MultiLineComment"),
+	)
+
+	astfile := &AstFile{Blocks: map[string]*AstBlock{
+		"f": &AstBlock{Name: "f"},
+		"tb": &AstBlock{Name: "tb", Vars: make(map[string]*AstConn)},
+	}}
+
+	ptree := &Assert{}
+	err := Parser.ParseString("assert (a, a@2, 1@1) f;", ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, err := CompileAssert(astfile, astfile.Blocks["tb"], ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "(assert f [((a 0) -1 0) ((a 0) -1 0) (1 -1 0)] [0 2 1])"
+	if ast.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, ast.String())
+	}
+	if ast.From() != 2 || ast.Op != astfile.Blocks["f"] {
+		t.Errorf("unexpected assert %v from %v", ast, ast.From())
+	}
+
+	for prog, expected := range map[string]string{
+		"assert (a) g;":    "block 'g' not defined",
+		"assert (a@-1) f;": "CompileAssert: delay -1 is negative",
+	} {
+		err = Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CompileAssert(astfile, astfile.Blocks["tb"], ptree)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error '%s', got %v", prog, expected, err)
+		}
+	}
+}
+
 func TestCompileExpr(t *testing.T) {
 	Parser := participle.MustBuild(
 		&Expr{},
@@ -137,8 +183,8 @@ func TestCompileExpr(t *testing.T) {
 		participle.Elide("Whitespace", "OneLineComment", "MultiLineComment"),
 	)
 	testblock := &AstBlock{Name: "tb", Vars: map[string]*AstConn{
-		"a": &AstConn{"a", 32},
-		"b": &AstConn{"b", 32},
+		"a": &AstConn{Name: "a", Width: 32},
+		"b": &AstConn{Name: "b", Width: 32},
 	}}
 
 	Comp := func(prog string) (*AstExpr, error) {
@@ -150,7 +196,7 @@ func TestCompileExpr(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		return CompileExpr(testblock, ptree)
+		return CompileExpr(&AstFile{}, testblock, ptree)
 	}
 
 	ast, err := Comp("a")
@@ -246,6 +292,332 @@ func TestCompileExpr(t *testing.T) {
 
 }
 
+func TestCompileBundle(t *testing.T) {
+	prog := `
+		bundle regs { rd d3; rs d3; }
+		bundle instr { opcode d4; r regs; imm d8; }
+
+		block f (i instr) -> (o d8) {}
+		block g (a instr) -> (b d8) {
+			(a.r.rs, a.imm[1..2], a.r) f -> b;
+		}
+	`
+	ptree := &File{}
+	err := Parser.ParseString(prog, ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ast, err := CompileFile(ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "(instr 18 [(opcode 0 4) (r 4 6) (imm 10 8)])"
+	if ast.Bundles["instr"].String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, ast.Bundles["instr"].String())
+	}
+
+	if ast.Blocks["f"].Args[0].Bundle != ast.Bundles["instr"] {
+		t.Error("arg does not point to bundle")
+	}
+
+	expected = "(f [((a 18 instr) 7 9) ((a 18 instr) 11 12) ((a 18 instr) 4 9)] [((b 8) -1 0)])"
+	if ast.Blocks["g"].Stmts[0].String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, ast.Blocks["g"].Stmts[0].String())
+	}
+
+	Fail := func(prog string) {
+		t.Helper()
+
+		ptree := &File{}
+		err := Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = CompileFile(ptree)
+		if err == nil {
+			t.Error("expected error, got nil")
+		}
+	}
+
+	Fail("bundle a { x d1; x d2; }")
+	Fail("bundle a { x b; }")
+	Fail("bundle a { x d0; }")
+	Fail("block f (a foo) {}")
+	Fail(`
+		bundle a { x d2; }
+		block f (a a) -> (b d1) { (a.y) f -> b; }
+	`)
+	Fail(`
+		bundle a { x d2; }
+		block f (a a) -> (b d1) { (a.x.y) f -> b; }
+	`)
+	Fail(`
+		bundle a { x d2; }
+		block f (a a) -> (b d1) { (a.x[1..2]) f -> b; }
+	`)
+}
+
+func TestCompileEnum(t *testing.T) {
+	Comp := func(prog string) (*AstFile, error) {
+		t.Helper()
+
+		ptree := &File{}
+		err := Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return CompileFile(ptree)
+	}
+
+	ast, err := Comp(`
+		enum state { idle, fetch, exec }
+		enum gstate gray { a, b, c, d }
+		enum hstate onehot { a1, b1, c1 }
+		enum op d4 { add = 2, sub, nop = 0x8 }
+
+		block f (s state, o op) -> (n state) {}
+		block g (s state) -> (n state) {
+			(s, op.sub) f -> n;
+			(fetch, nop) f -> n;
+		}
+		test gtest(g) {
+			idle ==> fetch;
+			state.exec ==> 0;
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"state": "(state binary 2 [(idle 0) (fetch 1) (exec 2)])",
+		"gstate": "(gstate gray 2 [(a 0) (b 1) (c 3) (d 2)])",
+		"hstate": "(hstate onehot 3 [(a1 1) (b1 2) (c1 4)])",
+		"op": "(op explicit 4 [(add 2) (sub 3) (nop 8)])",
+	}
+	for name, exp := range expected {
+		if ast.Enums[name].String() != exp {
+			t.Errorf("expected/got:\n%s\n%s\n", exp, ast.Enums[name].String())
+		}
+	}
+
+	if ast.Blocks["f"].Args[0].Enum != ast.Enums["state"] {
+		t.Error("arg does not point to enum")
+	}
+
+	exp := "[(f [((s 2 state) -1 0) (sub -1 0)] [((n 2 state) -1 0)]) (f [(fetch -1 0) (nop -1 0)] [((n 2 state) -1 0)])]"
+	if fmt.Sprint(ast.Blocks["g"].Stmts) != exp {
+		t.Errorf("expected/got:\n%s\n%s\n", exp, fmt.Sprint(ast.Blocks["g"].Stmts))
+	}
+
+	exp = "(gtest g [([(idle -1 0)] [(fetch -1 0)]) ([(exec -1 0)] [(0 -1 0)])])"
+	if ast.Tests["gtest"].String() != exp {
+		t.Errorf("expected/got:\n%s\n%s\n", exp, ast.Tests["gtest"].String())
+	}
+
+	if s := ast.Blocks["f"].Args[0].Format(1); s != "fetch" {
+		t.Errorf("expected fetch, got %s", s)
+	}
+
+	if s := ast.Blocks["f"].Args[0].Format(3); s != "3" {
+		t.Errorf("expected 3, got %s", s)
+	}
+
+	Fail := func(prog string) {
+		t.Helper()
+
+		_, err := Comp(prog)
+		if err == nil {
+			t.Error("expected error, got nil")
+		}
+	}
+
+	Fail("enum a { x, x }")
+	Fail("enum a { x = 1, y = 1 }")
+	Fail("enum a gray { x = 1 }")
+	Fail("enum a ternary { x }")
+	Fail("enum a d1 { x, y, z }")
+	Fail(`
+		enum a { x }
+		enum b { x }
+		block f (a d1) -> (b d1) { (x) f -> b; }
+	`)
+	Fail(`
+		enum a { x }
+		block f (a d1) -> (b d1) { (a.y) f -> b; }
+	`)
+}
+
+func TestCompileMemory(t *testing.T) {
+	Comp := func(prog string) (*AstFile, error) {
+		t.Helper()
+
+		ptree := &File{}
+		err := Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return CompileFile(ptree)
+	}
+
+	ast, err := Comp(`
+		rom seg (a d2) -> (s d7) = { 0x3f, 0x06 };
+		rom prog (a d8) -> (i d16) = hex "prog.hex";
+		ram mem (addr d8, din d16, we d1) -> (dout d16);
+		reg acc (d d8) -> (q d8) = { 5 };
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"seg": "(rom [63 6])",
+		"prog": "(rom hex \"prog.hex\")",
+		"mem": "(ram [])",
+		"acc": "(reg [5])",
+	}
+	for name, exp := range expected {
+		if ast.Blocks[name].Mem.String() != exp {
+			t.Errorf("expected/got:\n%s\n%s\n", exp, ast.Blocks[name].Mem.String())
+		}
+	}
+
+	if ast.Blocks["mem"].Args[2].Width != 1 {
+		t.Error("expected write enable to be d1")
+	}
+
+	Fail := func(prog string) {
+		t.Helper()
+
+		_, err := Comp(prog)
+		if err == nil {
+			t.Error("expected error, got nil")
+		}
+	}
+
+	Fail("rom r (a d2, b d2) -> (s d7);")
+	Fail("ram r (a d2) -> (s d7);")
+	Fail("ram r (a d2, d d8, we d1) -> (s d7);")
+	Fail("ram r (a d2, d d8, we d2) -> (s d8);")
+	Fail("rom r (a d1) -> (s d2) = { 1, 2, 3 };")
+	Fail("rom r (a d2) -> (s d2) = { 4 };")
+	Fail(`rom r (a d2) -> (s d2) = oct "a.oct";`)
+	Fail("reg r (d d2) -> (q d3);")
+	Fail("reg r (d d2, e d1) -> (q d2);")
+	Fail("reg r (d d2) -> (q d2) = { 1, 2 };")
+	Fail("reg r (d d2) -> (q d2) = { 4 };")
+	Fail(`reg r (d d2) -> (q d2) = hex "r.hex";`)
+}
+
+func TestCompileTable(t *testing.T) {
+	Comp := func(prog string) (*AstFile, error) {
+		t.Helper()
+
+		ptree := &File{}
+		err := Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return CompileFile(ptree)
+	}
+
+	ast, err := Comp(`
+		enum op { add, sub }
+		table dec (a d3, b d1) -> (o op) {
+			0b1x_0, 1 ==> sub;
+			0b0--, 0 ==> 1;
+			5, 0 ==> add;
+		}
+		block f (a d3) -> (o op) {
+			(a, 1) dec -> o;
+			(a[0], a[1]) and -> o[0];
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := ast.Blocks["dec"].Table
+	expected := "[([0b1x0 (1 -1 0)] [(sub -1 0)]) ([0b0xx (0 -1 0)] [(1 -1 0)]) ([(5 -1 0) (0 -1 0)] [(add -1 0)])]"
+	if table.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, table.String())
+	}
+
+	Lookup := func(args []int64, exp int64, expok bool) {
+		t.Helper()
+
+		rets, ok := table.Lookup(args)
+		if ok != expok || (ok && rets[0] != exp) {
+			t.Errorf("%v: expected %v %v, got %v %v", args, exp, expok, rets, ok)
+		}
+	}
+
+	Lookup([]int64{6, 1}, 1, true)
+	Lookup([]int64{4, 1}, 1, true)
+	Lookup([]int64{7, 1}, 0, false)
+	Lookup([]int64{3, 0}, 1, true)
+	Lookup([]int64{5, 0}, 0, true)
+	Lookup([]int64{13, 0}, 0, false)
+
+	if ast.Blocks["f"].Stmts[1].Op != Builtins["and"] {
+		t.Error("expected 'and' to be builtin")
+	}
+
+	synth := SynthesizeTable(ast.Blocks["dec"])
+	if synth.Table != nil || len(synth.Stmts) == 0 {
+		t.Error("expected table to be synthesized to statements")
+	}
+	for _, stmt := range synth.Stmts {
+		if !stmt.Op.Builtin {
+			t.Errorf("expected only builtins, got '%s'", stmt.Op.Name)
+		}
+	}
+
+	_, err = Comp("table a (a d1) -> (b d1) { c ==> 1; }")
+	if err == nil {
+		t.Error("expected that conns are not allowed in tables")
+	}
+}
+
+func TestCompileExtern(t *testing.T) {
+	prog := `
+		extern block uart (tx d8, send d1) -> (rx d8);
+		extern block sink (a d1);
+		block f (a d8) -> (b d8) {
+			(a, 1) uart -> b;
+		}
+	`
+	ptree := &File{}
+	err := Parser.ParseString(prog, ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ast, err := CompileFile(ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "(uart [(tx 8) (send 1)] [(rx 8)] map[rx:(rx 8) send:(send 1) tx:(tx 8)] [])"
+	if ast.Blocks["uart"].String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, ast.Blocks["uart"].String())
+	}
+
+	if !ast.Blocks["uart"].Extern || len(ast.Blocks["sink"].Rets) != 0 {
+		t.Error("expected extern blocks")
+	}
+
+	if ast.Blocks["f"].Stmts[0].Op != ast.Blocks["uart"] {
+		t.Error("stmt does not point to extern block")
+	}
+}
+
 func TestCompileTestBlock(t *testing.T) {
 	Parser := participle.MustBuild(
 		&TestBlock{},
@@ -318,7 +690,7 @@ func TestCompileTestStmt(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		return CompileTestStmt(asttest, ptree)
+		return CompileTestStmt(&AstFile{}, asttest, ptree)
 	}
 
 	ast, err := Comp("1,2 ==> 4;")
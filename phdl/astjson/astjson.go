@@ -0,0 +1,580 @@
+/*
+Package astjson encodes compiled PHDL files as JSON, for tools that don't link
+the phdl package.
+
+The encoding is versioned by the top level "version" field, which is Version
+for files written by this package. Later versions may add fields, but will
+change the version if they change the meaning of existing ones. A file is an
+object of:
+
+	version  the encoding version
+	enums    enums, sorted by name
+	bundles  bundles, sorted by name
+	blocks   blocks, sorted by name, not including builtins
+	tests    tests, sorted by name
+
+An enum has a name, an encoding ("binary", "onehot", "gray" or "explicit"), a
+width, and values, each a name and a value. A bundle has a name, a width, and
+fields, each with a name, the lowest bit it occupies (lo), a width, and the
+name of its bundle or enum type if it has one.
+
+A block has a name and a kind: "block", "table", "rom", "ram", "reg" or
+"extern". Its conns are objects of a name, a width (0 before type checking),
+and the name of a bundle or enum type if it has one, sorted by name. Args and
+rets name conns in order. Stmts are objects of an op, naming a block of the
+file or a builtin, and args and rets, which are exprs. Blocks with asserts have
+asserts, objects of an op, args, the delay of each arg in cycles, and the
+source line. Memory blocks have mem, an object of the kind, and the initial
+contents in either init, or file and format. Table blocks have table, a list of
+rows, each args of patterns and rets of exprs. A pattern is an object of a
+value, a mask of the bits to match, -1 for every bit, and a width, or an expr
+for a literal pattern.
+
+An expr is either a literal, with an enum if it is a symbolic value, or a conn
+naming a conn of the block. Either may have lo and hi, an inclusive bit range,
+and fields, a list of field accesses not yet resolved by type checking.
+
+A test has a name, the name of the block it tests, and stmts, each args and
+rets of literal exprs.
+*/
+package astjson
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Version is the version of the encoding written by Encode
+const Version = 1
+
+type jsonFile struct {
+	Version int           `json:"version"`
+	Enums   []*jsonEnum   `json:"enums"`
+	Bundles []*jsonBundle `json:"bundles"`
+	Blocks  []*jsonBlock  `json:"blocks"`
+	Tests   []*jsonTest   `json:"tests"`
+}
+
+type jsonEnum struct {
+	Name     string           `json:"name"`
+	Encoding string           `json:"encoding"`
+	Width    int              `json:"width"`
+	Values   []*jsonEnumValue `json:"values"`
+}
+
+type jsonEnumValue struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+type jsonBundle struct {
+	Name   string       `json:"name"`
+	Width  int          `json:"width"`
+	Fields []*jsonField `json:"fields"`
+}
+
+type jsonField struct {
+	Name   string `json:"name"`
+	Lo     int    `json:"lo"`
+	Width  int    `json:"width"`
+	Bundle string `json:"bundle,omitempty"`
+	Enum   string `json:"enum,omitempty"`
+}
+
+type jsonConn struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Bundle string `json:"bundle,omitempty"`
+	Enum   string `json:"enum,omitempty"`
+}
+
+type jsonBlock struct {
+	Name    string        `json:"name"`
+	Kind    string        `json:"kind"`
+	Conns   []*jsonConn   `json:"conns"`
+	Args    []string      `json:"args"`
+	Rets    []string      `json:"rets"`
+	Stmts   []*jsonStmt   `json:"stmts"`
+	Asserts []*jsonAssert `json:"asserts,omitempty"`
+	Mem     *jsonMem      `json:"mem,omitempty"`
+	Table   []*jsonRow    `json:"table,omitempty"`
+}
+
+type jsonAssert struct {
+	Op     string      `json:"op"`
+	Args   []*jsonExpr `json:"args"`
+	Delays []int       `json:"delays"`
+	Line   int         `json:"line"`
+}
+
+type jsonStmt struct {
+	Op   string      `json:"op"`
+	Args []*jsonExpr `json:"args"`
+	Rets []*jsonExpr `json:"rets"`
+}
+
+type jsonExpr struct {
+	Literal *int64   `json:"literal,omitempty"`
+	Enum    string   `json:"enum,omitempty"`
+	Conn    string   `json:"conn,omitempty"`
+	Lo      *int     `json:"lo,omitempty"`
+	Hi      *int     `json:"hi,omitempty"`
+	Fields  []string `json:"fields,omitempty"`
+}
+
+type jsonMem struct {
+	Kind   string  `json:"kind"`
+	Init   []int64 `json:"init,omitempty"`
+	File   string  `json:"file,omitempty"`
+	Format string  `json:"format,omitempty"`
+}
+
+type jsonRow struct {
+	Args []*jsonPattern `json:"args"`
+	Rets []*jsonExpr    `json:"rets"`
+}
+
+type jsonPattern struct {
+	Value int64     `json:"value"`
+	Mask  int64     `json:"mask"`
+	Width int       `json:"width"`
+	Expr  *jsonExpr `json:"expr,omitempty"`
+}
+
+type jsonTest struct {
+	Name  string          `json:"name"`
+	Block string          `json:"block"`
+	Stmts []*jsonTestStmt `json:"stmts"`
+}
+
+type jsonTestStmt struct {
+	Args []*jsonExpr `json:"args"`
+	Rets []*jsonExpr `json:"rets"`
+}
+
+func typeNames(bundle *phdl.AstBundle, enum *phdl.AstEnum) (string, string) {
+	var b, e string
+	if bundle != nil {
+		b = bundle.Name
+	}
+	if enum != nil {
+		e = enum.Name
+	}
+	return b, e
+}
+
+// Encode writes file as JSON
+func Encode(w io.Writer, file *phdl.AstFile) error {
+	jf := &jsonFile{
+		Version: Version,
+		Enums:   make([]*jsonEnum, 0),
+		Bundles: make([]*jsonBundle, 0),
+		Blocks:  make([]*jsonBlock, 0),
+		Tests:   make([]*jsonTest, 0),
+	}
+
+	for name, enum := range file.Enums {
+		je := &jsonEnum{Name: name, Encoding: enum.Encoding, Width: enum.Width,
+			Values: make([]*jsonEnumValue, 0)}
+		for _, value := range enum.Values {
+			je.Values = append(je.Values, &jsonEnumValue{value.Name, value.Value})
+		}
+		jf.Enums = append(jf.Enums, je)
+	}
+
+	for name, bundle := range file.Bundles {
+		jb := &jsonBundle{Name: name, Width: bundle.Width, Fields: make([]*jsonField, 0)}
+		for _, field := range bundle.Fields {
+			b, e := typeNames(field.Bundle, field.Enum)
+			jb.Fields = append(jb.Fields, &jsonField{field.Name, field.Lo, field.Width, b, e})
+		}
+		jf.Bundles = append(jf.Bundles, jb)
+	}
+
+	for _, block := range file.Blocks {
+		jf.Blocks = append(jf.Blocks, encodeBlock(block))
+	}
+
+	for name, test := range file.Tests {
+		jt := &jsonTest{Name: name, Block: test.Block.Name, Stmts: make([]*jsonTestStmt, 0)}
+		for _, stmt := range test.Stmts {
+			jt.Stmts = append(jt.Stmts, &jsonTestStmt{encodeExprs(stmt.Args), encodeExprs(stmt.Rets)})
+		}
+		jf.Tests = append(jf.Tests, jt)
+	}
+
+	// maps are sorted so that encoding is deterministic
+	sort.Slice(jf.Enums, func(i, j int) bool { return jf.Enums[i].Name < jf.Enums[j].Name })
+	sort.Slice(jf.Bundles, func(i, j int) bool { return jf.Bundles[i].Name < jf.Bundles[j].Name })
+	sort.Slice(jf.Blocks, func(i, j int) bool { return jf.Blocks[i].Name < jf.Blocks[j].Name })
+	sort.Slice(jf.Tests, func(i, j int) bool { return jf.Tests[i].Name < jf.Tests[j].Name })
+
+	out, err := json.MarshalIndent(jf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Encode: %s", err)
+	}
+	out = append(out, '\n')
+	_, err = w.Write(out)
+	return err
+}
+
+func kind(block *phdl.AstBlock) string {
+	switch {
+	case block.Mem != nil:
+		return block.Mem.Kind
+	case block.Table != nil:
+		return "table"
+	case block.Extern:
+		return "extern"
+	}
+	return "block"
+}
+
+func encodeBlock(block *phdl.AstBlock) *jsonBlock {
+	jb := &jsonBlock{
+		Name:  block.Name,
+		Kind:  kind(block),
+		Conns: make([]*jsonConn, 0),
+		Args:  make([]string, 0),
+		Rets:  make([]string, 0),
+		Stmts: make([]*jsonStmt, 0),
+	}
+
+	for name, conn := range block.Vars {
+		b, e := typeNames(conn.Bundle, conn.Enum)
+		jb.Conns = append(jb.Conns, &jsonConn{name, conn.Width, b, e})
+	}
+	sort.Slice(jb.Conns, func(i, j int) bool { return jb.Conns[i].Name < jb.Conns[j].Name })
+	for _, arg := range block.Args {
+		jb.Args = append(jb.Args, arg.Name)
+	}
+	for _, ret := range block.Rets {
+		jb.Rets = append(jb.Rets, ret.Name)
+	}
+
+	for _, stmt := range block.Stmts {
+		jb.Stmts = append(jb.Stmts, &jsonStmt{
+			Op:   stmt.Op.Name,
+			Args: encodeExprs(stmt.Args),
+			Rets: encodeExprs(stmt.Rets),
+		})
+	}
+	for _, assert := range block.Asserts {
+		jb.Asserts = append(jb.Asserts, &jsonAssert{
+			Op:     assert.Op.Name,
+			Args:   encodeExprs(assert.Args),
+			Delays: assert.Delays,
+			Line:   assert.Line,
+		})
+	}
+
+	if block.Mem != nil {
+		jb.Mem = &jsonMem{block.Mem.Kind, block.Mem.Init, block.Mem.File, block.Mem.Format}
+	}
+	if block.Table != nil {
+		jb.Table = make([]*jsonRow, 0)
+		for _, row := range block.Table.Rows {
+			jr := &jsonRow{Args: make([]*jsonPattern, 0), Rets: encodeExprs(row.Rets)}
+			for _, pat := range row.Args {
+				jp := &jsonPattern{Value: pat.Value, Mask: pat.Mask, Width: pat.Width}
+				if pat.Expr != nil {
+					jp.Expr = encodeExpr(pat.Expr)
+				}
+				jr.Args = append(jr.Args, jp)
+			}
+			jb.Table = append(jb.Table, jr)
+		}
+	}
+	return jb
+}
+
+func encodeExprs(exprs []*phdl.AstExpr) []*jsonExpr {
+	jexprs := make([]*jsonExpr, len(exprs))
+	for idx, expr := range exprs {
+		jexprs[idx] = encodeExpr(expr)
+	}
+	return jexprs
+}
+
+func encodeExpr(expr *phdl.AstExpr) *jsonExpr {
+	je := &jsonExpr{Fields: expr.Fields}
+	if expr.Conn == nil {
+		literal := expr.Literal
+		je.Literal = &literal
+		if expr.Enum != nil {
+			je.Enum = expr.Enum.Name
+		}
+	} else {
+		je.Conn = expr.Conn.Name
+	}
+	if expr.HasIndex() {
+		lo, hi := expr.Lo, expr.Hi
+		je.Lo, je.Hi = &lo, &hi
+	}
+	return je
+}
+
+// Decode reads a file encoded by Encode, or by another encoder of a version
+// no later than Version
+func Decode(r io.Reader) (*phdl.AstFile, error) {
+	jf := &jsonFile{}
+	err := json.NewDecoder(r).Decode(jf)
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %s", err)
+	} else if jf.Version < 1 || jf.Version > Version {
+		return nil, fmt.Errorf("Decode: unsupported version %v", jf.Version)
+	}
+
+	file, err := decodeFile(jf)
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %s", err)
+	}
+	return file, nil
+}
+
+func decodeFile(jf *jsonFile) (*phdl.AstFile, error) {
+	file := &phdl.AstFile{
+		Blocks:  make(map[string]*phdl.AstBlock),
+		Tests:   make(map[string]*phdl.AstTest),
+		Bundles: make(map[string]*phdl.AstBundle),
+		Enums:   make(map[string]*phdl.AstEnum),
+	}
+
+	// everything is declared before it is filled in, since they refer to
+	// each other by name
+	for _, je := range jf.Enums {
+		file.Enums[je.Name] = &phdl.AstEnum{Name: je.Name, Encoding: je.Encoding, Width: je.Width,
+			Values: make([]*phdl.AstEnumValue, 0)}
+	}
+	for _, jb := range jf.Bundles {
+		file.Bundles[jb.Name] = &phdl.AstBundle{Name: jb.Name, Width: jb.Width,
+			Fields: make([]*phdl.AstField, 0)}
+	}
+	for _, jb := range jf.Blocks {
+		file.Blocks[jb.Name] = &phdl.AstBlock{
+			Name:   jb.Name,
+			Args:   make([]*phdl.AstConn, 0),
+			Rets:   make([]*phdl.AstConn, 0),
+			Vars:   make(map[string]*phdl.AstConn),
+			Stmts:  make([]*phdl.AstStmt, 0),
+			Extern: jb.Kind == "extern",
+		}
+	}
+
+	for _, je := range jf.Enums {
+		enum := file.Enums[je.Name]
+		for _, value := range je.Values {
+			enum.Values = append(enum.Values, &phdl.AstEnumValue{
+				Name: value.Name, Value: value.Value, Enum: enum})
+		}
+	}
+
+	for _, jb := range jf.Bundles {
+		bundle := file.Bundles[jb.Name]
+		for _, jfield := range jb.Fields {
+			b, e, err := types(file, jfield.Bundle, jfield.Enum)
+			if err != nil {
+				return nil, fmt.Errorf("bundle '%s': %s", jb.Name, err)
+			}
+			bundle.Fields = append(bundle.Fields, &phdl.AstField{
+				Name: jfield.Name, Lo: jfield.Lo, Width: jfield.Width, Bundle: b, Enum: e})
+		}
+	}
+
+	for _, jb := range jf.Blocks {
+		err := decodeBlock(file, jb)
+		if err != nil {
+			return nil, fmt.Errorf("block '%s': %s", jb.Name, err)
+		}
+	}
+
+	for _, jt := range jf.Tests {
+		block, ok := file.Blocks[jt.Block]
+		if !ok {
+			return nil, fmt.Errorf("test '%s': block '%s' not defined", jt.Name, jt.Block)
+		}
+		test := &phdl.AstTest{Name: jt.Name, Block: block, Stmts: make([]*phdl.AstTestStmt, 0)}
+		empty := &phdl.AstBlock{Vars: make(map[string]*phdl.AstConn)}
+		for _, stmt := range jt.Stmts {
+			args, err := decodeExprs(file, empty, stmt.Args)
+			if err != nil {
+				return nil, fmt.Errorf("test '%s': %s", jt.Name, err)
+			}
+			rets, err := decodeExprs(file, empty, stmt.Rets)
+			if err != nil {
+				return nil, fmt.Errorf("test '%s': %s", jt.Name, err)
+			}
+			test.Stmts = append(test.Stmts, &phdl.AstTestStmt{Args: args, Rets: rets})
+		}
+		file.Tests[jt.Name] = test
+	}
+	return file, nil
+}
+
+// types gets a bundle and enum by name, either of which may be empty
+func types(file *phdl.AstFile, bundle, enum string) (*phdl.AstBundle, *phdl.AstEnum, error) {
+	var b *phdl.AstBundle
+	var e *phdl.AstEnum
+	if bundle != "" {
+		if b = file.Bundles[bundle]; b == nil {
+			return nil, nil, fmt.Errorf("bundle '%s' not defined", bundle)
+		}
+	}
+	if enum != "" {
+		if e = file.Enums[enum]; e == nil {
+			return nil, nil, fmt.Errorf("enum '%s' not defined", enum)
+		}
+	}
+	return b, e, nil
+}
+
+func decodeBlock(file *phdl.AstFile, jb *jsonBlock) error {
+	block := file.Blocks[jb.Name]
+	for _, jc := range jb.Conns {
+		b, e, err := types(file, jc.Bundle, jc.Enum)
+		if err != nil {
+			return err
+		}
+		block.Vars[jc.Name] = &phdl.AstConn{Name: jc.Name, Width: jc.Width, Bundle: b, Enum: e}
+	}
+
+	for _, name := range jb.Args {
+		conn, ok := block.Vars[name]
+		if !ok {
+			return fmt.Errorf("conn '%s' not defined", name)
+		}
+		block.Args = append(block.Args, conn)
+	}
+	for _, name := range jb.Rets {
+		conn, ok := block.Vars[name]
+		if !ok {
+			return fmt.Errorf("conn '%s' not defined", name)
+		}
+		block.Rets = append(block.Rets, conn)
+	}
+
+	for _, js := range jb.Stmts {
+		op, ok := file.Blocks[js.Op]
+		if !ok {
+			op, ok = phdl.Builtins[js.Op]
+		}
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", js.Op)
+		}
+		args, err := decodeExprs(file, block, js.Args)
+		if err != nil {
+			return err
+		}
+		rets, err := decodeExprs(file, block, js.Rets)
+		if err != nil {
+			return err
+		}
+		block.Stmts = append(block.Stmts, &phdl.AstStmt{Args: args, Op: op, Rets: rets})
+	}
+
+	for _, ja := range jb.Asserts {
+		op, ok := file.Blocks[ja.Op]
+		if !ok {
+			op, ok = phdl.Builtins[ja.Op]
+		}
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", ja.Op)
+		} else if len(ja.Delays) != len(ja.Args) {
+			return fmt.Errorf("assert of '%s' has %v args and %v delays", ja.Op,
+				len(ja.Args), len(ja.Delays))
+		}
+		args, err := decodeExprs(file, block, ja.Args)
+		if err != nil {
+			return err
+		}
+		block.Asserts = append(block.Asserts, &phdl.AstAssert{Args: args, Delays: ja.Delays,
+			Op: op, Line: ja.Line})
+	}
+
+	switch jb.Kind {
+	case "rom", "ram", "reg":
+		if jb.Mem == nil || jb.Mem.Kind != jb.Kind {
+			return fmt.Errorf("%s without mem of the same kind", jb.Kind)
+		}
+		block.Mem = &phdl.AstMem{Kind: jb.Mem.Kind, Format: jb.Mem.Format, File: jb.Mem.File,
+			Init: jb.Mem.Init}
+		if block.Mem.Init == nil {
+			block.Mem.Init = make([]int64, 0)
+		}
+	case "table":
+		block.Table = &phdl.AstTable{Rows: make([]*phdl.AstTableRow, 0)}
+		for _, jr := range jb.Table {
+			row := &phdl.AstTableRow{Args: make([]*phdl.AstPattern, 0)}
+			for _, jp := range jr.Args {
+				pat := &phdl.AstPattern{Value: jp.Value, Mask: jp.Mask, Width: jp.Width}
+				if jp.Expr != nil {
+					expr, err := decodeExpr(file, block, jp.Expr)
+					if err != nil {
+						return err
+					}
+					pat.Expr = expr
+				}
+				row.Args = append(row.Args, pat)
+			}
+			rets, err := decodeExprs(file, block, jr.Rets)
+			if err != nil {
+				return err
+			}
+			row.Rets = rets
+			block.Table.Rows = append(block.Table.Rows, row)
+		}
+	case "block", "extern":
+	default:
+		return fmt.Errorf("unknown kind '%s'", jb.Kind)
+	}
+	return nil
+}
+
+func decodeExprs(file *phdl.AstFile, block *phdl.AstBlock, jexprs []*jsonExpr) ([]*phdl.AstExpr, error) {
+	exprs := make([]*phdl.AstExpr, len(jexprs))
+	for idx, je := range jexprs {
+		expr, err := decodeExpr(file, block, je)
+		if err != nil {
+			return nil, err
+		}
+		exprs[idx] = expr
+	}
+	return exprs, nil
+}
+
+func decodeExpr(file *phdl.AstFile, block *phdl.AstBlock, je *jsonExpr) (*phdl.AstExpr, error) {
+	if je == nil {
+		return nil, fmt.Errorf("null expr")
+	}
+	expr := &phdl.AstExpr{Lo: -1, Fields: je.Fields}
+	if je.Lo != nil || je.Hi != nil {
+		if je.Lo == nil || je.Hi == nil {
+			return nil, fmt.Errorf("expr with only one of lo and hi")
+		}
+		expr.Lo, expr.Hi = *je.Lo, *je.Hi
+	}
+
+	if (je.Literal == nil) == (je.Conn == "") {
+		return nil, fmt.Errorf("expr must have one of literal and conn")
+	} else if je.Literal != nil {
+		expr.Literal = *je.Literal
+		if je.Enum != "" {
+			_, enum, err := types(file, "", je.Enum)
+			if err != nil {
+				return nil, err
+			}
+			expr.Enum = enum
+		}
+		return expr, nil
+	}
+
+	conn, ok := block.Vars[je.Conn]
+	if !ok {
+		return nil, fmt.Errorf("conn '%s' not defined", je.Conn)
+	}
+	expr.Conn = conn
+	return expr, nil
+}
@@ -0,0 +1,145 @@
+package astjson
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// describe prints everything in a file that the encoding should keep
+func describe(file *phdl.AstFile) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, file, file.Bundles, file.Enums)
+	names := make([]string, 0)
+	for name := range file.Blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		block := file.Blocks[name]
+		fmt.Fprintln(&b, block.Name, block.Vars, block.Extern, block.Asserts)
+		if block.Mem != nil {
+			fmt.Fprintln(&b, block.Mem, block.Mem.Init)
+		}
+		if block.Table != nil {
+			fmt.Fprintln(&b, block.Table)
+		}
+	}
+	return b.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	ast := checks.MustCompile(`
+		enum state gray { idle, busy, done }
+		bundle pair { s state; v d3; }
+
+		table maj (a d1, b d1, c d1) -> (o d1) {
+			1, 1, 0b- ==> 1;
+			1, 0b-, 1 ==> 1;
+			0b-, 1, 1 ==> 1;
+		}
+
+		rom sq (a d2) -> (d d4) = { 0, 1, 4, 9 };
+		rom prog (a d2) -> (d d4) = hex "prog.hex";
+		reg r (d d2) -> (q d2) = { 2 };
+		extern block e (a d1) -> (b d1);
+
+		block next (p pair) -> (n state) {
+			(p.s) r -> n;
+		}
+
+		block full (cin d1, a d2) -> (s d1, m d1, v d4) {
+			(a[0], a[1]) xor -> t;
+			(t, cin) xor -> s;
+			(cin, a[0], a[1]) maj -> m;
+			(a) sq -> v;
+			(t) e -> u;
+			assert (s, t@1) or;
+		}
+
+		test fulltest(full) {
+			0, 0 ==> 0, 0, 0;
+			1, 3 ==> 1, 1, 9;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Encode(&b, ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`"version": 1`,
+		`"kind": "table"`,
+		`"encoding": "gray"`,
+		`"op": "xor"`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected '%s' in:\n%s", s, b.String())
+		}
+	}
+
+	decoded, err := Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if describe(decoded) != describe(ast) {
+		t.Errorf("expected/got:\n%s\n%s\n", describe(ast), describe(decoded))
+	}
+
+	full := decoded.Blocks["full"]
+	if full.Stmts[0].Args[0].Conn != full.Args[1] {
+		t.Error("expr does not point to arg")
+	}
+	if full.Stmts[0].Op != phdl.Builtins["xor"] || full.Stmts[2].Op != decoded.Blocks["maj"] {
+		t.Error("stmt does not point to op")
+	}
+	if decoded.Blocks["next"].Rets[0].Enum != decoded.Enums["state"] {
+		t.Error("ret does not point to enum")
+	}
+	if assert := full.Asserts[0]; assert.Args[1].Conn != full.Vars["t"] || assert.Delays[1] != 1 ||
+		assert.Line != 26 {
+		t.Errorf("unexpected assert %v", assert)
+	}
+	if decoded.Tests["fulltest"].Block != full {
+		t.Error("test does not point to block")
+	}
+
+	// the decoded file is still well typed
+	err = checks.TypeCheckFile(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	block := func(body string) string {
+		return `{"version": 1, "blocks": [{"name": "a", "kind": "block", ` + body + `}]}`
+	}
+	for _, src := range []string{
+		`{"version": 2}`,
+		`{}`,
+		`{"version": 1, "blocks": [}`,
+		block(`"kind": "gate"`),
+		block(`"kind": "rom"`),
+		block(`"args": ["x"]`),
+		block(`"stmts": [{"op": "b", "args": [], "rets": []}]`),
+		block(`"conns": [{"name": "x", "width": 1}],
+			"stmts": [{"op": "not", "args": [{"conn": "y"}], "rets": [{"conn": "x"}]}]`),
+		block(`"conns": [{"name": "x", "width": 1}],
+			"stmts": [{"op": "not", "args": [{"literal": 1, "conn": "x"}], "rets": [{"conn": "x"}]}]`),
+		block(`"conns": [{"name": "x", "width": 1}],
+			"stmts": [{"op": "not", "args": [{"literal": 1}], "rets": [{"conn": "x", "lo": 0}]}]`),
+		block(`"conns": [{"name": "x", "width": 1, "enum": "s"}]`),
+		`{"version": 1, "tests": [{"name": "t", "block": "a", "stmts": []}]}`,
+	} {
+		_, err := Decode(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error decoding '%s'", src)
+		}
+	}
+}
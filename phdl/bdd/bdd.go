@@ -0,0 +1,321 @@
+/*
+Package bdd is reduced ordered binary decision diagrams, and their
+construction from combinational PHDL blocks.
+
+A BDD is canonical for its variable order, so two blocks built in the same
+manager are equivalent exactly when the nodes of their rets are the same.
+BDDs also count the args satisfying a function, and give an irredundant sum
+of products covering it.
+
+The size of a BDD depends heavily on the order of its variables: an adder is
+linear with the bits of its args interleaved, and exponential with one arg
+after the other. Build orders variables by a heuristic, or tries each
+heuristic and keeps the smallest result.
+*/
+package bdd
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Node is a node of a BDD, which is the function it is the root of
+type Node int
+
+// the terminal nodes of every manager
+const (
+	False Node = 0
+	True  Node = 1
+)
+
+// DefaultMaxNodes is the default limit on the nodes of a manager
+const DefaultMaxNodes = 1 << 20
+
+type node struct {
+	v      int // the variable tested, or the number of variables for terminals
+	lo, hi Node
+}
+
+// Manager holds the nodes of BDDs over a fixed number of variables, with
+// variable 0 tested first
+type Manager struct {
+	vars   int
+	nodes  []node
+	unique map[node]Node
+	ite    map[[3]Node]Node
+
+	// MaxNodes limits the nodes created. Operations that would exceed it
+	// panic with ErrTooLarge.
+	MaxNodes int
+}
+
+// ErrTooLarge is the value Manager operations panic with if a BDD needs more
+// than MaxNodes nodes
+var ErrTooLarge = errors.New("too many BDD nodes")
+
+// New creates a manager over vars variables
+func New(vars int) *Manager {
+	return &Manager{
+		vars:     vars,
+		nodes:    []node{{vars, False, False}, {vars, True, True}},
+		unique:   make(map[node]Node),
+		ite:      make(map[[3]Node]Node),
+		MaxNodes: DefaultMaxNodes,
+	}
+}
+
+// Vars gets the number of variables
+func (m *Manager) Vars() int {
+	return m.vars
+}
+
+// Nodes gets the number of nodes created, including the terminals
+func (m *Manager) Nodes() int {
+	return len(m.nodes)
+}
+
+func (m *Manager) mk(v int, lo, hi Node) Node {
+	if lo == hi {
+		return lo
+	}
+	key := node{v, lo, hi}
+	if n, ok := m.unique[key]; ok {
+		return n
+	}
+	if len(m.nodes) >= m.MaxNodes {
+		panic(ErrTooLarge)
+	}
+	m.nodes = append(m.nodes, key)
+	m.unique[key] = Node(len(m.nodes) - 1)
+	return Node(len(m.nodes) - 1)
+}
+
+// Var gets the function that is variable v
+func (m *Manager) Var(v int) Node {
+	if v < 0 || v >= m.vars {
+		panic(fmt.Sprintf("Var: no variable %v", v))
+	}
+	return m.mk(v, False, True)
+}
+
+// cofactors gets the functions of f with v false and true
+func (m *Manager) cofactors(f Node, v int) (Node, Node) {
+	n := m.nodes[f]
+	if n.v != v {
+		return f, f
+	}
+	return n.lo, n.hi
+}
+
+// Ite gets the function that is g where f is true, and h elsewhere
+func (m *Manager) Ite(f, g, h Node) Node {
+	switch {
+	case f == True:
+		return g
+	case f == False:
+		return h
+	case g == h:
+		return g
+	case g == True && h == False:
+		return f
+	}
+	key := [3]Node{f, g, h}
+	if r, ok := m.ite[key]; ok {
+		return r
+	}
+
+	v := m.nodes[f].v
+	if m.nodes[g].v < v {
+		v = m.nodes[g].v
+	}
+	if m.nodes[h].v < v {
+		v = m.nodes[h].v
+	}
+	f0, f1 := m.cofactors(f, v)
+	g0, g1 := m.cofactors(g, v)
+	h0, h1 := m.cofactors(h, v)
+	r := m.mk(v, m.Ite(f0, g0, h0), m.Ite(f1, g1, h1))
+	m.ite[key] = r
+	return r
+}
+
+// Not gets the inverse of f
+func (m *Manager) Not(f Node) Node {
+	return m.Ite(f, False, True)
+}
+
+// And gets the and of fs, which is True if there are none
+func (m *Manager) And(fs ...Node) Node {
+	r := True
+	for _, f := range fs {
+		r = m.Ite(r, f, False)
+	}
+	return r
+}
+
+// Or gets the or of fs, which is False if there are none
+func (m *Manager) Or(fs ...Node) Node {
+	r := False
+	for _, f := range fs {
+		r = m.Ite(r, True, f)
+	}
+	return r
+}
+
+// Xor gets the xor of f and g
+func (m *Manager) Xor(f, g Node) Node {
+	return m.Ite(f, m.Not(g), g)
+}
+
+// Eval gets the value of f for the value of each variable
+func (m *Manager) Eval(f Node, values []bool) bool {
+	for f != False && f != True {
+		n := m.nodes[f]
+		if values[n.v] {
+			f = n.hi
+		} else {
+			f = n.lo
+		}
+	}
+	return f == True
+}
+
+// Size gets the number of nodes of the BDDs of fs, excluding terminals
+func (m *Manager) Size(fs ...Node) int {
+	seen := make(map[Node]bool)
+	var visit func(f Node)
+	visit = func(f Node) {
+		if f == False || f == True || seen[f] {
+			return
+		}
+		seen[f] = true
+		visit(m.nodes[f].lo)
+		visit(m.nodes[f].hi)
+	}
+	for _, f := range fs {
+		visit(f)
+	}
+	return len(seen)
+}
+
+// Count gets the number of assignments of the variables for which f is true
+func (m *Manager) Count(f Node) *big.Int {
+	memo := make(map[Node]*big.Int)
+	// count gets the assignments of the variables from the variable of f
+	var count func(f Node) *big.Int
+	count = func(f Node) *big.Int {
+		if f == False {
+			return big.NewInt(0)
+		} else if f == True {
+			return big.NewInt(1)
+		} else if c, ok := memo[f]; ok {
+			return c
+		}
+		n := m.nodes[f]
+		lo := new(big.Int).Lsh(count(n.lo), uint(m.nodes[n.lo].v-n.v-1))
+		hi := new(big.Int).Lsh(count(n.hi), uint(m.nodes[n.hi].v-n.v-1))
+		c := lo.Add(lo, hi)
+		memo[f] = c
+		return c
+	}
+	return new(big.Int).Lsh(count(f), uint(m.nodes[f].v))
+}
+
+// Satisfy gets values of the variables for which f is true, or nil if f is
+// False. Variables f doesn't depend on are false.
+func (m *Manager) Satisfy(f Node) []bool {
+	if f == False {
+		return nil
+	}
+	values := make([]bool, m.vars)
+	for f != True {
+		n := m.nodes[f]
+		if n.lo == False {
+			values[n.v] = true
+			f = n.hi
+		} else {
+			f = n.lo
+		}
+	}
+	return values
+}
+
+// Cube is a product of literals, with the value of each variable: 1 for the
+// variable, -1 for its inverse, or 0 if it doesn't appear
+type Cube []int8
+
+// Cover gets an irredundant sum of products of f, by the Minato-Morreale
+// algorithm: no cube can be removed, and no literal removed from a cube,
+// without changing the function.
+func (m *Manager) Cover(f Node) []Cube {
+	cubes, _ := m.isop(f, f, make(map[[2]Node]cover))
+	return cubes
+}
+
+type cover struct {
+	cubes []Cube
+	f     Node
+}
+
+// isop covers a function between the lower bound l and upper bound u,
+// giving the cover and its function
+func (m *Manager) isop(l, u Node, memo map[[2]Node]cover) ([]Cube, Node) {
+	if l == False {
+		return []Cube{}, False
+	} else if u == True {
+		return []Cube{make(Cube, m.vars)}, True
+	} else if c, ok := memo[[2]Node{l, u}]; ok {
+		return c.cubes, c.f
+	}
+
+	v := m.nodes[l].v
+	if m.nodes[u].v < v {
+		v = m.nodes[u].v
+	}
+	l0, l1 := m.cofactors(l, v)
+	u0, u1 := m.cofactors(u, v)
+
+	// cubes with the inverse of v, then v, cover what the other cofactor
+	// of u excludes
+	c0, f0 := m.isop(m.And(l0, m.Not(u1)), u0, memo)
+	c1, f1 := m.isop(m.And(l1, m.Not(u0)), u1, memo)
+	// cubes without v cover what remains
+	rest := m.Or(m.And(l0, m.Not(f0)), m.And(l1, m.Not(f1)))
+	cd, fd := m.isop(rest, m.And(u0, u1), memo)
+
+	cubes := make([]Cube, 0, len(c0)+len(c1)+len(cd))
+	for _, c := range c0 {
+		cube := append(Cube{}, c...)
+		cube[v] = -1
+		cubes = append(cubes, cube)
+	}
+	for _, c := range c1 {
+		cube := append(Cube{}, c...)
+		cube[v] = 1
+		cubes = append(cubes, cube)
+	}
+	cubes = append(cubes, cd...)
+
+	x := m.Var(v)
+	f := m.Or(m.And(m.Not(x), f0), m.And(x, f1), fd)
+	memo[[2]Node{l, u}] = cover{cubes, f}
+	return cubes, f
+}
+
+// Function gets the function of a sum of products
+func (m *Manager) Function(cubes []Cube) Node {
+	sum := False
+	for _, cube := range cubes {
+		product := True
+		for v, lit := range cube {
+			if lit == 1 {
+				product = m.And(product, m.Var(v))
+			} else if lit == -1 {
+				product = m.And(product, m.Not(m.Var(v)))
+			}
+		}
+		sum = m.Or(sum, product)
+	}
+	return sum
+}
@@ -0,0 +1,235 @@
+package bdd
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/simulator"
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+const prog = `
+	block full (a d1, b d1, cin d1) -> (s d1, cout d1) {
+		(a, b) xor -> p;
+		(p, cin) xor -> s;
+		(a, b) and -> g;
+		(p, cin) and -> pc;
+		(g, pc) or -> cout;
+	}
+
+	block ripple (a d3, b d3) -> (s d3, c d1) {
+		(a[0], b[0], 0) full -> s[0], c0;
+		(a[1], b[1], c0) full -> s[1], c1;
+		(a[2], b[2], c1) full -> s[2], c;
+	}
+
+	// forgets the carry into the high bit
+	table broken (a d3, b d3) -> (s d3, c d1) {
+		0b-00, 0b-00 ==> 0, 0;
+		0b1--, 0b1-- ==> 0, 1;
+	}
+
+	table half (a d1, b d1) -> (s d1, c d1) {
+		0, 1 ==> 1, 0;
+		1, 0 ==> 1, 0;
+		1, 1 ==> 0, 1;
+	}
+
+	block xnor8 (a d8, b d8) -> (x d8) {
+		(a[0], b[0]) xnor -> x[0];
+		(a[1], b[1]) xnor -> x[1];
+		(a[2], b[2]) xnor -> x[2];
+		(a[3], b[3]) xnor -> x[3];
+		(a[4], b[4]) xnor -> x[4];
+		(a[5], b[5]) xnor -> x[5];
+		(a[6], b[6]) xnor -> x[6];
+		(a[7], b[7]) xnor -> x[7];
+	}
+
+	block and8 (x d8) -> (e d1) {
+		(x[0], x[1]) and -> y0;
+		(x[2], x[3]) and -> y1;
+		(x[4], x[5]) and -> y2;
+		(x[6], x[7]) and -> y3;
+		(y0, y1) and -> z0;
+		(y2, y3) and -> z1;
+		(z0, z1) and -> e;
+	}
+
+	block eq (a d8, b d8) -> (e d1) {
+		(a, b) xnor8 -> x;
+		(x) and8 -> e;
+	}
+
+	reg r (d d1) -> (q d1);
+	block seq (a d3, b d3) -> (s d3, c d1) {
+		(a[0]) r -> c;
+	}
+`
+
+func TestManager(t *testing.T) {
+	m := New(3)
+	a, b, c := m.Var(0), m.Var(1), m.Var(2)
+
+	// canonical forms of the same function are the same node
+	if m.And(a, b) != m.Not(m.Or(m.Not(a), m.Not(b))) {
+		t.Errorf("expected De Morgan's law to hold")
+	}
+	if m.Xor(m.Xor(a, b), b) != a {
+		t.Errorf("expected xor to cancel")
+	}
+
+	maj := m.Or(m.And(a, b), m.And(a, c), m.And(b, c))
+	if m.Count(maj).Int64() != 4 || m.Count(True).Int64() != 8 || m.Count(c).Int64() != 4 {
+		t.Errorf("unexpected counts %v %v %v", m.Count(maj), m.Count(True), m.Count(c))
+	}
+	if m.Size(maj) != 4 {
+		t.Errorf("expected 4 nodes, got %v", m.Size(maj))
+	}
+	if values := m.Satisfy(maj); !m.Eval(maj, values) {
+		t.Errorf("%v does not satisfy the majority", values)
+	}
+	if m.Satisfy(False) != nil {
+		t.Errorf("expected False to be unsatisfiable")
+	}
+
+	m.MaxNodes = m.Nodes()
+	defer func() {
+		if r := recover(); r != ErrTooLarge {
+			t.Errorf("expected ErrTooLarge, got %v", r)
+		}
+	}()
+	m.And(maj, m.Xor(a, c))
+}
+
+func TestCover(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		m := New(5)
+		minterms := make([]Cube, 0)
+		for mt := 0; mt < 32; mt++ {
+			if r.Intn(2) == 0 {
+				continue
+			}
+			cube := make(Cube, 5)
+			for v := range cube {
+				cube[v] = int8(2*((mt>>uint(v))&1) - 1)
+			}
+			minterms = append(minterms, cube)
+		}
+		f := m.Function(minterms)
+
+		cover := m.Cover(f)
+		if m.Function(cover) != f {
+			t.Fatalf("cover %v is not the function", cover)
+		}
+		// no cube or literal is redundant
+		for idx, cube := range cover {
+			rest := append(append([]Cube{}, cover[:idx]...), cover[idx+1:]...)
+			if m.Function(rest) == f {
+				t.Fatalf("cube %v of %v is redundant", cube, cover)
+			}
+			for v := range cube {
+				if cube[v] == 0 {
+					continue
+				}
+				wider := append(Cube{}, cube...)
+				wider[v] = 0
+				if m.Function(append(rest, wider)) == f {
+					t.Fatalf("literal %v of cube %v of %v is redundant", v, cube, cover)
+				}
+			}
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	sizes := make(map[string]int)
+	for _, h := range Heuristics {
+		f, err := Build(h, ast.Blocks["eq"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[h] = f.Size()
+	}
+	// the equality of two words is linear interleaved, and exponential
+	// one after the other
+	if sizes["interleaved"] != 3*8 || sizes["dfs"] != 3*8 || sizes["declared"] < 1<<8 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+
+	f, err := Build("auto", ast.Blocks["eq"])
+	if err != nil || f.Heuristic != "interleaved" {
+		t.Errorf("expected interleaved order, got %v", err)
+	}
+
+	_, err = Build("random", ast.Blocks["eq"])
+	if err == nil || err.Error() != "unknown variable order 'random'" {
+		t.Errorf("expected unknown order, got %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	ce, _, err := Compare(ast.Blocks["ripple"], ast.Blocks["ripple"], "auto")
+	if err != nil || ce != nil {
+		t.Fatalf("expected ripple to be equivalent to itself, got %v, %v", ce, err)
+	}
+
+	ce, count, err := Compare(ast.Blocks["ripple"], ast.Blocks["broken"], "declared")
+	if err != nil || ce == nil {
+		t.Fatalf("expected a counterexample, got %v", err)
+	}
+	// the args broken gets wrong, counted by brute force
+	differ := int64(0)
+	for a := simulator.PortType(0); a < 8; a++ {
+		for b := simulator.PortType(0); b < 8; b++ {
+			s, c := (a+b)&7, (a+b)>>3
+			var bs, bc simulator.PortType
+			if a&3 == 0 && b&3 == 0 {
+				bs, bc = 0, 0
+			} else if a&4 != 0 && b&4 != 0 {
+				bs, bc = 0, 1
+			}
+			if s != bs || c != bc {
+				differ++
+			}
+		}
+	}
+	if count.Int64() != differ {
+		t.Errorf("expected %v differing args, got %v", differ, count)
+	}
+	if s := (ce.Args[0] + ce.Args[1]) & 7; ce.ARets[0] != s {
+		t.Errorf("counterexample %v has the wrong sum", ce)
+	}
+
+	_, _, err = Compare(ast.Blocks["seq"], ast.Blocks["ripple"], "auto")
+	if err == nil || err.Error() != "'seq' is not combinational" {
+		t.Errorf("expected not combinational, got %v", err)
+	}
+}
+
+func TestWriteSOP(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	f, err := Build("declared", ast.Blocks["half"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = f.WriteSOP(buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "s = ~a & b | a & ~b\nc = a & b\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	err = f.WriteSOP(buf, "x")
+	if err == nil || err.Error() != "'half' has no ret 'x'" {
+		t.Errorf("expected no ret, got %v", err)
+	}
+}
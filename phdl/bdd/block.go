@@ -0,0 +1,355 @@
+package bdd
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/equiv"
+	"github.com/petelliott/logiko/phdl/netlist"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// Heuristics are the variable ordering heuristics, in the order "auto" tries
+// them
+var Heuristics = []string{"interleaved", "dfs", "declared"}
+
+// Order gives each bit of the args of a netlist, in the order of Inputs, a
+// variable by a heuristic:
+//   declared     the bits of each arg in turn, least significant first
+//   interleaved  bit 0 of each arg, then bit 1, and so on
+//   dfs          in the order a depth first search from each bit of the
+//                rets reaches them through the gates driving it
+func Order(n *netlist.Netlist, heuristic string) ([]int, error) {
+	input := make(map[netlist.Signal]int)
+	order := make([]int, 0)
+	for _, bits := range n.Inputs {
+		for _, bit := range bits {
+			input[bit] = len(input)
+			if heuristic == "declared" {
+				order = append(order, input[bit])
+			}
+		}
+	}
+
+	switch heuristic {
+	case "declared":
+	case "interleaved":
+		for i := 0; len(order) < len(input); i++ {
+			idx := 0
+			for _, bits := range n.Inputs {
+				if i < len(bits) {
+					order = append(order, idx+i)
+				}
+				idx += len(bits)
+			}
+		}
+	case "dfs":
+		driver := make(map[netlist.Signal]netlist.Gate)
+		for _, gate := range n.Gates {
+			driver[gate.Out] = gate
+		}
+		seen := make(map[netlist.Signal]bool)
+		var visit func(sig netlist.Signal)
+		visit = func(sig netlist.Signal) {
+			if seen[sig] {
+				return
+			}
+			seen[sig] = true
+			if idx, ok := input[sig]; ok {
+				order = append(order, idx)
+			}
+			for _, in := range driver[sig].Ins {
+				visit(in)
+			}
+		}
+		for _, bits := range n.Outputs {
+			for _, bit := range bits {
+				visit(bit)
+			}
+		}
+		// args no ret depends on
+		for _, bits := range n.Inputs {
+			for _, bit := range bits {
+				if !seen[bit] {
+					order = append(order, input[bit])
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown variable order '%s'", heuristic)
+	}
+
+	vars := make([]int, len(order))
+	for v, idx := range order {
+		vars[idx] = v
+	}
+	return vars, nil
+}
+
+// Netlist builds the BDD of every signal of a combinational netlist, with
+// the variable of each bit of its args, in the order of Inputs, given by vars
+func (m *Manager) Netlist(n *netlist.Netlist, vars []int) []Node {
+	nodes := make([]Node, n.Signals())
+	nodes[netlist.False] = False
+	nodes[netlist.True] = True
+	idx := 0
+	for _, bits := range n.Inputs {
+		for _, bit := range bits {
+			nodes[bit] = m.Var(vars[idx])
+			idx++
+		}
+	}
+
+	for _, gate := range n.Gates {
+		ins := make([]Node, len(gate.Ins))
+		for i, in := range gate.Ins {
+			ins[i] = nodes[in]
+		}
+
+		switch gate.Op {
+		case "buf":
+			nodes[gate.Out] = ins[0]
+		case "not":
+			nodes[gate.Out] = m.Not(ins[0])
+		case "and":
+			nodes[gate.Out] = m.And(ins...)
+		case "nand":
+			nodes[gate.Out] = m.Not(m.And(ins...))
+		case "or":
+			nodes[gate.Out] = m.Or(ins...)
+		case "nor":
+			nodes[gate.Out] = m.Not(m.Or(ins...))
+		case "xor":
+			nodes[gate.Out] = m.Xor(ins[0], ins[1])
+		case "xnor":
+			nodes[gate.Out] = m.Not(m.Xor(ins[0], ins[1]))
+		default:
+			panic(fmt.Sprintf("unknown gate '%s'", gate.Op))
+		}
+	}
+	return nodes
+}
+
+// Function is the BDDs of the rets of combinational blocks with the same
+// signature, in one manager
+type Function struct {
+	*Manager
+	Blocks    []*phdl.AstBlock
+	Heuristic string     // the variable order
+	Names     []string   // of each bit of the args, e.g. a[1]
+	Vars      []int      // the variable of each bit of the args
+	Rets      [][][]Node // of each bit of each ret of each block
+}
+
+// Build builds the BDDs of blocks, with variables ordered by a heuristic of
+// Order, or "auto" to try each heuristic and keep the smallest BDDs
+func Build(heuristic string, blocks ...*phdl.AstBlock) (*Function, error) {
+	netlists := make([]*netlist.Netlist, len(blocks))
+	for idx, block := range blocks {
+		err := elab.SameSignature(blocks[0], block)
+		if err != nil {
+			return nil, err
+		} else if block.Sequential() {
+			return nil, fmt.Errorf("'%s' is not combinational", block.Name)
+		}
+		netlists[idx], err = netlist.Flatten(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if heuristic != "auto" {
+		return build(heuristic, blocks, netlists)
+	}
+	var best *Function
+	var err error
+	for _, h := range Heuristics {
+		f, herr := build(h, blocks, netlists)
+		if herr != nil {
+			err = herr
+		} else if best == nil || f.Size() < best.Size() {
+			best = f
+		}
+	}
+	if best == nil {
+		return nil, err
+	}
+	return best, nil
+}
+
+// build builds the BDDs of netlists of blocks, ordering variables by the
+// first
+func build(heuristic string, blocks []*phdl.AstBlock, netlists []*netlist.Netlist) (f *Function, err error) {
+	vars, err := Order(netlists[0], heuristic)
+	if err != nil {
+		return nil, err
+	}
+	f = &Function{
+		Manager:   New(len(vars)),
+		Blocks:    blocks,
+		Heuristic: heuristic,
+		Names:     make([]string, 0, len(vars)),
+		Vars:      vars,
+		Rets:      make([][][]Node, len(blocks)),
+	}
+	for _, bits := range netlists[0].Inputs {
+		for _, bit := range bits {
+			f.Names = append(f.Names, netlists[0].Names[bit])
+		}
+	}
+
+	defer f.tooLarge(&err)
+	for idx, n := range netlists {
+		nodes := f.Netlist(n, vars)
+		f.Rets[idx] = make([][]Node, len(n.Outputs))
+		for ridx, bits := range n.Outputs {
+			f.Rets[idx][ridx] = make([]Node, len(bits))
+			for i, bit := range bits {
+				f.Rets[idx][ridx][i] = nodes[bit]
+			}
+		}
+	}
+	return f, nil
+}
+
+// tooLarge sets err if the deferring function panicked with ErrTooLarge
+func (f *Function) tooLarge(err *error) {
+	if r := recover(); r == ErrTooLarge {
+		*err = fmt.Errorf("more than %v BDD nodes with %s order", f.MaxNodes, f.Heuristic)
+	} else if r != nil {
+		panic(r)
+	}
+}
+
+// Size gets the number of nodes of the BDDs of the rets
+func (f *Function) Size() int {
+	roots := make([]Node, 0)
+	for _, rets := range f.Rets {
+		for _, bits := range rets {
+			roots = append(roots, bits...)
+		}
+	}
+	return f.Manager.Size(roots...)
+}
+
+// values gets the values of the variables for args
+func (f *Function) values(args []simulator.PortType) []bool {
+	values := make([]bool, f.Manager.Vars())
+	idx := 0
+	for aidx, arg := range f.Blocks[0].Args {
+		for i := 0; i < arg.Width; i++ {
+			values[f.Vars[idx]] = (args[aidx]>>uint(i))&1 == 1
+			idx++
+		}
+	}
+	return values
+}
+
+// Eval gets the rets of block idx for args
+func (f *Function) Eval(idx int, args []simulator.PortType) []simulator.PortType {
+	values := f.values(args)
+	rets := make([]simulator.PortType, len(f.Rets[idx]))
+	for ridx, bits := range f.Rets[idx] {
+		for i, bit := range bits {
+			if f.Manager.Eval(bit, values) {
+				rets[ridx] |= 1 << uint(i)
+			}
+		}
+	}
+	return rets
+}
+
+// Compare decides if two combinational blocks with the same signature give
+// the same rets for all args. It gives nil if they do, or a counterexample
+// and the number of args for which they differ.
+func Compare(a, b *phdl.AstBlock, heuristic string) (ce *equiv.Counterexample, count *big.Int, err error) {
+	f, err := Build(heuristic, a, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.tooLarge(&err)
+
+	differ := False
+	for ridx, bits := range f.Rets[0] {
+		for i, bit := range bits {
+			differ = f.Or(differ, f.Xor(bit, f.Rets[1][ridx][i]))
+		}
+	}
+	if differ == False {
+		return nil, nil, nil
+	}
+
+	values := f.Satisfy(differ)
+	args := make([]simulator.PortType, len(a.Args))
+	idx := 0
+	for aidx, arg := range a.Args {
+		for i := 0; i < arg.Width; i++ {
+			if values[f.Vars[idx]] {
+				args[aidx] |= 1 << uint(i)
+			}
+			idx++
+		}
+	}
+	return &equiv.Counterexample{
+		A:     a,
+		B:     b,
+		Args:  args,
+		ARets: f.Eval(0, args),
+		BRets: f.Eval(1, args),
+	}, f.Count(differ), nil
+}
+
+// SOP formats a cover as a sum of products of the bits of the args, such as
+// a[0] & ~b[0] | ~a[0] & b[0], with literals in the order of the args
+func (f *Function) SOP(cubes []Cube) string {
+	if len(cubes) == 0 {
+		return "0"
+	}
+	products := make([]string, len(cubes))
+	for cidx, cube := range cubes {
+		lits := make([]string, 0)
+		for idx, v := range f.Vars {
+			if cube[v] == 1 {
+				lits = append(lits, f.Names[idx])
+			} else if cube[v] == -1 {
+				lits = append(lits, "~"+f.Names[idx])
+			}
+		}
+		if len(lits) == 0 {
+			products[cidx] = "1"
+		} else {
+			products[cidx] = strings.Join(lits, " & ")
+		}
+	}
+	return strings.Join(products, " | ")
+}
+
+// WriteSOP writes a minimized sum of products of each bit of a ret of the
+// first block, or of every ret if ret is ""
+func (f *Function) WriteSOP(w io.Writer, ret string) (err error) {
+	defer f.tooLarge(&err)
+	found := false
+	for ridx, conn := range f.Blocks[0].Rets {
+		if ret != "" && conn.Name != ret {
+			continue
+		}
+		found = true
+		for i, bit := range f.Rets[0][ridx] {
+			name := conn.Name
+			if conn.Width != 1 {
+				name = fmt.Sprintf("%s[%v]", conn.Name, i)
+			}
+			_, err = fmt.Fprintf(w, "%s = %s\n", name, f.SOP(f.Cover(bit)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("'%s' has no ret '%s'", f.Blocks[0].Name, ret)
+	}
+	return nil
+}
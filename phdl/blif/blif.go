@@ -0,0 +1,178 @@
+// Package blif translates between PHDL and the Berkeley Logic Interchange
+// Format, for use with logic synthesis tools such as ABC and SIS
+package blif
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/netlist"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Clock is the name of the input added to models of sequential blocks, which
+// clocks every latch
+const Clock = "clk"
+
+// cover gets the rows of the single output cover of a gate of n ins
+func cover(op string, n int) []string {
+	switch op {
+	case "buf":
+		return []string{"1 1"}
+	case "not":
+		return []string{"0 1"}
+	case "xor":
+		return []string{"10 1", "01 1"}
+	case "xnor":
+		return []string{"11 1", "00 1"}
+	case "and", "nor":
+		bit := "1"
+		if op == "nor" {
+			bit = "0"
+		}
+		if n == 0 {
+			return []string{"1"}
+		}
+		return []string{strings.Repeat(bit, n) + " 1"}
+	}
+
+	// or and nand, with a row for each in
+	bit := "1"
+	if op == "nand" {
+		bit = "0"
+	}
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = strings.Repeat("-", i) + bit + strings.Repeat("-", n-i-1) + " 1"
+	}
+	return rows
+}
+
+// live marks the signals that the outputs depend on, through gates and
+// latches, leaving out the logic of asserts
+func live(n *netlist.Netlist) []bool {
+	drivers := make(map[netlist.Signal][]netlist.Signal)
+	for _, gate := range n.Gates {
+		drivers[gate.Out] = gate.Ins
+	}
+	for _, latch := range n.Latches {
+		drivers[latch.Q] = []netlist.Signal{latch.D}
+	}
+
+	marked := make([]bool, n.Signals())
+	stack := make([]netlist.Signal, 0)
+	for _, bits := range n.Outputs {
+		stack = append(stack, bits...)
+	}
+	for len(stack) != 0 {
+		sig := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !marked[sig] {
+			marked[sig] = true
+			stack = append(stack, drivers[sig]...)
+		}
+	}
+	return marked
+}
+
+type writer struct {
+	w         io.Writer
+	n         *netlist.Netlist
+	constants [2]bool // constant signals in use
+}
+
+// names gets the names of signals, noting the constants in use
+func (wr *writer) names(sigs ...netlist.Signal) []string {
+	names := make([]string, len(sigs))
+	for idx, sig := range sigs {
+		if sig == netlist.False || sig == netlist.True {
+			wr.constants[sig] = true
+		}
+		names[idx] = wr.n.Names[sig]
+	}
+	return names
+}
+
+func (wr *writer) cover(ins []string, out string, rows []string) {
+	fmt.Fprintf(wr.w, ".names %s\n", strings.Join(append(ins, out), " "))
+	for _, row := range rows {
+		fmt.Fprintln(wr.w, row)
+	}
+}
+
+// Write writes a type checked block as a BLIF model of its netlist, with every
+// sub-block flattened into it. Table blocks are synthesized to gates, roms
+// become a sum of products for each bit of data, and regs become a latch for
+// each bit. Sequential blocks get an extra input clocking the latches. Only
+// the logic the rets depend on is written, so asserts are left out. Rams,
+// extern blocks and combinational loops can't be written.
+func Write(w io.Writer, block *phdl.AstBlock) error {
+	clocked := block.Sequential()
+	if _, ok := block.Vars[Clock]; ok && clocked {
+		return fmt.Errorf("Write: block '%s': conn '%s' conflicts with clock input",
+			block.Name, Clock)
+	}
+
+	n, err := netlist.Flatten(block)
+	if err != nil {
+		return fmt.Errorf("Write: %s", err)
+	}
+	wr := &writer{w: w, n: n}
+
+	inputs := make([]string, 0)
+	if clocked {
+		inputs = append(inputs, Clock)
+	}
+	for _, bits := range n.Inputs {
+		inputs = append(inputs, wr.names(bits...)...)
+	}
+	outputs := make([]string, 0)
+	for _, ret := range block.Rets {
+		for i := 0; i < ret.Width; i++ {
+			outputs = append(outputs, netlist.BitName(ret, i))
+		}
+	}
+
+	fmt.Fprintf(w, ".model %s\n", block.Name)
+	fmt.Fprintf(w, ".inputs %s\n", strings.Join(inputs, " "))
+	fmt.Fprintf(w, ".outputs %s\n", strings.Join(outputs, " "))
+
+	marked := live(n)
+	for _, gate := range n.Gates {
+		if marked[gate.Out] {
+			wr.cover(wr.names(gate.Ins...), n.Names[gate.Out], cover(gate.Op, len(gate.Ins)))
+		}
+	}
+	for _, latch := range n.Latches {
+		if !marked[latch.Q] {
+			continue
+		}
+		init := 0
+		if latch.Init {
+			init = 1
+		}
+		fmt.Fprintf(w, ".latch %s %s re %s %v\n",
+			wr.names(latch.D)[0], n.Names[latch.Q], Clock, init)
+	}
+
+	// bits of rets that are never driven are False in the netlist, so they
+	// are buffered from it
+	idx := 0
+	for _, bits := range n.Outputs {
+		for _, sig := range bits {
+			if n.Names[sig] != outputs[idx] {
+				wr.cover(wr.names(sig), outputs[idx], cover("buf", 1))
+			}
+			idx++
+		}
+	}
+
+	if wr.constants[netlist.False] {
+		wr.cover(nil, n.Names[netlist.False], nil)
+	}
+	if wr.constants[netlist.True] {
+		wr.cover(nil, n.Names[netlist.True], []string{"1"})
+	}
+	_, err = fmt.Fprintln(w, ".end")
+	return err
+}
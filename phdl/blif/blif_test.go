@@ -0,0 +1,272 @@
+package blif
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/format"
+	"github.com/petelliott/logiko/simulator"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ast := checks.MustCompile(`
+		table maj (a d1, b d1, c d1) -> (o d1) {
+			1, 1, 0b- ==> 1;
+			1, 0b-, 1 ==> 1;
+			0b-, 1, 1 ==> 1;
+		}
+
+		rom sq (a d2) -> (d d4) = { 0, 1, 4, 9 };
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) nand -> nc;
+			(nc) not -> c;
+		}
+
+		block full (cin d1, a d2) -> (s d1, cout d1, m d1, v d3, q d4) {
+			(a[0], a[1]) half -> t, c1;
+			(t, cin) half -> s, c2;
+			(c1, c2) or -> cout;
+			(cin, a[0], a[1]) maj -> m;
+			(1) buf -> v[2];
+			(t) buf -> v[0];
+			(0) buf -> v[1];
+			(a) sq -> q;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Write(&b, ast.Blocks["full"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		".model full\n.inputs cin a[0] a[1]\n",
+		".names a[0] a[1] half0.nc\n0- 1\n-0 1\n",
+		".names $false\n.names $true\n1\n.end\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected '%s' in:\n%s", s, b.String())
+		}
+	}
+
+	imported, err := Import(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := imported.Blocks["full"]
+	names := make([]string, 0)
+	for _, conn := range append(full.Args, full.Rets...) {
+		names = append(names, conn.Name)
+	}
+	if strings.Join(names, " ") != "cin a s cout m v q" {
+		t.Errorf("unexpected ports %v", names)
+	}
+
+	ref, err := elab.BlockReference(full, ast.Blocks["full"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := elab.Exhaustive(full, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+
+	// written as PHDL, the import compiles to an equivalent block
+	var source bytes.Buffer
+	err = format.FprintFile(&source, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := checks.MustCompile(source.String()).Blocks["full"]
+	ref, err = elab.BlockReference(written, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = elab.Exhaustive(written, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
+
+func TestRoundTripRegister(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d1) -> (q d1) = { 1 };
+
+		block ring () -> (q d3) {
+			(x) r -> q[0];
+			(q[0]) r -> q[1];
+			(q[1]) r -> q[2];
+			(q[2]) not -> x;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Write(&b, ast.Blocks["ring"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), ".inputs clk\n") ||
+		!strings.Contains(b.String(), ".latch x q[0] re clk 1\n") {
+		t.Errorf("unexpected model:\n%s", b.String())
+	}
+
+	imported, err := Import(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := imported.Blocks["ring"]
+	if len(ring.Args) != 0 {
+		t.Errorf("expected clock to be dropped, got args %v", ring.Args)
+	}
+	sim, err := elab.Simulate(ring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []simulator.PortType{7, 6, 4, 0, 1, 3, 7} {
+		if got, _ := sim.Read("q"); got != q {
+			t.Errorf("expected %v, got %v", q, got)
+		}
+		sim.Step()
+	}
+}
+
+func TestImport(t *testing.T) {
+	src := `
+# a 2 bit adder with a registered carry
+.model top
+.inputs clock x[0] x[1] \
+        y[0] y[1]
+.outputs sum[0] sum[1] co
+.subckt fa a=x[0] b=y[0] ci=$false s=sum[0] co=c0
+.subckt fa a=x[1] b=y[1] ci=c0 s=sum[1] co=c1
+.subckt hold d=c1 q=co clk=clock
+.names $false
+.end
+
+.model hold
+.inputs d clk
+.outputs q
+.latch d q re clk 0
+.end
+
+.model fa
+.inputs a b ci
+.outputs s co
+.names a b ci s
+100 1
+010 1
+001 1
+111 1
+.names a b ci co  # off-set cover of the carry
+00- 0
+0-0 0
+-00 0
+.names a b ab
+11 1
+.end
+`
+	ast, err := Import(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fa := ast.Blocks["fa"]
+	if len(fa.Args) != 3 || fa.Stmts[2].Op != phdl.Builtins["and"] {
+		t.Errorf("unexpected block %v", fa)
+	}
+	for _, op := range []string{"cover", "cover_1", "latch0"} {
+		if _, ok := ast.Blocks[op]; !ok {
+			t.Errorf("expected block '%s'", op)
+		}
+	}
+
+	top := ast.Blocks["top"]
+	if len(top.Args) != 2 || top.Args[0].Name != "x" || top.Args[0].Width != 2 {
+		t.Errorf("unexpected args %v", top.Args)
+	}
+
+	sim, err := elab.Simulate(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := simulator.PortType(0); x < 4; x++ {
+		for y := simulator.PortType(0); y < 4; y++ {
+			sim.Write("x", x)
+			sim.Write("y", y)
+			if got, _ := sim.Read("sum"); got != (x+y)&3 {
+				t.Errorf("%v+%v: expected sum %v, got %v", x, y, (x+y)&3, got)
+			}
+			sim.Step()
+			if got, _ := sim.Read("co"); got != (x+y)>>2 {
+				t.Errorf("%v+%v: expected carry %v, got %v", x, y, (x+y)>>2, got)
+			}
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	ast := checks.MustCompile(`
+		ram mem (addr d2, data d1, we d1) -> (out d1);
+		reg r (d d1) -> (q d1);
+
+		block a (x d2) -> (y d1) {
+			(x, 0, 0) mem -> y;
+		}
+
+		block b (clk d1) -> (q d1) {
+			(clk) r -> q;
+		}
+	`)
+
+	for _, name := range []string{"a", "b"} {
+		err := Write(&bytes.Buffer{}, ast.Blocks[name])
+		if err == nil {
+			t.Errorf("expected error writing '%s'", name)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, src := range []string{
+		".model m\n.inputs a\n.outputs b\n.names a b\n1 1\n0 0\n.end\n",
+		".model m\n.inputs a\n.outputs b\n.names a b\n11 1\n.end\n",
+		".model m\n.inputs a c\n.outputs b\n.latch a b fe c 0\n.end\n",
+		".model m\n.inputs a\n.outputs b\n.latch a b re c 0\n.end\n",
+		".model m\n.outputs b\n.subckt n x=b\n.end\n",
+		".model m\n.outputs b\n.subckt m x=b\n.end\n",
+		".model m\n.outputs b\n.gate and2 a=b\n.end\n",
+		"1 1\n",
+		"",
+	} {
+		_, err := Import(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error importing '%s'", src)
+		}
+	}
+}
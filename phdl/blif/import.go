@@ -0,0 +1,721 @@
+package blif
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// entry is a .names, .latch or .subckt of a model
+type entry struct {
+	line    int
+	kind    string
+	signals []string // inputs then output of a cover, or input then output of a latch
+	rows    []string // cover rows, input plane then output
+	control string   // latch clock
+	init    int64
+	model   string
+	binds   [][2]string // formal then actual signal of a subckt
+}
+
+type model struct {
+	line    int
+	name    string
+	inputs  []string
+	outputs []string
+	entries []*entry
+}
+
+// port is a conn of a block and the BLIF signals of its bits
+type port struct {
+	conn  *phdl.AstConn
+	bits  []string
+	input bool
+}
+
+// lines reads the logical lines of BLIF, without comments and joined at
+// continuations, as the number of their first line and their fields
+func lines(r io.Reader, fn func(int, []string) error) error {
+	scanner := bufio.NewScanner(r)
+	var cont []string
+	start := 0
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if idx := strings.IndexByte(text, '#'); idx != -1 {
+			text = text[:idx]
+		}
+		if cont == nil {
+			start = n
+		}
+		text = strings.TrimRight(text, " \t\r")
+		more := strings.HasSuffix(text, "\\")
+		cont = append(cont, strings.Fields(strings.TrimSuffix(text, "\\"))...)
+		if more {
+			continue
+		}
+		if len(cont) != 0 {
+			if err := fn(start, cont); err != nil {
+				return err
+			}
+		}
+		cont = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(cont) != 0 {
+		return fn(start, cont)
+	}
+	return nil
+}
+
+func parse(r io.Reader) ([]*model, error) {
+	models := make([]*model, 0)
+	var mod *model
+	var cover *entry
+	err := lines(r, func(line int, fields []string) error {
+		directive := fields[0]
+		if directive[0] != '.' {
+			if cover == nil {
+				return fmt.Errorf("line %v: cover row outside of .names", line)
+			}
+			return cover.row(line, fields)
+		}
+		cover = nil
+
+		if directive == ".model" {
+			if mod != nil {
+				return fmt.Errorf("line %v: .model inside model '%s'", line, mod.name)
+			} else if len(fields) != 2 {
+				return fmt.Errorf("line %v: expected model name", line)
+			}
+			mod = &model{line: line, name: fields[1]}
+			models = append(models, mod)
+			return nil
+		} else if mod == nil {
+			return fmt.Errorf("line %v: %s outside of model", line, directive)
+		}
+
+		switch directive {
+		case ".inputs":
+			mod.inputs = append(mod.inputs, fields[1:]...)
+		case ".outputs":
+			mod.outputs = append(mod.outputs, fields[1:]...)
+		case ".clock":
+			// every latch is clocked by the implicit clock
+		case ".names":
+			if len(fields) < 2 {
+				return fmt.Errorf("line %v: .names without output", line)
+			}
+			cover = &entry{line: line, kind: "names", signals: fields[1:]}
+			mod.entries = append(mod.entries, cover)
+		case ".latch":
+			latch, err := parseLatch(line, fields)
+			if err != nil {
+				return err
+			}
+			mod.entries = append(mod.entries, latch)
+		case ".subckt":
+			if len(fields) < 2 {
+				return fmt.Errorf("line %v: .subckt without model", line)
+			}
+			sub := &entry{line: line, kind: "subckt", model: fields[1]}
+			for _, bind := range fields[2:] {
+				idx := strings.IndexByte(bind, '=')
+				if idx <= 0 || idx == len(bind)-1 {
+					return fmt.Errorf("line %v: invalid binding '%s'", line, bind)
+				}
+				sub.binds = append(sub.binds, [2]string{bind[:idx], bind[idx+1:]})
+			}
+			mod.entries = append(mod.entries, sub)
+		case ".end":
+			mod = nil
+		default:
+			return fmt.Errorf("line %v: unsupported directive '%s'", line, directive)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if len(models) == 0 {
+		return nil, fmt.Errorf("no models")
+	}
+	return models, nil
+}
+
+// row adds a row to a cover, checking it against the inputs and the other
+// rows
+func (e *entry) row(line int, fields []string) error {
+	k := len(e.signals) - 1
+	plane := ""
+	if k != 0 {
+		if len(fields) != 2 {
+			return fmt.Errorf("line %v: expected input plane and output", line)
+		}
+		plane = fields[0]
+	} else if len(fields) != 1 {
+		return fmt.Errorf("line %v: expected output", line)
+	}
+	out := fields[len(fields)-1]
+
+	if len(plane) != k || strings.Trim(plane, "01-") != "" {
+		return fmt.Errorf("line %v: invalid input plane '%s' for %v inputs", line, plane, k)
+	} else if out != "0" && out != "1" {
+		return fmt.Errorf("line %v: invalid output '%s'", line, out)
+	} else if len(e.rows) != 0 && e.rows[0][k] != out[0] {
+		return fmt.Errorf("line %v: cover mixes on-set and off-set rows", line)
+	}
+	e.rows = append(e.rows, plane+out)
+	return nil
+}
+
+// parseLatch parses a .latch with an optional type and control, and an
+// optional initial value. Only rising edge latches are supported, and
+// unknown initial values are read as 0.
+func parseLatch(line int, fields []string) (*entry, error) {
+	latch := &entry{line: line, kind: "latch"}
+	if len(fields) < 3 || len(fields) > 6 {
+		return nil, fmt.Errorf("line %v: expected latch input and output", line)
+	}
+	latch.signals = fields[1:3]
+	rest := fields[3:]
+	if len(rest) >= 2 {
+		if rest[0] != "re" {
+			return nil, fmt.Errorf("line %v: unsupported latch type '%s'", line, rest[0])
+		} else if rest[1] != "NIL" {
+			latch.control = rest[1]
+		}
+		rest = rest[2:]
+	}
+	if len(rest) == 1 {
+		switch rest[0] {
+		case "0", "2", "3":
+		case "1":
+			latch.init = 1
+		default:
+			return nil, fmt.Errorf("line %v: invalid initial value '%s'", line, rest[0])
+		}
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("line %v: invalid latch", line)
+	}
+	return latch, nil
+}
+
+// Import reads the models of a BLIF netlist into a PHDL file. Covers become
+// builtins where they match one, and otherwise table blocks. Latches become
+// reg blocks, all clocked by the implicit clock, so inputs used only as latch
+// clocks are dropped. Signals named x[0] to x[n-1] are grouped into a conn x
+// of width n.
+func Import(r io.Reader) (*phdl.AstFile, error) {
+	models, err := parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("Import: %s", err)
+	}
+
+	imp := &importer{
+		file: &phdl.AstFile{
+			Blocks:  make(map[string]*phdl.AstBlock),
+			Tests:   make(map[string]*phdl.AstTest),
+			Bundles: make(map[string]*phdl.AstBundle),
+			Enums:   make(map[string]*phdl.AstEnum),
+		},
+		models:  make(map[string]*model),
+		blocks:  make(map[string]*phdl.AstBlock),
+		ports:   make(map[string][]*port),
+		clocks:  make(map[string]map[string]bool),
+		pending: make(map[string]bool),
+		tables:  make(map[string]*phdl.AstBlock),
+		regs:    make(map[int64]*phdl.AstBlock),
+		names:   phdl.NewNamer(),
+	}
+	for _, mod := range models {
+		if _, ok := imp.models[mod.name]; ok {
+			return nil, fmt.Errorf("Import: line %v: model '%s' redefined", mod.line, mod.name)
+		}
+		imp.models[mod.name] = mod
+	}
+	for _, mod := range models {
+		if _, err := imp.model(mod.name); err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+	}
+	return imp.file, nil
+}
+
+type importer struct {
+	file    *phdl.AstFile
+	models  map[string]*model
+	blocks  map[string]*phdl.AstBlock  // by model name
+	ports   map[string][]*port         // by model name
+	clocks  map[string]map[string]bool // dropped clock inputs by model name
+	pending map[string]bool
+	tables  map[string]*phdl.AstBlock // by cover
+	regs    map[int64]*phdl.AstBlock  // by initial value
+	names   *phdl.Namer
+}
+
+var busRegexp = regexp.MustCompile(`^(.*)\[([0-9]+)\]$`)
+
+// group groups signals into the names and bits of conns
+func group(signals []string) [][]string {
+	bases := make([]string, 0)
+	buses := make(map[string][]string)
+	for _, signal := range signals {
+		base := signal
+		if match := busRegexp.FindStringSubmatch(signal); match != nil {
+			base = match[1] + "[]"
+		}
+		if _, ok := buses[base]; !ok {
+			bases = append(bases, base)
+		}
+		buses[base] = append(buses[base], signal)
+	}
+
+	groups := make([][]string, 0)
+	for _, base := range bases {
+		bits := buses[base]
+		if !strings.HasSuffix(base, "[]") {
+			for _, bit := range bits {
+				groups = append(groups, []string{bit, bit})
+			}
+			continue
+		}
+
+		// a bus if the indices are exactly 0 to n-1
+		ordered := make([]string, len(bits))
+		for _, bit := range bits {
+			i, err := strconv.Atoi(busRegexp.FindStringSubmatch(bit)[2])
+			if err != nil || i >= len(bits) || ordered[i] != "" {
+				ordered = nil
+				break
+			}
+			ordered[i] = bit
+		}
+		if ordered == nil {
+			for _, bit := range bits {
+				groups = append(groups, []string{bit, bit})
+			}
+		} else {
+			groups = append(groups, append([]string{strings.TrimSuffix(base, "[]")}, ordered...))
+		}
+	}
+	return groups
+}
+
+// model converts a model, after the models it uses
+func (imp *importer) model(name string) (*phdl.AstBlock, error) {
+	if block, ok := imp.blocks[name]; ok {
+		return block, nil
+	} else if imp.pending[name] {
+		return nil, fmt.Errorf("model '%s' uses itself", name)
+	}
+	mod, ok := imp.models[name]
+	if !ok {
+		return nil, fmt.Errorf("model '%s' not defined", name)
+	}
+	imp.pending[name] = true
+
+	// latch clocks, and the signals bound to the clocks of sub-models, are
+	// the implicit clock
+	controls := make(map[string]bool)
+	uses := make(map[string]int)
+	for _, signal := range mod.outputs {
+		uses[signal]++
+	}
+	for _, e := range mod.entries {
+		switch e.kind {
+		case "names":
+			for _, signal := range e.signals[:len(e.signals)-1] {
+				uses[signal]++
+			}
+		case "latch":
+			uses[e.signals[0]]++
+			if e.control != "" {
+				controls[e.control] = true
+			}
+		case "subckt":
+			if _, err := imp.model(e.model); err != nil {
+				return nil, fmt.Errorf("model '%s': line %v: %s", name, e.line, err)
+			}
+			for _, bind := range e.binds {
+				if imp.clocks[e.model][bind[0]] {
+					controls[bind[1]] = true
+				} else {
+					uses[bind[1]]++
+				}
+			}
+		}
+	}
+
+	inputs := make(map[string]bool)
+	clocks := make(map[string]bool)
+	args := make([]string, 0)
+	for _, signal := range mod.inputs {
+		inputs[signal] = true
+		if controls[signal] && uses[signal] == 0 {
+			clocks[signal] = true
+		} else {
+			args = append(args, signal)
+		}
+	}
+	for control := range controls {
+		if !inputs[control] {
+			return nil, fmt.Errorf("model '%s': clock '%s' is not an input", name, control)
+		}
+	}
+
+	block := &phdl.AstBlock{
+		Name:  imp.names.Name(name),
+		Args:  make([]*phdl.AstConn, 0),
+		Rets:  make([]*phdl.AstConn, 0),
+		Vars:  make(map[string]*phdl.AstConn),
+		Stmts: make([]*phdl.AstStmt, 0),
+	}
+	m := &lowering{
+		imp:     imp,
+		block:   block,
+		names:   phdl.NewNamer(),
+		signals: make(map[string]*phdl.AstExpr),
+	}
+
+	ports := make([]*port, 0)
+	for _, bits := range group(args) {
+		conn := m.conn(bits[0], len(bits)-1)
+		block.Args = append(block.Args, conn)
+		ports = append(ports, &port{conn: conn, bits: bits[1:], input: true})
+		for i, bit := range bits[1:] {
+			m.signals[bit] = connBit(conn, i)
+		}
+	}
+
+	// outputs that are inputs or other outputs are driven by a buf
+	aliases := make([][2]*phdl.AstExpr, 0)
+	for _, bits := range group(mod.outputs) {
+		conn := m.conn(bits[0], len(bits)-1)
+		block.Rets = append(block.Rets, conn)
+		ports = append(ports, &port{conn: conn, bits: bits[1:]})
+		for i, bit := range bits[1:] {
+			if expr, ok := m.signals[bit]; ok {
+				aliases = append(aliases, [2]*phdl.AstExpr{expr, connBit(conn, i)})
+			} else {
+				m.signals[bit] = connBit(conn, i)
+			}
+		}
+	}
+
+	internal := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range mod.entries {
+		signals := e.signals
+		for _, bind := range e.binds {
+			if !imp.clocks[e.model][bind[0]] {
+				signals = append(signals, bind[1])
+			}
+		}
+		for _, signal := range signals {
+			if _, ok := m.signals[signal]; !ok && !seen[signal] {
+				seen[signal] = true
+				internal = append(internal, signal)
+			}
+		}
+	}
+	for _, bits := range group(internal) {
+		conn := m.conn(bits[0], len(bits)-1)
+		for i, bit := range bits[1:] {
+			m.signals[bit] = connBit(conn, i)
+		}
+	}
+
+	for _, e := range mod.entries {
+		var err error
+		switch e.kind {
+		case "names":
+			m.cover(e)
+		case "latch":
+			m.latch(e)
+		case "subckt":
+			err = m.subckt(e)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("model '%s': line %v: %s", name, e.line, err)
+		}
+	}
+
+	for _, alias := range aliases {
+		m.gate("buf", alias[1], alias[0])
+	}
+
+	imp.blocks[name] = block
+	imp.ports[name] = ports
+	imp.clocks[name] = clocks
+	imp.file.Blocks[block.Name] = block
+	delete(imp.pending, name)
+	return block, nil
+}
+
+// lowering converts the entries of one model into PHDL statements
+type lowering struct {
+	imp     *importer
+	block   *phdl.AstBlock
+	names   *phdl.Namer
+	signals map[string]*phdl.AstExpr // the d1 expr of each signal
+}
+
+func (m *lowering) conn(name string, width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Name(name), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func (m *lowering) temp(width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Temp(), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func connBit(conn *phdl.AstConn, i int) *phdl.AstExpr {
+	if conn.Width == 1 {
+		return &phdl.AstExpr{Conn: conn, Lo: -1}
+	}
+	return &phdl.AstExpr{Conn: conn, Lo: i, Hi: i}
+}
+
+func literal(value int64) *phdl.AstExpr {
+	return &phdl.AstExpr{Literal: value, Lo: -1}
+}
+
+func (m *lowering) gate(op string, ret *phdl.AstExpr, args ...*phdl.AstExpr) {
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: args,
+		Op:   phdl.Builtins[op],
+		Rets: []*phdl.AstExpr{ret},
+	})
+}
+
+// eval evaluates a cover for inputs, bit i of which is input i
+func eval(rows []string, inputs int) int64 {
+	for _, row := range rows {
+		k := len(row) - 1
+		match := true
+		for i := 0; i < k && match; i++ {
+			bit := byte('0' + (inputs>>uint(i))&1)
+			match = row[i] == '-' || row[i] == bit
+		}
+		if match {
+			return int64(row[k] - '0')
+		}
+	}
+	if len(rows) != 0 && rows[0][len(rows[0])-1] == '0' {
+		return 1
+	}
+	return 0
+}
+
+// truthTable gets the outputs of a cover of k inputs, bit i of which is the
+// output for inputs i
+func truthTable(rows []string, k int) int64 {
+	var tt int64
+	for i := 0; i < 1<<uint(k); i++ {
+		tt |= eval(rows, i) << uint(i)
+	}
+	return tt
+}
+
+func (m *lowering) cover(e *entry) {
+	ins := e.signals[:len(e.signals)-1]
+	out := m.signals[e.signals[len(e.signals)-1]]
+	args := make([]*phdl.AstExpr, len(ins))
+	for idx, signal := range ins {
+		args[idx] = m.signals[signal]
+	}
+
+	if len(ins) == 0 {
+		m.gate("buf", out, literal(eval(e.rows, 0)))
+		return
+	} else if len(ins) <= 2 {
+		tt := truthTable(e.rows, len(ins))
+		for _, op := range []string{"buf", "not", "and", "or", "xor", "nand", "nor", "xnor"} {
+			n := len(phdl.Builtins[op].Args)
+			rows := cover(op, n)
+			for idx, row := range rows {
+				rows[idx] = strings.Replace(row, " ", "", 1)
+			}
+			if n == len(ins) && truthTable(rows, len(ins)) == tt {
+				m.gate(op, out, args...)
+				return
+			}
+		}
+	}
+
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: args,
+		Op:   m.imp.table(e.rows, len(ins)),
+		Rets: []*phdl.AstExpr{out},
+	})
+}
+
+// table gets a table block of a cover of k inputs, shared by equal covers
+func (imp *importer) table(rows []string, k int) *phdl.AstBlock {
+	key := fmt.Sprint(k, rows)
+	if block, ok := imp.tables[key]; ok {
+		return block
+	}
+
+	block := &phdl.AstBlock{
+		Name:  imp.names.Fresh("cover"),
+		Args:  make([]*phdl.AstConn, k),
+		Rets:  []*phdl.AstConn{{Name: "o", Width: 1}},
+		Vars:  map[string]*phdl.AstConn{},
+		Stmts: make([]*phdl.AstStmt, 0),
+		Table: &phdl.AstTable{Rows: make([]*phdl.AstTableRow, 0)},
+	}
+	block.Vars["o"] = block.Rets[0]
+	for i := range block.Args {
+		block.Args[i] = &phdl.AstConn{Name: fmt.Sprintf("i%v", i), Width: 1}
+		block.Vars[block.Args[i].Name] = block.Args[i]
+	}
+
+	patterns := func(plane string) []*phdl.AstPattern {
+		pats := make([]*phdl.AstPattern, len(plane))
+		for i, c := range plane {
+			switch c {
+			case '0':
+				pats[i] = &phdl.AstPattern{Value: 0, Mask: -1, Width: 1}
+			case '1':
+				pats[i] = &phdl.AstPattern{Value: 1, Mask: -1, Width: 1}
+			default:
+				pats[i] = &phdl.AstPattern{Value: 0, Mask: ^int64(1), Width: 1}
+			}
+		}
+		return pats
+	}
+	for _, row := range rows {
+		block.Table.Rows = append(block.Table.Rows, &phdl.AstTableRow{
+			Args: patterns(row[:k]),
+			Rets: []*phdl.AstExpr{literal(int64(row[k] - '0'))},
+		})
+	}
+	// rows of an off-set cover are 0, and everything else 1
+	if len(rows) != 0 && rows[0][k] == '0' {
+		block.Table.Rows = append(block.Table.Rows, &phdl.AstTableRow{
+			Args: patterns(strings.Repeat("-", k)),
+			Rets: []*phdl.AstExpr{literal(1)},
+		})
+	}
+
+	imp.tables[key] = block
+	imp.file.Blocks[block.Name] = block
+	return block
+}
+
+func (m *lowering) latch(e *entry) {
+	block, ok := m.imp.regs[e.init]
+	if !ok {
+		block = &phdl.AstBlock{
+			Name:  m.imp.names.Fresh(fmt.Sprintf("latch%v", e.init)),
+			Args:  []*phdl.AstConn{{Name: "d", Width: 1}},
+			Rets:  []*phdl.AstConn{{Name: "q", Width: 1}},
+			Vars:  map[string]*phdl.AstConn{},
+			Stmts: make([]*phdl.AstStmt, 0),
+			Mem:   &phdl.AstMem{Kind: "reg", Init: []int64{e.init}},
+		}
+		block.Vars["d"] = block.Args[0]
+		block.Vars["q"] = block.Rets[0]
+		m.imp.regs[e.init] = block
+		m.imp.file.Blocks[block.Name] = block
+	}
+
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: []*phdl.AstExpr{m.signals[e.signals[0]]},
+		Op:   block,
+		Rets: []*phdl.AstExpr{m.signals[e.signals[1]]},
+	})
+}
+
+func (m *lowering) subckt(e *entry) error {
+	ports := m.imp.ports[e.model]
+	clocks := m.imp.clocks[e.model]
+	formals := make(map[string]bool)
+	for _, p := range ports {
+		for _, bit := range p.bits {
+			formals[bit] = true
+		}
+	}
+
+	actuals := make(map[string]string)
+	for _, bind := range e.binds {
+		if !formals[bind[0]] && !clocks[bind[0]] {
+			return fmt.Errorf("model '%s' has no port '%s'", e.model, bind[0])
+		} else if _, ok := actuals[bind[0]]; ok {
+			return fmt.Errorf("port '%s' bound twice", bind[0])
+		}
+		actuals[bind[0]] = bind[1]
+	}
+
+	stmt := &phdl.AstStmt{
+		Args: make([]*phdl.AstExpr, 0),
+		Op:   m.imp.blocks[e.model],
+		Rets: make([]*phdl.AstExpr, 0),
+	}
+	for _, p := range ports {
+		if p.input {
+			stmt.Args = append(stmt.Args, m.input(p.bits, actuals))
+		}
+	}
+	m.block.Stmts = append(m.block.Stmts, stmt)
+	for _, p := range ports {
+		if !p.input {
+			stmt.Rets = append(stmt.Rets, m.output(p.bits, actuals))
+		}
+	}
+	return nil
+}
+
+// input gets an expr for the actuals of the bits of an input port. Unbound
+// bits are 0.
+func (m *lowering) input(bits []string, actuals map[string]string) *phdl.AstExpr {
+	exprs := make([]*phdl.AstExpr, len(bits))
+	for i, bit := range bits {
+		if actual, ok := actuals[bit]; ok {
+			exprs[i] = m.signals[actual]
+		} else {
+			exprs[i] = literal(0)
+		}
+	}
+	if expr := phdl.JoinBits(exprs); expr != nil {
+		return expr
+	}
+
+	tmp := m.temp(len(bits))
+	for i, expr := range exprs {
+		m.gate("buf", connBit(tmp, i), expr)
+	}
+	return &phdl.AstExpr{Conn: tmp, Lo: -1}
+}
+
+// output gets an expr for the actuals of the bits of an output port
+func (m *lowering) output(bits []string, actuals map[string]string) *phdl.AstExpr {
+	exprs := make([]*phdl.AstExpr, 0)
+	for _, bit := range bits {
+		if actual, ok := actuals[bit]; ok {
+			exprs = append(exprs, m.signals[actual])
+		}
+	}
+	if len(exprs) == len(bits) {
+		if expr := phdl.JoinBits(exprs); expr != nil {
+			return expr
+		}
+	}
+
+	tmp := m.temp(len(bits))
+	for i, bit := range bits {
+		if actual, ok := actuals[bit]; ok {
+			m.gate("buf", m.signals[actual], connBit(tmp, i))
+		}
+	}
+	return &phdl.AstExpr{Conn: tmp, Lo: -1}
+}
@@ -0,0 +1,146 @@
+// Package bmc checks the asserts of sequential blocks by bounded model
+// checking. The block is flattened into a netlist, and unrolled one cycle at a
+// time into a SAT solver, from the initial value of its regs, with new
+// variables for the args of each cycle. Each cycle is solved for args that
+// make any assert fail in it, so the shortest failing trace is found first.
+package bmc
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/netlist"
+	"github.com/petelliott/logiko/phdl/sat"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"sort"
+)
+
+// Trace is the args of each cycle from reset that make an assert fail in the
+// last cycle, with the values of the conns of the block simulating them
+type Trace struct {
+	Block  *phdl.AstBlock
+	Args   [][]simulator.PortType // of each cycle
+	Rets   [][]simulator.PortType // of each cycle
+	Conns  []*phdl.AstConn        // of the block, by name
+	Values [][]simulator.PortType // of Conns in each cycle
+	Failed []elab.AssertFailure   // in the last cycle
+}
+
+// Cycles gets the number of cycles of the trace
+func (t *Trace) Cycles() int {
+	return len(t.Args)
+}
+
+// Check searches for args that make an assert of a type checked block, or of
+// one of its instances, fail within k cycles of reset. It gives nil if the
+// asserts hold for k cycles, or the shortest trace that fails.
+func Check(block *phdl.AstBlock, k int) (*Trace, error) {
+	n, err := netlist.Flatten(block)
+	if err != nil {
+		return nil, err
+	} else if len(n.Asserts) == 0 {
+		return nil, fmt.Errorf("'%s' has no asserts", block.Name)
+	}
+
+	s := sat.New()
+	latches := make([]sat.Lit, len(n.Latches))
+	for idx, latch := range n.Latches {
+		latches[idx] = -s.True()
+		if latch.Init {
+			latches[idx] = s.True()
+		}
+	}
+
+	frames := make([][]sat.Lit, 0, k)
+	for cycle := 0; cycle < k; cycle++ {
+		inputs := make([]sat.Lit, 0)
+		for _, bits := range n.Inputs {
+			for range bits {
+				inputs = append(inputs, s.NewVar())
+			}
+		}
+		frames = append(frames, inputs)
+		lits := sat.Encode(s, n, inputs, latches)
+
+		fails := make([]sat.Lit, 0)
+		for _, assert := range n.Asserts {
+			if cycle >= assert.From {
+				fails = append(fails, -lits[assert.Signal])
+			}
+		}
+		if len(fails) != 0 {
+			fail := s.Or(fails...)
+			if s.Solve(fail) {
+				return replay(block, s, frames)
+			}
+			// the asserts hold in this cycle of every trace, which later
+			// cycles can rely on
+			s.AddClause(-fail)
+		}
+
+		for idx, latch := range n.Latches {
+			latches[idx] = lits[latch.D]
+		}
+	}
+	return nil, nil
+}
+
+// replay simulates the args of a model of the unrolled netlist, checking that
+// the simulator agrees that an assert fails in the last cycle
+func replay(block *phdl.AstBlock, s *sat.Solver, frames [][]sat.Lit) (*Trace, error) {
+	sim, err := elab.Simulate(block)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Trace{
+		Block:  block,
+		Args:   make([][]simulator.PortType, len(frames)),
+		Rets:   make([][]simulator.PortType, len(frames)),
+		Conns:  make([]*phdl.AstConn, 0, len(block.Vars)),
+		Values: make([][]simulator.PortType, len(frames)),
+	}
+	for _, conn := range block.Vars {
+		t.Conns = append(t.Conns, conn)
+	}
+	sort.Slice(t.Conns, func(i, j int) bool {
+		return t.Conns[i].Name < t.Conns[j].Name
+	})
+
+	for cycle, inputs := range frames {
+		if cycle != 0 {
+			sim.Step()
+		}
+
+		t.Args[cycle] = make([]simulator.PortType, len(block.Args))
+		idx := 0
+		for aidx, arg := range block.Args {
+			for i := 0; i < arg.Width; i++ {
+				if s.Value(inputs[idx]) {
+					t.Args[cycle][aidx] |= 1 << uint(i)
+				}
+				idx++
+			}
+			err = sim.Write(arg.Name, t.Args[cycle][aidx])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		t.Rets[cycle] = make([]simulator.PortType, len(block.Rets))
+		for ridx, ret := range block.Rets {
+			t.Rets[cycle][ridx], _ = sim.Read(ret.Name)
+		}
+		t.Values[cycle] = make([]simulator.PortType, len(t.Conns))
+		for cidx, conn := range t.Conns {
+			t.Values[cycle][cidx], _ = sim.Read(conn.Name)
+		}
+	}
+
+	t.Failed = sim.Asserts()
+	if len(t.Failed) == 0 {
+		return nil, fmt.Errorf("no assert of '%s' fails in simulation of a failing trace of %v cycles",
+			block.Name, len(frames))
+	}
+	return t, nil
+}
@@ -0,0 +1,193 @@
+package bmc
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/simulator"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const prog = `
+	reg r (d d3) -> (q d3);
+	ram mem (addr d1, din d1, we d1) -> (dout d1);
+
+	table below6 (q d3) -> (ok d1) {
+		0b0-- ==> 1;
+		0b10- ==> 1;
+	}
+
+	table mux (s d1, a d1, b d1) -> (o d1) {
+		1, 1, 0b- ==> 1;
+		0, 0b-, 1 ==> 1;
+	}
+
+	block inc (a d3) -> (b d3) {
+		(a[0]) not -> b[0];
+		(a[0], a[1]) xor -> b[1];
+		(a[0], a[1]) and -> c;
+		(c, a[2]) xor -> b[2];
+	}
+
+	block eq3 (y d3, z d3) -> (o d1) {
+		(y[0], z[0]) xnor -> e0;
+		(y[1], z[1]) xnor -> e1;
+		(y[2], z[2]) xnor -> e2;
+		(e0, e1) and -> e01;
+		(e01, e2) and -> o;
+	}
+
+	// counts the cycles e is set
+	block counter (e d1) -> (q d3) {
+		(n) r -> q;
+		(q) inc -> m;
+		(e, m[0], q[0]) mux -> n[0];
+		(e, m[1], q[1]) mux -> n[1];
+		(e, m[2], q[2]) mux -> n[2];
+		assert (q) below6;
+	}
+
+	// q is m of the cycle before when e was set
+	block enabled (e d1) -> (q d3) {
+		(n) r -> q;
+		(q) inc -> m;
+		(e, m[0], q[0]) mux -> n[0];
+		(e, m[1], q[1]) mux -> n[1];
+		(e, m[2], q[2]) mux -> n[2];
+		assert (m@1, q) eq3;
+	}
+
+	// q is always n of the cycle before
+	block free (e d1) -> (q d3) {
+		(n) r -> q;
+		(q) inc -> n;
+		assert (n@1, q) eq3;
+	}
+
+	block store (a d1) -> (d d1) {
+		(a, a, 1) mem -> d;
+		assert (d) buf;
+	}
+`
+
+func TestCheck(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	// the counter reaches 6 after being enabled for 6 cycles
+	trace, err := Check(ast.Blocks["counter"], 20)
+	if err != nil {
+		t.Fatal(err)
+	} else if trace == nil || trace.Cycles() != 7 {
+		t.Fatalf("expected a trace of 7 cycles, got %v", trace)
+	}
+	for cycle := 0; cycle < 6; cycle++ {
+		if trace.Args[cycle][0] != 1 || trace.Rets[cycle][0] != simulator.PortType(cycle) {
+			t.Errorf("cycle %v: unexpected args %v and rets %v", cycle,
+				trace.Args[cycle], trace.Rets[cycle])
+		}
+	}
+	if len(trace.Failed) != 1 || trace.Failed[0].Path != "assert0" {
+		t.Errorf("unexpected failures %v", trace.Failed)
+	}
+
+	// but not in 6 cycles, and the assert of free always holds
+	for name, k := range map[string]int{"counter": 6, "free": 20} {
+		trace, err = Check(ast.Blocks[name], k)
+		if err != nil || trace != nil {
+			t.Errorf("%s: expected no trace, got %v, %v", name, trace, err)
+		}
+	}
+
+	// a delayed assert fails once e is clear
+	trace, err = Check(ast.Blocks["enabled"], 20)
+	if err != nil {
+		t.Fatal(err)
+	} else if trace == nil || trace.Cycles() != 2 || trace.Args[0][0] != 0 {
+		t.Fatalf("expected a trace of 2 cycles, got %v", trace)
+	}
+
+	for name, expected := range map[string]string{
+		"inc":   "'inc' has no asserts",
+		"store": "Flatten: ram 'mem' can't be flattened",
+	} {
+		_, err = Check(ast.Blocks[name], 20)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error '%s', got %v", name, expected, err)
+		}
+	}
+}
+
+func TestWriteTest(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	trace, err := Check(ast.Blocks["counter"], 20)
+	if err != nil || trace == nil {
+		t.Fatalf("expected a trace, got %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = trace.WriteTest(buf, "counter_bmc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "// assert0 at line 37 (below6) does not hold in cycle 6\n" +
+		"test counter_bmc (counter) {\n"
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("expected prefix %q, got %q", expected, buf.String())
+	}
+
+	// running the test reproduces the failure, and nothing else
+	ast = checks.MustCompile(prog+buf.String())
+	_, failures, err := elab.RunTest(ast.Tests["counter_bmc"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Vector != 6 || failures[0].Assert == nil {
+		t.Errorf("expected the assert to fail in vector 6, got %v", failures)
+	}
+}
+
+func TestWriteVCD(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	trace, err := Check(ast.Blocks["enabled"], 20)
+	if err != nil || trace == nil {
+		t.Fatalf("expected a trace, got %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = trace.WriteVCD(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e, m, n and q, then a value of each in cycle 0, and the changes of
+	// cycle 1
+	expected := `$timescale 1ns $end
+$scope module enabled $end
+$var wire 1 ! clk $end
+$var wire 1 " e $end
+$var wire 3 # m [2:0] $end
+$var wire 3 $ n [2:0] $end
+$var wire 3 % q [2:0] $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+0!
+0"
+b1 #
+b0 $
+b0 %
+$end
+#5
+1!
+#10
+0!
+`
+	if !strings.HasPrefix(buf.String(), expected) || !strings.HasSuffix(buf.String(), "#15\n1!\n#20\n") {
+		t.Errorf("unexpected VCD:\n%s", buf.String())
+	}
+
+	if vcdID(0) != "!" || vcdID(93) != "~" || vcdID(94) != "!!" || vcdID(95) != "\"!" {
+		t.Errorf("unexpected ids %q %q %q %q", vcdID(0), vcdID(93), vcdID(94), vcdID(95))
+	}
+}
@@ -0,0 +1,93 @@
+package bmc
+
+import (
+	"github.com/petelliott/logiko/simulator"
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// vcdID gives the identifier code of the nth signal of a VCD, using the
+// printable characters '!' to '~' as digits
+func vcdID(n int) string {
+	id := ""
+	for {
+		id += string(rune('!' + n%94))
+		n /= 94
+		if n == 0 {
+			return id
+		}
+		n--
+	}
+}
+
+// vcdValue formats a value change of a signal
+func vcdValue(value simulator.PortType, width int, id string) string {
+	if width == 1 {
+		return fmt.Sprintf("%v%s", value&1, id)
+	}
+	return fmt.Sprintf("b%b %s", value, id)
+}
+
+// WriteVCD writes the trace as a value change dump of the clock and the conns
+// of the block. Each cycle is 10 time units, with the rising edge of the clock
+// 5 units in.
+func (t *Trace) WriteVCD(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "$timescale 1ns $end\n")
+	fmt.Fprintf(bw, "$scope module %s $end\n", t.Block.Name)
+	fmt.Fprintf(bw, "$var wire 1 %s clk $end\n", vcdID(0))
+	for idx, conn := range t.Conns {
+		name := conn.Name
+		if conn.Width != 1 {
+			name = fmt.Sprintf("%s [%v:0]", conn.Name, conn.Width-1)
+		}
+		fmt.Fprintf(bw, "$var wire %v %s %s $end\n", conn.Width, vcdID(idx+1), name)
+	}
+	fmt.Fprintf(bw, "$upscope $end\n$enddefinitions $end\n")
+
+	for cycle, values := range t.Values {
+		fmt.Fprintf(bw, "#%v\n", 10*cycle)
+		if cycle == 0 {
+			fmt.Fprintf(bw, "$dumpvars\n")
+		}
+		fmt.Fprintln(bw, vcdValue(0, 1, vcdID(0)))
+		for idx, conn := range t.Conns {
+			if cycle == 0 || values[idx] != t.Values[cycle-1][idx] {
+				fmt.Fprintln(bw, vcdValue(values[idx], conn.Width, vcdID(idx+1)))
+			}
+		}
+		if cycle == 0 {
+			fmt.Fprintf(bw, "$end\n")
+		}
+		fmt.Fprintf(bw, "#%v\n%s\n", 10*cycle+5, vcdValue(1, 1, vcdID(0)))
+	}
+	fmt.Fprintf(bw, "#%v\n", 10*t.Cycles())
+	return bw.Flush()
+}
+
+// WriteTest writes the trace as a test named name, with a vector of each
+// cycle expecting the rets the block gave, so that running it reproduces the
+// failing asserts. Values are written as numbers, as a bare enum value may be
+// ambiguous.
+func (t *Trace) WriteTest(w io.Writer, name string) error {
+	bw := bufio.NewWriter(w)
+	for _, af := range t.Failed {
+		fmt.Fprintf(bw, "// %s in cycle %v\n", af, t.Cycles()-1)
+	}
+	fmt.Fprintf(bw, "test %s (%s) {\n", name, t.Block.Name)
+	for cycle := range t.Args {
+		args := make([]string, len(t.Args[cycle]))
+		for idx, value := range t.Args[cycle] {
+			args[idx] = fmt.Sprint(value)
+		}
+		rets := make([]string, len(t.Rets[cycle]))
+		for idx, value := range t.Rets[cycle] {
+			rets[idx] = fmt.Sprint(value)
+		}
+		fmt.Fprintf(bw, "\t%s ==> %s;\n", strings.Join(args, ", "), strings.Join(rets, ", "))
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
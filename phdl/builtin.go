@@ -0,0 +1,43 @@
+package phdl
+
+// Builtins are the primitive gates available to every file, unless shadowed
+// by a block of the same name. Every port of a builtin is d1.
+var Builtins = map[string]*AstBlock{
+	"buf":  builtinGate("buf", 1),
+	"not":  builtinGate("not", 1),
+	"and":  builtinGate("and", 2),
+	"or":   builtinGate("or", 2),
+	"xor":  builtinGate("xor", 2),
+	"nand": builtinGate("nand", 2),
+	"nor":  builtinGate("nor", 2),
+	"xnor": builtinGate("xnor", 2),
+}
+
+func builtinGate(name string, nargs int) *AstBlock {
+	block := &AstBlock{
+		Name: name,
+		Args: make([]*AstConn, 0),
+		Rets: []*AstConn{&AstConn{Name: "o", Width: 1}},
+		Vars: make(map[string]*AstConn),
+		Stmts: make([]*AstStmt, 0),
+		Builtin: true,
+	}
+
+	for _, arg := range []string{"a", "b"}[:nargs] {
+		conn := &AstConn{Name: arg, Width: 1}
+		block.Vars[arg] = conn
+		block.Args = append(block.Args, conn)
+	}
+	block.Vars["o"] = block.Rets[0]
+
+	return block
+}
+
+// lookupBlock gets a block defined in astfile, or a builtin
+func (af *AstFile) lookupBlock(name string) (*AstBlock, bool) {
+	if block, ok := af.Blocks[name]; ok {
+		return block, true
+	}
+	block, ok := Builtins[name]
+	return block, ok
+}
@@ -0,0 +1,59 @@
+package checks
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compile parses, compiles and type checks a PHDL source
+func Compile(r io.Reader) (*phdl.AstFile, error) {
+	ptree := &phdl.File{}
+	err := phdl.Parser.Parse(r, ptree)
+	if err != nil {
+		return nil, err
+	}
+	ast, err := phdl.CompileFile(ptree)
+	if err != nil {
+		return nil, err
+	}
+	err = TypeCheckFile(ast)
+	if err != nil {
+		return nil, err
+	}
+	return ast, nil
+}
+
+// CompilePath compiles the PHDL source file at path like Compile. The memory
+// files it names are relative to the directory of the source, so they are
+// joined to it.
+func CompilePath(path string) (*phdl.AstFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ast, err := Compile(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range ast.Blocks {
+		if block.Mem != nil && block.Mem.File != "" && !filepath.IsAbs(block.Mem.File) {
+			block.Mem.File = filepath.Join(filepath.Dir(path), block.Mem.File)
+		}
+	}
+	return ast, nil
+}
+
+// MustCompile compiles a PHDL source like Compile, but panics if it does not
+// compile, for sources that are constants of a program or test
+func MustCompile(src string) *phdl.AstFile {
+	ast, err := Compile(strings.NewReader(src))
+	if err != nil {
+		panic("checks: MustCompile: " + err.Error())
+	}
+	return ast
+}
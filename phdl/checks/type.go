@@ -19,6 +19,13 @@ func TypeCheckFile(file *phdl.AstFile) error {
 }
 
 func TypeCheckBlock(block *phdl.AstBlock) error {
+	if block.Table != nil {
+		err := TypeCheckTable(block)
+		if err != nil {
+			return fmt.Errorf("block '%s': %s", block.Name, err)
+		}
+	}
+
 	for _, stmt := range block.Stmts {
 		err := TypeCheckStmt(stmt)
 		if err != nil {
@@ -34,6 +41,29 @@ func TypeCheckBlock(block *phdl.AstBlock) error {
 		}
 	}
 
+	for _, assert := range block.Asserts {
+		err := TypeCheckAssert(assert)
+		if err != nil {
+			return fmt.Errorf("block '%s': %s", block.Name, err)
+		}
+	}
+
+	// Error for all field accesses that were never flattened
+	exprs := make([]*phdl.AstExpr, 0)
+	for _, stmt := range block.Stmts {
+		exprs = append(append(exprs, stmt.Args...), stmt.Rets...)
+	}
+	for _, assert := range block.Asserts {
+		exprs = append(exprs, assert.Args...)
+	}
+	for _, expr := range exprs {
+		if len(expr.Fields) != 0 {
+			return fmt.Errorf(
+				"block '%s': fields of conn '%s' cannot be resolved",
+				block.Name, expr.Conn.Name)
+		}
+	}
+
 	// Error for all unknown type conns
 	for _, v := range block.Vars {
 		if !v.HasType() {
@@ -48,14 +78,14 @@ func TypeCheckBlock(block *phdl.AstBlock) error {
 
 func TypeCheckStmt(stmt *phdl.AstStmt) error {
 	for idx, arg := range stmt.Args {
-		err := TypeCheckExpr(stmt.Op.Args[idx].Width, arg)
+		err := TypeCheckPort(stmt.Op.Args[idx], arg)
 		if err != nil {
 			return err
 		}
 	}
 
 	for idx, ret := range stmt.Rets {
-		err := TypeCheckExpr(stmt.Op.Rets[idx].Width, ret)
+		err := TypeCheckPort(stmt.Op.Rets[idx], ret)
 		if err != nil {
 			return err
 		}
@@ -63,7 +93,112 @@ func TypeCheckStmt(stmt *phdl.AstStmt) error {
 	return nil
 }
 
+// TypeCheckAssert checks that the block of an assert has a d1 ret, and that
+// its args match
+func TypeCheckAssert(assert *phdl.AstAssert) error {
+	if len(assert.Op.Rets) != 1 || assert.Op.Rets[0].Width != 1 {
+		return fmt.Errorf("assert at line %v: '%s' does not have a single d1 ret",
+			assert.Line, assert.Op.Name)
+	} else if len(assert.Args) != len(assert.Op.Args) {
+		return fmt.Errorf("assert at line %v: expected %v args for '%s', got %v",
+			assert.Line, len(assert.Op.Args), assert.Op.Name, len(assert.Args))
+	}
+
+	for idx, arg := range assert.Args {
+		err := TypeCheckPort(assert.Op.Args[idx], arg)
+		if err != nil {
+			return fmt.Errorf("assert at line %v: %s", assert.Line, err)
+		}
+	}
+	return nil
+}
+
+// TypeCheckTable checks that every row of a table block has a pattern for
+// each arg and a value for each ret, and that they fit in their ports
+func TypeCheckTable(block *phdl.AstBlock) error {
+	for idx, row := range block.Table.Rows {
+		if len(row.Args) != len(block.Args) || len(row.Rets) != len(block.Rets) {
+			return fmt.Errorf(
+				"row %v: expected %v args and %v rets, got %v and %v",
+				idx, len(block.Args), len(block.Rets),
+				len(row.Args), len(row.Rets))
+		}
+
+		for i, pat := range row.Args {
+			port := block.Args[i]
+			if pat.Expr != nil {
+				err := TypeCheckPort(port, pat.Expr)
+				if err != nil {
+					return fmt.Errorf("row %v: %s", idx, err)
+				}
+			} else if pat.Width > port.Width {
+				return fmt.Errorf(
+					"row %v: pattern '%v' does not fit in d%v",
+					idx, pat, port.Width)
+			}
+		}
+
+		for i, ret := range row.Rets {
+			err := TypeCheckPort(block.Rets[i], ret)
+			if err != nil {
+				return fmt.Errorf("row %v: %s", idx, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TypeCheckPort checks expr against the type of port, and resolves the
+// bundle or enum of conns whose type is inferred from port. A plain dN conn
+// may be connected to a bundle or enum port of the same width, and the other
+// way around, as they are just bits; only conns of two different bundles or
+// enums are an error.
+func TypeCheckPort(port *phdl.AstConn, expr *phdl.AstExpr) error {
+	inferred := expr.Conn != nil && !expr.Conn.HasType()
+	err := TypeCheckExpr(port.Width, expr)
+	if err != nil {
+		return err
+	}
+
+	if expr.Enum != nil {
+		if port.Enum != nil && expr.Enum != port.Enum {
+			return fmt.Errorf(
+				"expected enum '%v', got '%v' (%v)",
+				port.Enum.Name, expr.Enum.Symbol(expr.Literal).Name,
+				expr.Enum.Name)
+		}
+		return nil
+	} else if expr.Conn == nil || expr.HasIndex() || len(expr.Fields) != 0 {
+		return nil
+	}
+
+	if inferred {
+		expr.Conn.Bundle = port.Bundle
+		expr.Conn.Enum = port.Enum
+	} else if port.Enum != nil && expr.Conn.Enum != nil && expr.Conn.Enum != port.Enum {
+		return fmt.Errorf(
+			"expected enum '%v', got '%v' (%v)",
+			port.Enum.Name, expr.Conn.Name, expr.Conn.Enum.Name)
+	} else if port.Bundle != nil && expr.Conn.Bundle != nil && expr.Conn.Bundle != port.Bundle {
+		return fmt.Errorf(
+			"expected bundle '%v', got '%v' (%v)",
+			port.Bundle.Name, expr.Conn.Name, expr.Conn.Bundle.Name)
+	}
+	return nil
+}
+
 func TypeCheckExpr(expected int, expr *phdl.AstExpr) error {
+	if len(expr.Fields) != 0 {
+		if !expr.Conn.HasType() {
+			// resolved once the type of the conn is known
+			return nil
+		}
+		err := expr.ResolveFields()
+		if err != nil {
+			return err
+		}
+	}
+
 	if expr.HasIndex() {
 		width := (expr.Hi-expr.Lo)+1
 		if width != expected {
@@ -3,6 +3,10 @@ package checks
 import (
 	"testing"
 	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -102,6 +106,287 @@ func TestTypeCheckBlock(t *testing.T) {
 	}
 }
 
+func TestTypeCheckBundle(t *testing.T) {
+	Comp := func(t *testing.T, prog string) *phdl.AstFile {
+		t.Helper()
+
+		ptree := &phdl.File{}
+		err := phdl.Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast, err := phdl.CompileFile(ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ast
+	}
+
+	ast := Comp(t, `
+		bundle pair { lo d2; hi d3; }
+
+		block mk (a d5) -> (p pair) {}
+		block hi (a d3) -> (b d3) {}
+
+		block b (a d5) -> (b d3) {
+			(p.hi) hi -> b;
+			(a) mk -> p;
+		}
+	`)
+
+	err := TypeCheckBlock(ast.Blocks["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := ast.Blocks["b"].Vars["p"]
+	if p.Bundle != ast.Bundles["pair"] || p.Width != 5 {
+		t.Errorf("expected 'p' to be inferred as pair, got %v", p)
+	}
+
+	expr := ast.Blocks["b"].Stmts[0].Args[0]
+	if expr.Lo != 2 || expr.Hi != 4 {
+		t.Errorf("incorrect field bounds (l=%v, h=%v)", expr.Lo, expr.Hi)
+	}
+
+	ast = Comp(t, `
+		bundle pair { lo d2; hi d3; }
+		bundle other { lo d3; hi d2; }
+
+		block use (p pair) -> (o d1) {}
+
+		block b (a other) -> (b d1) {
+			(a) use -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err == nil || !strings.Contains(err.Error(), "expected bundle") {
+		t.Error("expected bundle mismatch error, got", err)
+	}
+
+	// plain conns of the same width are bits of any bundle
+	ast = Comp(t, `
+		bundle pair { lo d2; hi d3; }
+
+		block use (p pair) -> (o d5) {}
+
+		block b (a d5) -> (b d5) {
+			(a) use -> c;
+			(c) use -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err != nil {
+		t.Error(err)
+	}
+	if ast.Blocks["b"].Vars["c"].Bundle != nil {
+		t.Error("expected 'c' to stay a plain conn")
+	}
+
+	ast = Comp(t, `
+		bundle pair { lo d2; hi d3; }
+
+		block use (p pair) -> (o d1) {}
+
+		block b (a d4) -> (b d1) {
+			(a) use -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err == nil || err.Error() != "block 'b': expected d5, got 'a' (d4)" {
+		t.Error("expected width mismatch error, got", err)
+	}
+
+	ast = Comp(t, `
+		block id (a d3) -> (b d3) {}
+
+		block b (a d3) -> (b d3) {
+			(p.hi) id -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTypeCheckEnum(t *testing.T) {
+	Comp := func(t *testing.T, prog string) *phdl.AstFile {
+		t.Helper()
+
+		ptree := &phdl.File{}
+		err := phdl.Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast, err := phdl.CompileFile(ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ast
+	}
+
+	ast := Comp(t, `
+		enum state { idle, run }
+		enum op { add, sub }
+
+		block next (s state) -> (n state) {}
+
+		block b (a state) -> (b state) {
+			(a) next -> s;
+			(s) next -> b;
+			(idle) next -> b;
+		}
+	`)
+
+	err := TypeCheckBlock(ast.Blocks["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ast.Blocks["b"].Vars["s"].Enum != ast.Enums["state"] {
+		t.Error("expected 's' to be inferred as state")
+	}
+
+	ast = Comp(t, `
+		enum state { idle, run }
+		enum op { add, sub }
+
+		block next (s state) -> (n state) {}
+
+		block b (a state) -> (b state) {
+			(sub) next -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err == nil || !strings.Contains(err.Error(), "expected enum") {
+		t.Error("expected enum mismatch error, got", err)
+	}
+
+	ast = Comp(t, `
+		enum state { idle, run }
+		enum op { add, sub }
+
+		block next (s state) -> (n state) {}
+
+		block b (a op) -> (b state) {
+			(a) next -> b;
+		}
+	`)
+
+	err = TypeCheckBlock(ast.Blocks["b"])
+	if err == nil || !strings.Contains(err.Error(), "expected enum") {
+		t.Error("expected enum mismatch error, got", err)
+	}
+}
+
+func TestTypeCheckTable(t *testing.T) {
+	Comp := func(t *testing.T, prog string) *phdl.AstFile {
+		t.Helper()
+
+		ptree := &phdl.File{}
+		err := phdl.Parser.ParseString(prog, ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast, err := phdl.CompileFile(ptree)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return ast
+	}
+
+	ast := Comp(t, `
+		enum op { add, sub }
+		table ok (a d2, b d1) -> (o op) {
+			0b1x, 1 ==> sub;
+			3, 0 ==> op.add;
+		}
+		table wide (a d2) -> (o d1) { 0b1x0 ==> 1; }
+		table big (a d2) -> (o d1) { 4 ==> 1; }
+		table short (a d2, b d1) -> (o d1) { 1 ==> 1; }
+		table plainenum (a d2) -> (o d1) { add ==> 1; }
+	`)
+
+	err := TypeCheckBlock(ast.Blocks["ok"])
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, name := range []string{"wide", "big", "short"} {
+		err = TypeCheckBlock(ast.Blocks[name])
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// enum literals are allowed in plain ports
+	err = TypeCheckBlock(ast.Blocks["plainenum"])
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTypeCheckAssert(t *testing.T) {
+	ptree := &phdl.File{}
+	err := phdl.Parser.ParseString(`
+		block pair (a d1, b d1) -> (x d1, y d1) {
+			(a, b) and -> x;
+			(a, b) or -> y;
+		}
+		block ok (a d2) -> (b d1) {
+			(a[0], a[1]) and -> b;
+			assert (a[0]@1, b) or;
+		}
+		block two (a d1) -> (b d1) {
+			(a) not -> b;
+			assert (a, b) pair;
+		}
+		block wide (a d2) -> (b d1) {
+			(a[0]) not -> b;
+			assert (a, b) or;
+		}
+		block few (a d1) -> (b d1) {
+			(a) not -> b;
+			assert (a) or;
+		}
+	`, ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ast, err := phdl.CompileFile(ptree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = TypeCheckBlock(ast.Blocks["ok"])
+	if err != nil {
+		t.Error(err)
+	}
+
+	for name, expected := range map[string]string{
+		"two":  "block 'two': assert at line 12: 'pair' does not have a single d1 ret",
+		"wide": "block 'wide': assert at line 16: expected d1, got 'a' (d2)",
+		"few":  "block 'few': assert at line 20: expected 2 args for 'or', got 1",
+	} {
+		err = TypeCheckBlock(ast.Blocks[name])
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected error '%s', got %v", name, expected, err)
+		}
+	}
+}
+
 func TestTypeCheckExpr(t *testing.T) {
 	err := TypeCheckExpr(1, &phdl.AstExpr{Literal: 0})
 	if err != nil {
@@ -143,3 +428,44 @@ func TestTypeCheckExpr(t *testing.T) {
 		t.Error("expected error")
 	}
 }
+
+func TestCompilePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "rom.hex"), []byte("de ad @3 ef"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "top.phdl")
+	err = ioutil.WriteFile(path, []byte(`
+		rom r (a d2) -> (d d8) = hex "rom.hex";
+		rom abs (a d2) -> (d d8) = hex "/rom.hex";
+	`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ast, err := CompilePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file := ast.Blocks["r"].Mem.File; file != filepath.Join(dir, "rom.hex") {
+		t.Errorf("expected the file to be relative to the source, got '%s'", file)
+	}
+	if file := ast.Blocks["abs"].Mem.File; file != "/rom.hex" {
+		t.Errorf("expected an absolute file to be kept, got '%s'", file)
+	}
+	contents, err := ast.Blocks["r"].Mem.Contents()
+	if err != nil || fmt.Sprint(contents) != "[222 173 0 239]" {
+		t.Errorf("unexpected contents %v, %v", contents, err)
+	}
+
+	_, err = CompilePath(filepath.Join(dir, "missing.phdl"))
+	if err == nil {
+		t.Error("expected missing file error")
+	}
+}
@@ -0,0 +1,186 @@
+// Package dot renders PHDL blocks as graphviz graphs
+package dot
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Options controls how blocks are drawn
+type Options struct {
+	// Depth is the number of levels of sub-blocks drawn inline, as clusters
+	// of their statements, rather than as single nodes
+	Depth int
+}
+
+// WriteFile writes a graph for every block in file, in order of name
+func WriteFile(w io.Writer, file *phdl.AstFile) error {
+	return Options{}.WriteFile(w, file)
+}
+
+// WriteBlock writes a graph of the statements of a type checked block
+func WriteBlock(w io.Writer, block *phdl.AstBlock) error {
+	return Options{}.WriteBlock(w, block)
+}
+
+func (opts Options) WriteFile(w io.Writer, file *phdl.AstFile) error {
+	names := make([]string, 0, len(file.Blocks))
+	for name := range file.Blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := opts.WriteBlock(w, file.Blocks[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts Options) WriteBlock(w io.Writer, block *phdl.AstBlock) error {
+	fmt.Fprintf(w, "digraph %s {\n", strconv.Quote(block.Name))
+	fmt.Fprintln(w, "\trankdir=LR;")
+
+	for _, arg := range block.Args {
+		fmt.Fprintf(w, "\t%s [label=%s, shape=invhouse];\n",
+			strconv.Quote(arg.Name), strconv.Quote(connLabel(arg)))
+	}
+	for _, ret := range block.Rets {
+		fmt.Fprintf(w, "\t%s [label=%s, shape=house];\n",
+			strconv.Quote(ret.Name), strconv.Quote(connLabel(ret)))
+	}
+
+	opts.writeScope(w, "", "\t", block, opts.Depth)
+
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// expandable checks if a block has statements that can be drawn inline
+func expandable(block *phdl.AstBlock) bool {
+	return !block.Builtin && !block.Extern && block.Mem == nil && block.Table == nil
+}
+
+func connLabel(conn *phdl.AstConn) string {
+	return fmt.Sprintf("%s d%v", conn.Name, conn.Width)
+}
+
+// exprLabel labels the edge of an expr connected to a port of width
+func exprLabel(expr *phdl.AstExpr, width int) string {
+	if !expr.HasIndex() {
+		return fmt.Sprintf("%s d%v", expr.Conn.Name, width)
+	} else if expr.Lo == expr.Hi {
+		return fmt.Sprintf("%s[%v] d%v", expr.Conn.Name, expr.Lo, width)
+	}
+	return fmt.Sprintf("%s[%v..%v] d%v", expr.Conn.Name, expr.Lo, expr.Hi, width)
+}
+
+// bitRange gets the lowest and highest bit of a conn an expr covers
+func bitRange(expr *phdl.AstExpr) (int, int) {
+	if !expr.HasIndex() {
+		return 0, expr.Conn.Width - 1
+	}
+	return expr.Lo, expr.Hi
+}
+
+// endpoint is the node at one end of an expr, and the port it connects to
+type endpoint struct {
+	node  string
+	expr  *phdl.AstExpr
+	width int
+}
+
+// writeScope writes the nodes and edges of the statements of block, with
+// node names prefixed by prefix. The ports of block are expected to be nodes
+// named by prefix and the port name.
+func (opts Options) writeScope(w io.Writer, prefix, indent string, block *phdl.AstBlock, depth int) {
+	drivers := make(map[*phdl.AstConn][]endpoint)
+	readers := make(map[*phdl.AstConn][]endpoint)
+
+	for _, arg := range block.Args {
+		expr := &phdl.AstExpr{Conn: arg, Lo: -1}
+		drivers[arg] = append(drivers[arg], endpoint{prefix + arg.Name, expr, arg.Width})
+	}
+	for _, ret := range block.Rets {
+		expr := &phdl.AstExpr{Conn: ret, Lo: -1}
+		readers[ret] = append(readers[ret], endpoint{prefix + ret.Name, expr, ret.Width})
+	}
+
+	insts := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		// conn names can't contain '/', so instances don't clash with ports
+		node := prefix + insts[sidx] + "/"
+		expand := depth > 0 && expandable(stmt.Op)
+
+		if expand {
+			fmt.Fprintf(w, "%ssubgraph %s {\n", indent, strconv.Quote("cluster_"+node))
+			fmt.Fprintf(w, "%s\tlabel=%s;\n", indent,
+				strconv.Quote(fmt.Sprintf("%s: %s", insts[sidx], stmt.Op.Name)))
+			for _, port := range append(stmt.Op.Args, stmt.Op.Rets...) {
+				fmt.Fprintf(w, "%s\t%s [label=\"\", xlabel=%s, shape=point];\n",
+					indent, strconv.Quote(node+port.Name), strconv.Quote(port.Name))
+			}
+			opts.writeScope(w, node, indent+"\t", stmt.Op, depth-1)
+			fmt.Fprintf(w, "%s}\n", indent)
+		} else {
+			shape := "box"
+			if stmt.Op.Builtin {
+				shape = "ellipse"
+			}
+			fmt.Fprintf(w, "%s%s [label=%s, shape=%s];\n",
+				indent, strconv.Quote(node), strconv.Quote(stmt.Op.Name), shape)
+		}
+
+		portNode := func(port *phdl.AstConn) string {
+			if expand {
+				return node + port.Name
+			}
+			return node
+		}
+
+		for idx, arg := range stmt.Args {
+			port := stmt.Op.Args[idx]
+			if arg.Conn == nil {
+				lit := fmt.Sprintf("%s%s=", node, port.Name)
+				fmt.Fprintf(w, "%s%s [label=%s, shape=plaintext];\n", indent,
+					strconv.Quote(lit), strconv.Quote(port.Format(arg.Literal)))
+				fmt.Fprintf(w, "%s%s -> %s;\n", indent,
+					strconv.Quote(lit), strconv.Quote(portNode(port)))
+				continue
+			}
+			readers[arg.Conn] = append(readers[arg.Conn],
+				endpoint{portNode(port), arg, port.Width})
+		}
+		for idx, ret := range stmt.Rets {
+			port := stmt.Op.Rets[idx]
+			drivers[ret.Conn] = append(drivers[ret.Conn],
+				endpoint{portNode(port), ret, port.Width})
+		}
+	}
+
+	names := make([]string, 0, len(block.Vars))
+	for name := range block.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		conn := block.Vars[name]
+		for _, r := range readers[conn] {
+			rlo, rhi := bitRange(r.expr)
+			for _, d := range drivers[conn] {
+				if dlo, dhi := bitRange(d.expr); dlo > rhi || dhi < rlo {
+					continue
+				}
+				fmt.Fprintf(w, "%s%s -> %s [label=%s];\n", indent,
+					strconv.Quote(d.node), strconv.Quote(r.node),
+					strconv.Quote(exprLabel(r.expr, r.width)))
+			}
+		}
+	}
+}
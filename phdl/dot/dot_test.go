@@ -0,0 +1,99 @@
+package dot
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl/checks"
+	"strings"
+	"testing"
+)
+
+const adder = `
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) and -> c;
+	}
+
+	block top (x d2) -> (s d1, c d1) {
+		(x[0], x[1]) half -> s, t;
+		(t, 1) or -> c;
+	}
+`
+
+func TestWriteBlock(t *testing.T) {
+	ast := checks.MustCompile(adder)
+
+	var b bytes.Buffer
+	err := WriteBlock(&b, ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `digraph "top" {
+	rankdir=LR;
+	"x" [label="x d2", shape=invhouse];
+	"s" [label="s d1", shape=house];
+	"c" [label="c d1", shape=house];
+	"half0/" [label="half", shape=box];
+	"or0/" [label="or", shape=ellipse];
+	"or0/b=" [label="1", shape=plaintext];
+	"or0/b=" -> "or0/";
+	"or0/" -> "c" [label="c d1"];
+	"half0/" -> "s" [label="s d1"];
+	"half0/" -> "or0/" [label="t d1"];
+	"x" -> "half0/" [label="x[0] d1"];
+	"x" -> "half0/" [label="x[1] d1"];
+}
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestWriteBlockDepth(t *testing.T) {
+	ast := checks.MustCompile(adder)
+
+	var b bytes.Buffer
+	err := Options{Depth: 1}.WriteBlock(&b, ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`subgraph "cluster_half0/" {`,
+		`label="half0: half";`,
+		`"half0/a" [label="", xlabel="a", shape=point];`,
+		`"half0/xor0/" [label="xor", shape=ellipse];`,
+		`"half0/a" -> "half0/xor0/" [label="a d1"];`,
+		`"half0/and0/" -> "half0/c" [label="c d1"];`,
+		`"half0/c" -> "or0/" [label="t d1"];`,
+		`"x" -> "half0/b" [label="x[1] d1"];`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("expected '%s' in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestWriteBlockSlices(t *testing.T) {
+	ast := checks.MustCompile(`
+		block top (x d1, y d1) -> (o d2) {
+			(x) not -> o[0];
+			(y) not -> o[1];
+			(o[1]) buf -> z;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := WriteBlock(&b, ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the driver of o[1] connects to a reader of o[1]
+	if strings.Contains(b.String(), `"not0/" -> "buf0/"`) {
+		t.Errorf("unexpected edge from not0 in:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), `"not1/" -> "buf0/" [label="o[1] d1"];`) {
+		t.Errorf("expected edge from not1 in:\n%s", b.String())
+	}
+}
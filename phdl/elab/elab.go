@@ -0,0 +1,230 @@
+// Package elab elaborates type checked PHDL blocks into simulator components
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+)
+
+// MaxAddrWidth is the widest address of a memory that will be simulated
+const MaxAddrWidth = 24
+
+// Options control how blocks are elaborated
+type Options struct {
+	// SynthesizeTables builds table blocks out of gates, instead of a
+	// single lookup component
+	SynthesizeTables bool
+}
+
+// Elaborate builds a component for a type checked block with the default
+// options
+func Elaborate(block *phdl.AstBlock) (simulator.AttachableComponent, error) {
+	return Options{}.Elaborate(block)
+}
+
+// Elaborate builds a component for a type checked block. Each statement
+// becomes a child instance named by AstBlock.InstanceNames. Blocks with
+// combinational loops can't be elaborated.
+func (opts Options) Elaborate(block *phdl.AstBlock) (simulator.AttachableComponent, error) {
+	err := checkLoops(block)
+	if err != nil {
+		return nil, err
+	}
+	return opts.elaborate(block)
+}
+
+func (opts Options) elaborate(block *phdl.AstBlock) (simulator.AttachableComponent, error) {
+	if block.Builtin {
+		return simulator.NewFuncComponent(gates[block.Name], len(block.Args), 1), nil
+	} else if block.Mem != nil {
+		return elaborateMemory(block)
+	} else if block.Extern {
+		return elaborateExtern(block)
+	} else if block.Table != nil && opts.SynthesizeTables {
+		block = phdl.SynthesizeTable(block)
+	} else if block.Table != nil {
+		return elaborateTable(block), nil
+	}
+
+	circuit := simulator.NewCircuit()
+	for name, conn := range block.Vars {
+		circuit.Net(name, conn.Width)
+	}
+	for _, arg := range block.Args {
+		circuit.AddInput(circuit.Net(arg.Name, arg.Width))
+	}
+	for _, ret := range block.Rets {
+		circuit.AddOutput(circuit.Net(ret.Name, ret.Width))
+	}
+
+	names := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		if len(stmt.Args) > len(stmt.Op.Args) || len(stmt.Rets) > len(stmt.Op.Rets) {
+			return nil, fmt.Errorf("block '%s': too many args or rets for '%s'",
+				block.Name, stmt.Op.Name)
+		}
+
+		child, err := opts.elaborate(stmt.Op)
+		if err != nil {
+			return nil, err
+		}
+
+		ins := make([]simulator.Slice, len(stmt.Args))
+		for idx, arg := range stmt.Args {
+			ins[idx] = exprSlice(circuit, stmt.Op.Args[idx], arg)
+		}
+
+		outs := make([]simulator.Slice, len(stmt.Rets))
+		for idx, ret := range stmt.Rets {
+			outs[idx] = exprSlice(circuit, stmt.Op.Rets[idx], ret)
+		}
+
+		circuit.Add(names[sidx], child, ins, outs)
+	}
+
+	for aidx, name := range block.AssertNames() {
+		err := opts.elaborateAssert(circuit, name, block.Asserts[aidx])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return circuit, nil
+}
+
+// elaborateAssert adds an instance of the block of an assert, named name,
+// with its ret on the net AssertNet(name). Each delayed arg is sampled through
+// a chain of flip-flops.
+func (opts Options) elaborateAssert(circuit *simulator.Circuit, name string, assert *phdl.AstAssert) error {
+	child, err := opts.elaborate(assert.Op)
+	if err != nil {
+		return err
+	}
+
+	ins := make([]simulator.Slice, len(assert.Args))
+	for idx, arg := range assert.Args {
+		port := assert.Op.Args[idx]
+		ins[idx] = exprSlice(circuit, port, arg)
+		for d := 1; d <= assert.Delays[idx]; d++ {
+			past := circuit.Net(fmt.Sprintf("%s$%v@%v", name, idx, d), port.Width)
+			out := simulator.Slice{Net: past, Lo: -1}
+			circuit.Add(past.Name, simulator.NewFlipFlop(port.Width, 0),
+				[]simulator.Slice{ins[idx]}, []simulator.Slice{out})
+			ins[idx] = out
+		}
+	}
+
+	out := simulator.Slice{Net: circuit.Net(AssertNet(name), 1), Lo: -1}
+	circuit.Add(name, child, ins, []simulator.Slice{out})
+	return nil
+}
+
+// AssertNet names the net of the ret of an assert, which is not a valid conn
+// name
+func AssertNet(name string) string {
+	return "$" + name
+}
+
+// exprSlice converts an expr connected to port into a slice of a net in
+// circuit
+func exprSlice(circuit *simulator.Circuit, port *phdl.AstConn, expr *phdl.AstExpr) simulator.Slice {
+	if expr.Conn == nil {
+		return simulator.Slice{Literal: simulator.PortType(expr.Literal) & mask(port.Width)}
+	}
+
+	return simulator.Slice{
+		Net: circuit.Net(expr.Conn.Name, expr.Conn.Width),
+		Lo: expr.Lo,
+		Hi: expr.Hi,
+	}
+}
+
+func elaborateExtern(block *phdl.AstBlock) (simulator.AttachableComponent, error) {
+	inWidths := make([]int, len(block.Args))
+	for idx, arg := range block.Args {
+		inWidths[idx] = arg.Width
+	}
+	outWidths := make([]int, len(block.Rets))
+	for idx, ret := range block.Rets {
+		outWidths[idx] = ret.Width
+	}
+
+	comp, err := simulator.NewRegistered(block.Name, inWidths, outWidths)
+	if err != nil {
+		return nil, fmt.Errorf("extern block '%s': %s", block.Name, err)
+	}
+	return comp, nil
+}
+
+// gates implements each builtin on d1 ports
+var gates = map[string]func([]simulator.PortType, []simulator.PortType){
+	"buf":  func(in, out []simulator.PortType) { out[0] = in[0] },
+	"not":  func(in, out []simulator.PortType) { out[0] = 1 &^ in[0] },
+	"and":  func(in, out []simulator.PortType) { out[0] = in[0] & in[1] },
+	"or":   func(in, out []simulator.PortType) { out[0] = in[0] | in[1] },
+	"xor":  func(in, out []simulator.PortType) { out[0] = in[0] ^ in[1] },
+	"nand": func(in, out []simulator.PortType) { out[0] = 1 &^ (in[0] & in[1]) },
+	"nor":  func(in, out []simulator.PortType) { out[0] = 1 &^ (in[0] | in[1]) },
+	"xnor": func(in, out []simulator.PortType) { out[0] = 1 &^ (in[0] ^ in[1]) },
+}
+
+func elaborateTable(block *phdl.AstBlock) simulator.AttachableComponent {
+	return simulator.NewFuncComponent(func(in, out []simulator.PortType) {
+		args := make([]int64, len(in))
+		for idx, value := range in {
+			args[idx] = int64(value)
+		}
+
+		rets, ok := block.Table.Lookup(args)
+		for idx := range out {
+			out[idx] = 0
+			if ok {
+				out[idx] = simulator.PortType(rets[idx]) & mask(block.Rets[idx].Width)
+			}
+		}
+	}, len(block.Args), len(block.Rets))
+}
+
+func mask(width int) simulator.PortType {
+	if width >= 64 {
+		return ^simulator.PortType(0)
+	}
+	return (simulator.PortType(1) << uint(width)) - 1
+}
+
+func elaborateMemory(block *phdl.AstBlock) (simulator.AttachableComponent, error) {
+	if block.Mem.Kind == "reg" {
+		var init simulator.PortType
+		if len(block.Mem.Init) != 0 {
+			init = simulator.PortType(block.Mem.Init[0])
+		}
+		return simulator.NewFlipFlop(block.Rets[0].Width, init), nil
+	}
+
+	addrWidth := block.Args[0].Width
+	dataWidth := block.Rets[0].Width
+	if addrWidth > MaxAddrWidth {
+		return nil, fmt.Errorf(
+			"memory '%s': address width d%v is wider than d%v",
+			block.Name, addrWidth, MaxAddrWidth)
+	}
+
+	contents, err := block.Mem.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("memory '%s': %s", block.Name, err)
+	} else if len(contents) > 1<<uint(addrWidth) {
+		return nil, fmt.Errorf(
+			"memory '%s': %v words in '%s' do not fit in %v words",
+			block.Name, len(contents), block.Mem.File, 1<<uint(addrWidth))
+	}
+	init := make([]simulator.PortType, len(contents))
+	for idx, value := range contents {
+		init[idx] = simulator.PortType(value)
+	}
+
+	if block.Mem.Kind == "rom" {
+		return simulator.NewROM(addrWidth, dataWidth, init), nil
+	}
+	return simulator.NewRAM(addrWidth, dataWidth, init), nil
+}
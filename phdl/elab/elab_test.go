@@ -0,0 +1,577 @@
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/simulator"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func expect(t *testing.T, expected simulator.PortType, got simulator.PortType) {
+	t.Helper()
+	if expected != got {
+		t.Errorf("expected '%v', got '%v'", expected, got)
+	}
+}
+
+func TestElaborateMemory(t *testing.T) {
+	ast := checks.MustCompile(`
+		rom seg (a d2) -> (s d8) = { 0x3f, 0x06, 0x5b };
+		ram mem (addr d2, din d8, we d1) -> (dout d8);
+
+		block top (a d2, we d1) -> (s d8, m d8) {
+			(a) seg -> s;
+			(a, s, we) mem -> m;
+		}
+	`)
+
+	comp, err := Elaborate(ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.NewSim(comp)
+
+	expect(t, 0x3f, sim.Read(0))
+	sim.Write(0, 1)
+	expect(t, 0x06, sim.Read(0))
+
+	sim.Write(1, 1)
+	sim.Step()
+	v, err := sim.Peek("mem0", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, 0x06, v)
+
+	sim.Write(1, 0)
+	sim.Step()
+	expect(t, 0x06, sim.Read(1))
+
+	err = sim.Poke("seg0", 3, 0x66)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Write(0, 3)
+	expect(t, 0x66, sim.Read(0))
+}
+
+func TestElaborateRegister(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d1) -> (q d1) = { 1 };
+
+		// a three bit shift register fed back through an inverter
+		block ring () -> (q d3) {
+			(x) r -> q[0];
+			(q[0]) r -> q[1];
+			(q[1]) r -> q[2];
+			(q[2]) not -> x;
+		}
+	`)
+
+	comp, err := Elaborate(ast.Blocks["ring"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.NewSim(comp)
+
+	for _, q := range []simulator.PortType{7, 6, 4, 0, 1, 3, 7} {
+		expect(t, q, sim.Read(0))
+		sim.Step()
+	}
+}
+
+func TestElaborateMemoryFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "elab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rom.hex")
+	err = ioutil.WriteFile(path, []byte("de ad @3 ef"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ast := checks.MustCompile(`
+		rom r (a d2) -> (d d8) = hex "`+path+`";
+		rom small (a d1) -> (d d8) = hex "`+path+`";
+		rom missing (a d1) -> (d d8) = bin "`+path+`.missing";
+	`)
+
+	comp, err := Elaborate(ast.Blocks["r"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.NewSim(comp)
+	sim.Write(0, 3)
+	expect(t, 0xef, sim.Read(0))
+
+	_, err = Elaborate(ast.Blocks["small"])
+	if err == nil {
+		t.Error("expected file to not fit")
+	}
+
+	_, err = Elaborate(ast.Blocks["missing"])
+	if err == nil {
+		t.Error("expected missing file error")
+	}
+}
+
+func TestElaborateBuiltin(t *testing.T) {
+	ast := checks.MustCompile(`
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) and -> c;
+		}
+	`)
+
+	comp, err := Elaborate(ast.Blocks["half"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.NewSim(comp)
+
+	for a := simulator.PortType(0); a < 2; a++ {
+		for b := simulator.PortType(0); b < 2; b++ {
+			sim.Write(0, a)
+			sim.Write(1, b)
+			expect(t, a^b, sim.Read(0))
+			expect(t, a&b, sim.Read(1))
+		}
+	}
+}
+
+func TestElaborateTable(t *testing.T) {
+	ast := checks.MustCompile(`
+		table dec (a d3, e d1) -> (o d4, v d1) {
+			0b---, 0 ==> 0, 0;
+			0b1x1, 1 ==> 0xf, 1;
+			0b1-0, 1 ==> 0x8, 1;
+			2, 1 ==> 0x5, 1;
+		}
+	`)
+
+	lookup, err := Elaborate(ast.Blocks["dec"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	gates, err := Options{SynthesizeTables: true}.Elaborate(ast.Blocks["dec"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lsim := simulator.NewSim(lookup)
+	gsim := simulator.NewSim(gates)
+	for a := simulator.PortType(0); a < 8; a++ {
+		for e := simulator.PortType(0); e < 2; e++ {
+			lsim.Write(0, a)
+			lsim.Write(1, e)
+			gsim.Write(0, a)
+			gsim.Write(1, e)
+
+			for port := 0; port < 2; port++ {
+				if lsim.Read(port) != gsim.Read(port) {
+					t.Errorf("a=%v e=%v port %v: lookup %v, gates %v",
+						a, e, port, lsim.Read(port), gsim.Read(port))
+				}
+			}
+		}
+	}
+
+	lsim.Write(0, 2)
+	lsim.Write(1, 1)
+	expect(t, 0x5, lsim.Read(0))
+	lsim.Write(0, 7)
+	expect(t, 0xf, lsim.Read(0))
+	lsim.Write(0, 1)
+	expect(t, 0, lsim.Read(1))
+}
+
+func TestElaborateExtern(t *testing.T) {
+	simulator.Register("elab_test_mul", simulator.FuncFactory(
+		func(in, out []simulator.PortType) {
+			out[0] = in[0] * in[1]
+		}))
+	defer simulator.Unregister("elab_test_mul")
+
+	ast := checks.MustCompile(`
+		extern block elab_test_mul (a d4, b d4) -> (o d8);
+		extern block elab_test_missing (a d4) -> (o d4);
+
+		block sq (a d4) -> (o d8) {
+			(a, a) elab_test_mul -> o;
+		}
+	`)
+
+	comp, err := Elaborate(ast.Blocks["sq"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := simulator.NewSim(comp)
+	sim.Write(0, 12)
+	expect(t, 144, sim.Read(0))
+
+	_, err = Elaborate(ast.Blocks["elab_test_missing"])
+	if err == nil {
+		t.Error("expected unregistered extern error")
+	}
+}
+
+func TestElaborateLoops(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d1) -> (q d1);
+
+		block osc (a d1) -> (o d1) {
+			(o) not -> o;
+		}
+
+		block inv (a d1) -> (o d1) {
+			(a) not -> o;
+		}
+
+		// a loop through a sub-block
+		block deep (a d1) -> (o d1) {
+			(a, t) and -> o;
+			(o) inv -> t;
+		}
+
+		// feedback through a reg, or between different bits, is not a loop
+		block toggle (a d1) -> (o d1) {
+			(n) r -> o;
+			(o, a) xor -> n;
+		}
+		block shift (a d1) -> (o d2) {
+			(a) buf -> o[0];
+			(o[0]) not -> o[1];
+		}
+	`)
+
+	for name, loop := range map[string]string{"osc": "o", "deep": "o"} {
+		_, err := Elaborate(ast.Blocks[name])
+		if err == nil || !strings.Contains(err.Error(), "combinational loop through '"+loop+"'") {
+			t.Errorf("%s: expected a loop through '%s', got %v", name, loop, err)
+		}
+	}
+	for _, name := range []string{"toggle", "shift"} {
+		_, err := Elaborate(ast.Blocks[name])
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d2) -> (q d2);
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) nand -> nc;
+			(nc) not -> c;
+		}
+
+		block alu (x d2) -> (s d1, q d2) {
+			(x[0], x[1]) half -> s, carry;
+			(x) r -> q;
+		}
+
+		block top (x d2) -> (s d1, q d2) {
+			(x) alu -> s, q;
+		}
+	`)
+
+	sim, err := Simulate(ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sim.Write("x", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path     string
+		expected simulator.PortType
+	}{
+		{"x", 3},
+		{"s", 0},
+		{"q", 0},
+		{"alu0.carry", 1},
+		{"alu0.half0.nc", 0},
+	} {
+		got, err := sim.Read(c.path)
+		if err != nil {
+			t.Errorf("%s: %s", c.path, err)
+		}
+		expect(t, c.expected, got)
+	}
+
+	sim.Step()
+	q, _ := sim.Read("q")
+	expect(t, 3, q)
+
+	conn, err := sim.Conn("alu0.half0.nc")
+	if err != nil || conn.Width != 1 {
+		t.Errorf("unexpected conn %v, %v", conn, err)
+	}
+
+	for _, path := range []string{"y", "alu0.y", "alu1.carry", "alu0.r0.q"} {
+		_, err := sim.Read(path)
+		if err == nil {
+			t.Errorf("expected error reading '%s'", path)
+		}
+	}
+	if err := sim.Write("x", 4); err == nil {
+		t.Error("expected error writing a value that does not fit")
+	}
+	if err := sim.Write("s", 1); err == nil || err.Error() != "'s' is not an input" {
+		t.Errorf("expected error writing an output, got %v", err)
+	}
+	if err := sim.Write("y", 1); err == nil || err.Error() != "no conn 'y'" {
+		t.Errorf("expected error writing an unknown conn, got %v", err)
+	}
+}
+
+func TestRunTest(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d2) -> (q d2);
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) and -> c;
+		}
+
+		block acc (x d2) -> (q d2) {
+			(x) r -> q;
+		}
+
+		test ht(half) {
+			0, 0 ==> 0, 0;
+			1, 1 ==> 0, 1;
+			1, 0 ==> 1, 1;
+		}
+
+		test at(acc) {
+			2 ==> 0;
+			3 ==> 2;
+			0 ==> 0;
+		}
+	`)
+
+	results, failures, err := RunTest(ast.Tests["ht"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || len(failures) != 1 {
+		t.Fatalf("expected 3 results and 1 failure, got %v, %v", results, failures)
+	}
+	expect(t, 0, results[2].Got[1])
+	expect(t, 1, results[2].Expected[1])
+	if failures[0].Error() != "vector 2: c expected 1, got 0" {
+		t.Errorf("unexpected failure '%s'", failures[0])
+	}
+
+	// each vector is a cycle
+	results, failures, err = RunTest(ast.Tests["at"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Vector != 2 {
+		t.Fatalf("expected vector 2 to fail, got %v", failures)
+	}
+	expect(t, 3, results[2].Got[0])
+}
+
+func TestAsserts(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d2) -> (q d2);
+
+		block inner (a d1) -> (b d1) {
+			(a) not -> b;
+			assert (a, b) xor;
+		}
+
+		// q follows x a cycle late
+		block follow (x d2) -> (q d2) {
+			(x) r -> q;
+			(x[0]) inner -> y;
+			assert (x[1]@1, q[1]) xnor;
+		}
+
+		test ft(follow) {
+			2 ==> 0;
+			1 ==> 2;
+			3 ==> 1;
+		}
+	`)
+
+	sim, err := Simulate(ast.Blocks["follow"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the delayed arg is not checked before it has a value
+	sim.Write("x", 2)
+	if af := sim.Asserts(); len(af) != 0 {
+		t.Errorf("unexpected failures %v", af)
+	}
+	sim.Step()
+	if af := sim.Asserts(); len(af) != 0 {
+		t.Errorf("unexpected failures %v", af)
+	}
+
+	results, failures, err := RunTest(ast.Tests["ft"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || len(failures) != 0 {
+		t.Errorf("expected no failures, got %v", failures)
+	}
+
+	// the nets of asserts are not conns
+	for _, name := range []string{"$assert0", "inner0.$assert0", "assert0.a@1"} {
+		_, err = sim.Read(name)
+		if err == nil || err.Error() != "no conn '"+name+"'" {
+			t.Errorf("%s: expected no conn, got %v", name, err)
+		}
+	}
+}
+
+func TestAssertFailure(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d1) -> (q d1);
+
+		block inner (a d1) -> (b d1) {
+			(a) buf -> b;
+			assert (b) buf;
+		}
+
+		block toggle (e d1) -> (q d1) {
+			(n) r -> q;
+			(q, e) xor -> n;
+			(q) inner -> o;
+		}
+
+		test tt(toggle) {
+			0 ==> 0;
+			1 ==> 0;
+			0 ==> 1;
+		}
+	`)
+
+	results, failures, err := RunTest(ast.Tests["tt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || len(failures) != 2 {
+		t.Fatalf("expected 2 failures, got %v", failures)
+	}
+	expected := "vector 1: inner0.assert0 at line 6 (buf) does not hold"
+	if failures[1].Error() != expected || failures[1].Assert.Assert.Op.Name != "buf" {
+		t.Errorf("expected '%s', got '%s'", expected, failures[1])
+	}
+}
+
+func TestExhaustive(t *testing.T) {
+	ast := checks.MustCompile(`
+		block ripple (a d2, b d2) -> (s d2, c d1) {
+			(a[0], b[0]) xor -> s[0];
+			(a[0], b[0]) and -> c0;
+			(a[1], b[1]) xor -> p;
+			(p, c0) xor -> s[1];
+			(a[1], b[1]) and -> g;
+			(p, c0) and -> pc;
+			(g, pc) or -> c;
+		}
+
+		// forgets the carry into the high bit
+		block broken (a d2, b d2) -> (s d2, c d1) {
+			(a[0], b[0]) xor -> s[0];
+			(a[1], b[1]) xor -> s[1];
+			(a[1], b[1]) and -> c;
+		}
+
+		block narrow (a d2, b d1) -> (s d2, c d1) {
+			(a[0], b) xor -> s[0];
+		}
+
+		reg r (d d1) -> (q d1);
+
+		block zero (a d64) -> (b d64) {}
+	`)
+	add := Func(func(args []simulator.PortType) []simulator.PortType {
+		sum := args[0] + args[1]
+		return []simulator.PortType{sum, sum >> 2}
+	})
+
+	mismatches, err := Exhaustive(ast.Blocks["ripple"], add)
+	if err != nil || len(mismatches) != 0 {
+		t.Fatalf("expected ripple to add, got %v, %v", mismatches, err)
+	}
+
+	ref, err := BlockReference(ast.Blocks["broken"], ast.Blocks["ripple"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = ExhaustiveOptions{Limit: 2}.Exhaustive(ast.Blocks["broken"], ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 2 || mismatches[0].Error() != "a=1 b=1: s expected 2, got 0" ||
+		mismatches[1].Error() != "a=1 b=3: s expected 0, got 2, c expected 1, got 0" {
+		t.Errorf("unexpected mismatches %v", mismatches)
+	}
+
+	// a golden file recorded from ripple
+	var b bytes.Buffer
+	err = ExhaustiveOptions{}.WriteGolden(&b, ast.Blocks["ripple"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "# ripple: a b ==> s c\n0 0 ==> 0 0\n0 1 ==> 1 0\n") ||
+		strings.Count(b.String(), "\n") != 17 {
+		t.Errorf("unexpected golden file:\n%s", b.String())
+	}
+	golden, err := ReadGolden(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = Exhaustive(ast.Blocks["broken"], golden)
+	if err != nil || len(mismatches) != 4 {
+		t.Errorf("expected 4 mismatches, got %v, %v", mismatches, err)
+	}
+
+	_, err = BlockReference(ast.Blocks["ripple"], ast.Blocks["narrow"])
+	if err == nil || err.Error() != "'ripple' (d2, d2) -> (d2, d1) and 'narrow' (d2, d1) -> (d2, d1) have different signatures" {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = ExhaustiveOptions{MaxInputWidth: 3}.Exhaustive(ast.Blocks["ripple"], add)
+	if err == nil || err.Error() != "'ripple' has 4 input bits, more than 3" {
+		t.Errorf("unexpected error %v", err)
+	}
+	// every bit of a d64 arg is enumerated, with no overflow
+	not := Func(func(args []simulator.PortType) []simulator.PortType {
+		return []simulator.PortType{^args[0]}
+	})
+	mismatches, err = ExhaustiveOptions{MaxInputWidth: 64, Limit: 1}.Exhaustive(ast.Blocks["zero"], not)
+	if err != nil || len(mismatches) != 1 {
+		t.Errorf("expected a mismatch, got %v, %v", mismatches, err)
+	}
+	_, err = ExhaustiveOptions{MaxInputWidth: 100}.Exhaustive(ast.Blocks["ripple"], add)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = Exhaustive(ast.Blocks["r"], add)
+	if err == nil || err.Error() != "'r' is not combinational" {
+		t.Errorf("unexpected error %v", err)
+	}
+	_, err = ReadGolden(strings.NewReader("0 1 => 1"))
+	if err == nil || err.Error() != "ReadGolden: line 1: expected '==>'" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
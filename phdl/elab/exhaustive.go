@@ -0,0 +1,293 @@
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/simulator"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxInputWidth is the widest total input of a block that is tested
+// exhaustively by default
+const DefaultMaxInputWidth = 16
+
+// Reference gives the expected rets of a block for its args
+type Reference interface {
+	Eval(args []simulator.PortType) ([]simulator.PortType, error)
+}
+
+// Func is a reference computed by a Go function
+type Func func(args []simulator.PortType) []simulator.PortType
+
+func (f Func) Eval(args []simulator.PortType) ([]simulator.PortType, error) {
+	return f(args), nil
+}
+
+type blockReference struct {
+	sim *Simulation
+}
+
+// BlockReference gets a reference that simulates another combinational
+// block, with the same signature as block
+func BlockReference(block, ref *phdl.AstBlock) (Reference, error) {
+	err := SameSignature(block, ref)
+	if err != nil {
+		return nil, err
+	} else if ref.Sequential() {
+		return nil, fmt.Errorf("'%s' is not combinational", ref.Name)
+	}
+	sim, err := Simulate(ref)
+	if err != nil {
+		return nil, err
+	}
+	return blockReference{sim}, nil
+}
+
+func (br blockReference) Eval(args []simulator.PortType) ([]simulator.PortType, error) {
+	for idx, arg := range br.sim.Block.Args {
+		err := br.sim.Write(arg.Name, args[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+	rets := make([]simulator.PortType, len(br.sim.Block.Rets))
+	for idx, ret := range br.sim.Block.Rets {
+		rets[idx], _ = br.sim.Read(ret.Name)
+	}
+	return rets, nil
+}
+
+// SameSignature checks that two blocks have args and rets of the same widths
+func SameSignature(a, b *phdl.AstBlock) error {
+	widths := func(conns []*phdl.AstConn) string {
+		s := make([]string, len(conns))
+		for idx, conn := range conns {
+			s[idx] = fmt.Sprintf("d%v", conn.Width)
+		}
+		return "(" + strings.Join(s, ", ") + ")"
+	}
+	sa := widths(a.Args) + " -> " + widths(a.Rets)
+	sb := widths(b.Args) + " -> " + widths(b.Rets)
+	if sa != sb {
+		return fmt.Errorf("'%s' %s and '%s' %s have different signatures", a.Name, sa, b.Name, sb)
+	}
+	return nil
+}
+
+// golden is a reference recorded by WriteGolden
+type golden map[string][]simulator.PortType
+
+func goldenKey(args []simulator.PortType) string {
+	s := make([]string, len(args))
+	for idx, arg := range args {
+		s[idx] = strconv.FormatInt(int64(arg), 10)
+	}
+	return strings.Join(s, " ")
+}
+
+func (g golden) Eval(args []simulator.PortType) ([]simulator.PortType, error) {
+	rets, ok := g[goldenKey(args)]
+	if !ok {
+		return nil, fmt.Errorf("no golden vector for %s", goldenKey(args))
+	}
+	return rets, nil
+}
+
+// WriteGolden records the rets of a combinational block for every
+// combination of its args, one vector per line:
+//
+//	# half: a b ==> s c
+//	0 0 ==> 0 0
+//	0 1 ==> 1 0
+func (opts ExhaustiveOptions) WriteGolden(w io.Writer, block *phdl.AstBlock) error {
+	sim, err := opts.simulate(block)
+	if err != nil {
+		return err
+	}
+
+	names := func(conns []*phdl.AstConn) string {
+		s := make([]string, len(conns))
+		for idx, conn := range conns {
+			s[idx] = conn.Name
+		}
+		return strings.Join(s, " ")
+	}
+	fmt.Fprintf(w, "# %s: %s ==> %s\n", block.Name, names(block.Args), names(block.Rets))
+
+	return vectors(block, func(args []simulator.PortType) error {
+		rets, err := blockReference{sim}.Eval(args)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s ==> %s\n", goldenKey(args), goldenKey(rets))
+		return err
+	})
+}
+
+// ReadGolden reads a reference recorded by WriteGolden
+func ReadGolden(r io.Reader) (Reference, error) {
+	g := make(golden)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(text, "==>")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ReadGolden: line %v: expected '==>'", line)
+		}
+
+		values := make([][]simulator.PortType, 2)
+		for pidx, part := range parts {
+			for _, field := range strings.Fields(part) {
+				v, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("ReadGolden: line %v: invalid value '%s'", line, field)
+				}
+				values[pidx] = append(values[pidx], simulator.PortType(v))
+			}
+		}
+		g[goldenKey(values[0])] = values[1]
+	}
+	return g, scanner.Err()
+}
+
+// Mismatch is a vector of an exhaustive test where a block differed from its
+// reference
+type Mismatch struct {
+	Block    *phdl.AstBlock
+	Args     []simulator.PortType
+	Expected []simulator.PortType
+	Got      []simulator.PortType
+}
+
+func (m Mismatch) Error() string {
+	args := make([]string, len(m.Args))
+	for idx, arg := range m.Block.Args {
+		args[idx] = fmt.Sprintf("%s=%s", arg.Name, arg.Format(int64(m.Args[idx])))
+	}
+	rets := make([]string, 0)
+	for idx, ret := range m.Block.Rets {
+		if m.Got[idx] != m.Expected[idx] {
+			rets = append(rets, fmt.Sprintf("%s expected %s, got %s", ret.Name,
+				ret.Format(int64(m.Expected[idx])), ret.Format(int64(m.Got[idx]))))
+		}
+	}
+	return strings.Join(args, " ") + ": " + strings.Join(rets, ", ")
+}
+
+// ExhaustiveOptions control exhaustive tests
+type ExhaustiveOptions struct {
+	// MaxInputWidth is the widest total input of a block that is tested, or
+	// DefaultMaxInputWidth if 0
+	MaxInputWidth int
+	// Limit is the most mismatches found before stopping, or all if 0
+	Limit int
+}
+
+// Exhaustive compares a combinational block with a reference for every
+// combination of its args with the default options
+func Exhaustive(block *phdl.AstBlock, ref Reference) ([]Mismatch, error) {
+	return ExhaustiveOptions{}.Exhaustive(block, ref)
+}
+
+// Exhaustive compares a combinational block with a reference for every
+// combination of its args, giving the mismatches in the order the vectors are
+// enumerated. The first arg is the most significant, as in a truth table.
+func (opts ExhaustiveOptions) Exhaustive(block *phdl.AstBlock, ref Reference) ([]Mismatch, error) {
+	sim, err := opts.simulate(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]Mismatch, 0)
+	err = vectors(block, func(args []simulator.PortType) error {
+		got, err := blockReference{sim}.Eval(args)
+		if err != nil {
+			return err
+		}
+		rets, err := ref.Eval(args)
+		if err != nil {
+			return err
+		} else if len(rets) != len(got) {
+			return fmt.Errorf("reference gave %v rets for '%s', expected %v",
+				len(rets), block.Name, len(got))
+		}
+
+		expected := make([]simulator.PortType, len(rets))
+		for idx, ret := range rets {
+			expected[idx] = ret & mask(block.Rets[idx].Width)
+		}
+		for idx := range got {
+			if got[idx] != expected[idx] {
+				mismatches = append(mismatches, Mismatch{block, args, expected, got})
+				if len(mismatches) == opts.Limit {
+					return errLimit
+				}
+				break
+			}
+		}
+		return nil
+	})
+	if err == errLimit {
+		err = nil
+	}
+	return mismatches, err
+}
+
+var errLimit = errors.New("limit reached")
+
+// simulate checks that a block can be tested exhaustively, and simulates it
+func (opts ExhaustiveOptions) simulate(block *phdl.AstBlock) (*Simulation, error) {
+	max := opts.MaxInputWidth
+	if max == 0 {
+		max = DefaultMaxInputWidth
+	} else if max > 64 {
+		// vectors are enumerated in a uint64
+		max = 64
+	}
+	width := 0
+	for _, arg := range block.Args {
+		width += arg.Width
+	}
+
+	if block.Sequential() {
+		return nil, fmt.Errorf("'%s' is not combinational", block.Name)
+	} else if width > max {
+		return nil, fmt.Errorf("'%s' has %v input bits, more than %v", block.Name, width, max)
+	}
+	return Simulate(block)
+}
+
+// vectors calls fun with every combination of the args of a block, in
+// order, until it gives an error
+func vectors(block *phdl.AstBlock, fun func(args []simulator.PortType) error) error {
+	width := 0
+	for _, arg := range block.Args {
+		width += arg.Width
+	}
+
+	// the last vector is every bit set, which 1<<width overflows for d64
+	last := uint64(mask(width))
+	for v := uint64(0); ; v++ {
+		args := make([]simulator.PortType, len(block.Args))
+		shift := uint(0)
+		for idx := len(block.Args) - 1; idx >= 0; idx-- {
+			w := block.Args[idx].Width
+			args[idx] = simulator.PortType(v>>shift) & mask(w)
+			shift += uint(w)
+		}
+
+		err := fun(args)
+		if err != nil {
+			return err
+		} else if v == last {
+			return nil
+		}
+	}
+}
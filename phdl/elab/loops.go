@@ -0,0 +1,172 @@
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+)
+
+// bit is bit i of a conn, or of a literal if conn is nil
+type bit struct {
+	conn *phdl.AstConn
+	i    int
+}
+
+func (b bit) String() string {
+	if b.conn.Width == 1 {
+		return b.conn.Name
+	}
+	return fmt.Sprintf("%s[%v]", b.conn.Name, b.i)
+}
+
+// portBits gets the bits of the exprs connected to ports, in order, with
+// literal bits for ports that are not connected
+func portBits(ports []*phdl.AstConn, exprs []*phdl.AstExpr) []bit {
+	bits := make([]bit, 0)
+	for idx, port := range ports {
+		lo, conn := 0, (*phdl.AstConn)(nil)
+		if idx < len(exprs) && exprs[idx].Conn != nil {
+			conn = exprs[idx].Conn
+			if exprs[idx].HasIndex() {
+				lo = exprs[idx].Lo
+			}
+		}
+		for i := 0; i < port.Width; i++ {
+			if conn != nil && lo+i < conn.Width {
+				bits = append(bits, bit{conn, lo + i})
+			} else {
+				bits = append(bits, bit{})
+			}
+		}
+	}
+	return bits
+}
+
+func connBits(conns []*phdl.AstConn) []bit {
+	bits := make([]bit, 0)
+	for _, conn := range conns {
+		for i := 0; i < conn.Width; i++ {
+			bits = append(bits, bit{conn, i})
+		}
+	}
+	return bits
+}
+
+// loops finds the combinational loops of blocks, which the simulator can't
+// settle
+type loops struct {
+	deps map[*phdl.AstBlock][][]bool
+}
+
+func checkLoops(block *phdl.AstBlock) error {
+	l := &loops{make(map[*phdl.AstBlock][][]bool)}
+	_, err := l.block(block)
+	return err
+}
+
+// block gets which bits of the args of a block each bit of its rets depends
+// on combinationally
+func (l *loops) block(block *phdl.AstBlock) ([][]bool, error) {
+	if deps, ok := l.deps[block]; ok {
+		return deps, nil
+	}
+
+	args, rets := connBits(block.Args), connBits(block.Rets)
+	deps := make([][]bool, len(rets))
+	switch {
+	case block.Mem != nil && block.Mem.Kind != "rom":
+		// rams and regs only change on the clock
+		for r := range deps {
+			deps[r] = make([]bool, len(args))
+		}
+	case block.Builtin || block.Extern || block.Mem != nil || block.Table != nil:
+		for r := range deps {
+			deps[r] = make([]bool, len(args))
+			for a := range args {
+				deps[r][a] = true
+			}
+		}
+	default:
+		var err error
+		deps, err = l.stmts(block, args, rets)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.deps[block] = deps
+	return deps, nil
+}
+
+// stmts finds the bits that each bit of a block is driven from through its
+// statements, and follows them back to its args
+func (l *loops) stmts(block *phdl.AstBlock, args, rets []bit) ([][]bool, error) {
+	drivers := make(map[bit][]bit)
+	driven := make([]bit, 0) // in order, so the loop reported is the same every time
+	for _, stmt := range block.Stmts {
+		sub, err := l.block(stmt.Op)
+		if err != nil {
+			return nil, err
+		}
+		ins := portBits(stmt.Op.Args, stmt.Args)
+		outs := portBits(stmt.Op.Rets, stmt.Rets)
+		for r, out := range outs {
+			if out.conn != nil {
+				driven = append(driven, out)
+			}
+			for a, in := range ins {
+				if out.conn != nil && in.conn != nil && sub[r][a] {
+					drivers[out] = append(drivers[out], in)
+				}
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[bit]int)
+	from := make(map[bit][]bool)
+	for a, arg := range args {
+		state[arg] = visited
+		from[arg] = make([]bool, len(args))
+		from[arg][a] = true
+	}
+
+	var visit func(b bit) error
+	visit = func(b bit) error {
+		if state[b] == visited {
+			return nil
+		} else if state[b] == visiting {
+			return fmt.Errorf("block '%s': combinational loop through '%s'", block.Name, b)
+		}
+		state[b] = visiting
+		from[b] = make([]bool, len(args))
+		for _, driver := range drivers[b] {
+			err := visit(driver)
+			if err != nil {
+				return err
+			}
+			for a, ok := range from[driver] {
+				from[b][a] = from[b][a] || ok
+			}
+		}
+		state[b] = visited
+		return nil
+	}
+
+	for _, b := range driven {
+		err := visit(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	deps := make([][]bool, len(rets))
+	for r, ret := range rets {
+		deps[r] = make([]bool, len(args))
+		copy(deps[r], from[ret])
+	}
+	return deps, nil
+}
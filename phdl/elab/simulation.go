@@ -0,0 +1,161 @@
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Simulation simulates an elaborated block, with its conns accessed by name
+// instead of port index. Conns of instances are named by a '.' seperated path
+// of instance names, e.g. alu.adder0.carry.
+type Simulation struct {
+	Block   *phdl.AstBlock
+	Sim     *simulator.Sim
+	circuit *simulator.Circuit // nil unless the block elaborates to a circuit
+	inputs  []simulator.PortType
+	cycle   int
+}
+
+// Simulate elaborates a simulation of a type checked block with the default
+// options
+func Simulate(block *phdl.AstBlock) (*Simulation, error) {
+	return Options{}.Simulate(block)
+}
+
+// Simulate elaborates a simulation of a type checked block
+func (opts Options) Simulate(block *phdl.AstBlock) (*Simulation, error) {
+	comp, err := opts.Elaborate(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Simulation{
+		Block:  block,
+		Sim:    simulator.NewSim(comp),
+		inputs: make([]simulator.PortType, len(block.Args)),
+	}
+	s.circuit, _ = comp.(*simulator.Circuit)
+	return s, nil
+}
+
+// Conn gets the conn at a path
+func (s *Simulation) Conn(path string) (*phdl.AstConn, error) {
+	block := s.Block
+	parts := strings.Split(path, ".")
+	for _, name := range parts[:len(parts)-1] {
+		var child *phdl.AstBlock
+		for idx, instance := range block.InstanceNames() {
+			if instance == name {
+				child = block.Stmts[idx].Op
+			}
+		}
+		if child == nil {
+			return nil, fmt.Errorf("no conn '%s'", path)
+		}
+		block = child
+	}
+
+	conn, ok := block.Vars[parts[len(parts)-1]]
+	if !ok {
+		return nil, fmt.Errorf("no conn '%s'", path)
+	}
+	return conn, nil
+}
+
+// Read gets the value of the conn at a path
+func (s *Simulation) Read(path string) (simulator.PortType, error) {
+	for idx, arg := range s.Block.Args {
+		if arg.Name == path {
+			return s.inputs[idx], nil
+		}
+	}
+	for idx, ret := range s.Block.Rets {
+		if ret.Name == path {
+			return s.Sim.Read(idx), nil
+		}
+	}
+
+	// only conns, not the internal nets of asserts
+	_, err := s.Conn(path)
+	if err != nil {
+		return 0, err
+	}
+	if s.circuit != nil {
+		if net := s.circuit.Lookup(path); net != nil {
+			return net.Value(), nil
+		}
+	}
+	return 0, fmt.Errorf("no conn '%s'", path)
+}
+
+// Write sets the value of an arg of the block. It is an error if the value
+// does not fit in the arg.
+func (s *Simulation) Write(name string, value simulator.PortType) error {
+	for idx, arg := range s.Block.Args {
+		if arg.Name != name {
+			continue
+		} else if bits.Len64(uint64(value)) > arg.Width {
+			return fmt.Errorf("%v does not fit in '%s' (d%v)", value, name, arg.Width)
+		}
+
+		s.inputs[idx] = value
+		s.Sim.Write(idx, value)
+		return nil
+	}
+
+	_, err := s.Conn(name)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("'%s' is not an input", name)
+}
+
+// Step simulates one rising edge of the clock
+func (s *Simulation) Step() {
+	s.Sim.Step()
+	s.cycle++
+}
+
+// Cycle gets the number of rising edges simulated
+func (s *Simulation) Cycle() int {
+	return s.cycle
+}
+
+// AssertFailure is an assert that does not hold
+type AssertFailure struct {
+	Path   string // of the assert, e.g. alu0.assert1
+	Assert *phdl.AstAssert
+}
+
+func (af AssertFailure) Error() string {
+	return fmt.Sprintf("%s at line %v (%s) does not hold", af.Path, af.Assert.Line,
+		af.Assert.Op.Name)
+}
+
+// Asserts gets the asserts of the block and its instances that do not hold
+// in the current cycle
+func (s *Simulation) Asserts() []AssertFailure {
+	failures := make([]AssertFailure, 0)
+	if s.circuit == nil {
+		return failures
+	}
+
+	var visit func(block *phdl.AstBlock, prefix string)
+	visit = func(block *phdl.AstBlock, prefix string) {
+		for idx, name := range block.AssertNames() {
+			assert := block.Asserts[idx]
+			net := s.circuit.Lookup(prefix + AssertNet(name))
+			if s.cycle >= assert.From() && net != nil && net.Value() != 1 {
+				failures = append(failures, AssertFailure{prefix + name, assert})
+			}
+		}
+		for idx, instance := range block.InstanceNames() {
+			visit(block.Stmts[idx].Op, prefix+instance+".")
+		}
+	}
+	visit(s.Block, "")
+	return failures
+}
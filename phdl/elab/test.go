@@ -0,0 +1,77 @@
+package elab
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+)
+
+// TestFailure is a ret of a test vector that differed from its expected
+// value, or an assert that did not hold
+type TestFailure struct {
+	Vector   int
+	Ret      *phdl.AstConn
+	Expected simulator.PortType
+	Got      simulator.PortType
+	Assert   *AssertFailure // non-nil instead of Ret for asserts
+}
+
+func (tf TestFailure) Error() string {
+	if tf.Assert != nil {
+		return fmt.Sprintf("vector %v: %s", tf.Vector, tf.Assert)
+	}
+	return fmt.Sprintf("vector %v: %s expected %s, got %s", tf.Vector, tf.Ret.Name,
+		tf.Ret.Format(int64(tf.Expected)), tf.Ret.Format(int64(tf.Got)))
+}
+
+// TestResult is the values of the rets of a test vector
+type TestResult struct {
+	Got      []simulator.PortType
+	Expected []simulator.PortType
+}
+
+// RunTest simulates the vectors of a type checked test. Each vector is one
+// cycle: its args are written, its rets are compared with the outputs of the
+// block and its asserts are checked, then the clock is stepped.
+func RunTest(test *phdl.AstTest) ([]TestResult, []TestFailure, error) {
+	sim, err := Simulate(test.Block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]TestResult, len(test.Stmts))
+	failures := make([]TestFailure, 0)
+	for sidx, stmt := range test.Stmts {
+		for idx, arg := range stmt.Args {
+			if idx < len(test.Block.Args) {
+				port := test.Block.Args[idx]
+				sim.Write(port.Name, simulator.PortType(arg.Literal)&mask(port.Width))
+			}
+		}
+
+		result := TestResult{
+			Got:      make([]simulator.PortType, 0, len(stmt.Rets)),
+			Expected: make([]simulator.PortType, 0, len(stmt.Rets)),
+		}
+		for idx, ret := range stmt.Rets {
+			if idx >= len(test.Block.Rets) {
+				continue
+			}
+			port := test.Block.Rets[idx]
+			expected := simulator.PortType(ret.Literal) & mask(port.Width)
+			got, _ := sim.Read(port.Name)
+			result.Got = append(result.Got, got)
+			result.Expected = append(result.Expected, expected)
+			if got != expected {
+				failures = append(failures, TestFailure{sidx, port, expected, got, nil})
+			}
+		}
+		for _, af := range sim.Asserts() {
+			af := af
+			failures = append(failures, TestFailure{Vector: sidx, Assert: &af})
+		}
+		results[sidx] = result
+		sim.Step()
+	}
+	return results, failures, nil
+}
@@ -0,0 +1,116 @@
+// Package equiv proves that two combinational blocks compute the same
+// function, or finds args for which they differ. The blocks are flattened
+// into netlists and joined into a miter, whose output is set when any pair of
+// rets differ, and which is satisfiable exactly when the blocks differ.
+package equiv
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/netlist"
+	"github.com/petelliott/logiko/phdl/sat"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"strings"
+)
+
+// Counterexample is args for which two blocks give different rets
+type Counterexample struct {
+	A, B  *phdl.AstBlock
+	Args  []simulator.PortType
+	ARets []simulator.PortType
+	BRets []simulator.PortType
+}
+
+func (ce Counterexample) String() string {
+	ports := func(conns []*phdl.AstConn, values []simulator.PortType) string {
+		s := make([]string, len(conns))
+		for idx, conn := range conns {
+			s[idx] = fmt.Sprintf("%s=%s", conn.Name, conn.Format(int64(values[idx])))
+		}
+		return strings.Join(s, " ")
+	}
+	return fmt.Sprintf("%s: '%s' gives %s, '%s' gives %s", ports(ce.A.Args, ce.Args),
+		ce.A.Name, ports(ce.A.Rets, ce.ARets), ce.B.Name, ports(ce.B.Rets, ce.BRets))
+}
+
+// Check decides if two combinational blocks with the same signature give the
+// same rets for all args. It gives nil if they do, or a counterexample.
+func Check(a, b *phdl.AstBlock) (*Counterexample, error) {
+	err := elab.SameSignature(a, b)
+	if err != nil {
+		return nil, err
+	}
+	netlists := make([]*netlist.Netlist, 2)
+	for idx, block := range []*phdl.AstBlock{a, b} {
+		if block.Sequential() {
+			return nil, fmt.Errorf("'%s' is not combinational", block.Name)
+		}
+		netlists[idx], err = netlist.Flatten(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the blocks share input variables
+	s := sat.New()
+	inputs := make([]sat.Lit, 0)
+	for _, arg := range a.Args {
+		for i := 0; i < arg.Width; i++ {
+			inputs = append(inputs, s.NewVar())
+		}
+	}
+	alits := sat.Encode(s, netlists[0], inputs, nil)
+	blits := sat.Encode(s, netlists[1], inputs, nil)
+
+	differ := make([]sat.Lit, 0)
+	for idx, bits := range netlists[0].Outputs {
+		for i, bit := range bits {
+			differ = append(differ, s.Xor(alits[bit], blits[netlists[1].Outputs[idx][i]]))
+		}
+	}
+	s.AddClause(differ...)
+
+	if !s.Solve() {
+		return nil, nil
+	}
+
+	values := make([]bool, len(inputs))
+	for idx, in := range inputs {
+		values[idx] = s.Value(in)
+	}
+	return &Counterexample{
+		A:     a,
+		B:     b,
+		Args:  words(a.Args, values),
+		ARets: rets(netlists[0], a, values),
+		BRets: rets(netlists[1], b, values),
+	}, nil
+}
+
+// words joins bits, least significant first, into the values of conns
+func words(conns []*phdl.AstConn, bits []bool) []simulator.PortType {
+	values := make([]simulator.PortType, len(conns))
+	idx := 0
+	for cidx, conn := range conns {
+		for i := 0; i < conn.Width; i++ {
+			if bits[idx] {
+				values[cidx] |= 1 << uint(i)
+			}
+			idx++
+		}
+	}
+	return values
+}
+
+// rets evaluates the rets of a netlist of block for the bits of its args
+func rets(n *netlist.Netlist, block *phdl.AstBlock, inputs []bool) []simulator.PortType {
+	values := n.Eval(inputs, nil)
+	bits := make([]bool, 0)
+	for _, out := range n.Outputs {
+		for _, bit := range out {
+			bits = append(bits, values[bit])
+		}
+	}
+	return words(block.Rets, bits)
+}
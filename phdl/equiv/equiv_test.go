@@ -0,0 +1,105 @@
+package equiv
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/simulator"
+	"testing"
+)
+
+const prog = `
+	block full (a d1, b d1, cin d1) -> (s d1, cout d1) {
+		(a, b) xor -> p;
+		(p, cin) xor -> s;
+		(a, b) and -> g;
+		(p, cin) and -> pc;
+		(g, pc) or -> cout;
+	}
+
+	block ripple (a d3, b d3) -> (s d3, c d1) {
+		(a[0], b[0], 0) full -> s[0], c0;
+		(a[1], b[1], c0) full -> s[1], c1;
+		(a[2], b[2], c1) full -> s[2], c;
+	}
+
+	// carry lookahead, from generate and propagate of each bit
+	block cla (a d3, b d3) -> (s d3, c d1) {
+		(a[0], b[0]) and -> g0;
+		(a[1], b[1]) and -> g1;
+		(a[2], b[2]) and -> g2;
+		(a[0], b[0]) xor -> p0;
+		(a[1], b[1]) xor -> p1;
+		(a[2], b[2]) xor -> p2;
+		(p0) buf -> s[0];
+		(p1, g0) xor -> s[1];
+		(p1, g0) and -> p1g0;
+		(g1, p1g0) or -> c1;
+		(p2, c1) xor -> s[2];
+		(p2, c1) nand -> np2c1;
+		(g2) not -> ng2;
+		(ng2, np2c1) nand -> c;
+	}
+
+	// forgets the carry into the high bit
+	table broken (a d3, b d3) -> (s d3, c d1) {
+		0b-00, 0b-00 ==> 0, 0;
+		0b1--, 0b1-- ==> 0, 1;
+	}
+
+	block narrow (a d3, b d2) -> (s d3, c d1) {
+		(a[0], b[0]) xor -> s[0];
+	}
+
+	reg r (d d1) -> (q d1);
+	block seq (a d3, b d3) -> (s d3, c d1) {
+		(a[0]) r -> c;
+	}
+`
+
+func TestCheck(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	ce, err := Check(ast.Blocks["ripple"], ast.Blocks["cla"])
+	if err != nil || ce != nil {
+		t.Fatalf("expected ripple and cla to be equivalent, got %v, %v", ce, err)
+	}
+
+	ce, err = Check(ast.Blocks["ripple"], ast.Blocks["broken"])
+	if err != nil || ce == nil {
+		t.Fatalf("expected a counterexample, got %v", err)
+	}
+
+	// the counterexample holds when simulated
+	for idx, block := range []*phdl.AstBlock{ce.A, ce.B} {
+		sim, err := elab.Simulate(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sim.Write("a", ce.Args[0])
+		sim.Write("b", ce.Args[1])
+		expected := [][]simulator.PortType{ce.ARets, ce.BRets}[idx]
+		for ridx, ret := range block.Rets {
+			got, _ := sim.Read(ret.Name)
+			if got != expected[ridx] {
+				t.Errorf("%s: %s is %v, expected %v", block.Name, ret.Name, got, expected[ridx])
+			}
+		}
+	}
+	if ce.ARets[0] == ce.BRets[0] && ce.ARets[1] == ce.BRets[1] {
+		t.Errorf("counterexample does not differ: %v", ce)
+	}
+
+	for _, c := range []struct {
+		a, b     string
+		expected string
+	}{
+		{"ripple", "narrow", "'ripple' (d3, d3) -> (d3, d1) and 'narrow' (d3, d2) -> (d3, d1) have different signatures"},
+		{"seq", "ripple", "'seq' is not combinational"},
+	} {
+		_, err := Check(ast.Blocks[c.a], ast.Blocks[c.b])
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("expected error '%s', got %v", c.expected, err)
+		}
+	}
+}
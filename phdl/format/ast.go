@@ -0,0 +1,386 @@
+package format
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// FprintFile writes a compiled file as source, such as one given by an
+// importer. Enums come first, then bundles, blocks and tests, each after the
+// declarations they use, and otherwise by name. Builtins are not written, and
+// conns are written by name, with the fields of their exprs flattened into
+// indices. Conns that are only indexed are split into bits, as their width
+// could not be inferred.
+func FprintFile(w io.Writer, file *phdl.AstFile) error {
+	d := &decompiler{
+		tree:    &phdl.File{Blocks: make([]*phdl.AnyBlock, 0)},
+		written: make(map[interface{}]bool),
+	}
+
+	for _, name := range sortedKeys(file.Enums) {
+		d.enum(file.Enums[name])
+	}
+	for _, name := range sortedKeys(file.Bundles) {
+		d.bundle(file.Bundles[name])
+	}
+	for _, name := range sortedKeys(file.Blocks) {
+		err := d.block(file.Blocks[name])
+		if err != nil {
+			return fmt.Errorf("FprintFile: %s", err)
+		}
+	}
+	for _, name := range sortedKeys(file.Tests) {
+		d.test(file.Tests[name])
+	}
+	return Fprint(w, d.tree)
+}
+
+// sortedKeys gets the keys of a map of names, in order
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch m := m.(type) {
+	case map[string]*phdl.AstEnum:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*phdl.AstBundle:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*phdl.AstBlock:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*phdl.AstTest:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decompiler builds a parse tree of a compiled file
+type decompiler struct {
+	tree    *phdl.File
+	written map[interface{}]bool // enums, bundles and blocks in the tree
+}
+
+func (d *decompiler) add(ablock *phdl.AnyBlock) {
+	d.tree.Blocks = append(d.tree.Blocks, ablock)
+}
+
+func ident(name string) *phdl.Ident {
+	return &phdl.Ident{Value: name}
+}
+
+func (d *decompiler) enum(enum *phdl.AstEnum) {
+	if d.written[enum] {
+		return
+	}
+	d.written[enum] = true
+
+	penum := &phdl.Enum{
+		Ident:  ident(enum.Name),
+		Type:   fmt.Sprintf("d%v", enum.Width),
+		Values: make([]*phdl.EnumValue, len(enum.Values)),
+	}
+	if enum.Encoding != "binary" && enum.Encoding != "explicit" {
+		penum.Encoding = ident(enum.Encoding)
+	}
+	for idx, value := range enum.Values {
+		penum.Values[idx] = &phdl.EnumValue{Ident: ident(value.Name)}
+		if enum.Encoding == "explicit" {
+			penum.Values[idx].Value = strconv.FormatInt(value.Value, 10)
+		}
+	}
+	d.add(&phdl.AnyBlock{Enum: penum})
+}
+
+func (d *decompiler) bundle(bundle *phdl.AstBundle) {
+	if d.written[bundle] {
+		return
+	}
+	d.written[bundle] = true
+
+	fields := make([]*phdl.Declaration, len(bundle.Fields))
+	for idx, field := range bundle.Fields {
+		if field.Bundle != nil {
+			d.bundle(field.Bundle)
+		} else if field.Enum != nil {
+			d.enum(field.Enum)
+		}
+		fields[idx] = &phdl.Declaration{
+			Ident: ident(field.Name),
+			Type:  typeName(field.Width, field.Bundle, field.Enum),
+		}
+	}
+	d.add(&phdl.AnyBlock{Bundle: &phdl.Bundle{Ident: ident(bundle.Name), Fields: fields}})
+}
+
+func typeName(width int, bundle *phdl.AstBundle, enum *phdl.AstEnum) string {
+	if bundle != nil {
+		return bundle.Name
+	} else if enum != nil {
+		return enum.Name
+	}
+	return fmt.Sprintf("d%v", width)
+}
+
+func portDecls(conns []*phdl.AstConn) []*phdl.Declaration {
+	decls := make([]*phdl.Declaration, len(conns))
+	for idx, conn := range conns {
+		decls[idx] = &phdl.Declaration{
+			Ident: ident(conn.Name),
+			Type:  typeName(conn.Width, conn.Bundle, conn.Enum),
+		}
+	}
+	return decls
+}
+
+func treeExpr(expr *phdl.AstExpr) *phdl.Expr {
+	if expr.Enum != nil {
+		if symbol := expr.Enum.Symbol(expr.Literal); symbol != nil {
+			return &phdl.Expr{Ident: ident(symbol.Name)}
+		}
+	}
+	if expr.Conn == nil {
+		return &phdl.Expr{Literal: strconv.FormatInt(expr.Literal, 10)}
+	}
+
+	pexpr := &phdl.Expr{Ident: ident(expr.Conn.Name)}
+	for _, field := range expr.Fields {
+		pexpr.Fields = append(pexpr.Fields, ident(field))
+	}
+	if expr.HasIndex() {
+		pexpr.Index = &phdl.Index{Lo: strconv.Itoa(expr.Lo)}
+		if expr.Hi != expr.Lo {
+			pexpr.Index.Hi = strconv.Itoa(expr.Hi)
+		}
+	}
+	return pexpr
+}
+
+func treeExprs(exprs []*phdl.AstExpr) []*phdl.Expr {
+	pexprs := make([]*phdl.Expr, len(exprs))
+	for idx, expr := range exprs {
+		pexprs[idx] = treeExpr(expr)
+	}
+	return pexprs
+}
+
+// uses writes the types of the ports of a block
+func (d *decompiler) uses(block *phdl.AstBlock) {
+	for _, conn := range append(append([]*phdl.AstConn{}, block.Args...), block.Rets...) {
+		if conn.Bundle != nil {
+			d.bundle(conn.Bundle)
+		} else if conn.Enum != nil {
+			d.enum(conn.Enum)
+		}
+	}
+}
+
+// block writes a block, after the blocks it instantiates
+func (d *decompiler) block(block *phdl.AstBlock) error {
+	if block.Builtin || d.written[block] {
+		return nil
+	}
+	d.written[block] = true
+	d.uses(block)
+
+	for _, stmt := range block.Stmts {
+		err := d.block(stmt.Op)
+		if err != nil {
+			return err
+		}
+	}
+	for _, assert := range block.Asserts {
+		err := d.block(assert.Op)
+		if err != nil {
+			return err
+		}
+	}
+
+	name := ident(block.Name)
+	args, rets := portDecls(block.Args), portDecls(block.Rets)
+	switch {
+	case block.Extern:
+		d.add(&phdl.AnyBlock{Extern: &phdl.Extern{Ident: name, Args: args, Rets: rets}})
+
+	case block.Mem != nil:
+		mem := &phdl.Memory{Kind: block.Mem.Kind, Ident: name, Args: args, Rets: rets}
+		if block.Mem.File != "" {
+			mem.Init = &phdl.MemInit{
+				Format: ident(block.Mem.Format),
+				File:   strconv.Quote(block.Mem.File),
+			}
+		} else if len(block.Mem.Init) != 0 {
+			mem.Init = &phdl.MemInit{Values: make([]string, len(block.Mem.Init))}
+			for idx, value := range block.Mem.Init {
+				mem.Init.Values[idx] = strconv.FormatInt(value, 10)
+			}
+		}
+		d.add(&phdl.AnyBlock{Memory: mem})
+
+	case block.Table != nil:
+		table := &phdl.TableBlock{Ident: name, Args: args, Rets: rets}
+		for _, row := range block.Table.Rows {
+			trow := &phdl.TableRow{Rets: treeExprs(row.Rets)}
+			for _, pat := range row.Args {
+				if pat.Expr != nil {
+					trow.Args = append(trow.Args, &phdl.Pattern{Expr: treeExpr(pat.Expr)})
+				} else {
+					trow.Args = append(trow.Args, &phdl.Pattern{Pattern: pat.String()})
+				}
+			}
+			table.Rows = append(table.Rows, trow)
+		}
+		d.add(&phdl.AnyBlock{TableBlock: table})
+
+	default:
+		pblock, err := body(block)
+		if err != nil {
+			return err
+		}
+		pblock.Ident, pblock.Args, pblock.Rets = name, args, rets
+		d.add(&phdl.AnyBlock{Block: pblock})
+	}
+	return nil
+}
+
+func (d *decompiler) test(test *phdl.AstTest) {
+	ptest := &phdl.TestBlock{Ident: ident(test.Name), Block: ident(test.Block.Name)}
+	for _, stmt := range test.Stmts {
+		ptest.Stmts = append(ptest.Stmts, &phdl.TestStmt{
+			Args: treeExprs(stmt.Args),
+			Rets: treeExprs(stmt.Rets),
+		})
+	}
+	d.add(&phdl.AnyBlock{TestBlock: ptest})
+}
+
+// splitter writes the exprs of a block. The width of a conn is inferred from
+// the ports it is connected to whole, so a conn that is only indexed, as
+// importers give, is split into a d1 conn for each bit. A range of its bits is
+// copied to or from a temporary conn by buf statements.
+type splitter struct {
+	names  *phdl.Namer
+	bits   map[*phdl.AstConn][]string // of the conns that are split
+	before []*phdl.Statement          // copying ranges of the args of a statement
+	after  []*phdl.Statement          // copying ranges of the rets of a statement
+}
+
+func buf(arg, ret *phdl.Expr) *phdl.Statement {
+	return &phdl.Statement{Args: []*phdl.Expr{arg}, Ident: ident("buf"), Rets: []*phdl.Expr{ret}}
+}
+
+func (sp *splitter) expr(expr *phdl.AstExpr, ret bool) *phdl.Expr {
+	bits, ok := sp.bits[expr.Conn]
+	if !ok {
+		return treeExpr(expr)
+	} else if expr.Lo == expr.Hi {
+		return &phdl.Expr{Ident: ident(bits[expr.Lo])}
+	}
+
+	temp := sp.names.Temp()
+	for i := expr.Lo; i <= expr.Hi; i++ {
+		bit := &phdl.Expr{Ident: ident(bits[i])}
+		part := &phdl.Expr{Ident: ident(temp), Index: &phdl.Index{Lo: strconv.Itoa(i - expr.Lo)}}
+		if ret {
+			sp.after = append(sp.after, buf(part, bit))
+		} else {
+			sp.before = append(sp.before, buf(bit, part))
+		}
+	}
+	return &phdl.Expr{Ident: ident(temp)}
+}
+
+func (sp *splitter) exprs(exprs []*phdl.AstExpr, ret bool) []*phdl.Expr {
+	pexprs := make([]*phdl.Expr, len(exprs))
+	for idx, expr := range exprs {
+		pexprs[idx] = sp.expr(expr, ret)
+	}
+	return pexprs
+}
+
+// body writes the statements and asserts of a block
+func body(block *phdl.AstBlock) (*phdl.Block, error) {
+	sp := &splitter{names: phdl.NewNamer(), bits: make(map[*phdl.AstConn][]string)}
+
+	whole := make(map[*phdl.AstConn]bool)
+	for _, conn := range append(append([]*phdl.AstConn{}, block.Args...), block.Rets...) {
+		whole[conn] = true
+	}
+	exprs := make([]*phdl.AstExpr, 0)
+	for _, stmt := range block.Stmts {
+		exprs = append(append(exprs, stmt.Args...), stmt.Rets...)
+	}
+	for _, assert := range block.Asserts {
+		exprs = append(exprs, assert.Args...)
+	}
+	for _, expr := range exprs {
+		if expr.Conn != nil && !expr.HasIndex() {
+			whole[expr.Conn] = true
+		}
+	}
+
+	names := make([]string, 0, len(block.Vars))
+	for name := range block.Vars {
+		names = append(names, name)
+		sp.names.Name(name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conn := block.Vars[name]
+		if whole[conn] {
+			continue
+		} else if conn.Width == 1 {
+			sp.bits[conn] = []string{conn.Name}
+			continue
+		}
+		sp.bits[conn] = make([]string, conn.Width)
+		for i := range sp.bits[conn] {
+			sp.bits[conn][i] = sp.names.Fresh(fmt.Sprintf("%s_%d", conn.Name, i))
+		}
+	}
+
+	pblock := &phdl.Block{}
+	for idx, stmt := range block.Stmts {
+		if len(stmt.Rets) == 0 {
+			return nil, fmt.Errorf("statement %v of '%s' has no rets", idx, block.Name)
+		}
+		sp.before, sp.after = nil, nil
+		pstmt := &phdl.Statement{
+			Args:  sp.exprs(stmt.Args, false),
+			Ident: ident(stmt.Op.Name),
+			Rets:  sp.exprs(stmt.Rets, true),
+		}
+		pblock.Stmts = append(append(append(pblock.Stmts, sp.before...), pstmt), sp.after...)
+	}
+	for _, assert := range block.Asserts {
+		sp.before = nil
+		passert := &phdl.Assert{Ident: ident(assert.Op.Name)}
+		for aidx, arg := range assert.Args {
+			parg := &phdl.AssertArg{Expr: sp.expr(arg, false)}
+			if assert.Delays[aidx] != 0 {
+				parg.Delay = strconv.Itoa(assert.Delays[aidx])
+			}
+			passert.Args = append(passert.Args, parg)
+		}
+		pblock.Stmts = append(pblock.Stmts, sp.before...)
+		pblock.Asserts = append(pblock.Asserts, passert)
+	}
+
+	// the printer orders statements and asserts by offset, so statements are
+	// given the lower offsets to come first
+	for idx, stmt := range pblock.Stmts {
+		stmt.Pos.Offset = idx
+	}
+	for idx, assert := range pblock.Asserts {
+		assert.Pos.Offset = len(pblock.Stmts) + idx
+	}
+	return pblock, nil
+}
@@ -0,0 +1,445 @@
+/*
+Package format prints PHDL parse trees as canonical source.
+
+Blocks are separated by a blank line, and their bodies are indented by a tab,
+with a statement, row or field on every line. Single blank lines within a body
+are kept. The columns of table rows and test statements are aligned.
+
+The parser elides comments, so Source finds them by lexing the source again,
+and writes each before the first node that follows it, or at the end of the
+line that it followed.
+*/
+package format
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/alecthomas/participle/lexer"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Source formats PHDL source, keeping its comments
+func Source(src []byte) ([]byte, error) {
+	file := &phdl.File{}
+	err := phdl.Parser.ParseBytes(src, file)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{src: src}
+	err = p.scan()
+	if err != nil {
+		return nil, err
+	}
+	p.file(file)
+	return p.bytes(), nil
+}
+
+// Fprint writes a parse tree as source, without comments
+func Fprint(w io.Writer, file *phdl.File) error {
+	p := &printer{}
+	p.file(file)
+	_, err := w.Write(p.bytes())
+	return err
+}
+
+type comment struct {
+	text     string
+	offset   int
+	trailing bool // follows code on the same line
+	blank    bool // follows a blank line
+}
+
+type printer struct {
+	src      []byte
+	lines    []string
+	indent   int
+	comments []comment
+	rbraces  []int // offsets of closing braces
+}
+
+// scan finds the comments and closing braces of the source
+func (p *printer) scan() error {
+	lex, err := phdl.Lexer.Lex(bytes.NewReader(p.src))
+	if err != nil {
+		return err
+	}
+	tokens, err := lexer.ConsumeAll(lex)
+	if err != nil {
+		return err
+	}
+
+	symbols := phdl.Lexer.Symbols()
+	for _, token := range tokens {
+		switch token.Type {
+		case symbols["OneLineComment"], symbols["MultiLineComment"]:
+			p.comments = append(p.comments, comment{
+				text:     token.Value,
+				offset:   token.Pos.Offset,
+				trailing: p.newlines(token.Pos.Offset) == 0,
+				blank:    p.newlines(token.Pos.Offset) > 1,
+			})
+		case symbols["Rbrace"]:
+			p.rbraces = append(p.rbraces, token.Pos.Offset)
+		}
+	}
+	return nil
+}
+
+// newlines counts the newlines in the whitespace before offset, or gives -1
+// if there is only whitespace before offset
+func (p *printer) newlines(offset int) int {
+	n := 0
+	for i := offset - 1; i >= 0; i-- {
+		switch p.src[i] {
+		case '\n':
+			n++
+		case ' ', '\t', '\r':
+		default:
+			return n
+		}
+	}
+	return -1
+}
+
+// closing gets the offset of the first closing brace after offset. None of
+// the bodies in braces contain braces, so this closes the body that offset
+// begins.
+func (p *printer) closing(offset int) int {
+	for _, brace := range p.rbraces {
+		if brace > offset {
+			return brace
+		}
+	}
+	return len(p.src)
+}
+
+func (p *printer) bytes() []byte {
+	p.flush(len(p.src) + 1)
+	if len(p.lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(p.lines, "\n") + "\n")
+}
+
+func (p *printer) line(s string) {
+	p.lines = append(p.lines, strings.Repeat("\t", p.indent)+s)
+}
+
+// separate writes a blank line, unless it would begin a body or file, or
+// follow another blank line
+func (p *printer) separate() {
+	if len(p.lines) == 0 {
+		return
+	}
+	last := p.lines[len(p.lines)-1]
+	if last != "" && !strings.HasSuffix(last, "{") {
+		p.lines = append(p.lines, "")
+	}
+}
+
+// flush writes the comments before offset
+func (p *printer) flush(offset int) {
+	for len(p.comments) > 0 && p.comments[0].offset < offset {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		last := len(p.lines) - 1
+		for last >= 0 && p.lines[last] == "" {
+			last--
+		}
+		if c.trailing && last >= 0 {
+			p.lines[last] += " " + c.text
+			continue
+		}
+
+		if c.blank {
+			p.separate()
+		}
+		p.line(c.text)
+	}
+}
+
+// item writes the comments before a node at offset, and a blank line if
+// there was one before it in the source
+func (p *printer) item(offset int) {
+	if p.src == nil {
+		return
+	}
+	p.flush(offset)
+	if p.newlines(offset) > 1 {
+		p.separate()
+	}
+}
+
+// body writes header, and lines in braces. pos gives the offset of each line
+// in the source, and end the offset of the body's closing brace.
+func (p *printer) body(header string, lines []string, pos []int, end int) {
+	if len(lines) == 0 && !p.commentsBefore(end) {
+		p.line(header + " {}")
+		return
+	}
+
+	p.line(header + " {")
+	p.indent++
+	for i, line := range lines {
+		p.item(pos[i])
+		p.line(line)
+	}
+	p.flush(end)
+	p.indent--
+	p.line("}")
+}
+
+func (p *printer) commentsBefore(offset int) bool {
+	return len(p.comments) > 0 && p.comments[0].offset < offset
+}
+
+func (p *printer) file(file *phdl.File) {
+	for _, ablock := range file.Blocks {
+		p.separate()
+		p.item(ablock.Pos.Offset)
+		p.anyBlock(ablock)
+	}
+
+	// comments at the end are separated like another block
+	if len(p.comments) > 0 && !p.comments[0].trailing {
+		p.separate()
+	}
+}
+
+func (p *printer) anyBlock(ablock *phdl.AnyBlock) {
+	end := p.closing(ablock.Pos.Offset)
+	switch {
+	case ablock.Block != nil:
+		block := ablock.Block
+		lines := make([]string, 0, len(block.Stmts)+len(block.Asserts))
+		pos := make([]int, 0, len(block.Stmts)+len(block.Asserts))
+		// statements and asserts are interleaved as in the source
+		stmts, asserts := block.Stmts, block.Asserts
+		for len(stmts) != 0 || len(asserts) != 0 {
+			if len(asserts) == 0 || (len(stmts) != 0 && stmts[0].Pos.Offset < asserts[0].Pos.Offset) {
+				lines = append(lines, statement(stmts[0]))
+				pos = append(pos, stmts[0].Pos.Offset)
+				stmts = stmts[1:]
+			} else {
+				lines = append(lines, assertion(asserts[0]))
+				pos = append(pos, asserts[0].Pos.Offset)
+				asserts = asserts[1:]
+			}
+		}
+		p.body("block "+signature(block.Ident, block.Args, block.Rets, false), lines, pos, end)
+
+	case ablock.TestBlock != nil:
+		test := ablock.TestBlock
+		rows := make([][]string, len(test.Stmts))
+		pos := make([]int, len(test.Stmts))
+		for i, stmt := range test.Stmts {
+			rows[i] = row(exprs(stmt.Args), exprs(stmt.Rets))
+			pos[i] = stmt.Pos.Offset
+		}
+		header := fmt.Sprintf("test %s(%s)", test.Ident.Value, test.Block.Value)
+		p.body(header, align(rows), pos, end)
+
+	case ablock.Bundle != nil:
+		bundle := ablock.Bundle
+		lines := make([]string, len(bundle.Fields))
+		pos := make([]int, len(bundle.Fields))
+		for i, field := range bundle.Fields {
+			lines[i] = declaration(field) + ";"
+			pos[i] = field.Pos.Offset
+		}
+		p.body("bundle "+bundle.Ident.Value, lines, pos, end)
+
+	case ablock.Enum != nil:
+		p.enum(ablock.Enum, end)
+
+	case ablock.Memory != nil:
+		mem := ablock.Memory
+		s := mem.Kind + " " + signature(mem.Ident, mem.Args, mem.Rets, true)
+		if mem.Init != nil && mem.Init.Format != nil {
+			s += fmt.Sprintf(" = %s %s", mem.Init.Format.Value, mem.Init.File)
+		} else if mem.Init != nil {
+			s += " = " + braces(mem.Init.Values)
+		}
+		p.line(s + ";")
+
+	case ablock.TableBlock != nil:
+		table := ablock.TableBlock
+		rows := make([][]string, len(table.Rows))
+		pos := make([]int, len(table.Rows))
+		for i, trow := range table.Rows {
+			args := make([]string, len(trow.Args))
+			for j, pat := range trow.Args {
+				if pat.Expr != nil {
+					args[j] = expr(pat.Expr)
+				} else {
+					args[j] = pat.Pattern
+				}
+			}
+			rows[i] = row(args, exprs(trow.Rets))
+			pos[i] = trow.Pos.Offset
+		}
+		p.body("table "+signature(table.Ident, table.Args, table.Rets, true), align(rows), pos, end)
+
+	case ablock.Extern != nil:
+		extern := ablock.Extern
+		p.line("extern block " + signature(extern.Ident, extern.Args, extern.Rets, false) + ";")
+	}
+}
+
+// enum writes an enum on one line, or a value on every line if it contains
+// comments
+func (p *printer) enum(enum *phdl.Enum, end int) {
+	header := "enum " + enum.Ident.Value
+	if enum.Encoding != nil {
+		header += " " + enum.Encoding.Value
+	}
+	if enum.Type != "" {
+		header += " " + enum.Type
+	}
+
+	values := make([]string, len(enum.Values))
+	pos := make([]int, len(enum.Values))
+	for i, value := range enum.Values {
+		values[i] = value.Ident.Value
+		if value.Value != "" {
+			values[i] += " = " + value.Value
+		}
+		pos[i] = value.Pos.Offset
+	}
+
+	if p.src == nil || !p.commentsBefore(end) {
+		p.line(header + " " + braces(values))
+		return
+	}
+	for i := 0; i < len(values)-1; i++ {
+		values[i] += ","
+	}
+	p.body(header, values, pos, end)
+}
+
+func braces(values []string) string {
+	if len(values) == 0 {
+		return "{}"
+	}
+	return "{ " + strings.Join(values, ", ") + " }"
+}
+
+// signature formats a block's name and ports. Rets are written if there are
+// any, or if required.
+func signature(ident *phdl.Ident, args, rets []*phdl.Declaration, required bool) string {
+	s := ident.Value + " " + declarations(args)
+	if len(rets) > 0 || required {
+		s += " -> " + declarations(rets)
+	}
+	return s
+}
+
+func declarations(decls []*phdl.Declaration) string {
+	s := make([]string, len(decls))
+	for i, decl := range decls {
+		s[i] = declaration(decl)
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+func declaration(decl *phdl.Declaration) string {
+	return decl.Ident.Value + " " + decl.Type
+}
+
+func statement(stmt *phdl.Statement) string {
+	s := "(" + strings.Join(exprs(stmt.Args), ", ") + ")"
+	if stmt.Ident != nil {
+		s += " " + stmt.Ident.Value + " -> " + strings.Join(exprs(stmt.Rets), ", ")
+	}
+	return s + ";"
+}
+
+func assertion(assert *phdl.Assert) string {
+	args := make([]string, len(assert.Args))
+	for i, arg := range assert.Args {
+		args[i] = expr(arg.Expr)
+		if arg.Delay != "" {
+			args[i] += "@" + arg.Delay
+		}
+	}
+	return "assert (" + strings.Join(args, ", ") + ") " + assert.Ident.Value + ";"
+}
+
+func exprs(es []*phdl.Expr) []string {
+	s := make([]string, len(es))
+	for i, e := range es {
+		s[i] = expr(e)
+	}
+	return s
+}
+
+func expr(e *phdl.Expr) string {
+	if e.Ident == nil {
+		return e.Literal
+	}
+	s := e.Ident.Value
+	for _, field := range e.Fields {
+		s += "." + field.Value
+	}
+	if e.Index != nil {
+		s += "[" + e.Index.Lo
+		if e.Index.Hi != "" {
+			s += ".." + e.Index.Hi
+		}
+		s += "]"
+	}
+	return s
+}
+
+// row gets the cells of a table row or test statement
+func row(args, rets []string) []string {
+	cells := make([]string, 0, len(args)+len(rets)+1)
+	for i, arg := range args {
+		if i < len(args)-1 {
+			arg += ","
+		}
+		cells = append(cells, arg)
+	}
+	cells = append(cells, "==>")
+	for i, ret := range rets {
+		if i < len(rets)-1 {
+			ret += ","
+		}
+		cells = append(cells, ret)
+	}
+	cells[len(cells)-1] += ";"
+	return cells
+}
+
+// align joins the cells of rows, padding them to the width of their column
+func align(rows [][]string) []string {
+	widths := make([]int, 0)
+	for _, cells := range rows {
+		for i, cell := range cells {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	lines := make([]string, len(rows))
+	for i, cells := range rows {
+		var b strings.Builder
+		for j, cell := range cells {
+			if j < len(cells)-1 {
+				fmt.Fprintf(&b, "%-*s ", widths[j], cell)
+			} else {
+				b.WriteString(cell)
+			}
+		}
+		lines[i] = b.String()
+	}
+	return lines
+}
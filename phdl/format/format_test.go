@@ -0,0 +1,278 @@
+package format
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"testing"
+)
+
+const messy = `// a half adder
+block half(a d1,b d1)->(s d1,c d1){
+  (a,b)xor->s; // sum
+
+    (a , b) and -> c ;
+}
+/* enums */ enum state gray { idle, busy }
+enum op d4 {
+	add = 2, // addition
+	sub
+}
+
+
+
+bundle pair {s state;v d3;}
+rom sq(a d2)->(d d4)={0,1,0x4,9};
+rom prog (a d2) -> (d d4) = hex   "prog.hex";
+extern   block e(a d1);
+table maj (a d1, b d1, c d1) -> (o d1) {
+	1, 1, 0b- ==> 1;
+	1, 0b-, 1 ==> 1;
+	0b-, 1, 1 ==> 1;
+	/* the rest are zero */
+}
+block empty () {}
+test ht(half){0,0==>0,0;1,1==>0,1;}
+block g (p pair) -> (o d2) {
+	(p.v[0..1]) buf -> o;
+	assert(o[0] , o[1]@2)xor ;
+}
+// end
+`
+
+const formatted = `// a half adder
+block half (a d1, b d1) -> (s d1, c d1) {
+	(a, b) xor -> s; // sum
+
+	(a, b) and -> c;
+}
+
+/* enums */
+enum state gray { idle, busy }
+
+enum op d4 {
+	add = 2, // addition
+	sub
+}
+
+bundle pair {
+	s state;
+	v d3;
+}
+
+rom sq (a d2) -> (d d4) = { 0, 1, 0x4, 9 };
+
+rom prog (a d2) -> (d d4) = hex "prog.hex";
+
+extern block e (a d1);
+
+table maj (a d1, b d1, c d1) -> (o d1) {
+	1,   1,   0b- ==> 1;
+	1,   0b-, 1   ==> 1;
+	0b-, 1,   1   ==> 1;
+	/* the rest are zero */
+}
+
+block empty () {}
+
+test ht(half) {
+	0, 0 ==> 0, 0;
+	1, 1 ==> 0, 1;
+}
+
+block g (p pair) -> (o d2) {
+	(p.v[0..1]) buf -> o;
+	assert (o[0], o[1]@2) xor;
+}
+
+// end
+`
+
+func TestSource(t *testing.T) {
+	out, err := Source([]byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != formatted {
+		t.Errorf("expected/got:\n%s\n%s\n", formatted, out)
+	}
+
+	again, err := Source(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, out) {
+		t.Errorf("formatting is not idempotent:\n%s\n%s\n", out, again)
+	}
+
+	_, err = Source([]byte("block a ( {}"))
+	if err == nil {
+		t.Error("expected error formatting invalid source")
+	}
+}
+
+func TestFprint(t *testing.T) {
+	file := &phdl.File{}
+	err := phdl.Parser.ParseString(messy, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	err = Fprint(&b, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "block half (a d1, b d1) -> (s d1, c d1) {\n\t(a, b) xor -> s;\n\t(a, b) and -> c;\n}\n"
+	if !bytes.HasPrefix(b.Bytes(), []byte(expected)) {
+		t.Errorf("expected prefix/got:\n%s\n%s\n", expected, b.String())
+	}
+	if bytes.Contains(b.Bytes(), []byte("//")) {
+		t.Errorf("expected no comments, got:\n%s\n", b.String())
+	}
+}
+
+const compiled = `
+	enum state gray { idle, busy }
+	enum op { ld = 2, st }
+	bundle pair { lo d2; hi d2; }
+	bundle cmd { o op; p pair; }
+
+	rom sq (a d2) -> (d d4) = { 0, 1, 4, 9 };
+	reg r (d d1) -> (q d1);
+	extern block ext (a d1) -> (b d1);
+
+	table maj (a d1, b d1, c d1) -> (m d1) {
+		1, 1, 0b- ==> 1;
+		0b-, 1, 1 ==> 1;
+		1, 0b-, 1 ==> 1;
+	}
+
+	block top (a d1, b d1) -> (m d1) {
+		(a, b, a) maj -> m;
+		(x) r -> y;
+		(m) not -> x;
+		assert (y@2, m) or;
+	}
+
+	block user (c cmd, s state) -> (d d4) {
+		(c.p.lo) sq -> d;
+		(c[0]) ext -> e;
+	}
+
+	test t (top) {
+		0, 1 ==> 1;
+	}
+`
+
+func TestFprintFile(t *testing.T) {
+	var b bytes.Buffer
+	err := FprintFile(&b, checks.MustCompile(compiled))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// declarations come after those they use, with fields flattened
+	expected := `enum op d2 { ld = 2, st = 3 }
+
+enum state gray d1 { idle, busy }
+
+bundle pair {
+	lo d2;
+	hi d2;
+}
+
+bundle cmd {
+	o op;
+	p pair;
+}
+
+extern block ext (a d1) -> (b d1);
+
+table maj (a d1, b d1, c d1) -> (m d1) {
+	1,   1,   0bx ==> 1;
+	0bx, 1,   1   ==> 1;
+	1,   0bx, 1   ==> 1;
+}
+
+reg r (d d1) -> (q d1);
+
+rom sq (a d2) -> (d d4) = { 0, 1, 4, 9 };
+
+block top (a d1, b d1) -> (m d1) {
+	(a, b, a) maj -> m;
+	(x) r -> y;
+	(m) not -> x;
+	assert (y@2, m) or;
+}
+
+block user (c cmd, s state) -> (d d4) {
+	(c[2..3]) sq -> d;
+	(c[0]) ext -> e;
+}
+
+test t(top) {
+	0, 1 ==> 1;
+}
+`
+	if b.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, b.String())
+	}
+
+	// the source compiles to the same file
+	var again bytes.Buffer
+	err = FprintFile(&again, checks.MustCompile(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != b.String() {
+		t.Errorf("expected/got:\n%s\n%s\n", b.String(), again.String())
+	}
+}
+
+func TestFprintFileSplit(t *testing.T) {
+	ast := checks.MustCompile(`
+		block inv2 (a d2) -> (b d2) {
+			(a[0]) not -> b[0];
+			(a[1]) not -> b[1];
+		}
+
+		block top (a d1, b d2) -> (y d1, z d2) {
+			(a) not -> m;
+			(m) not -> y;
+			(b) inv2 -> n;
+			(n) inv2 -> z;
+		}
+	`)
+
+	// make m and n only indexed, as an importer may
+	top := ast.Blocks["top"]
+	top.Vars["m"].Width = 2
+	top.Stmts[0].Rets[0].Lo, top.Stmts[0].Rets[0].Hi = 0, 0
+	top.Stmts[1].Args[0].Lo, top.Stmts[1].Args[0].Hi = 0, 0
+	top.Vars["n"].Width = 3
+	top.Stmts[2].Rets[0].Lo, top.Stmts[2].Rets[0].Hi = 0, 1
+	top.Stmts[3].Args[0].Lo, top.Stmts[3].Args[0].Hi = 0, 1
+
+	var b bytes.Buffer
+	err := FprintFile(&b, ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `block top (a d1, b d2) -> (y d1, z d2) {
+	(a) not -> m_0;
+	(m_0) not -> y;
+	(b) inv2 -> _t0;
+	(_t0[0]) buf -> n_0;
+	(_t0[1]) buf -> n_1;
+	(n_0) buf -> _t1[0];
+	(n_1) buf -> _t1[1];
+	(_t1) inv2 -> z;
+}
+`
+	if !bytes.HasSuffix(b.Bytes(), []byte(expected)) {
+		t.Errorf("expected suffix/got:\n%s\n%s\n", expected, b.String())
+	}
+	checks.MustCompile(b.String())
+}
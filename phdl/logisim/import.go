@@ -0,0 +1,958 @@
+package logisim
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type xmlAttr struct {
+	Name string `xml:"name,attr"`
+	Val  string `xml:"val,attr"`
+	Text string `xml:",chardata"`
+}
+
+type xmlComp struct {
+	Lib   string    `xml:"lib,attr"`
+	Loc   string    `xml:"loc,attr"`
+	Name  string    `xml:"name,attr"`
+	Attrs []xmlAttr `xml:"a"`
+}
+
+type xmlWire struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+}
+
+type xmlBox struct {
+	X      int    `xml:"x,attr"`
+	Y      int    `xml:"y,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Pin    string `xml:"pin,attr"`
+	Facing string `xml:"facing,attr"`
+}
+
+func (b xmlBox) center() point {
+	return point{b.X + b.Width/2, b.Y + b.Height/2}
+}
+
+type xmlAppear struct {
+	Anchor *xmlBox  `xml:"circ-anchor"`
+	Ports  []xmlBox `xml:"circ-port"`
+}
+
+type xmlCircuit struct {
+	Name   string     `xml:"name,attr"`
+	Attrs  []xmlAttr  `xml:"a"`
+	Appear *xmlAppear `xml:"appear"`
+	Comps  []*xmlComp `xml:"comp"`
+	Wires  []xmlWire  `xml:"wire"`
+}
+
+type xmlLib struct {
+	Name string `xml:"name,attr"`
+	Desc string `xml:"desc,attr"`
+}
+
+type xmlProject struct {
+	XMLName  xml.Name      `xml:"project"`
+	Source   string        `xml:"source,attr"`
+	Libs     []xmlLib      `xml:"lib"`
+	Circuits []*xmlCircuit `xml:"circuit"`
+}
+
+func attr(attrs []xmlAttr, name, def string) string {
+	for _, a := range attrs {
+		if a.Name == name {
+			if a.Val == "" {
+				return strings.TrimSpace(a.Text)
+			}
+			return a.Val
+		}
+	}
+	return def
+}
+
+func intAttr(attrs []xmlAttr, name string, def int) (int, error) {
+	val := attr(attrs, name, "")
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s'", name, val)
+	}
+	return n, nil
+}
+
+func parsePoint(s string) (point, error) {
+	var p point
+	_, err := fmt.Sscanf(strings.Replace(s, " ", "", -1), "(%d,%d)", &p.x, &p.y)
+	if err != nil {
+		return p, fmt.Errorf("invalid location '%s'", s)
+	}
+	return p, nil
+}
+
+// gateOps are the builtins of the Logisim gates
+var gateOps = map[string]string{}
+
+func init() {
+	for op, name := range gateNames {
+		gateOps[name] = op
+	}
+}
+
+// Import reads the circuits of a Logisim-evolution project into a PHDL file.
+// Circuits may use each other, the basic gates, pins, tunnels, splitters and
+// constants. Wires connect at their ends. Input pins become args and output
+// pins rets, each ordered top to bottom, named after their labels, and
+// labelled tunnels become conns where possible. Subcircuits are placed by
+// their custom appearance if they have one, otherwise the classic or
+// evolution default; the evolution default depends on the widths of labels,
+// which are assumed to be in the fixed font of Logisim-evolution.
+func Import(r io.Reader) (*phdl.AstFile, error) {
+	project := &xmlProject{}
+	err := xml.NewDecoder(r).Decode(project)
+	if err != nil {
+		return nil, fmt.Errorf("Import: %s", err)
+	}
+
+	imp := &importer{
+		file: &phdl.AstFile{
+			Blocks:  make(map[string]*phdl.AstBlock),
+			Tests:   make(map[string]*phdl.AstTest),
+			Bundles: make(map[string]*phdl.AstBundle),
+			Enums:   make(map[string]*phdl.AstEnum),
+		},
+		libs:     make(map[string]string),
+		xmls:     make(map[string]*xmlCircuit),
+		circuits: make(map[string]*circuitPorts),
+		pending:  make(map[string]bool),
+		names:    phdl.NewNamer(),
+		inputs:   2,
+	}
+	// Logisim before evolution defaulted to 5 inputs on gates
+	if strings.HasPrefix(project.Source, "2.7") {
+		imp.inputs = 5
+	}
+	for _, lib := range project.Libs {
+		imp.libs[lib.Name] = lib.Desc
+	}
+	for _, c := range project.Circuits {
+		if _, ok := imp.xmls[c.Name]; ok {
+			return nil, fmt.Errorf("Import: circuit '%s' redefined", c.Name)
+		}
+		imp.xmls[c.Name] = c
+	}
+	for _, c := range project.Circuits {
+		if _, err := imp.circuit(c.Name); err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+	}
+	return imp.file, nil
+}
+
+type importer struct {
+	file     *phdl.AstFile
+	libs     map[string]string // descriptions by name
+	xmls     map[string]*xmlCircuit
+	circuits map[string]*circuitPorts
+	pending  map[string]bool
+	names    *phdl.Namer
+	inputs   int // default inputs of gates
+}
+
+// circuitPorts is a converted circuit, with the offsets of its ports from
+// the anchor of its appearance, facing east
+type circuitPorts struct {
+	block   *phdl.AstBlock
+	offsets []*point // of args then rets, nil for ports not drawn
+}
+
+// terminal is a connection point of a component
+type terminal struct {
+	loc   point
+	width int
+}
+
+type component struct {
+	kind   string // "pin", "tunnel", "constant", "splitter", "gate" or "circuit"
+	op     string // builtin of a gate
+	label  string
+	output bool // an output pin
+	facing string
+	value  int64
+	width  int
+	ends   []int         // end of each bit of a splitter, -1 for none
+	negate []bool        // inputs of a gate
+	sub    *circuitPorts // of a circuit
+	terms  []terminal    // the output, combined end or arg first
+}
+
+// circuit converts a circuit, after the circuits it uses
+func (imp *importer) circuit(name string) (*circuitPorts, error) {
+	if ports, ok := imp.circuits[name]; ok {
+		return ports, nil
+	} else if imp.pending[name] {
+		return nil, fmt.Errorf("circuit '%s' uses itself", name)
+	}
+	c := imp.xmls[name]
+	imp.pending[name] = true
+
+	comps := make([]*component, 0)
+	for _, xc := range c.Comps {
+		comp, err := imp.component(xc)
+		if err != nil {
+			return nil, fmt.Errorf("circuit '%s': component '%s' at %s: %s",
+				name, xc.Name, xc.Loc, err)
+		} else if comp != nil {
+			comps = append(comps, comp)
+		}
+	}
+
+	m := &lowering{
+		block: &phdl.AstBlock{
+			Name:  imp.names.Name(name),
+			Args:  make([]*phdl.AstConn, 0),
+			Rets:  make([]*phdl.AstConn, 0),
+			Vars:  make(map[string]*phdl.AstConn),
+			Stmts: make([]*phdl.AstStmt, 0),
+		},
+		names:  phdl.NewNamer(),
+		points: make(map[point]int),
+		claims: make(map[int]*phdl.AstExpr),
+	}
+	err := m.connect(comps, c.Wires)
+	if err != nil {
+		return nil, fmt.Errorf("circuit '%s': %s", name, err)
+	}
+
+	// pins top to bottom, then left to right
+	pins := make([]*component, 0)
+	for _, comp := range comps {
+		if comp.kind == "pin" {
+			pins = append(pins, comp)
+		}
+	}
+	sort.SliceStable(pins, func(i, j int) bool {
+		a, b := pins[i].terms[0].loc, pins[j].terms[0].loc
+		return a.y < b.y || (a.y == b.y && a.x < b.x)
+	})
+
+	ports := &circuitPorts{block: m.block}
+	aliases := make([][2]*phdl.AstExpr, 0)
+	pinOrder := make([]*component, 0)
+	for _, output := range []bool{false, true} {
+		for _, pin := range pins {
+			if pin.output != output {
+				continue
+			}
+			pinOrder = append(pinOrder, pin)
+
+			base := "in"
+			if output {
+				base = "out"
+			}
+			conn := m.conn(pin.label, base, pin.width)
+			if output {
+				m.block.Rets = append(m.block.Rets, conn)
+			} else {
+				m.block.Args = append(m.block.Args, conn)
+			}
+
+			for i := 0; i < pin.width; i++ {
+				root := m.bitRoot(pin.terms[0].loc, i)
+				if expr, ok := m.claims[root]; ok && output {
+					aliases = append(aliases, [2]*phdl.AstExpr{expr, connBit(conn, i)})
+				} else if !ok {
+					m.claims[root] = connBit(conn, i)
+				}
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, comp := range comps {
+		if comp.kind != "tunnel" || comp.label == "" || seen[comp.label] {
+			continue
+		}
+		seen[comp.label] = true
+		if n := m.net(comp.terms[0].loc); m.unclaimed(n) {
+			conn := m.conn(comp.label, "", n.width)
+			for i := 0; i < n.width; i++ {
+				m.claims[m.findBit(n.base+i)] = connBit(conn, i)
+			}
+		}
+	}
+
+	for _, comp := range comps {
+		err := m.lower(comp)
+		if err != nil {
+			return nil, fmt.Errorf("circuit '%s': %s", name, err)
+		}
+	}
+	for _, alias := range aliases {
+		m.gate("buf", alias[1], alias[0])
+	}
+
+	ports.offsets, err = imp.appearance(c, pinOrder)
+	if err != nil {
+		return nil, fmt.Errorf("circuit '%s': %s", name, err)
+	}
+
+	imp.circuits[name] = ports
+	imp.file.Blocks[m.block.Name] = m.block
+	delete(imp.pending, name)
+	return ports, nil
+}
+
+func (imp *importer) component(xc *xmlComp) (*component, error) {
+	loc, err := parsePoint(xc.Loc)
+	if err != nil {
+		return nil, err
+	}
+	comp := &component{
+		label:  attr(xc.Attrs, "label", ""),
+		facing: attr(xc.Attrs, "facing", "east"),
+	}
+	if _, ok := degrees[comp.facing]; !ok {
+		return nil, fmt.Errorf("invalid facing '%s'", comp.facing)
+	}
+	comp.width, err = intAttr(xc.Attrs, "width", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	lib, ok := imp.libs[xc.Lib]
+	if xc.Lib == "" {
+		if _, ok := imp.xmls[xc.Name]; !ok {
+			return nil, fmt.Errorf("circuit not defined")
+		}
+		comp.kind = "circuit"
+		comp.sub, err = imp.circuit(xc.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		block := comp.sub.block
+		conns := append(append([]*phdl.AstConn{}, block.Args...), block.Rets...)
+		for idx, offset := range comp.sub.offsets {
+			term := terminal{width: conns[idx].Width}
+			if offset == nil {
+				// not drawn, so never connected
+				term.width = 0
+			} else {
+				term.loc = loc.add(rotate(*offset, degrees[comp.facing]))
+			}
+			comp.terms = append(comp.terms, term)
+		}
+		return comp, nil
+	} else if !ok {
+		return nil, fmt.Errorf("library '%s' not defined", xc.Lib)
+	}
+
+	switch lib + " " + xc.Name {
+	case "#Wiring Pin":
+		comp.kind = "pin"
+		comp.output = attr(xc.Attrs, "output", "false") == "true"
+	case "#Wiring Tunnel":
+		comp.kind = "tunnel"
+	case "#Wiring Constant":
+		comp.kind = "constant"
+		val := attr(xc.Attrs, "value", "0x1")
+		value, err := strconv.ParseUint(strings.TrimPrefix(val, "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value '%s'", val)
+		}
+		comp.value = int64(value)
+	case "#Wiring Power", "#Wiring Ground":
+		comp.kind = "constant"
+		if xc.Name == "Power" {
+			comp.value = -1
+		}
+	case "#Wiring Splitter":
+		return splitter(xc, loc)
+	case "#Wiring Probe", "#Base Text":
+		return nil, nil
+	case "#Gates Buffer", "#Gates NOT Gate", "#Gates AND Gate", "#Gates OR Gate",
+		"#Gates XOR Gate", "#Gates NAND Gate", "#Gates NOR Gate", "#Gates XNOR Gate":
+		return imp.gate(xc, comp, loc)
+	default:
+		return nil, fmt.Errorf("unsupported component")
+	}
+
+	comp.terms = []terminal{{loc, comp.width}}
+	return comp, nil
+}
+
+func (imp *importer) gate(xc *xmlComp, comp *component, loc point) (*component, error) {
+	comp.kind = "gate"
+	comp.op = gateOps[xc.Name]
+
+	size, err := intAttr(xc.Attrs, "size", gateSize(comp.op))
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := intAttr(xc.Attrs, "inputs", imp.inputs)
+	if err != nil {
+		return nil, err
+	}
+	if comp.op == "buf" || comp.op == "not" {
+		inputs = 1
+	} else if inputs < 2 {
+		return nil, fmt.Errorf("invalid inputs %v", inputs)
+	}
+
+	comp.terms = []terminal{{loc, comp.width}}
+	for i := 0; i < inputs; i++ {
+		offset := gateInput(comp.op, size, inputs, i, comp.facing)
+		comp.terms = append(comp.terms, terminal{loc.add(offset), comp.width})
+		comp.negate = append(comp.negate, attr(xc.Attrs, fmt.Sprintf("negate%v", i), "") == "true")
+	}
+
+	// an xor gate of many inputs defaults to being on when exactly one is
+	if (comp.op == "xor" || comp.op == "xnor") && inputs > 2 &&
+		attr(xc.Attrs, "xor", "1") != "odd" {
+		return nil, fmt.Errorf("only odd parity xor gates are supported")
+	}
+	return comp, nil
+}
+
+func splitter(xc *xmlComp, loc point) (*component, error) {
+	comp := &component{kind: "splitter", facing: attr(xc.Attrs, "facing", "east")}
+	if _, ok := degrees[comp.facing]; !ok {
+		return nil, fmt.Errorf("invalid facing '%s'", comp.facing)
+	}
+
+	fanout, err := intAttr(xc.Attrs, "fanout", 2)
+	if err != nil {
+		return nil, err
+	}
+	incoming, err := intAttr(xc.Attrs, "incoming", 2)
+	if err != nil {
+		return nil, err
+	}
+	spacing, err := intAttr(xc.Attrs, "spacing", 1)
+	if err != nil {
+		return nil, err
+	}
+	if fanout < 1 || incoming < 1 {
+		return nil, fmt.Errorf("invalid fanout %v or incoming %v", fanout, incoming)
+	}
+
+	comp.ends = distribution(fanout, incoming)
+	widths := make([]int, fanout)
+	for i := range comp.ends {
+		val := attr(xc.Attrs, fmt.Sprintf("bit%v", i), "")
+		if val == "none" {
+			comp.ends[i] = -1
+		} else if val != "" {
+			end, err := strconv.Atoi(val)
+			if err != nil || end < 0 || end >= fanout {
+				return nil, fmt.Errorf("invalid bit%v '%s'", i, val)
+			}
+			comp.ends[i] = end
+		}
+		if comp.ends[i] != -1 {
+			widths[comp.ends[i]]++
+		}
+	}
+
+	appear := attr(xc.Attrs, "appear", "left")
+	comp.terms = []terminal{{loc, incoming}}
+	for i, width := range widths {
+		offset := splitterEnd(fanout, i, spacing, comp.facing, appear)
+		comp.terms = append(comp.terms, terminal{loc.add(offset), width})
+	}
+	return comp, nil
+}
+
+// appearance gets the offsets of the ports of a circuit from its anchor,
+// for pins ordered as the args and rets of its block
+func (imp *importer) appearance(c *xmlCircuit, pins []*component) ([]*point, error) {
+	offsets := make([]*point, len(pins))
+	if c.Appear != nil && c.Appear.Anchor != nil {
+		anchor := c.Appear.Anchor.center()
+		deg, ok := degrees[c.Appear.Anchor.Facing]
+		if !ok {
+			deg = 0
+		}
+		for _, port := range c.Appear.Ports {
+			loc, err := parsePoint(port.Pin)
+			if err != nil {
+				return nil, err
+			}
+			for idx, pin := range pins {
+				if pin.terms[0].loc == loc {
+					offset := rotate(port.center().sub(anchor), -deg)
+					offsets[idx] = &offset
+				}
+			}
+		}
+		return offsets, nil
+	}
+
+	var edges map[string][]int
+	var locs func(string, int, int) point
+	if attr(c.Attrs, "appearance", "classic") == "logisim_evolution" {
+		edges, locs = evolutionAppearance(c, pins)
+	} else {
+		edges, locs = classicAppearance(pins)
+	}
+
+	// anchor on the first east port, then north, west, and south
+	var anchor point
+	for _, edge := range []string{"east", "north", "west", "south"} {
+		if len(edges[edge]) != 0 {
+			anchor = locs(edge, 0, len(edges[edge]))
+			break
+		}
+	}
+	for edge, idxs := range edges {
+		for i, idx := range idxs {
+			offset := locs(edge, i, len(idxs)).sub(anchor)
+			offsets[idx] = &offset
+		}
+	}
+	return offsets, nil
+}
+
+// sortEdge sorts the pins of an edge top to bottom on the east and west
+// edges, and left to right on the others
+func sortEdge(idxs []int, pins []*component, edge string) {
+	sort.SliceStable(idxs, func(i, j int) bool {
+		a, b := pins[idxs[i]].terms[0].loc, pins[idxs[j]].terms[0].loc
+		if edge == "north" || edge == "south" {
+			return a.x < b.x || (a.x == b.x && a.y < b.y)
+		}
+		return a.y < b.y || (a.y == b.y && a.x < b.x)
+	})
+}
+
+var opposite = map[string]string{
+	"east": "west", "west": "east", "north": "south", "south": "north",
+}
+
+// classicAppearance puts pins on the edge opposite their facing, as the
+// classic Logisim default appearance
+func classicAppearance(pins []*component) (map[string][]int, func(string, int, int) point) {
+	edges := make(map[string][]int)
+	for idx, pin := range pins {
+		edge := opposite[pin.facing]
+		edges[edge] = append(edges[edge], idx)
+	}
+	for edge, idxs := range edges {
+		sortEdge(idxs, pins, edge)
+	}
+
+	max := func(a, b int) int {
+		if a > b {
+			return a
+		}
+		return b
+	}
+	nN, nS, nE, nW := len(edges["north"]), len(edges["south"]), len(edges["east"]), len(edges["west"])
+	maxVert, maxHorz := max(nN, nS), max(nE, nW)
+
+	offset := func(facing, opposite, others int) int {
+		most := max(facing, opposite)
+		offs := 10
+		if most <= 1 && others == 0 {
+			offs = 15
+		} else if most > 2 && others == 0 {
+			offs = 5
+		}
+		return offs + 10*((most-facing)/2)
+	}
+	dimension := func(this, others int) int {
+		if this < 3 {
+			return 30
+		} else if others == 0 {
+			return 10 * this
+		}
+		return 10*this + 10
+	}
+	offsN, offsS := offset(nN, nS, maxHorz), offset(nS, nN, maxHorz)
+	offsE, offsW := offset(nE, nW, maxVert), offset(nW, nE, maxVert)
+	width, height := dimension(maxVert, maxHorz), dimension(maxHorz, maxVert)
+
+	return edges, func(edge string, i, n int) point {
+		switch edge {
+		case "west":
+			return point{0, offsW + 10*i}
+		case "east":
+			return point{width, offsE + 10*i}
+		case "north":
+			return point{offsN + 10*i, 0}
+		}
+		return point{offsS + 10*i, height}
+	}
+}
+
+// evolutionAppearance puts inputs on the west edge and outputs on the east,
+// as the Logisim-evolution default appearance, which is as wide as the
+// labels in its fixed font of 8 pixel wide characters
+func evolutionAppearance(c *xmlCircuit, pins []*component) (map[string][]int, func(string, int, int) point) {
+	edges := make(map[string][]int)
+	labels := make(map[string]int)
+	for idx, pin := range pins {
+		edge := "west"
+		if pin.output {
+			edge = "east"
+		}
+		edges[edge] = append(edges[edge], idx)
+		if w := 8 * len(pin.label); w > labels[edge] {
+			labels[edge] = w
+		}
+	}
+	for edge, idxs := range edges {
+		sortEdge(idxs, pins, edge)
+	}
+
+	width := labels["west"] + labels["east"] + 35
+	if w := 8*len(c.Name) + 15; w > width {
+		width = w
+	}
+	if width < 30 {
+		width = 30
+	}
+	width = 10 * ((width + 9) / 10)
+
+	return edges, func(edge string, i, n int) point {
+		if edge == "east" {
+			return point{width, 10 + 20*i}
+		}
+		return point{0, 10 + 20*i}
+	}
+}
+
+// net is a set of connected points
+type net struct {
+	width int // 0 until a terminal is connected
+	base  int // of its bits, -1 until a terminal is connected
+	terms int
+	wired bool
+}
+
+// lowering converts the components of one circuit into PHDL statements
+type lowering struct {
+	block  *phdl.AstBlock
+	names  *phdl.Namer
+	points map[point]int
+	parent []int // of points
+	nets   map[int]*net
+	bits   []int                 // parent of each bit
+	claims map[int]*phdl.AstExpr // the d1 expr of each root bit
+}
+
+func find(parent []int, i int) int {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+func (m *lowering) point(p point) int {
+	if id, ok := m.points[p]; ok {
+		return id
+	}
+	m.points[p] = len(m.parent)
+	m.parent = append(m.parent, len(m.parent))
+	return m.points[p]
+}
+
+func (m *lowering) union(a, b point) {
+	m.parent[find(m.parent, m.point(a))] = find(m.parent, m.point(b))
+}
+
+func (m *lowering) net(p point) *net {
+	return m.nets[find(m.parent, m.point(p))]
+}
+
+func (m *lowering) findBit(i int) int {
+	return find(m.bits, i)
+}
+
+func (m *lowering) bitRoot(p point, i int) int {
+	return m.findBit(m.net(p).base + i)
+}
+
+// connect joins the points of wires and tunnels into nets, and the bits of
+// nets through splitters
+func (m *lowering) connect(comps []*component, wires []xmlWire) error {
+	for _, wire := range wires {
+		from, err := parsePoint(wire.From)
+		if err != nil {
+			return err
+		}
+		to, err := parsePoint(wire.To)
+		if err != nil {
+			return err
+		}
+		m.union(from, to)
+	}
+
+	tunnels := make(map[string]point)
+	for _, comp := range comps {
+		for _, term := range comp.terms {
+			if term.width != 0 {
+				m.point(term.loc)
+			}
+		}
+		if comp.kind == "tunnel" && comp.label != "" {
+			if loc, ok := tunnels[comp.label]; ok {
+				m.union(comp.terms[0].loc, loc)
+			}
+			tunnels[comp.label] = comp.terms[0].loc
+		}
+	}
+
+	m.nets = make(map[int]*net)
+	for _, id := range m.points {
+		if root := find(m.parent, id); m.nets[root] == nil {
+			m.nets[root] = &net{base: -1}
+		}
+	}
+	for _, wire := range wires {
+		from, _ := parsePoint(wire.From)
+		m.net(from).wired = true
+	}
+	for _, comp := range comps {
+		for _, term := range comp.terms {
+			if term.width == 0 {
+				continue
+			}
+			n := m.net(term.loc)
+			n.terms++
+			if n.width == 0 {
+				n.width = term.width
+			} else if n.width != term.width {
+				return fmt.Errorf("width mismatch at %v: %v and %v", term.loc, n.width, term.width)
+			}
+
+			// number the bits of nets in order of their first terminal
+			if n.base == -1 {
+				n.base = len(m.bits)
+				for i := 0; i < n.width; i++ {
+					m.bits = append(m.bits, len(m.bits))
+				}
+			}
+		}
+	}
+
+	for _, comp := range comps {
+		if comp.kind != "splitter" {
+			continue
+		}
+		combined := m.net(comp.terms[0].loc)
+		pos := make([]int, len(comp.terms)-1)
+		for bit, end := range comp.ends {
+			if end == -1 {
+				continue
+			}
+			other := m.net(comp.terms[1+end].loc)
+			a := m.findBit(combined.base + bit)
+			b := m.findBit(other.base + pos[end])
+			m.bits[a] = b
+			pos[end]++
+		}
+	}
+	return nil
+}
+
+// connected checks if a terminal is connected to anything else
+func (m *lowering) connected(term terminal) bool {
+	n := m.net(term.loc)
+	return term.width != 0 && (n.terms > 1 || n.wired)
+}
+
+// unclaimed checks if no bit of n has an expr
+func (m *lowering) unclaimed(n *net) bool {
+	for i := 0; i < n.width; i++ {
+		if _, ok := m.claims[m.findBit(n.base+i)]; ok {
+			return false
+		}
+	}
+	return n.width != 0
+}
+
+// conn adds a conn named after label, or base if label is empty
+func (m *lowering) conn(label, base string, width int) *phdl.AstConn {
+	var name string
+	if label != "" {
+		name = m.names.Name(label)
+	} else {
+		name = m.names.Fresh(base)
+	}
+	conn := &phdl.AstConn{Name: name, Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func (m *lowering) temp(width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Temp(), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func connBit(conn *phdl.AstConn, i int) *phdl.AstExpr {
+	if conn.Width == 1 {
+		return &phdl.AstExpr{Conn: conn, Lo: -1}
+	}
+	return &phdl.AstExpr{Conn: conn, Lo: i, Hi: i}
+}
+
+func literal(value int64) *phdl.AstExpr {
+	return &phdl.AstExpr{Literal: value, Lo: -1}
+}
+
+// bit gets the d1 expr of bit i of the net at p. Nets without a conn get a
+// temporary one.
+func (m *lowering) bit(p point, i int) *phdl.AstExpr {
+	n := m.net(p)
+	root := m.findBit(n.base + i)
+	if expr, ok := m.claims[root]; ok {
+		return expr
+	}
+
+	tmp := m.temp(n.width)
+	for j := 0; j < n.width; j++ {
+		if r := m.findBit(n.base + j); m.claims[r] == nil {
+			m.claims[r] = connBit(tmp, j)
+		}
+	}
+	return m.claims[root]
+}
+
+func (m *lowering) gate(op string, ret *phdl.AstExpr, args ...*phdl.AstExpr) {
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: args,
+		Op:   phdl.Builtins[op],
+		Rets: []*phdl.AstExpr{ret},
+	})
+}
+
+// base is the associative op that gates of many inputs are built from
+var base = map[string]string{
+	"and": "and", "nand": "and", "or": "or", "nor": "or", "xor": "xor", "xnor": "xor",
+}
+
+func (m *lowering) lower(comp *component) error {
+	switch comp.kind {
+	case "constant":
+		for i := 0; i < comp.width; i++ {
+			m.gate("buf", m.bit(comp.terms[0].loc, i), literal((comp.value>>uint(i))&1))
+		}
+	case "gate":
+		if !m.connected(comp.terms[0]) {
+			return nil
+		}
+		for i := 0; i < comp.width; i++ {
+			m.lowerGate(comp, i)
+		}
+	case "circuit":
+		return m.instance(comp)
+	}
+	return nil
+}
+
+// lowerGate builds bit i of a gate from builtins, leaving out unconnected
+// inputs as Logisim does
+func (m *lowering) lowerGate(comp *component, i int) {
+	args := make([]*phdl.AstExpr, 0)
+	for idx, term := range comp.terms[1:] {
+		if !m.connected(term) {
+			continue
+		}
+		arg := m.bit(term.loc, i)
+		if comp.negate[idx] {
+			tmp := connBit(m.temp(1), 0)
+			m.gate("not", tmp, arg)
+			arg = tmp
+		}
+		args = append(args, arg)
+	}
+	out := m.bit(comp.terms[0].loc, i)
+
+	switch {
+	case len(args) == 0:
+		return
+	case comp.op == "buf" || comp.op == "not":
+		m.gate(comp.op, out, args[0])
+	case len(args) == 1 && base[comp.op] == comp.op:
+		m.gate("buf", out, args[0])
+	case len(args) == 1:
+		m.gate("not", out, args[0])
+	default:
+		acc := args[0]
+		for _, arg := range args[1 : len(args)-1] {
+			tmp := connBit(m.temp(1), 0)
+			m.gate(base[comp.op], tmp, acc, arg)
+			acc = tmp
+		}
+		m.gate(comp.op, out, acc, args[len(args)-1])
+	}
+}
+
+func (m *lowering) instance(comp *component) error {
+	block := comp.sub.block
+	stmt := &phdl.AstStmt{
+		Args: make([]*phdl.AstExpr, 0),
+		Op:   block,
+		Rets: make([]*phdl.AstExpr, 0),
+	}
+
+	for idx, arg := range block.Args {
+		term := comp.terms[idx]
+		exprs := make([]*phdl.AstExpr, arg.Width)
+		for i := range exprs {
+			if m.connected(term) {
+				exprs[i] = m.bit(term.loc, i)
+			} else {
+				exprs[i] = literal(0)
+			}
+		}
+		if expr := phdl.JoinBits(exprs); expr != nil {
+			stmt.Args = append(stmt.Args, expr)
+			continue
+		}
+
+		tmp := m.temp(arg.Width)
+		for i, expr := range exprs {
+			m.gate("buf", connBit(tmp, i), expr)
+		}
+		stmt.Args = append(stmt.Args, &phdl.AstExpr{Conn: tmp, Lo: -1})
+	}
+	m.block.Stmts = append(m.block.Stmts, stmt)
+
+	for idx, ret := range block.Rets {
+		term := comp.terms[len(block.Args)+idx]
+		if !m.connected(term) {
+			stmt.Rets = append(stmt.Rets, &phdl.AstExpr{Conn: m.temp(ret.Width), Lo: -1})
+			continue
+		}
+
+		exprs := make([]*phdl.AstExpr, ret.Width)
+		for i := range exprs {
+			exprs[i] = m.bit(term.loc, i)
+		}
+		if expr := phdl.JoinBits(exprs); expr != nil {
+			stmt.Rets = append(stmt.Rets, expr)
+			continue
+		}
+
+		tmp := m.temp(ret.Width)
+		for i, expr := range exprs {
+			m.gate("buf", expr, connBit(tmp, i))
+		}
+		stmt.Rets = append(stmt.Rets, &phdl.AstExpr{Conn: tmp, Lo: -1})
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+// Package logisim translates between PHDL and the .circ projects of
+// Logisim-evolution
+package logisim
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Version is the Logisim-evolution version written to projects
+const Version = "3.8.0"
+
+// gateNames are the Logisim components of the builtins
+var gateNames = map[string]string{
+	"buf": "Buffer", "not": "NOT Gate", "and": "AND Gate", "or": "OR Gate",
+	"xor": "XOR Gate", "nand": "NAND Gate", "nor": "NOR Gate", "xnor": "XNOR Gate",
+}
+
+type point struct {
+	x, y int
+}
+
+func (p point) String() string {
+	return fmt.Sprintf("(%v,%v)", p.x, p.y)
+}
+
+func (p point) add(q point) point {
+	return point{p.x + q.x, p.y + q.y}
+}
+
+func (p point) sub(q point) point {
+	return point{p.x - q.x, p.y - q.y}
+}
+
+var degrees = map[string]int{"east": 0, "north": 90, "west": 180, "south": 270}
+
+// rotate rotates an offset counterclockwise by deg, a multiple of 90
+func rotate(p point, deg int) point {
+	for deg = ((deg % 360) + 360) % 360; deg > 0; deg -= 90 {
+		p = point{p.y, -p.x}
+	}
+	return p
+}
+
+// gateInput gets the offset of input index of a gate from its output. The
+// negated gates have an extra 10 for the bubble, and the xor gates for their
+// curved back.
+func gateInput(op string, size, inputs, index int, facing string) point {
+	if op == "buf" || op == "not" {
+		return rotate(point{-size, 0}, degrees[facing])
+	}
+
+	axis := size
+	if op == "xor" || op == "xnor" {
+		axis += 10
+	}
+	if op == "nand" || op == "nor" || op == "xnor" {
+		axis += 10
+	}
+
+	skipStart, skipDist, skipLowerEven := -5, 10, 10
+	if inputs <= 3 && size >= 40 && (size < 60 || inputs <= 2) {
+		skipStart, skipDist, skipLowerEven = -10, 20, 20
+	} else if inputs <= 3 && size >= 60 {
+		skipStart, skipDist, skipLowerEven = -15, 30, 30
+	} else if inputs == 4 && size >= 60 {
+		skipStart, skipDist, skipLowerEven = -5, 20, 0
+	}
+
+	var dy int
+	if inputs%2 == 1 {
+		dy = skipStart*(inputs-1) + skipDist*index
+	} else {
+		dy = skipStart*inputs + skipDist*index
+		if index >= inputs/2 {
+			dy += skipLowerEven
+		}
+	}
+
+	switch facing {
+	case "north":
+		return point{dy, axis}
+	case "south":
+		return point{dy, -axis}
+	case "west":
+		return point{axis, dy}
+	}
+	return point{-axis, dy}
+}
+
+// splitterEnd gets the offset of end index of a splitter from its combined
+// end
+func splitterEnd(fanout, index, spacing int, facing, appear string) point {
+	justify := -1
+	if appear == "center" || appear == "legacy" {
+		justify = 0
+	} else if appear == "right" {
+		justify = 1
+	}
+
+	if facing == "north" || facing == "south" {
+		m := 1
+		if facing == "south" {
+			m = -1
+		}
+		dx := 10 * fanout
+		if justify == 0 {
+			dx = 10 * ((fanout+1)/2 - 1)
+		} else if m*justify < 0 {
+			dx = -10
+		}
+		return point{spacing * (dx - 10*index), -m * 20}
+	}
+
+	m := 1
+	if facing == "west" {
+		m = -1
+	}
+	dy := -10 * fanout
+	if justify == 0 {
+		dy = -10 * (fanout / 2)
+	} else if m*justify > 0 {
+		dy = 10
+	}
+	return point{m * 20, spacing * (dy + 10*index)}
+}
+
+// distribution gets the end of each bit of a splitter that has not set them,
+// spreading the bits over the ends in order
+func distribution(fanout, bits int) []int {
+	ends := make([]int, bits)
+	if fanout >= bits {
+		for i := range ends {
+			ends[i] = i
+		}
+		return ends
+	}
+
+	per, extra := bits/fanout, bits%fanout
+	end, left := -1, 0
+	for i := range ends {
+		if left == 0 {
+			end++
+			left = per
+			if extra > 0 {
+				left++
+				extra--
+			}
+		}
+		ends[i] = end
+		left--
+	}
+	return ends
+}
+
+// appearance is the box drawn for a block used as a sub-circuit, with the
+// offsets of its ports from the anchor
+type appearance struct {
+	width, height int
+	anchor        point // from the top left corner
+	args, rets    []point
+}
+
+// blockAppearance puts args on the left and rets on the right of a box, with
+// the anchor on the first ret, or the first arg if there are none
+func blockAppearance(block *phdl.AstBlock) *appearance {
+	n := len(block.Args)
+	if len(block.Rets) > n {
+		n = len(block.Rets)
+	}
+	if n == 0 {
+		n = 1
+	}
+	app := &appearance{
+		width:  10 * ((8*len(block.Name) + 29) / 10),
+		height: 20*n + 20,
+	}
+	if app.width < 40 {
+		app.width = 40
+	}
+	if len(block.Rets) != 0 {
+		app.anchor = point{app.width, 20}
+	} else if len(block.Args) != 0 {
+		app.anchor = point{0, 20}
+	}
+
+	for i := range block.Args {
+		app.args = append(app.args, point{0, 20 + 20*i}.sub(app.anchor))
+	}
+	for i := range block.Rets {
+		app.rets = append(app.rets, point{app.width, 20 + 20*i}.sub(app.anchor))
+	}
+	return app
+}
+
+// Write writes every block of file as a circuit of a Logisim-evolution
+// project, with main as the main circuit if it is not empty. The statements
+// of a block are stacked in a column, with their ports connected by tunnels
+// named after conns, through splitters for slices. Table blocks are
+// synthesized to gates. Memories and extern blocks are not written, and
+// blocks using them can't be.
+func Write(w io.Writer, file *phdl.AstFile, main string) error {
+	names := make([]string, 0, len(file.Blocks))
+	for name, block := range file.Blocks {
+		if block.Mem == nil && !block.Extern {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if _, ok := file.Blocks[main]; main != "" && !ok {
+		return fmt.Errorf("Write: block '%s' not defined", main)
+	} else if main == "" && len(names) != 0 {
+		main = names[0]
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, `<?xml version="1.0" encoding="UTF-8" standalone="no"?>`)
+	fmt.Fprintf(&b, "<project source=\"%s\" version=\"1.0\">\n", Version)
+	fmt.Fprintln(&b, `  <lib desc="#Wiring" name="0"/>`)
+	fmt.Fprintln(&b, `  <lib desc="#Gates" name="1"/>`)
+	fmt.Fprintf(&b, "  <main name=\"%s\"/>\n", main)
+	for _, name := range names {
+		err := writeCircuit(&b, file.Blocks[name])
+		if err != nil {
+			return fmt.Errorf("Write: %s", err)
+		}
+	}
+	fmt.Fprintln(&b, "</project>")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// circuit writes the components of one circuit
+type circuit struct {
+	b      *bytes.Buffer
+	labelW int // room for the widest tunnel
+}
+
+func (c *circuit) comp(lib, name string, loc point, attrs ...string) {
+	if lib == "" {
+		fmt.Fprintf(c.b, "    <comp loc=\"%v\" name=\"%s\">\n", loc, name)
+	} else {
+		fmt.Fprintf(c.b, "    <comp lib=\"%s\" loc=\"%v\" name=\"%s\">\n", lib, loc, name)
+	}
+	for i := 0; i < len(attrs); i += 2 {
+		fmt.Fprintf(c.b, "      <a name=\"%s\" val=\"%s\"/>\n", attrs[i], attrs[i+1])
+	}
+	fmt.Fprintln(c.b, "    </comp>")
+}
+
+// connect connects expr to a port at p of width. Tunnels of args extend left
+// of the port, and of rets right.
+func (c *circuit) connect(p point, expr *phdl.AstExpr, width int, arg bool) {
+	facing := "west"
+	if arg {
+		facing = "east"
+	}
+
+	if expr.Conn == nil {
+		value := uint64(expr.Literal)
+		if width < 64 {
+			value &= (uint64(1) << uint(width)) - 1
+		}
+		c.comp("0", "Constant", p,
+			"width", fmt.Sprint(width), "value", fmt.Sprintf("0x%x", value))
+		return
+	}
+
+	conn := expr.Conn
+	if expr.HasIndex() {
+		// a splitter of one end with the bits of the slice
+		loc := point{p.x + 20, p.y - 10}
+		if arg {
+			loc = point{p.x - 20, p.y + 10}
+		}
+		attrs := []string{"facing", facing, "fanout", "1",
+			"incoming", fmt.Sprint(conn.Width), "appear", "left"}
+		for i := 0; i < conn.Width; i++ {
+			end := "none"
+			if i >= expr.Lo && i <= expr.Hi {
+				end = "0"
+			}
+			attrs = append(attrs, fmt.Sprintf("bit%v", i), end)
+		}
+		c.comp("0", "Splitter", loc, attrs...)
+		p = loc
+	}
+
+	c.comp("0", "Tunnel", p,
+		"facing", facing, "width", fmt.Sprint(conn.Width), "label", conn.Name)
+}
+
+// ports gets the offsets of the args and rets of an op from its location
+func ports(op *phdl.AstBlock) ([]point, []point) {
+	if !op.Builtin {
+		app := blockAppearance(op)
+		return app.args, app.rets
+	}
+
+	args := make([]point, len(op.Args))
+	for i := range args {
+		args[i] = gateInput(op.Name, gateSize(op.Name), len(op.Args), i, "east")
+	}
+	return args, []point{{0, 0}}
+}
+
+func gateSize(op string) int {
+	switch op {
+	case "buf":
+		return 20
+	case "not":
+		return 30
+	}
+	return 50
+}
+
+func writeCircuit(b *bytes.Buffer, block *phdl.AstBlock) error {
+	if block.Table != nil {
+		block = phdl.SynthesizeTable(block)
+	}
+	for _, stmt := range block.Stmts {
+		if stmt.Op.Mem != nil || stmt.Op.Extern {
+			return fmt.Errorf("block '%s': '%s' can't be written", block.Name, stmt.Op.Name)
+		}
+	}
+
+	c := &circuit{b: b}
+	for name := range block.Vars {
+		if w := 8*len(name) + 20; w > c.labelW {
+			c.labelW = w
+		}
+	}
+	c.labelW = 10 * ((c.labelW + 9) / 10)
+
+	fmt.Fprintf(b, "  <circuit name=\"%s\">\n", block.Name)
+	fmt.Fprintf(b, "    <a name=\"circuit\" val=\"%s\"/>\n", block.Name)
+
+	// pins on the left and right, around a column of statements
+	reach := 0
+	for _, stmt := range block.Stmts {
+		args, _ := ports(stmt.Op)
+		for _, arg := range args {
+			if -arg.x > reach {
+				reach = -arg.x
+			}
+		}
+	}
+	pinX := 100
+	stmtX := pinX + 2*c.labelW + 60 + reach
+	outX := stmtX + 2*c.labelW + 60
+
+	app := blockAppearance(block)
+	origin := point{50, 50}
+	anchor := origin.add(app.anchor)
+	fmt.Fprintln(b, "    <appear>")
+	fmt.Fprintf(b, "      <rect fill=\"none\" height=\"%v\" stroke=\"#000000\" stroke-width=\"2\" width=\"%v\" x=\"%v\" y=\"%v\"/>\n",
+		app.height, app.width, origin.x, origin.y)
+	fmt.Fprintf(b, "      <text font-family=\"SansSerif\" font-size=\"12\" text-anchor=\"middle\" x=\"%v\" y=\"%v\">%s</text>\n",
+		origin.x+app.width/2, origin.y+app.height-6, block.Name)
+	for i := range block.Args {
+		p := anchor.add(app.args[i])
+		fmt.Fprintf(b, "      <circ-port height=\"8\" pin=\"%v\" width=\"8\" x=\"%v\" y=\"%v\"/>\n",
+			point{pinX, 100 + 40*i}, p.x-4, p.y-4)
+	}
+	for i := range block.Rets {
+		p := anchor.add(app.rets[i])
+		fmt.Fprintf(b, "      <circ-port height=\"10\" pin=\"%v\" width=\"10\" x=\"%v\" y=\"%v\"/>\n",
+			point{outX, 100 + 40*i}, p.x-5, p.y-5)
+	}
+	fmt.Fprintf(b, "      <circ-anchor facing=\"east\" height=\"6\" width=\"6\" x=\"%v\" y=\"%v\"/>\n",
+		anchor.x-3, anchor.y-3)
+	fmt.Fprintln(b, "    </appear>")
+
+	for i, arg := range block.Args {
+		p := point{pinX, 100 + 40*i}
+		c.comp("0", "Pin", p, "width", fmt.Sprint(arg.Width), "label", arg.Name)
+		c.connect(p, &phdl.AstExpr{Conn: arg, Lo: -1}, arg.Width, false)
+	}
+	for i, ret := range block.Rets {
+		p := point{outX, 100 + 40*i}
+		c.comp("0", "Pin", p, "facing", "west", "output", "true",
+			"width", fmt.Sprint(ret.Width), "label", ret.Name)
+		c.connect(p, &phdl.AstExpr{Conn: ret, Lo: -1}, ret.Width, true)
+	}
+
+	y := 100
+	for _, stmt := range block.Stmts {
+		args, rets := ports(stmt.Op)
+		top, bottom := 0, 0
+		for _, p := range append(append([]point{}, args...), rets...) {
+			if p.y < top {
+				top = p.y
+			}
+			if p.y > bottom {
+				bottom = p.y
+			}
+		}
+		loc := point{stmtX, y - top + 10}
+		y = loc.y + bottom + 50
+
+		if stmt.Op.Builtin {
+			attrs := []string{"size", fmt.Sprint(gateSize(stmt.Op.Name))}
+			if len(stmt.Op.Args) == 2 {
+				attrs = append(attrs, "inputs", "2")
+			} else if stmt.Op.Name == "buf" {
+				attrs = nil
+			}
+			c.comp("1", gateNames[stmt.Op.Name], loc, attrs...)
+		} else {
+			c.comp("", stmt.Op.Name, loc)
+		}
+
+		for idx, arg := range stmt.Args {
+			c.connect(loc.add(args[idx]), arg, stmt.Op.Args[idx].Width, true)
+		}
+		for idx, ret := range stmt.Rets {
+			c.connect(loc.add(rets[idx]), ret, stmt.Op.Rets[idx].Width, false)
+		}
+	}
+
+	fmt.Fprintln(b, "  </circuit>")
+	return nil
+}
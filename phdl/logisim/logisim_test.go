@@ -0,0 +1,291 @@
+package logisim
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/format"
+	"github.com/petelliott/logiko/simulator"
+	"strings"
+	"testing"
+)
+
+func TestGeometry(t *testing.T) {
+	for _, c := range []struct {
+		op                  string
+		size, inputs, index int
+		facing              string
+		expected            point
+	}{
+		{"and", 50, 2, 0, "east", point{-50, -20}},
+		{"and", 50, 2, 1, "east", point{-50, 20}},
+		{"nand", 50, 2, 1, "east", point{-60, 20}},
+		{"xnor", 30, 3, 2, "east", point{-50, 10}},
+		{"or", 50, 5, 0, "north", point{-20, 50}},
+		{"not", 30, 1, 0, "south", point{0, -30}},
+	} {
+		got := gateInput(c.op, c.size, c.inputs, c.index, c.facing)
+		if got != c.expected {
+			t.Errorf("%v: expected %v, got %v", c, c.expected, got)
+		}
+	}
+
+	if got := splitterEnd(2, 1, 1, "east", "left"); got != (point{20, -10}) {
+		t.Errorf("expected (20,-10), got %v", got)
+	}
+	if got := splitterEnd(3, 0, 1, "west", "center"); got != (point{-20, -10}) {
+		t.Errorf("expected (-20,-10), got %v", got)
+	}
+	if got := distribution(3, 8); fmtInts(got) != "0 0 0 1 1 1 2 2" {
+		t.Errorf("unexpected distribution %v", got)
+	}
+}
+
+func fmtInts(ints []int) string {
+	s := make([]string, len(ints))
+	for idx, i := range ints {
+		s[idx] = string(rune('0' + i))
+	}
+	return strings.Join(s, " ")
+}
+
+func TestRoundTrip(t *testing.T) {
+	ast := checks.MustCompile(`
+		table maj (a d1, b d1, c d1) -> (o d1) {
+			1, 1, 0b- ==> 1;
+			1, 0b-, 1 ==> 1;
+			0b-, 1, 1 ==> 1;
+		}
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) nand -> nc;
+			(nc) not -> c;
+		}
+
+		block full (cin d1, a d2) -> (s d1, cout d1, m d1, v d3) {
+			(a[0], a[1]) half -> t, c1;
+			(t, cin) half -> s, c2;
+			(c1, c2) or -> cout;
+			(cin, a[0], a[1]) maj -> m;
+			(1) buf -> v[2];
+			(t) buf -> v[0];
+			(0) buf -> v[1];
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Write(&b, ast, "full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<main name="full"/>`,
+		`<comp loc="(`,
+		`name="half">`,
+		`<a name="label" val="cin"/>`,
+		`<circ-anchor facing="east"`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected '%s' in:\n%s", s, b.String())
+		}
+	}
+
+	imported, err := Import(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := imported.Blocks["full"]
+	names := make([]string, 0)
+	for _, conn := range append(full.Args, full.Rets...) {
+		names = append(names, conn.Name)
+	}
+	if strings.Join(names, " ") != "cin a s cout m v" {
+		t.Errorf("unexpected ports %v", names)
+	}
+
+	ref, err := elab.BlockReference(full, ast.Blocks["full"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := elab.Exhaustive(full, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+
+	// written as PHDL, the import compiles to an equivalent block
+	var source bytes.Buffer
+	err = format.FprintFile(&source, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := checks.MustCompile(source.String()).Blocks["full"]
+	ref, err = elab.BlockReference(written, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err = elab.Exhaustive(written, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
+
+const project = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<project source="3.8.0" version="1.0">
+  <lib desc="#Wiring" name="0"/>
+  <lib desc="#Gates" name="1"/>
+  <lib desc="#Base" name="2"/>
+  <main name="main"/>
+  <circuit name="inv">
+    <comp lib="0" loc="(100,100)" name="Pin">
+      <a name="label" val="a"/>
+    </comp>
+    <comp lib="0" loc="(200,100)" name="Pin">
+      <a name="facing" val="west"/>
+      <a name="output" val="true"/>
+      <a name="label" val="y"/>
+    </comp>
+    <comp lib="1" loc="(180,100)" name="NOT Gate"/>
+    <wire from="(100,100)" to="(150,100)"/>
+    <wire from="(180,100)" to="(200,100)"/>
+  </circuit>
+  <circuit name="main">
+    <comp lib="2" loc="(50,50)" name="Text">
+      <a name="text" val="a comment"/>
+    </comp>
+    <comp lib="0" loc="(100,100)" name="Pin">
+      <a name="label" val="a"/>
+      <a name="width" val="2"/>
+    </comp>
+    <comp lib="0" loc="(100,200)" name="Pin">
+      <a name="label" val="b"/>
+    </comp>
+    <comp lib="0" loc="(400,100)" name="Pin">
+      <a name="facing" val="west"/>
+      <a name="output" val="true"/>
+      <a name="label" val="y"/>
+    </comp>
+    <comp lib="0" loc="(400,200)" name="Pin">
+      <a name="facing" val="west"/>
+      <a name="output" val="true"/>
+      <a name="width" val="2"/>
+      <a name="label" val="z"/>
+    </comp>
+    <comp lib="0" loc="(120,100)" name="Splitter"/>
+    <wire from="(100,100)" to="(120,100)"/>
+    <comp lib="1" loc="(250,100)" name="AND Gate">
+      <a name="negate1" val="true"/>
+    </comp>
+    <wire from="(140,80)" to="(200,80)"/>
+    <wire from="(140,90)" to="(160,90)"/>
+    <wire from="(160,90)" to="(160,120)"/>
+    <wire from="(160,120)" to="(200,120)"/>
+    <comp loc="(350,100)" name="inv"/>
+    <wire from="(250,100)" to="(320,100)"/>
+    <wire from="(350,100)" to="(400,100)"/>
+    <comp lib="0" loc="(400,200)" name="Splitter">
+      <a name="facing" val="west"/>
+    </comp>
+    <comp lib="0" loc="(100,200)" name="Tunnel">
+      <a name="label" val="bt"/>
+    </comp>
+    <comp lib="0" loc="(360,210)" name="Tunnel">
+      <a name="facing" val="east"/>
+      <a name="label" val="bt"/>
+    </comp>
+    <wire from="(360,210)" to="(380,210)"/>
+    <comp lib="0" loc="(370,220)" name="Constant"/>
+    <wire from="(370,220)" to="(380,220)"/>
+  </circuit>
+</project>
+`
+
+func TestImport(t *testing.T) {
+	ast, err := Import(strings.NewReader(project))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	main := ast.Blocks["main"]
+	if len(main.Args) != 2 || main.Args[0].Width != 2 || main.Rets[1].Name != "z" {
+		t.Errorf("unexpected ports %v -> %v", main.Args, main.Rets)
+	}
+
+	sim, err := elab.Simulate(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for a := simulator.PortType(0); a < 4; a++ {
+		for b := simulator.PortType(0); b < 2; b++ {
+			sim.Write("a", a)
+			sim.Write("b", b)
+			y := 1 &^ (a & 1 &^ (a >> 1))
+			if got, _ := sim.Read("y"); got != y {
+				t.Errorf("a=%v b=%v: expected y=%v, got %v", a, b, y, got)
+			}
+			if got, _ := sim.Read("z"); got != b|2 {
+				t.Errorf("a=%v b=%v: expected z=%v, got %v", a, b, b|2, got)
+			}
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	circuit := func(body string) string {
+		return `<project source="3.8.0" version="1.0">
+			<lib desc="#Wiring" name="0"/><lib desc="#Gates" name="1"/>
+			<circuit name="m">` + body + `</circuit></project>`
+	}
+	for _, src := range []string{
+		circuit(`<comp lib="0" loc="(10,10)" name="Pin"><a name="width" val="2"/></comp>
+			<comp lib="1" loc="(60,30)" name="AND Gate"/>
+			<wire from="(10,10)" to="(10,20)"/>`),
+		circuit(`<comp lib="0" loc="(10,10)" name="Clock"/>`),
+		circuit(`<comp lib="4" loc="(10,10)" name="Register"/>`),
+		circuit(`<comp loc="(10,10)" name="n"/>`),
+		circuit(`<comp loc="(10,10)" name="m"/>`),
+		circuit(`<comp lib="0" loc="10,10" name="Pin"/>`),
+		circuit(`<comp lib="1" loc="(10,10)" name="XOR Gate"><a name="inputs" val="3"/></comp>`),
+		`<project><circuit name="m">`,
+		`<circuit name="m"/>`,
+	} {
+		_, err := Import(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error importing '%s'", src)
+		}
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	ast := checks.MustCompile(`
+		rom mem (addr d2) -> (out d1) = { 1, 0, 1, 1 };
+
+		block a (x d2) -> (y d1) {
+			(x) mem -> y;
+		}
+	`)
+
+	err := Write(&bytes.Buffer{}, ast, "")
+	if err == nil {
+		t.Errorf("expected error writing block using a rom")
+	}
+	err = Write(&bytes.Buffer{}, ast, "b")
+	if err == nil {
+		t.Errorf("expected error writing undefined main")
+	}
+}
@@ -0,0 +1,368 @@
+package lsp
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/alecthomas/participle/lexer"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type symbolKind int
+
+const (
+	symBlock symbolKind = iota
+	symConn
+)
+
+type symbol struct {
+	kind  symbolKind
+	block string // the block that a conn is in
+	name  string
+}
+
+// occurrence is an identifier in the source that names a symbol
+type occurrence struct {
+	symbol
+	pos  lexer.Position
+	decl bool // declares a block or port
+	ret  bool // drives a conn, as a ret of a statement
+}
+
+func (o *occurrence) Range() Range {
+	start := Position{o.pos.Line - 1, o.pos.Column - 1}
+	return Range{start, Position{start.Line, start.Character + len(o.name)}}
+}
+
+// document is the analysis of an open source file. If the source does not
+// parse, everything but the diagnostics is kept from the last version that
+// did.
+type document struct {
+	uri         string
+	file        *phdl.File
+	ast         *phdl.AstFile
+	occurrences []*occurrence
+	scopes      []scope
+	diagnostics []Diagnostic
+}
+
+// scope is a top level declaration, beginning at a line of the source
+type scope struct {
+	line  int
+	block string // "" if it does not declare a block
+}
+
+func newDocument(uri string) *document {
+	return &document{
+		uri: uri,
+		ast: &phdl.AstFile{
+			Blocks:  make(map[string]*phdl.AstBlock),
+			Tests:   make(map[string]*phdl.AstTest),
+			Bundles: make(map[string]*phdl.AstBundle),
+			Enums:   make(map[string]*phdl.AstEnum),
+		},
+		occurrences: make([]*occurrence, 0),
+		scopes:      make([]scope, 0),
+		diagnostics: make([]Diagnostic, 0),
+	}
+}
+
+// analyze parses, compiles and type checks text. Unlike CompileFile, it
+// continues after errors, so there is a diagnostic for every declaration
+// that has one.
+func (doc *document) analyze(text string) *document {
+	file := &phdl.File{}
+	err := phdl.Parser.ParseString(text, file)
+	if err != nil {
+		stale := *doc
+		stale.diagnostics = []Diagnostic{parseDiagnostic(err)}
+		return &stale
+	}
+
+	next := newDocument(doc.uri)
+	next.file = file
+	for _, ablock := range file.Blocks {
+		err := phdl.CompileAnyBlock(next.ast, ablock)
+		if err != nil {
+			next.diagnose(declName(ablock), err)
+		}
+	}
+
+	checked := make(map[*phdl.AstBlock]bool)
+	for _, ablock := range file.Blocks {
+		ident := declName(ablock)
+		block, ok := next.ast.Blocks[ident.Value]
+		if ablock.TestBlock != nil || ablock.Bundle != nil || ablock.Enum != nil ||
+			!ok || checked[block] {
+			continue
+		}
+		checked[block] = true
+
+		err := checks.TypeCheckBlock(block)
+		if err != nil {
+			next.diagnose(ident, err)
+		}
+	}
+
+	next.index()
+	return next
+}
+
+func parseDiagnostic(err error) Diagnostic {
+	pos := Position{}
+	if lerr, ok := err.(*lexer.Error); ok {
+		pos = Position{lerr.Pos.Line - 1, lerr.Pos.Column - 1}
+	}
+	return Diagnostic{
+		Range:    Range{pos, Position{pos.Line, pos.Character + 1}},
+		Severity: SeverityError,
+		Source:   "phdl",
+		Message:  err.Error(),
+	}
+}
+
+// diagnose adds an error at the name of a declaration
+func (doc *document) diagnose(ident *phdl.Ident, err error) {
+	o := &occurrence{symbol: symbol{name: ident.Value}, pos: ident.Pos}
+	doc.diagnostics = append(doc.diagnostics, Diagnostic{
+		Range:    o.Range(),
+		Severity: SeverityError,
+		Source:   "phdl",
+		Message:  err.Error(),
+	})
+}
+
+// declName gets the name of a top level declaration
+func declName(ablock *phdl.AnyBlock) *phdl.Ident {
+	switch {
+	case ablock.Block != nil:
+		return ablock.Block.Ident
+	case ablock.TestBlock != nil:
+		return ablock.TestBlock.Ident
+	case ablock.Bundle != nil:
+		return ablock.Bundle.Ident
+	case ablock.Enum != nil:
+		return ablock.Enum.Ident
+	case ablock.Memory != nil:
+		return ablock.Memory.Ident
+	case ablock.TableBlock != nil:
+		return ablock.TableBlock.Ident
+	}
+	return ablock.Extern.Ident
+}
+
+// index finds the occurrences of blocks and conns in the parse tree
+func (doc *document) index() {
+	for _, ablock := range doc.file.Blocks {
+		sc := scope{line: ablock.Pos.Line}
+		switch {
+		case ablock.Block != nil:
+			block := ablock.Block
+			sc.block = block.Ident.Value
+			doc.ports(block.Ident, block.Args, block.Rets)
+			for _, stmt := range block.Stmts {
+				if stmt.Ident != nil {
+					doc.add(symBlock, "", stmt.Ident, false, false)
+				}
+				doc.exprs(sc.block, stmt.Args, false)
+				doc.exprs(sc.block, stmt.Rets, true)
+			}
+
+		case ablock.TableBlock != nil:
+			table := ablock.TableBlock
+			sc.block = table.Ident.Value
+			doc.ports(table.Ident, table.Args, table.Rets)
+
+		case ablock.Memory != nil:
+			doc.ports(ablock.Memory.Ident, ablock.Memory.Args, ablock.Memory.Rets)
+
+		case ablock.Extern != nil:
+			doc.ports(ablock.Extern.Ident, ablock.Extern.Args, ablock.Extern.Rets)
+
+		case ablock.TestBlock != nil:
+			doc.add(symBlock, "", ablock.TestBlock.Block, false, false)
+		}
+		doc.scopes = append(doc.scopes, sc)
+	}
+}
+
+func (doc *document) add(kind symbolKind, block string, ident *phdl.Ident, decl, ret bool) {
+	doc.occurrences = append(doc.occurrences, &occurrence{
+		symbol: symbol{kind, block, ident.Value},
+		pos:    ident.Pos,
+		decl:   decl,
+		ret:    ret,
+	})
+}
+
+func (doc *document) ports(ident *phdl.Ident, args, rets []*phdl.Declaration) {
+	doc.add(symBlock, "", ident, true, false)
+	for _, decl := range append(args, rets...) {
+		doc.add(symConn, ident.Value, decl.Ident, true, false)
+	}
+}
+
+func (doc *document) exprs(block string, exprs []*phdl.Expr, ret bool) {
+	ablock := doc.ast.Blocks[block]
+	for _, expr := range exprs {
+		if expr.Ident == nil {
+			continue
+		}
+		// names that are not conns of a compiled block are enum values
+		if ablock != nil && ablock.Vars[expr.Ident.Value] == nil {
+			continue
+		}
+		doc.add(symConn, block, expr.Ident, false, ret)
+	}
+}
+
+// at gets the occurrence at a position, or nil
+func (doc *document) at(pos Position) *occurrence {
+	for _, o := range doc.occurrences {
+		r := o.Range()
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character &&
+			pos.Character <= r.End.Character {
+			return o
+		}
+	}
+	return nil
+}
+
+// references gets every occurrence of the symbol of o
+func (doc *document) references(o *occurrence) []*occurrence {
+	refs := make([]*occurrence, 0)
+	for _, other := range doc.occurrences {
+		if other.symbol == o.symbol {
+			refs = append(refs, other)
+		}
+	}
+	return refs
+}
+
+// definition gets the occurrence that declares the symbol of o. Conns that
+// are not ports are defined where they are first driven.
+func (doc *document) definition(o *occurrence) *occurrence {
+	var ret, first *occurrence
+	for _, ref := range doc.references(o) {
+		if ref.decl {
+			return ref
+		} else if ref.ret && ret == nil {
+			ret = ref
+		} else if first == nil {
+			first = ref
+		}
+	}
+	if o.kind == symBlock {
+		return nil
+	} else if ret != nil {
+		return ret
+	}
+	return first
+}
+
+func (doc *document) lookupBlock(name string) *phdl.AstBlock {
+	if block, ok := doc.ast.Blocks[name]; ok {
+		return block
+	}
+	return phdl.Builtins[name]
+}
+
+// hover describes the symbol of o
+func (doc *document) hover(o *occurrence) string {
+	if o.kind == symBlock {
+		block := doc.lookupBlock(o.name)
+		if block == nil {
+			return ""
+		}
+		return signature(block)
+	}
+
+	block, ok := doc.ast.Blocks[o.block]
+	if !ok {
+		return ""
+	}
+	conn, ok := block.Vars[o.name]
+	if !ok {
+		return ""
+	}
+	return conn.Name + " " + connType(conn)
+}
+
+// completion gets the blocks, and the conns of the block at line
+func (doc *document) completion(line int) []CompletionItem {
+	items := make([]CompletionItem, 0)
+	blocks := make([]*phdl.AstBlock, 0)
+	for _, block := range doc.ast.Blocks {
+		blocks = append(blocks, block)
+	}
+	for name, block := range phdl.Builtins {
+		if _, ok := doc.ast.Blocks[name]; !ok {
+			blocks = append(blocks, block)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Name < blocks[j].Name })
+	for _, block := range blocks {
+		items = append(items, CompletionItem{block.Name, CompletionFunction, signature(block)})
+	}
+
+	sc := scope{}
+	for _, s := range doc.scopes {
+		if s.line <= line+1 {
+			sc = s
+		}
+	}
+	if block, ok := doc.ast.Blocks[sc.block]; ok {
+		conns := make([]*phdl.AstConn, 0)
+		for _, conn := range block.Vars {
+			conns = append(conns, conn)
+		}
+		sort.Slice(conns, func(i, j int) bool { return conns[i].Name < conns[j].Name })
+		for _, conn := range conns {
+			items = append(items, CompletionItem{conn.Name, CompletionVariable, connType(conn)})
+		}
+	}
+	return items
+}
+
+// signature formats a block like its declaration
+func signature(block *phdl.AstBlock) string {
+	kind := "block"
+	switch {
+	case block.Builtin:
+		kind = "builtin"
+	case block.Mem != nil:
+		kind = block.Mem.Kind
+	case block.Table != nil:
+		kind = "table"
+	case block.Extern:
+		kind = "extern block"
+	}
+
+	s := fmt.Sprintf("%s %s %s", kind, block.Name, ports(block.Args))
+	if len(block.Rets) > 0 {
+		s += " -> " + ports(block.Rets)
+	}
+	return s
+}
+
+func ports(conns []*phdl.AstConn) string {
+	s := make([]string, len(conns))
+	for i, conn := range conns {
+		s[i] = conn.Name + " " + connType(conn)
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+// connType formats the type of a conn, with the width of bundles and enums
+func connType(conn *phdl.AstConn) string {
+	switch {
+	case !conn.HasType():
+		return "(unknown width)"
+	case conn.Bundle != nil:
+		return fmt.Sprintf("%s (d%v)", conn.Bundle.Name, conn.Width)
+	case conn.Enum != nil:
+		return fmt.Sprintf("%s (d%v)", conn.Enum.Name, conn.Width)
+	}
+	return fmt.Sprintf("d%v", conn.Width)
+}
@@ -0,0 +1,201 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const source = `enum state { idle, busy }
+
+block half (a d1, b d1) -> (s d1, c d1) {
+	(a, b) xor -> s;
+	(a, b) and -> c;
+}
+
+block full (cin d1, a d2) -> (s d1, cout d1) {
+	(a[0], a[1]) half -> t, c1;
+	(t, cin) half -> s, c2;
+	(c1, c2) or -> cout;
+}
+
+block next (s state) -> (n state) {
+	(idle) buf -> n;
+}
+
+test ht(half) {
+	0, 0 ==> 0, 0;
+}
+`
+
+func TestDocument(t *testing.T) {
+	doc := newDocument("file:///a.phdl").analyze(source)
+	if len(doc.diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics %v", doc.diagnostics)
+	}
+
+	// half in the first statement of full
+	o := doc.at(Position{8, 15})
+	if o == nil || o.kind != symBlock || o.name != "half" {
+		t.Fatalf("expected block half, got %v", o)
+	}
+	if def := doc.definition(o); def.Range() != (Range{Position{2, 6}, Position{2, 10}}) {
+		t.Errorf("unexpected definition %v", def.Range())
+	}
+	if refs := doc.references(o); len(refs) != 4 {
+		t.Errorf("expected 4 references, got %v", len(refs))
+	}
+	expected := "block half (a d1, b d1) -> (s d1, c d1)"
+	if got := doc.hover(o); got != expected {
+		t.Errorf("expected hover '%s', got '%s'", expected, got)
+	}
+
+	// t, inferred from the ret of half
+	o = doc.at(Position{9, 2})
+	if o == nil || o.kind != symConn || o.block != "full" {
+		t.Fatalf("expected conn t, got %v", o)
+	}
+	if def := doc.definition(o); def.Range().Start != (Position{8, 22}) {
+		t.Errorf("unexpected definition %v", def.Range())
+	}
+	if got := doc.hover(o); got != "t d1" {
+		t.Errorf("expected hover 't d1', got '%s'", got)
+	}
+
+	if got := doc.hover(doc.at(Position{10, 11})); got != "builtin or (a d1, b d1) -> (o d1)" {
+		t.Errorf("unexpected hover '%s'", got)
+	}
+	if def := doc.definition(doc.at(Position{10, 11})); def != nil {
+		t.Errorf("expected builtin to have no definition, got %v", def)
+	}
+
+	// enum values are not conns
+	if o := doc.at(Position{14, 2}); o != nil {
+		t.Errorf("expected no symbol at enum value, got %v", o)
+	}
+	if got := doc.hover(doc.at(Position{13, 12})); got != "s state (d1)" {
+		t.Errorf("unexpected hover '%s'", got)
+	}
+
+	labels := make([]string, 0)
+	for _, item := range doc.completion(9) {
+		labels = append(labels, item.Label)
+	}
+	if !strings.Contains(strings.Join(labels, " "), "half nand next nor not or xnor xor a c1 c2 cin cout s t") {
+		t.Errorf("unexpected completion %v", labels)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	doc := newDocument("file:///a.phdl").analyze(`
+block a (x d1) -> (y d1) {
+	(x) missing -> y;
+}
+
+block b (x d2) -> (y d1) {
+	(x) not -> y;
+}
+
+block c (x d1) -> (y d1) {
+	(x) not -> y;
+}
+`)
+	if len(doc.diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", doc.diagnostics)
+	}
+	if doc.diagnostics[0].Range.Start != (Position{1, 6}) ||
+		doc.diagnostics[1].Range.Start != (Position{5, 6}) {
+		t.Errorf("unexpected diagnostics %v", doc.diagnostics)
+	}
+	if !strings.Contains(doc.diagnostics[1].Message, "expected d1, got 'x' (d2)") {
+		t.Errorf("unexpected message '%s'", doc.diagnostics[1].Message)
+	}
+
+	// a parse error keeps the last analysis
+	broken := doc.analyze("block c (x d1) -> (y d1) {\n\t(x) not ->")
+	if len(broken.diagnostics) != 1 || broken.diagnostics[0].Range.Start != (Position{1, 9}) {
+		t.Errorf("unexpected diagnostics %v", broken.diagnostics)
+	}
+	if broken.at(Position{10, 2}) == nil {
+		t.Error("expected occurrences of the last analysis")
+	}
+}
+
+func request(b *bytes.Buffer, id int, method string, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	if id != 0 {
+		msg["id"] = id
+	}
+	writeMessage(b, msg)
+}
+
+func TestServe(t *testing.T) {
+	uri := "file:///a.phdl"
+	doc := map[string]interface{}{"uri": uri}
+	var in bytes.Buffer
+	request(&in, 1, "initialize", map[string]interface{}{})
+	request(&in, 0, "initialized", map[string]interface{}{})
+	request(&in, 0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "phdl", "text": source},
+	})
+	request(&in, 2, "textDocument/definition", map[string]interface{}{
+		"textDocument": doc, "position": Position{8, 15},
+	})
+	request(&in, 3, "textDocument/references", map[string]interface{}{
+		"textDocument": doc, "position": Position{8, 15},
+		"context": map[string]bool{"includeDeclaration": false},
+	})
+	request(&in, 0, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   doc,
+		"contentChanges": []map[string]string{{"text": "block a () -> (y d1) { (x) missing -> y; }"}},
+	})
+	request(&in, 4, "textDocument/hover", map[string]interface{}{
+		"textDocument": doc, "position": Position{0, 40},
+	})
+	request(&in, 5, "workspace/symbol", map[string]interface{}{})
+	request(&in, 6, "shutdown", nil)
+	request(&in, 0, "exit", nil)
+
+	var out bytes.Buffer
+	err := Serve(&in, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := make([]string, 0)
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		responses = append(responses, string(body))
+	}
+
+	for i, expected := range []string{
+		`"hoverProvider":true`,
+		`"diagnostics":[]`,
+		`"result":{"uri":"file:///a.phdl","range":{"start":{"line":2,"character":6}`,
+		`"result":[{"uri":"file:///a.phdl","range":{"start":{"line":8,"character":14}`,
+		`"message":"block 'missing' not defined"`,
+		`"id":4,"jsonrpc":"2.0","result":null`,
+		`"error":{"code":-32601`,
+		`"id":6,"jsonrpc":"2.0","result":null`,
+	} {
+		if i >= len(responses) {
+			t.Fatalf("expected %v responses, got %v", i+1, len(responses))
+		}
+		if !strings.Contains(responses[i], expected) {
+			t.Errorf("expected '%s' in '%s'", expected, responses[i])
+		}
+	}
+
+	// references exclude the declaration
+	var refs struct{ Result []Location }
+	json.Unmarshal([]byte(responses[3]), &refs)
+	if len(refs.Result) != 3 {
+		t.Errorf("expected 3 references, got %v", refs.Result)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Package lsp implements a language server for PHDL.
+
+Serve speaks the language server protocol over a pair of streams, usually
+stdin and stdout. Open documents are compiled and type checked on every change,
+and are published with a diagnostic for each declaration that has an error.
+The server answers hover, go to definition and find references for block
+names and conns, and completes the names of blocks and the conns of the
+enclosing block.
+
+Only full document sync is supported. Characters are counted as runes, which
+is the same as UTF-16 code units for the ASCII identifiers of PHDL.
+*/
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+type server struct {
+	w    io.Writer
+	docs map[string]*document
+}
+
+// Serve runs a language server reading from r and writing to w, until it is
+// sent exit or r is closed
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{w: w, docs: make(map[string]*document)}
+	br := bufio.NewReader(r)
+	for {
+		body, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		msg := &message{}
+		err = json.Unmarshal(body, msg)
+		if err != nil {
+			err = s.respond(nil, nil, &rpcError{codeParseError, err.Error()})
+			if err != nil {
+				return err
+			}
+			continue
+		} else if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(msg)
+		if rerr, ok := err.(*rpcError); ok {
+			if msg.ID != nil {
+				err = s.respond(msg.ID, nil, rerr)
+			} else {
+				err = nil
+			}
+		} else if err == nil && msg.ID != nil {
+			err = s.respond(msg.ID, result, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) respond(id *json.RawMessage, result interface{}, rerr *rpcError) error {
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	if rerr != nil {
+		resp["error"] = rerr
+	} else {
+		resp["result"] = result
+	}
+	return writeMessage(s.w, resp)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.w, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	})
+}
+
+func params(msg *message, v interface{}) error {
+	err := json.Unmarshal(msg.Params, v)
+	if err != nil {
+		return &rpcError{codeInvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// handle gets the result of a message. Errors to send to the client are
+// *rpcError, and other errors stop the server.
+func (s *server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "logiko"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		p := &DidOpenTextDocumentParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.update(p.TextDocument.URI, newDocument(p.TextDocument.URI), p.TextDocument.Text)
+	case "textDocument/didChange":
+		p := &DidChangeTextDocumentParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[p.TextDocument.URI]
+		if !ok || len(p.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(p.TextDocument.URI, doc, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		p := &DidCloseTextDocumentParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics",
+			PublishDiagnosticsParams{p.TextDocument.URI, make([]Diagnostic, 0)})
+
+	case "textDocument/hover":
+		p := &TextDocumentPositionParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		doc, o := s.lookup(p)
+		if o == nil {
+			return nil, nil
+		}
+		text := doc.hover(o)
+		if text == "" {
+			return nil, nil
+		}
+		return Hover{MarkupContent{"markdown", "```phdl\n" + text + "\n```"}, o.Range()}, nil
+	case "textDocument/definition":
+		p := &TextDocumentPositionParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		doc, o := s.lookup(p)
+		if o == nil {
+			return nil, nil
+		}
+		def := doc.definition(o)
+		if def == nil {
+			return nil, nil
+		}
+		return Location{doc.uri, def.Range()}, nil
+	case "textDocument/references":
+		p := &ReferenceParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		doc, o := s.lookup(&p.TextDocumentPositionParams)
+		locations := make([]Location, 0)
+		if o == nil {
+			return locations, nil
+		}
+		for _, ref := range doc.references(o) {
+			if !ref.decl || p.Context.IncludeDeclaration {
+				locations = append(locations, Location{doc.uri, ref.Range()})
+			}
+		}
+		return locations, nil
+	case "textDocument/completion":
+		p := &TextDocumentPositionParams{}
+		err := params(msg, p)
+		if err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return make([]CompletionItem, 0), nil
+		}
+		return doc.completion(p.Position.Line), nil
+	}
+
+	if msg.ID == nil {
+		// unknown notifications are ignored
+		return nil, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, "method not found: " + msg.Method}
+}
+
+// update analyzes a new version of a document, and publishes its diagnostics
+func (s *server) update(uri string, doc *document, text string) error {
+	doc = doc.analyze(text)
+	s.docs[uri] = doc
+	return s.notify("textDocument/publishDiagnostics",
+		PublishDiagnosticsParams{uri, doc.diagnostics})
+}
+
+// lookup gets the document and occurrence at the position of a request, or
+// nil if there is none
+func (s *server) lookup(p *TextDocumentPositionParams) (*document, *occurrence) {
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, nil
+	}
+	return doc, doc.at(p.Position)
+}
@@ -0,0 +1,174 @@
+package phdl
+
+import (
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"math/bits"
+	"os"
+	"strconv"
+)
+
+// AstMem describes the memory primitive that implements a block. A rom block
+// has an address arg and a data ret. A ram block has address, data and write
+// enable (d1) args, and a data ret. A reg block has a data arg and a data ret
+// of the same width, and holds one word.
+type AstMem struct {
+	Kind   string // "rom", "ram" or "reg"
+	Format string // "hex" or "bin", if initialized from File
+	File   string // joined to the directory of the source by checks.CompilePath
+	Init   []int64
+}
+
+func (am AstMem) String() string {
+	if am.File != "" {
+		return fmt.Sprintf(
+			"(%v %v %q)",
+			am.Kind,
+			am.Format,
+			am.File,
+		)
+	}
+	return fmt.Sprintf(
+		"(%v %v)",
+		am.Kind,
+		am.Init,
+	)
+}
+
+// Contents gets the initial words of the memory, read from File if it is set,
+// or else Init
+func (am AstMem) Contents() ([]int64, error) {
+	if am.File == "" {
+		return am.Init, nil
+	}
+
+	file, err := os.Open(am.File)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var data []simulator.PortType
+	if am.Format == "bin" {
+		data, err = simulator.ReadMemB(file)
+	} else {
+		data, err = simulator.ReadMemH(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	init := make([]int64, len(data))
+	for idx, value := range data {
+		init[idx] = int64(value)
+	}
+	return init, nil
+}
+
+func CompileMemory(astfile *AstFile, mem *Memory) (*AstBlock, error) {
+	ablock := &AstBlock{
+		Name: mem.Ident.Value,
+		Args: make([]*AstConn, 0),
+		Rets: make([]*AstConn, 0),
+		Vars: make(map[string]*AstConn, 0),
+		Stmts: make([]*AstStmt, 0),
+		Mem: &AstMem{Kind: mem.Kind, Init: make([]int64, 0)},
+	}
+
+	for _, arg := range mem.Args {
+		conn, err := declToConn(astfile, arg)
+		if err != nil {
+			return nil, fmt.Errorf("CompileMemory: %s", err)
+		}
+		ablock.Vars[arg.Ident.Value] = conn
+		ablock.Args = append(ablock.Args, conn)
+	}
+
+	for _, ret := range mem.Rets {
+		conn, err := declToConn(astfile, ret)
+		if err != nil {
+			return nil, fmt.Errorf("CompileMemory: %s", err)
+		}
+		ablock.Vars[ret.Ident.Value] = conn
+		ablock.Rets = append(ablock.Rets, conn)
+	}
+
+	if mem.Kind == "rom" && (len(ablock.Args) != 1 || len(ablock.Rets) != 1) {
+		return nil, fmt.Errorf(
+			"CompileMemory: rom '%s' must have one arg (addr) and one ret (data)",
+			ablock.Name)
+	} else if mem.Kind == "ram" {
+		if len(ablock.Args) != 3 || len(ablock.Rets) != 1 {
+			return nil, fmt.Errorf(
+				"CompileMemory: ram '%s' must have three args (addr, data, " +
+				"write enable) and one ret (data)",
+				ablock.Name)
+		} else if ablock.Args[1].Width != ablock.Rets[0].Width {
+			return nil, fmt.Errorf(
+				"CompileMemory: ram '%s' has data in of d%v and data out of d%v",
+				ablock.Name, ablock.Args[1].Width, ablock.Rets[0].Width)
+		} else if ablock.Args[2].Width != 1 {
+			return nil, fmt.Errorf(
+				"CompileMemory: write enable of ram '%s' must be d1",
+				ablock.Name)
+		}
+	} else if mem.Kind == "reg" {
+		if len(ablock.Args) != 1 || len(ablock.Rets) != 1 {
+			return nil, fmt.Errorf(
+				"CompileMemory: reg '%s' must have one arg (data) and one ret (data)",
+				ablock.Name)
+		} else if ablock.Args[0].Width != ablock.Rets[0].Width {
+			return nil, fmt.Errorf(
+				"CompileMemory: reg '%s' has data in of d%v and data out of d%v",
+				ablock.Name, ablock.Args[0].Width, ablock.Rets[0].Width)
+		} else if mem.Init != nil && mem.Init.Format != nil {
+			return nil, fmt.Errorf(
+				"CompileMemory: reg '%s' cannot be initialized from a file",
+				ablock.Name)
+		}
+	}
+
+	if mem.Init == nil {
+		return ablock, nil
+	}
+
+	if mem.Init.Format != nil {
+		format := mem.Init.Format.Value
+		if format != "hex" && format != "bin" {
+			return nil, fmt.Errorf(
+				"CompileMemory: unknown format '%s', expected hex or bin", format)
+		}
+		file, err := strconv.Unquote(mem.Init.File)
+		if err != nil {
+			return nil, fmt.Errorf("CompileMemory: %s", err)
+		}
+		ablock.Mem.Format = format
+		ablock.Mem.File = file
+		return ablock, nil
+	}
+
+	size := int64(1) << uint(ablock.Args[0].Width)
+	if mem.Kind == "reg" {
+		size = 1
+	}
+	if (ablock.Args[0].Width < 63 || mem.Kind == "reg") &&
+		int64(len(mem.Init.Values)) > size {
+		return nil, fmt.Errorf(
+			"CompileMemory: %v values do not fit in '%s' (%v words)",
+			len(mem.Init.Values), ablock.Name, size)
+	}
+
+	for _, value := range mem.Init.Values {
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("CompileMemory: %s", err)
+		} else if bits.Len64(uint64(v)) > ablock.Rets[0].Width {
+			return nil, fmt.Errorf(
+				"CompileMemory: value '%v' does not fit in d%v",
+				value, ablock.Rets[0].Width)
+		}
+		ablock.Mem.Init = append(ablock.Mem.Init, v)
+	}
+
+	return ablock, nil
+}
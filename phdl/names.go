@@ -0,0 +1,68 @@
+package phdl
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var identRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+var typeRegexp = regexp.MustCompile(`^d[0-9]+$`)
+var invalidRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+var reserved = map[string]bool{
+	"block": true, "test": true, "bundle": true, "enum": true,
+	"extern": true, "table": true, "rom": true, "ram": true, "reg": true,
+	"assert": true,
+}
+
+// Namer gives names from other formats unique identifiers that are valid in
+// PHDL, for importers
+type Namer struct {
+	names map[string]string
+	used  map[string]bool
+}
+
+func NewNamer() *Namer {
+	return &Namer{make(map[string]string), make(map[string]bool)}
+}
+
+// Name gets the identifier of a foreign name, the same for every call
+func (n *Namer) Name(foreign string) string {
+	if name, ok := n.names[foreign]; ok {
+		return name
+	}
+
+	name := foreign
+	if !identRegexp.MatchString(name) || typeRegexp.MatchString(name) ||
+		reserved[name] || Builtins[name] != nil {
+		name = "_" + invalidRegexp.ReplaceAllString(name, "_")
+	}
+	for base, i := name, 1; n.used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+
+	n.names[foreign] = name
+	n.used[name] = true
+	return name
+}
+
+// Temp gets a fresh identifier not named after a foreign name
+func (n *Namer) Temp() string {
+	name := ""
+	for i := 0; name == "" || n.used[name]; i++ {
+		name = fmt.Sprintf("_t%d", i)
+	}
+	n.used[name] = true
+	return name
+}
+
+// Fresh gets an unused identifier based on base, which must be a valid
+// identifier, and is not named after a foreign name
+func (n *Namer) Fresh(base string) string {
+	name := base
+	for i := 1; n.used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	n.used[name] = true
+	return name
+}
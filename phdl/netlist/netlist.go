@@ -0,0 +1,420 @@
+// Package netlist flattens PHDL blocks into gates on single bit signals, for
+// analyses that reason about the logic of a design instead of simulating it
+package netlist
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+)
+
+// Signal is a single bit signal of a netlist
+type Signal int
+
+// the constant signals of every netlist
+const (
+	False Signal = 0
+	True  Signal = 1
+)
+
+// Gate drives Out with a builtin gate of Ins. and and or gates may have any
+// number of Ins, with no Ins being True and False respectively.
+type Gate struct {
+	Op  string
+	Ins []Signal
+	Out Signal
+}
+
+// Latch is a bit of a reg, which is set to D on each rising edge of the clock
+type Latch struct {
+	D    Signal
+	Q    Signal
+	Init bool
+}
+
+// Assert is an assert of the block or of one of its instances, which holds
+// while Signal is True from cycle From on
+type Assert struct {
+	Name   string // e.g. alu0.assert1
+	Line   int
+	Signal Signal
+	From   int
+}
+
+// Netlist is a block flattened into gates. Gates are in topological order,
+// with latches breaking cycles, and signals that are never driven are
+// replaced with False, as the simulator leaves them 0.
+type Netlist struct {
+	Names   []string   // of each signal, e.g. half0.nc or q[1]
+	Inputs  [][]Signal // the bits of each arg, least significant first
+	Outputs [][]Signal // the bits of each ret
+	Gates   []Gate
+	Latches []Latch
+	Asserts []Assert
+}
+
+// Signals gets the number of signals
+func (n *Netlist) Signals() int {
+	return len(n.Names)
+}
+
+// Eval computes the value of every signal, given the values of the bits of
+// the args in the order of Inputs, and the value of each latch
+func (n *Netlist) Eval(inputs []bool, latches []bool) []bool {
+	values := make([]bool, n.Signals())
+	values[True] = true
+	idx := 0
+	for _, bits := range n.Inputs {
+		for _, bit := range bits {
+			values[bit] = inputs[idx]
+			idx++
+		}
+	}
+	for idx, latch := range n.Latches {
+		values[latch.Q] = latches[idx]
+	}
+	for _, gate := range n.Gates {
+		values[gate.Out] = gate.eval(values)
+	}
+	return values
+}
+
+func (g Gate) eval(values []bool) bool {
+	switch g.Op {
+	case "buf":
+		return values[g.Ins[0]]
+	case "not":
+		return !values[g.Ins[0]]
+	case "and", "nand":
+		v := true
+		for _, in := range g.Ins {
+			v = v && values[in]
+		}
+		return v != (g.Op == "nand")
+	case "or", "nor":
+		v := false
+		for _, in := range g.Ins {
+			v = v || values[in]
+		}
+		return v != (g.Op == "nor")
+	case "xor":
+		return values[g.Ins[0]] != values[g.Ins[1]]
+	case "xnor":
+		return values[g.Ins[0]] == values[g.Ins[1]]
+	}
+	panic(fmt.Sprintf("unknown gate '%s'", g.Op))
+}
+
+// BitName names bit i of a conn, which names its signal in the netlist of a
+// block, prefixed by the instance it is in
+func BitName(conn *phdl.AstConn, i int) string {
+	if conn.Width == 1 {
+		return conn.Name
+	}
+	return fmt.Sprintf("%s[%v]", conn.Name, i)
+}
+
+type builder struct {
+	n *Netlist
+}
+
+// scope gives the signals of the bits of the conns of one instance
+type scope struct {
+	prefix string
+	bits   map[*phdl.AstConn][]Signal // bits of ports bound to the outer scope
+}
+
+func (b *builder) signal(name string) Signal {
+	b.n.Names = append(b.n.Names, name)
+	return Signal(len(b.n.Names) - 1)
+}
+
+func (b *builder) conn(s *scope, conn *phdl.AstConn) []Signal {
+	if bits, ok := s.bits[conn]; ok {
+		return bits
+	}
+	bits := make([]Signal, conn.Width)
+	for i := range bits {
+		bits[i] = b.signal(s.prefix + BitName(conn, i))
+	}
+	s.bits[conn] = bits
+	return bits
+}
+
+// expr gets the signals of an expr connected to a port of width
+func (b *builder) expr(s *scope, expr *phdl.AstExpr, width int) []Signal {
+	bits := make([]Signal, width)
+	if expr.Conn == nil {
+		for i := range bits {
+			bits[i] = Signal((expr.Literal >> uint(i)) & 1)
+		}
+		return bits
+	}
+
+	conn := b.conn(s, expr.Conn)
+	lo := 0
+	if expr.HasIndex() {
+		lo = expr.Lo
+	}
+	copy(bits, conn[lo:])
+	return bits
+}
+
+func (b *builder) gate(op string, out Signal, ins ...Signal) {
+	b.n.Gates = append(b.n.Gates, Gate{op, ins, out})
+}
+
+// Flatten builds a netlist of a type checked block, with every sub-block
+// flattened into it. Table blocks are synthesized to gates, roms become a sum
+// of products for each bit of data, and regs become a latch for each bit.
+// Rams, extern blocks and combinational loops can't be flattened. Asserts are
+// flattened like statements, with a latch for each bit of each cycle an arg is
+// delayed by.
+func Flatten(block *phdl.AstBlock) (*Netlist, error) {
+	b := &builder{&Netlist{
+		Names:   []string{"$false", "$true"},
+		Inputs:  make([][]Signal, 0, len(block.Args)),
+		Outputs: make([][]Signal, 0, len(block.Rets)),
+		Gates:   make([]Gate, 0),
+		Latches: make([]Latch, 0),
+		Asserts: make([]Assert, 0),
+	}}
+
+	top := &scope{bits: make(map[*phdl.AstConn][]Signal)}
+	for _, arg := range block.Args {
+		b.n.Inputs = append(b.n.Inputs, b.conn(top, arg))
+	}
+	for _, ret := range block.Rets {
+		b.n.Outputs = append(b.n.Outputs, b.conn(top, ret))
+	}
+
+	err := b.block(top, block)
+	if err != nil {
+		return nil, fmt.Errorf("Flatten: %s", err)
+	}
+	b.undriven()
+	err = b.sort()
+	if err != nil {
+		return nil, fmt.Errorf("Flatten: %s", err)
+	}
+	return b.n, nil
+}
+
+// block flattens a block in scope s
+func (b *builder) block(s *scope, block *phdl.AstBlock) error {
+	switch {
+	case block.Builtin:
+		ins := make([]Signal, 0)
+		for _, arg := range block.Args {
+			ins = append(ins, b.conn(s, arg)...)
+		}
+		b.gate(block.Name, b.conn(s, block.Rets[0])[0], ins...)
+		return nil
+	case block.Extern:
+		return fmt.Errorf("extern block '%s' can't be flattened", block.Name)
+	case block.Mem != nil && block.Mem.Kind == "ram":
+		return fmt.Errorf("ram '%s' can't be flattened", block.Name)
+	case block.Mem != nil && block.Mem.Kind == "reg":
+		var init int64
+		if len(block.Mem.Init) != 0 {
+			init = block.Mem.Init[0]
+		}
+		d := b.conn(s, block.Args[0])
+		q := b.conn(s, block.Rets[0])
+		for i := range q {
+			b.n.Latches = append(b.n.Latches, Latch{d[i], q[i], (init>>uint(i))&1 == 1})
+		}
+		return nil
+	case block.Mem != nil:
+		return b.rom(s, block)
+	case block.Table != nil:
+		block = phdl.SynthesizeTable(block)
+	}
+
+	insts := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		inner := &scope{
+			prefix: s.prefix + insts[sidx] + ".",
+			bits:   make(map[*phdl.AstConn][]Signal),
+		}
+		for idx, arg := range stmt.Args {
+			port := stmt.Op.Args[idx]
+			inner.bits[port] = b.expr(s, arg, port.Width)
+		}
+		for idx, ret := range stmt.Rets {
+			// literal rets are left unbound, so they get their own signals
+			// rather than driving the constants
+			if ret.Conn != nil {
+				port := stmt.Op.Rets[idx]
+				inner.bits[port] = b.expr(s, ret, port.Width)
+			}
+		}
+
+		err := b.block(inner, stmt.Op)
+		if err != nil {
+			return err
+		}
+	}
+
+	for aidx, name := range block.AssertNames() {
+		err := b.assert(s, name, block.Asserts[aidx])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assert flattens an assert named name in scope s
+func (b *builder) assert(s *scope, name string, assert *phdl.AstAssert) error {
+	inner := &scope{
+		prefix: s.prefix + name + ".",
+		bits:   make(map[*phdl.AstConn][]Signal),
+	}
+	for idx, arg := range assert.Args {
+		port := assert.Op.Args[idx]
+		bits := b.expr(s, arg, port.Width)
+		for d := 1; d <= assert.Delays[idx]; d++ {
+			past := make([]Signal, len(bits))
+			for i, bit := range bits {
+				past[i] = b.signal(fmt.Sprintf("%s%s@%v", inner.prefix, BitName(port, i), d))
+				b.n.Latches = append(b.n.Latches, Latch{bit, past[i], false})
+			}
+			bits = past
+		}
+		inner.bits[port] = bits
+	}
+
+	sig := b.signal(s.prefix + "$" + name)
+	inner.bits[assert.Op.Rets[0]] = []Signal{sig}
+	b.n.Asserts = append(b.n.Asserts, Assert{s.prefix + name, assert.Line, sig, assert.From()})
+	return b.block(inner, assert.Op)
+}
+
+// rom builds an or for each bit of data, of an and matching each address
+// where the bit is set
+func (b *builder) rom(s *scope, block *phdl.AstBlock) error {
+	init, err := block.Mem.Contents()
+	if err != nil {
+		return fmt.Errorf("rom '%s': %s", block.Name, err)
+	}
+
+	addr := b.conn(s, block.Args[0])
+	data := b.conn(s, block.Rets[0])
+	inverted := make([]Signal, len(addr))
+	for i, bit := range addr {
+		inverted[i] = b.signal(s.prefix + "~" + b.n.Names[bit])
+		b.gate("not", inverted[i], bit)
+	}
+
+	words := make([]Signal, len(init))
+	for a := range init {
+		ins := make([]Signal, len(addr))
+		for i := range addr {
+			if (a>>uint(i))&1 == 1 {
+				ins[i] = addr[i]
+			} else {
+				ins[i] = inverted[i]
+			}
+		}
+		words[a] = b.signal(fmt.Sprintf("%s[%v]", s.prefix+block.Name, a))
+		b.gate("and", words[a], ins...)
+	}
+
+	for i, out := range data {
+		ins := make([]Signal, 0)
+		for a, value := range init {
+			if (value>>uint(i))&1 == 1 {
+				ins = append(ins, words[a])
+			}
+		}
+		b.gate("or", out, ins...)
+	}
+	return nil
+}
+
+// undriven replaces signals that are not driven with False
+func (b *builder) undriven() {
+	driven := make([]bool, b.n.Signals())
+	driven[False] = true
+	driven[True] = true
+	for _, bits := range b.n.Inputs {
+		for _, bit := range bits {
+			driven[bit] = true
+		}
+	}
+	for _, gate := range b.n.Gates {
+		driven[gate.Out] = true
+	}
+	for _, latch := range b.n.Latches {
+		driven[latch.Q] = true
+	}
+
+	replace := func(sig Signal) Signal {
+		if !driven[sig] {
+			return False
+		}
+		return sig
+	}
+	for _, gate := range b.n.Gates {
+		for idx, in := range gate.Ins {
+			gate.Ins[idx] = replace(in)
+		}
+	}
+	for idx := range b.n.Latches {
+		b.n.Latches[idx].D = replace(b.n.Latches[idx].D)
+	}
+	for _, bits := range b.n.Outputs {
+		for idx, bit := range bits {
+			bits[idx] = replace(bit)
+		}
+	}
+	for idx := range b.n.Asserts {
+		b.n.Asserts[idx].Signal = replace(b.n.Asserts[idx].Signal)
+	}
+}
+
+// sort orders the gates so that each comes after the gates driving its ins
+func (b *builder) sort() error {
+	driver := make(map[Signal]int)
+	for idx, gate := range b.n.Gates {
+		driver[gate.Out] = idx
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(b.n.Gates))
+	sorted := make([]Gate, 0, len(b.n.Gates))
+
+	var visit func(idx int) error
+	visit = func(idx int) error {
+		if state[idx] == visited {
+			return nil
+		} else if state[idx] == visiting {
+			return fmt.Errorf("combinational loop through '%s'", b.n.Names[b.n.Gates[idx].Out])
+		}
+		state[idx] = visiting
+		for _, in := range b.n.Gates[idx].Ins {
+			if d, ok := driver[in]; ok {
+				err := visit(d)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		state[idx] = visited
+		sorted = append(sorted, b.n.Gates[idx])
+		return nil
+	}
+
+	for idx := range b.n.Gates {
+		err := visit(idx)
+		if err != nil {
+			return err
+		}
+	}
+	b.n.Gates = sorted
+	return nil
+}
@@ -0,0 +1,197 @@
+package netlist
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/simulator"
+	"strings"
+	"testing"
+)
+
+const prog = `
+	rom seg (a d2) -> (s d3) = { 5, 2, 7 };
+	reg r (d d2) -> (q d2) = { 2 };
+	ram mem (addr d2, din d2, we d1) -> (dout d2);
+
+	table sel (a d2, b d1) -> (o d2) {
+		0b1-, 1 ==> 3;
+		0b01, 0b- ==> 1;
+	}
+
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) nand -> nc;
+		(nc) not -> c;
+	}
+
+	// statements out of order, and an undriven conn
+	block mix (a d2, b d1) -> (x d3, y d2, z d1, w d1) {
+		(t[0], b) half -> z, w;
+		(a, b) sel -> t;
+		(a) seg -> x;
+		(t[1], u) and -> y[1];
+		(b) not -> y[0];
+	}
+
+	block counter (e d1) -> (q d2) {
+		(n) r -> q;
+		(q[0], e) xor -> n[0];
+		(q[0], e) and -> k;
+		(q[1], k) xor -> n[1];
+	}
+
+	block loop (a d1) -> (b d1) {
+		(a, c) and -> b;
+		(b) not -> c;
+	}
+
+	block store (a d2) -> (d d2) {
+		(a, a, 1) mem -> d;
+	}
+`
+
+// bits splits values into bits, least significant first
+func bits(values []simulator.PortType, conns []*phdl.AstConn) []bool {
+	b := make([]bool, 0)
+	for idx, conn := range conns {
+		for i := 0; i < conn.Width; i++ {
+			b = append(b, (values[idx]>>uint(i))&1 == 1)
+		}
+	}
+	return b
+}
+
+func TestFlatten(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	block := ast.Blocks["mix"]
+	n, err := Flatten(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Names[n.Inputs[0][1]] != "a[1]" || n.Names[n.Outputs[0][0]] != "x[0]" ||
+		n.Names[n.Gates[0].Out] != "sel0._t0" {
+		t.Errorf("unexpected names %v", n.Names)
+	}
+
+	// every combination of args gives the same rets as the simulator
+	sim, err := elab.Simulate(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for a := simulator.PortType(0); a < 4; a++ {
+		for b := simulator.PortType(0); b < 2; b++ {
+			sim.Write("a", a)
+			sim.Write("b", b)
+			rets := make([]simulator.PortType, len(block.Rets))
+			for idx, ret := range block.Rets {
+				rets[idx], _ = sim.Read(ret.Name)
+			}
+
+			values := n.Eval(bits([]simulator.PortType{a, b}, block.Args), nil)
+			expected := bits(rets, block.Rets)
+			idx := 0
+			for _, out := range n.Outputs {
+				for _, bit := range out {
+					if values[bit] != expected[idx] {
+						t.Errorf("a=%v b=%v: bit %v of rets expected %v", a, b, idx, expected[idx])
+					}
+					idx++
+				}
+			}
+		}
+	}
+}
+
+func TestFlattenLiteralRet(t *testing.T) {
+	ast := checks.MustCompile(`
+		block drop (a d1, b d1) -> (o d1) {
+			(a, b) or -> 0;
+			(a, 0) or -> o;
+		}
+	`)
+	n, err := Flatten(ast.Blocks["drop"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gate := range n.Gates {
+		if gate.Out == False || gate.Out == True {
+			t.Fatalf("gate %v drives a constant", gate)
+		}
+	}
+
+	// o = a
+	for v := 0; v < 4; v++ {
+		values := n.Eval([]bool{v&1 == 1, v&2 == 2}, nil)
+		if values[n.Outputs[0][0]] != (v&1 == 1) {
+			t.Errorf("a=%v b=%v: expected o=%v", v&1, v>>1, v&1)
+		}
+	}
+}
+
+func TestFlattenLatches(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	n, err := Flatten(ast.Blocks["counter"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Latches) != 2 || n.Latches[0].Init || !n.Latches[1].Init ||
+		n.Names[n.Latches[1].D] != "n[1]" || n.Latches[1].Q != n.Outputs[0][1] {
+		t.Fatalf("unexpected latches %v", n.Latches)
+	}
+
+	// q = 2, e = 1 gives n = 3
+	values := n.Eval([]bool{true}, []bool{false, true})
+	if !values[n.Latches[0].D] || !values[n.Latches[1].D] {
+		t.Errorf("expected n = 3")
+	}
+}
+
+func TestFlattenAsserts(t *testing.T) {
+	ast := checks.MustCompile(`
+		block inner (a d1) -> (b d1) {
+			(a) not -> b;
+			assert (a, b) xor;
+		}
+		block outer (x d2) -> (y d1) {
+			(x[0]) inner -> y;
+			assert (x[0]@2, x[1]@1) or;
+		}
+	`)
+	n, err := Flatten(ast.Blocks["outer"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Asserts) != 2 || n.Asserts[0].Name != "inner0.assert0" || n.Asserts[0].From != 0 ||
+		n.Asserts[1].Name != "assert0" || n.Asserts[1].Line != 8 || n.Asserts[1].From != 2 {
+		t.Fatalf("unexpected asserts %v", n.Asserts)
+	}
+
+	// a latch for each cycle of each delay
+	if len(n.Latches) != 3 || n.Names[n.Latches[1].Q] != "assert0.a@2" ||
+		n.Latches[1].D != n.Latches[0].Q || n.Latches[2].D != n.Inputs[0][1] {
+		t.Fatalf("unexpected latches %v", n.Latches)
+	}
+
+	values := n.Eval([]bool{false, false}, []bool{false, true, false})
+	if !values[n.Asserts[0].Signal] || !values[n.Asserts[1].Signal] {
+		t.Errorf("expected the asserts to hold")
+	}
+	values = n.Eval([]bool{true, true}, []bool{true, false, false})
+	if values[n.Asserts[1].Signal] {
+		t.Errorf("expected assert0 to fail")
+	}
+}
+
+func TestFlattenErrors(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	for name, expected := range map[string]string{
+		"loop":  "Flatten: combinational loop through",
+		"store": "Flatten: ram 'mem' can't be flattened",
+	} {
+		_, err := Flatten(ast.Blocks[name])
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%s: expected error '%s', got %v", name, expected, err)
+		}
+	}
+}
@@ -10,13 +10,23 @@ var (
 	Lexer = lexer.Must(ebnf.New(`
 		OneLineComment = "//" { "\u0000"…"\uffff"-"\n" } .
 		MultiLineComment = "/*" { "\u0000"…"\uffff"-"*/" } "*/" .
-		Ident1 = ( block | test ) ( alpha | "_" | digit ) { "_" | alpha | digit } .
+		Ident1 = keyword ( alpha | "_" | digit ) { "_" | alpha | digit } .
 		Ident2 = "d" { digit } ( alpha | "_" ) { "_" | alpha | digit } .
 		Type = "d" digit { digit } .
 		Block = block .
 		Test = test .
+		Bundle = bundle .
+		Enum = enum .
+		Extern = extern .
+		Table = table .
+		Rom = rom .
+		Ram = ram .
+		Reg = reg .
+		Assert = assert .
 		Ident3 =  ( alpha | "_" ) { "_" | alpha | digit } .
+		Pattern = "0b" { "0" | "1" | "_" } ( "x" | "X" | "-" ) { "0" | "1" | "x" | "X" | "-" | "_" } .
 		Number = [ "-" ] digit [ "x" | "o" | "b" ] { hexdig } .
+		String = "\"" { "\u0000"…"\uffff"-"\""-"\n" } "\"" .
 		Whitespace = " " | "\t" | "\n" | "\r" .
 		Lparen = "(" .
 		Rparen = ")" .
@@ -26,12 +36,24 @@ var (
 		Rbrak = "]" .
 		Comma = "," .
 		Ellipsis = ".." .
+		Dot = "." .
 		Semicolon = ";" .
 		Arrow = "->" .
 		TestArrow = "==>" .
+		Assign = "=" .
+		At = "@" .
 
+		keyword = "b" ( "lock" | "undle" ) | "t" ( "est" | "able" ) | "e" ( "num" | "xtern" ) | "r" ( "om" | "am" | "eg" ) | "assert" .
 		block = "block" .
 		test = "test" .
+		bundle = "bundle" .
+		enum = "enum" .
+		extern = "extern" .
+		table = "table" .
+		rom = "rom" .
+		ram = "ram" .
+		reg = "reg" .
+		assert = "assert" .
 		hexdig = digit | "a"…"f" | "A"…"F" .
 		alpha = "a"…"z" | "A"…"Z" .
 		digit = "0"…"9" .
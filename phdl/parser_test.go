@@ -72,6 +72,81 @@ func TestLexerKeyword(t *testing.T) {
 	)
 }
 
+func TestLexerBundle(t *testing.T) {
+	lexerExpect(
+		t,
+		"bundle bundles a.b",
+		[]testToken{
+			{"Bundle", "bundle"},
+			{"Whitespace", " "},
+			{"Ident1", "bundles"},
+			{"Whitespace", " "},
+			{"Ident3", "a"},
+			{"Dot", "."},
+			{"Ident3", "b"},
+		},
+	)
+}
+
+func TestLexerMemory(t *testing.T) {
+	lexerExpect(
+		t,
+		"rom ram reg roms regs \"a.hex\"",
+		[]testToken{
+			{"Rom", "rom"},
+			{"Whitespace", " "},
+			{"Ram", "ram"},
+			{"Whitespace", " "},
+			{"Reg", "reg"},
+			{"Whitespace", " "},
+			{"Ident1", "roms"},
+			{"Whitespace", " "},
+			{"Ident1", "regs"},
+			{"Whitespace", " "},
+			{"String", "\"a.hex\""},
+		},
+	)
+}
+
+func TestLexerPattern(t *testing.T) {
+	lexerExpect(
+		t,
+		"table 0b1x0_- 0b10 tables",
+		[]testToken{
+			{"Table", "table"}, {"Whitespace", " "},
+			{"Pattern", "0b1x0_-"}, {"Whitespace", " "},
+			{"Number", "0b10"}, {"Whitespace", " "},
+			{"Ident1", "tables"},
+		},
+	)
+}
+
+func TestLexerExtern(t *testing.T) {
+	lexerExpect(
+		t,
+		"extern enum externs e",
+		[]testToken{
+			{"Extern", "extern"}, {"Whitespace", " "},
+			{"Enum", "enum"}, {"Whitespace", " "},
+			{"Ident1", "externs"}, {"Whitespace", " "},
+			{"Ident3", "e"},
+		},
+	)
+}
+
+func TestLexerAssert(t *testing.T) {
+	lexerExpect(
+		t,
+		"assert (q@1) asserts",
+		[]testToken{
+			{"Assert", "assert"}, {"Whitespace", " "},
+			{"Lparen", "("}, {"Ident3", "q"}, {"At", "@"},
+			{"Number", "1"}, {"Rparen", ")"}, {"Whitespace", " "},
+			{"Ident1", "asserts"},
+		},
+	)
+}
+
 func TestLexerLiteral(t *testing.T) {
 	lexerExpect(
 		t,
@@ -119,7 +194,7 @@ func TestLexerAsterixComment(t *testing.T) {
 func TestLexerSeperators(t *testing.T) {
 	lexerExpect(
 		t,
-		"(){}[]->==>;..",
+		"(){}[]->==>;...=",
 		[]testToken{
 			{"Lparen", "("},
 			{"Rparen", ")"},
@@ -131,6 +206,8 @@ func TestLexerSeperators(t *testing.T) {
 			{"TestArrow", "==>"},
 			{"Semicolon", ";"},
 			{"Ellipsis", ".."},
+			{"Dot", "."},
+			{"Assign", "="},
 		},
 	)
 }
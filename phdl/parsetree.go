@@ -1,40 +1,69 @@
 package phdl
 
+import (
+	"github.com/alecthomas/participle/lexer"
+)
+
 type File struct {
 	Blocks []*AnyBlock `@@*`
 }
 
 type AnyBlock struct {
+	Pos lexer.Position
 	Block *Block         `  @@`
 	TestBlock *TestBlock `| @@`
+	Bundle *Bundle       `| @@`
+	Enum *Enum           `| @@`
+	Memory *Memory       `| @@`
+	TableBlock *TableBlock `| @@`
+	Extern *Extern       `| @@`
 }
 
 type Ident struct {
+	Pos lexer.Position
 	Value string ` @Ident1 | @Ident2 | @Ident3 `
 }
 
 type Block struct {
-	Ident *Ident         ` Block @@ `
-	Args  []*Declaration ` Lparen (@@ (Comma @@)* )? Rparen `
-	Rets  []*Declaration ` (Arrow Lparen (@@ (Comma @@)* )? Rparen)? `
-	Stmts []*Statement   ` Lbrace @@* Rbrace `
+	Ident   *Ident         ` Block @@ `
+	Args    []*Declaration ` Lparen (@@ (Comma @@)* )? Rparen `
+	Rets    []*Declaration ` (Arrow Lparen (@@ (Comma @@)* )? Rparen)? `
+	Stmts   []*Statement   ` Lbrace ( @@ `
+	Asserts []*Assert      ` | @@ )* Rbrace `
 }
 
 type Declaration struct {
+	Pos lexer.Position
 	Ident *Ident ` @@ `
-	Type  string ` @Type `
+	Type  string ` @( Type | Ident1 | Ident2 | Ident3 ) `
 }
 
 type Statement struct {
+	Pos lexer.Position
 	Args  []*Expr ` Lparen ( @@ (Comma @@)* )? Rparen `
 	Ident *Ident  ` ( @@ Arrow `
 	Rets  []*Expr ` @@ (Comma @@)* )? Semicolon `
 }
 
+// Assert is a property that the d1 ret of a block of its args is 1 in every
+// cycle, e.g. assert (q, q@1) inc;
+type Assert struct {
+	Pos lexer.Position
+	Args  []*AssertArg ` Assert Lparen ( @@ (Comma @@)* )? Rparen `
+	Ident *Ident       ` @@ Semicolon `
+}
+
+// AssertArg is an expr sampled Delay cycles earlier
+type AssertArg struct {
+	Expr  *Expr  ` @@ `
+	Delay string ` ( At @Number )? `
+}
+
 type Expr struct {
-	Literal string `@Number `
-	Ident   *Ident `| ( @@ `
-	Index   *Index `( Lbrak @@ Rbrak )? )? `
+	Literal string   `@Number `
+	Ident   *Ident   `| ( @@ `
+	Fields  []*Ident `( Dot @@ )* `
+	Index   *Index   `( Lbrak @@ Rbrak )? )? `
 }
 
 type Index struct {
@@ -49,6 +78,63 @@ type TestBlock struct {
 }
 
 type TestStmt struct {
+	Pos lexer.Position
 	Args []*Expr ` ( @@ (Comma @@)* )? TestArrow `
 	Rets []*Expr ` ( @@ (Comma @@)* )? Semicolon`
 }
+
+type Bundle struct {
+	Ident  *Ident         ` Bundle @@ `
+	Fields []*Declaration ` Lbrace ( @@ Semicolon )* Rbrace `
+}
+
+type Enum struct {
+	Ident    *Ident       ` Enum @@ `
+	Encoding *Ident       ` @@? `
+	Type     string       ` @Type? `
+	Values   []*EnumValue ` Lbrace ( @@ (Comma @@)* )? Rbrace `
+}
+
+type EnumValue struct {
+	Pos lexer.Position
+	Ident *Ident ` @@ `
+	Value string ` ( Assign @Number )? `
+}
+
+type Memory struct {
+	Kind  string         ` @( Rom | Ram | Reg ) `
+	Ident *Ident         ` @@ `
+	Args  []*Declaration ` Lparen (@@ (Comma @@)* )? Rparen `
+	Rets  []*Declaration ` Arrow Lparen (@@ (Comma @@)* )? Rparen `
+	Init  *MemInit       ` ( Assign @@ )? Semicolon `
+}
+
+type MemInit struct {
+	Format *Ident   `  @@ `
+	File   string   `  @String `
+	Values []string `| Lbrace ( @Number (Comma @Number)* )? Rbrace `
+}
+
+type TableBlock struct {
+	Ident *Ident         ` Table @@ `
+	Args  []*Declaration ` Lparen (@@ (Comma @@)* )? Rparen `
+	Rets  []*Declaration ` Arrow Lparen (@@ (Comma @@)* )? Rparen `
+	Rows  []*TableRow    ` Lbrace @@* Rbrace `
+}
+
+type TableRow struct {
+	Pos lexer.Position
+	Args []*Pattern ` ( @@ (Comma @@)* )? TestArrow `
+	Rets []*Expr    ` ( @@ (Comma @@)* )? Semicolon `
+}
+
+type Pattern struct {
+	Pattern string ` @Pattern `
+	Expr    *Expr  `| @@ `
+}
+
+type Extern struct {
+	Ident *Ident         ` Extern Block @@ `
+	Args  []*Declaration ` Lparen (@@ (Comma @@)* )? Rparen `
+	Rets  []*Declaration ` (Arrow Lparen (@@ (Comma @@)* )? Rparen)? Semicolon `
+}
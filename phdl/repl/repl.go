@@ -0,0 +1,242 @@
+/*
+Package repl simulates a PHDL block interactively.
+
+Each line is a command:
+
+	a = 0x5        set the input a, by number or enum value
+	a              print a conn, which may be a port, an internal conn, or a
+	               conn of an instance such as half0.nc
+	print a b      print conns
+	step [n]       step the clock n times, or once
+	radix hex      print values in hex, bin or dec
+	watch a b      print conns after every write and step
+	unwatch a b    stop watching conns
+	list           list the ports, conns and instances of the block
+	help           print the commands
+	quit           stop the simulation
+*/
+package repl
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/simulator"
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const help = `commands:
+  name = value   set an input
+  name           print a conn, e.g. a or half0.nc
+  print name...  print conns
+  step [n]       step the clock n times
+  radix r        print values in hex, bin or dec
+  watch name...  print conns after every write and step
+  unwatch name...
+  list           list ports, conns and instances
+  quit
+`
+
+type repl struct {
+	w       io.Writer
+	block   *phdl.AstBlock
+	sim     *elab.Simulation
+	radix   string
+	watches []string
+}
+
+// Run simulates block, reading commands from r and writing their output to
+// w, until r is closed or it reads quit
+func Run(r io.Reader, w io.Writer, block *phdl.AstBlock) error {
+	sim, err := elab.Simulate(block)
+	if err != nil {
+		return err
+	}
+
+	rp := &repl{
+		w:       w,
+		block:   block,
+		sim:     sim,
+		radix:   "hex",
+		watches: make([]string, 0),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return nil
+		}
+		err := rp.exec(line)
+		if err != nil {
+			fmt.Fprintln(w, "error:", err)
+		}
+	}
+}
+
+func (rp *repl) exec(line string) error {
+	if strings.Contains(line, "=") {
+		parts := strings.SplitN(line, "=", 2)
+		err := rp.write(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return err
+		}
+		return rp.printWatches()
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "help":
+		fmt.Fprint(rp.w, help)
+	case "print":
+		return rp.print(fields[1:])
+	case "step":
+		n := 1
+		if len(fields) > 1 {
+			var err error
+			n, err = strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid step count '%s'", fields[1])
+			}
+		}
+		for i := 0; i < n; i++ {
+			rp.sim.Step()
+		}
+		return rp.printWatches()
+	case "radix":
+		if len(fields) != 2 || (fields[1] != "hex" && fields[1] != "bin" && fields[1] != "dec") {
+			return fmt.Errorf("expected radix hex, bin or dec")
+		}
+		rp.radix = fields[1]
+	case "watch":
+		for _, name := range fields[1:] {
+			_, err := rp.sim.Read(name)
+			if err != nil {
+				return err
+			}
+			rp.watches = append(rp.watches, name)
+		}
+		return rp.print(fields[1:])
+	case "unwatch":
+		for _, name := range fields[1:] {
+			rp.unwatch(name)
+		}
+	case "list":
+		rp.list()
+	default:
+		if len(fields) > 1 {
+			return fmt.Errorf("unknown command '%s'", fields[0])
+		}
+		return rp.print(fields)
+	}
+	return nil
+}
+
+// write sets an input to a number, or a value of its enum
+func (rp *repl) write(name, value string) error {
+	conn, err := rp.sim.Conn(name)
+	if err != nil {
+		return err
+	}
+
+	if conn.Enum != nil && conn.Enum.Value(value) != nil {
+		return rp.sim.Write(name, simulator.PortType(conn.Enum.Value(value).Value))
+	}
+	v, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	return rp.sim.Write(name, simulator.PortType(v))
+}
+
+func (rp *repl) print(names []string) error {
+	for _, name := range names {
+		value, err := rp.sim.Read(name)
+		if err != nil {
+			return err
+		}
+		conn, err := rp.sim.Conn(name)
+		if err != nil {
+			return err
+		}
+
+		s := Format(value, conn.Width, rp.radix)
+		if conn.Enum != nil {
+			if symbol := conn.Enum.Symbol(int64(value)); symbol != nil {
+				s += " (" + symbol.Name + ")"
+			}
+		}
+		fmt.Fprintf(rp.w, "%s = %s\n", name, s)
+	}
+	return nil
+}
+
+func (rp *repl) printWatches() error {
+	return rp.print(rp.watches)
+}
+
+func (rp *repl) unwatch(name string) {
+	watches := make([]string, 0, len(rp.watches))
+	for _, watch := range rp.watches {
+		if watch != name {
+			watches = append(watches, watch)
+		}
+	}
+	rp.watches = watches
+}
+
+// Format formats a value in hex, bin or dec, padded to width in hex and bin
+func Format(value simulator.PortType, width int, radix string) string {
+	switch radix {
+	case "bin":
+		return fmt.Sprintf("0b%0*b", width, value)
+	case "dec":
+		return fmt.Sprintf("%d", value)
+	}
+	return fmt.Sprintf("0x%0*x", (width+3)/4, value)
+}
+
+func (rp *repl) list() {
+	ports := func(conns []*phdl.AstConn) string {
+		s := make([]string, len(conns))
+		for idx, conn := range conns {
+			s[idx] = fmt.Sprintf("%s d%v", conn.Name, conn.Width)
+		}
+		return strings.Join(s, ", ")
+	}
+	fmt.Fprintf(rp.w, "inputs: %s\n", ports(rp.block.Args))
+	fmt.Fprintf(rp.w, "outputs: %s\n", ports(rp.block.Rets))
+
+	isPort := make(map[*phdl.AstConn]bool)
+	for _, conn := range append(rp.block.Args, rp.block.Rets...) {
+		isPort[conn] = true
+	}
+	conns := make([]*phdl.AstConn, 0)
+	for _, conn := range rp.block.Vars {
+		if !isPort[conn] {
+			conns = append(conns, conn)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Name < conns[j].Name })
+	fmt.Fprintf(rp.w, "conns: %s\n", ports(conns))
+
+	names := rp.block.InstanceNames()
+	instances := make([]string, len(names))
+	for idx, name := range names {
+		instances[idx] = fmt.Sprintf("%s (%s)", name, rp.block.Stmts[idx].Op.Name)
+	}
+	fmt.Fprintf(rp.w, "instances: %s\n", strings.Join(instances, ", "))
+}
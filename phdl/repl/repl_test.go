@@ -0,0 +1,107 @@
+package repl
+
+import (
+	"bytes"
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"strings"
+	"testing"
+)
+
+const prog = `
+	enum mode { hold, count }
+	reg r (d d2) -> (q d2);
+
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) nand -> nc;
+		(nc) not -> c;
+	}
+
+	block counter (m mode, x d2) -> (q d2, s d1) {
+		(x[0], x[1]) half -> s, c;
+		(n) r -> q;
+		(q[0], m) xor -> n[0];
+		(q[0], m) and -> k;
+		(q[1], k) xor -> n[1];
+	}
+`
+
+func run(t *testing.T, block *phdl.AstBlock, script string) string {
+	t.Helper()
+	var out bytes.Buffer
+	err := Run(strings.NewReader(script), &out, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRun(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	out := run(t, ast.Blocks["counter"], `
+x = 0b11
+s
+half0.nc
+radix bin
+print x q
+watch q m
+m = count
+step 3
+unwatch m
+radix dec
+step
+list
+quit
+q
+`)
+
+	for _, expected := range []string{
+		"> s = 0x0\n",
+		"> half0.nc = 0x0\n",
+		"x = 0b11\nq = 0b00\n",
+		"q = 0b00\nm = 0b0 (hold)\n",
+		"q = 0b00\nm = 0b1 (count)\n",
+		"q = 0b11\nm = 0b1 (count)\n",
+		"> q = 0\n",
+		"inputs: m d1, x d2\noutputs: q d2, s d1\nconns: c d1, k d1, n d2\n",
+		"instances: half0 (half), r0 (r), xor0 (xor), and0 (and), xor1 (xor)\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected '%s' in:\n%s", expected, out)
+		}
+	}
+	if strings.Count(out, "> ") != 14 {
+		t.Errorf("expected to stop at quit:\n%s", out)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	out := run(t, ast.Blocks["counter"], `
+x = 4
+x = five
+q = 1
+y = 1
+y
+half0.y
+radix oct
+step -1
+frobnicate x
+`)
+
+	for _, expected := range []string{
+		"error: 4 does not fit in 'x' (d2)",
+		"error: invalid value 'five'",
+		"error: 'q' is not an input",
+		"error: no conn 'y'",
+		"error: no conn 'half0.y'",
+		"error: expected radix hex, bin or dec",
+		"error: invalid step count '-1'",
+		"error: unknown command 'frobnicate'",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected '%s' in:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,586 @@
+/*
+Package sat is a CDCL SAT solver, for asking satisfiability questions about
+designs without depending on an external solver.
+
+The solver learns a clause from each conflict at its first unique implication
+point, watches two literals of each clause for propagation, picks variables
+by VSIDS activity with saved phases, and restarts on the Luby sequence.
+Clauses may be added between calls to Solve, and each call may assume some
+literals, so one solver can answer a sequence of related questions.
+
+Netlists are encoded with Encode, which constrains a literal for each signal
+by the Tseitin encoding of its gate.
+*/
+package sat
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Lit is a variable, numbered from 1, or its negation
+type Lit int
+
+// Var gets the variable of a lit
+func (l Lit) Var() int {
+	if l < 0 {
+		return int(-l)
+	}
+	return int(l)
+}
+
+// index numbers the lits of each variable consecutively, for watch lists
+func (l Lit) index() int {
+	if l < 0 {
+		return 2*int(-l) + 1
+	}
+	return 2 * int(l)
+}
+
+type clause struct {
+	lits     []Lit // the first two are watched, and the first is implied
+	learnt   bool
+	activity float64
+}
+
+// Solver decides the satisfiability of a set of clauses
+type Solver struct {
+	clauses []*clause
+	learnts []*clause
+	watches [][]*clause // clauses watching each lit, by index
+	ok      bool        // false once the clauses are unsatisfiable
+
+	// by variable, with variable 0 unused
+	assigns  []int8 // 1 true, -1 false, or 0 unassigned
+	level    []int
+	reason   []*clause
+	phase    []bool
+	activity []float64
+	seen     []bool
+
+	trail    []Lit
+	trailLim []int // the start of each decision level in trail
+	qhead    int   // the next lit of trail to propagate
+	order    varHeap
+
+	varInc     float64
+	claInc     float64
+	maxLearnts float64
+	model      []bool
+	truth      Lit
+
+	// statistics
+	Conflicts    int
+	Decisions    int
+	Propagations int
+}
+
+const (
+	varDecay     = 0.95
+	claDecay     = 0.999
+	restartFirst = 100
+)
+
+// New creates a solver with no variables
+func New() *Solver {
+	s := &Solver{
+		clauses:  make([]*clause, 0),
+		learnts:  make([]*clause, 0),
+		watches:  make([][]*clause, 2),
+		ok:       true,
+		assigns:  []int8{0},
+		level:    []int{0},
+		reason:   []*clause{nil},
+		phase:    []bool{false},
+		activity: []float64{0},
+		seen:     []bool{false},
+		trail:    make([]Lit, 0),
+		trailLim: make([]int, 0),
+		varInc:   1,
+		claInc:   1,
+	}
+	s.order = varHeap{s: s, indices: []int{-1}}
+	return s
+}
+
+// NewVar adds a variable, giving its positive lit
+func (s *Solver) NewVar() Lit {
+	v := len(s.assigns)
+	s.assigns = append(s.assigns, 0)
+	s.level = append(s.level, 0)
+	s.reason = append(s.reason, nil)
+	s.phase = append(s.phase, false)
+	s.activity = append(s.activity, 0)
+	s.seen = append(s.seen, false)
+	s.watches = append(s.watches, nil, nil)
+	s.order.indices = append(s.order.indices, -1)
+	s.order.insert(v)
+	return Lit(v)
+}
+
+// Vars gets the number of variables
+func (s *Solver) Vars() int {
+	return len(s.assigns) - 1
+}
+
+// Clauses gets the number of clauses added, excluding units and learnt clauses
+func (s *Solver) Clauses() int {
+	return len(s.clauses)
+}
+
+func (s *Solver) value(l Lit) int8 {
+	if l < 0 {
+		return -s.assigns[-l]
+	}
+	return s.assigns[l]
+}
+
+func (s *Solver) decisionLevel() int {
+	return len(s.trailLim)
+}
+
+// AddClause adds the disjunction of lits. It gives false if the clauses are
+// now unsatisfiable.
+func (s *Solver) AddClause(lits ...Lit) bool {
+	if !s.ok {
+		return false
+	}
+	s.cancel(0)
+
+	ps := append([]Lit{}, lits...)
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	out := ps[:0]
+	for idx, l := range ps {
+		if l == 0 || l.Var() > s.Vars() {
+			panic(fmt.Sprintf("AddClause: no variable %v", l))
+		} else if s.value(l) == 1 {
+			return true
+		} else if idx > 0 && l == ps[idx-1] {
+			continue
+		} else if s.value(l) == -1 {
+			continue
+		}
+		for _, prev := range out {
+			if prev == -l {
+				return true
+			}
+		}
+		out = append(out, l)
+	}
+
+	switch len(out) {
+	case 0:
+		s.ok = false
+	case 1:
+		s.assign(out[0], nil)
+		s.ok = s.propagate() == nil
+	default:
+		c := &clause{lits: out}
+		s.clauses = append(s.clauses, c)
+		s.attach(c)
+	}
+	return s.ok
+}
+
+func (s *Solver) attach(c *clause) {
+	for _, l := range c.lits[:2] {
+		s.watches[l.index()] = append(s.watches[l.index()], c)
+	}
+}
+
+func (s *Solver) detach(c *clause) {
+	for _, l := range c.lits[:2] {
+		ws := s.watches[l.index()]
+		for idx, w := range ws {
+			if w == c {
+				ws[idx] = ws[len(ws)-1]
+				s.watches[l.index()] = ws[:len(ws)-1]
+				break
+			}
+		}
+	}
+}
+
+func (s *Solver) assign(l Lit, reason *clause) {
+	v := l.Var()
+	if l < 0 {
+		s.assigns[v] = -1
+	} else {
+		s.assigns[v] = 1
+	}
+	s.level[v] = s.decisionLevel()
+	s.reason[v] = reason
+	s.trail = append(s.trail, l)
+}
+
+// propagate assigns the lits implied by unit clauses, giving a clause with
+// every lit false if there is a conflict
+func (s *Solver) propagate() *clause {
+	for s.qhead < len(s.trail) {
+		falseLit := -s.trail[s.qhead]
+		s.qhead++
+		s.Propagations++
+
+		ws := s.watches[falseLit.index()]
+		i, j := 0, 0
+		for i < len(ws) {
+			c := ws[i]
+			i++
+			if c.lits[0] == falseLit {
+				c.lits[0], c.lits[1] = c.lits[1], c.lits[0]
+			}
+			if s.value(c.lits[0]) == 1 {
+				ws[j] = c
+				j++
+				continue
+			}
+
+			found := false
+			for k := 2; k < len(c.lits); k++ {
+				if s.value(c.lits[k]) != -1 {
+					c.lits[1], c.lits[k] = c.lits[k], c.lits[1]
+					s.watches[c.lits[1].index()] = append(s.watches[c.lits[1].index()], c)
+					found = true
+					break
+				}
+			}
+			if found {
+				continue
+			}
+
+			ws[j] = c
+			j++
+			if s.value(c.lits[0]) == -1 {
+				for i < len(ws) {
+					ws[j] = ws[i]
+					i++
+					j++
+				}
+				s.watches[falseLit.index()] = ws[:j]
+				s.qhead = len(s.trail)
+				return c
+			}
+			s.assign(c.lits[0], c)
+		}
+		s.watches[falseLit.index()] = ws[:j]
+	}
+	return nil
+}
+
+// analyze learns a clause from a conflict, with the lit at the first unique
+// implication point first, and gives the level to backtrack to
+func (s *Solver) analyze(confl *clause) ([]Lit, int) {
+	learnt := []Lit{0}
+	pathC := 0
+	p := Lit(0)
+	idx := len(s.trail) - 1
+
+	for {
+		if confl.learnt {
+			s.bumpClause(confl)
+		}
+		start := 0
+		if p != 0 {
+			start = 1
+		}
+		for _, q := range confl.lits[start:] {
+			v := q.Var()
+			if !s.seen[v] && s.level[v] > 0 {
+				s.seen[v] = true
+				s.bumpVar(v)
+				if s.level[v] >= s.decisionLevel() {
+					pathC++
+				} else {
+					learnt = append(learnt, q)
+				}
+			}
+		}
+
+		for !s.seen[s.trail[idx].Var()] {
+			idx--
+		}
+		p = s.trail[idx]
+		idx--
+		confl = s.reason[p.Var()]
+		s.seen[p.Var()] = false
+		pathC--
+		if pathC == 0 {
+			break
+		}
+	}
+	learnt[0] = -p
+
+	// remove lits implied by the other lits of the clause
+	minimized := make([]Lit, 1, len(learnt))
+	minimized[0] = learnt[0]
+	for _, l := range learnt[1:] {
+		r := s.reason[l.Var()]
+		if r == nil {
+			minimized = append(minimized, l)
+			continue
+		}
+		for _, q := range r.lits[1:] {
+			if !s.seen[q.Var()] && s.level[q.Var()] > 0 {
+				minimized = append(minimized, l)
+				break
+			}
+		}
+	}
+	for _, l := range learnt {
+		s.seen[l.Var()] = false
+	}
+	learnt = minimized
+
+	// the highest level of the other lits is watched second
+	bt := 0
+	for i := 1; i < len(learnt); i++ {
+		if s.level[learnt[i].Var()] > bt {
+			bt = s.level[learnt[i].Var()]
+			learnt[1], learnt[i] = learnt[i], learnt[1]
+		}
+	}
+	return learnt, bt
+}
+
+func (s *Solver) bumpVar(v int) {
+	s.activity[v] += s.varInc
+	if s.activity[v] > 1e100 {
+		for i := range s.activity {
+			s.activity[i] *= 1e-100
+		}
+		s.varInc *= 1e-100
+	}
+	if s.order.contains(v) {
+		s.order.up(s.order.indices[v])
+	}
+}
+
+func (s *Solver) bumpClause(c *clause) {
+	c.activity += s.claInc
+	if c.activity > 1e20 {
+		for _, l := range s.learnts {
+			l.activity *= 1e-20
+		}
+		s.claInc *= 1e-20
+	}
+}
+
+// cancel undoes the assignments above level
+func (s *Solver) cancel(level int) {
+	if s.decisionLevel() <= level {
+		return
+	}
+	for c := len(s.trail) - 1; c >= s.trailLim[level]; c-- {
+		v := s.trail[c].Var()
+		s.phase[v] = s.trail[c] > 0
+		s.assigns[v] = 0
+		s.reason[v] = nil
+		if !s.order.contains(v) {
+			s.order.insert(v)
+		}
+	}
+	s.qhead = s.trailLim[level]
+	s.trail = s.trail[:s.trailLim[level]]
+	s.trailLim = s.trailLim[:level]
+}
+
+// locked checks if a clause is the reason for an assignment
+func (s *Solver) locked(c *clause) bool {
+	v := c.lits[0].Var()
+	return s.reason[v] == c && s.value(c.lits[0]) == 1
+}
+
+// reduce removes the less active half of the learnt clauses
+func (s *Solver) reduce() {
+	sort.Slice(s.learnts, func(i, j int) bool {
+		return s.learnts[i].activity < s.learnts[j].activity
+	})
+	kept := s.learnts[:0]
+	for idx, c := range s.learnts {
+		if idx < len(s.learnts)/2 && len(c.lits) > 2 && !s.locked(c) {
+			s.detach(c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	s.learnts = kept
+}
+
+// search searches for a model until it has had maxConflicts conflicts. It
+// gives 1 if it finds a model, -1 if there is none under the assumptions, or
+// 0 to restart.
+func (s *Solver) search(maxConflicts int, assumptions []Lit) int {
+	conflicts := 0
+	for {
+		confl := s.propagate()
+		if confl != nil {
+			s.Conflicts++
+			conflicts++
+			if s.decisionLevel() == 0 {
+				s.ok = false
+				return -1
+			}
+
+			learnt, bt := s.analyze(confl)
+			s.cancel(bt)
+			if len(learnt) == 1 {
+				s.assign(learnt[0], nil)
+			} else {
+				c := &clause{lits: learnt, learnt: true}
+				s.learnts = append(s.learnts, c)
+				s.attach(c)
+				s.bumpClause(c)
+				s.assign(learnt[0], c)
+			}
+			s.varInc /= varDecay
+			s.claInc /= claDecay
+			continue
+		}
+
+		if conflicts >= maxConflicts {
+			s.cancel(0)
+			return 0
+		} else if float64(len(s.learnts)-len(s.trail)) >= s.maxLearnts {
+			s.reduce()
+		}
+
+		next := Lit(0)
+		for s.decisionLevel() < len(assumptions) {
+			p := assumptions[s.decisionLevel()]
+			if s.value(p) == 1 {
+				s.trailLim = append(s.trailLim, len(s.trail))
+			} else if s.value(p) == -1 {
+				return -1
+			} else {
+				next = p
+				break
+			}
+		}
+		if next == 0 {
+			v := s.order.pop()
+			for v != 0 && s.assigns[v] != 0 {
+				v = s.order.pop()
+			}
+			if v == 0 {
+				return 1
+			}
+			s.Decisions++
+			next = Lit(v)
+			if !s.phase[v] {
+				next = -next
+			}
+		}
+		s.trailLim = append(s.trailLim, len(s.trail))
+		s.assign(next, nil)
+	}
+}
+
+// luby gets the ith term of the Luby sequence 1, 1, 2, 1, 1, 2, 4, ...
+func luby(i int) int {
+	size, seq := 1, 0
+	for size < i+1 {
+		seq++
+		size = 2*size + 1
+	}
+	for size-1 != i {
+		size = (size - 1) >> 1
+		seq--
+		i = i % size
+	}
+	return 1 << uint(seq)
+}
+
+// Solve decides if the clauses are satisfiable with the assumptions true. If
+// they are, Value gives the model found.
+func (s *Solver) Solve(assumptions ...Lit) bool {
+	s.model = nil
+	if !s.ok {
+		return false
+	}
+	s.maxLearnts = float64(len(s.clauses))/3 + 100
+
+	for restart := 0; ; restart++ {
+		status := s.search(luby(restart)*restartFirst, assumptions)
+		if status == 1 {
+			s.model = make([]bool, len(s.assigns))
+			for v := range s.model {
+				s.model[v] = s.assigns[v] == 1
+			}
+		}
+		if status != 0 {
+			s.cancel(0)
+			return status == 1
+		}
+		s.maxLearnts *= 1.1
+	}
+}
+
+// Value gets the value of a lit in the model found by the last call to Solve
+func (s *Solver) Value(l Lit) bool {
+	if s.model == nil {
+		panic("Value: no model")
+	}
+	return s.model[l.Var()] == (l > 0)
+}
+
+// varHeap orders unassigned variables by activity, most active first
+type varHeap struct {
+	s       *Solver
+	heap    []int
+	indices []int // of each variable in heap, or -1
+}
+
+func (h *varHeap) less(a, b int) bool {
+	return h.s.activity[h.heap[a]] > h.s.activity[h.heap[b]]
+}
+
+func (h *varHeap) swap(a, b int) {
+	h.heap[a], h.heap[b] = h.heap[b], h.heap[a]
+	h.indices[h.heap[a]] = a
+	h.indices[h.heap[b]] = b
+}
+
+func (h *varHeap) contains(v int) bool {
+	return h.indices[v] >= 0
+}
+
+func (h *varHeap) insert(v int) {
+	h.indices[v] = len(h.heap)
+	h.heap = append(h.heap, v)
+	h.up(len(h.heap) - 1)
+}
+
+func (h *varHeap) up(i int) {
+	for i > 0 && h.less(i, (i-1)/2) {
+		h.swap(i, (i-1)/2)
+		i = (i - 1) / 2
+	}
+}
+
+// pop removes the most active variable, or gives 0 if there are none
+func (h *varHeap) pop() int {
+	if len(h.heap) == 0 {
+		return 0
+	}
+	v := h.heap[0]
+	h.swap(0, len(h.heap)-1)
+	h.heap = h.heap[:len(h.heap)-1]
+	h.indices[v] = -1
+
+	i := 0
+	for {
+		child := 2*i + 1
+		if child >= len(h.heap) {
+			break
+		}
+		if child+1 < len(h.heap) && h.less(child+1, child) {
+			child++
+		}
+		if !h.less(child, i) {
+			break
+		}
+		h.swap(i, child)
+		i = child
+	}
+	return v
+}
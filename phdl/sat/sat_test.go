@@ -0,0 +1,213 @@
+package sat
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/netlist"
+	"math/rand"
+	"testing"
+)
+
+// pigeons constrains each of n pigeons to be in one of holes holes, with no
+// two in the same hole
+func pigeons(s *Solver, n, holes int) [][]Lit {
+	p := make([][]Lit, n)
+	for i := range p {
+		p[i] = make([]Lit, holes)
+		for h := range p[i] {
+			p[i][h] = s.NewVar()
+		}
+		s.AddClause(p[i]...)
+	}
+	for h := 0; h < holes; h++ {
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				s.AddClause(-p[i][h], -p[j][h])
+			}
+		}
+	}
+	return p
+}
+
+func TestPigeons(t *testing.T) {
+	s := New()
+	pigeons(s, 7, 6)
+	if s.Solve() {
+		t.Errorf("expected 7 pigeons in 6 holes to be unsatisfiable")
+	}
+	if s.Conflicts == 0 {
+		t.Errorf("expected conflicts")
+	}
+
+	s = New()
+	p := pigeons(s, 6, 6)
+	if !s.Solve() {
+		t.Fatalf("expected 6 pigeons in 6 holes to be satisfiable")
+	}
+	for h := 0; h < 6; h++ {
+		count := 0
+		for i := range p {
+			if s.Value(p[i][h]) {
+				count++
+			}
+		}
+		if count > 1 {
+			t.Errorf("hole %v has %v pigeons", h, count)
+		}
+	}
+}
+
+// brute decides the satisfiability of clauses on vars variables by trying
+// every assignment
+func brute(vars int, clauses [][]Lit) bool {
+	for m := 0; m < 1<<uint(vars); m++ {
+		if satisfies(clauses, func(l Lit) bool { return (m>>uint(l.Var()-1))&1 == 1 == (l > 0) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func satisfies(clauses [][]Lit, value func(Lit) bool) bool {
+	for _, c := range clauses {
+		sat := false
+		for _, l := range c {
+			sat = sat || value(l)
+		}
+		if !sat {
+			return false
+		}
+	}
+	return true
+}
+
+func random3SAT(r *rand.Rand, vars, n int) [][]Lit {
+	clauses := make([][]Lit, n)
+	for i := range clauses {
+		clauses[i] = make([]Lit, 3)
+		for j := range clauses[i] {
+			clauses[i][j] = Lit(r.Intn(vars) + 1)
+			if r.Intn(2) == 0 {
+				clauses[i][j] = -clauses[i][j]
+			}
+		}
+	}
+	return clauses
+}
+
+func TestRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		vars := 12
+		clauses := random3SAT(r, vars, 51)
+		s := New()
+		for v := 0; v < vars; v++ {
+			s.NewVar()
+		}
+		for _, c := range clauses {
+			s.AddClause(c...)
+		}
+
+		got := s.Solve()
+		if got != brute(vars, clauses) {
+			t.Fatalf("instance %v: expected %v, got %v", i, !got, got)
+		} else if got && !satisfies(clauses, s.Value) {
+			t.Fatalf("instance %v: model doesn't satisfy the clauses", i)
+		}
+	}
+
+	// larger instances, too big to check by brute force
+	for i := 0; i < 20; i++ {
+		clauses := random3SAT(r, 150, 600)
+		s := New()
+		for v := 0; v < 150; v++ {
+			s.NewVar()
+		}
+		for _, c := range clauses {
+			s.AddClause(c...)
+		}
+		if s.Solve() && !satisfies(clauses, s.Value) {
+			t.Fatalf("large instance %v: model doesn't satisfy the clauses", i)
+		}
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	s := New()
+	a, b, c := s.NewVar(), s.NewVar(), s.NewVar()
+	s.AddClause(a, b)
+	s.AddClause(-a, c)
+
+	if !s.Solve(-b) || s.Value(b) || !s.Value(a) || !s.Value(c) {
+		t.Errorf("expected a and c assuming not b")
+	}
+	if s.Solve(-b, -c) {
+		t.Errorf("expected unsatisfiable assuming not b and not c")
+	}
+	// assumptions are not kept
+	if !s.Solve() {
+		t.Errorf("expected satisfiable without assumptions")
+	}
+
+	s.AddClause(-b)
+	s.AddClause(-c)
+	if s.Solve() {
+		t.Errorf("expected unsatisfiable")
+	}
+	if s.AddClause(a) {
+		t.Errorf("expected clauses to stay unsatisfiable")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d1) -> (q d1);
+
+		block mix (a d2, b d1) -> (x d1, y d2) {
+			(a[0], b) nor -> x;
+			(b) r -> y[1];
+			(a[1], b) xnor -> n;
+			(n, x) nand -> y[0];
+		}
+	`)
+	n, err := netlist.Flatten(ast.Blocks["mix"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only model with the inputs and latches forced is the one Eval gives
+	s := New()
+	lits := Encode(s, n, nil, nil)
+	for m := 0; m < 8; m++ {
+		inputs := []bool{m&1 == 1, m&2 == 2, m&4 == 4}
+		latches := []bool{m&2 == 2}
+		expected := n.Eval(inputs, latches)
+
+		assumptions := make([]Lit, 0)
+		force := func(l Lit, v bool) {
+			if v {
+				assumptions = append(assumptions, l)
+			} else {
+				assumptions = append(assumptions, -l)
+			}
+		}
+		idx := 0
+		for _, bits := range n.Inputs {
+			for _, bit := range bits {
+				force(lits[bit], inputs[idx])
+				idx++
+			}
+		}
+		for idx, latch := range n.Latches {
+			force(lits[latch.Q], latches[idx])
+		}
+
+		if !s.Solve(assumptions...) {
+			t.Fatalf("inputs %v: expected satisfiable", inputs)
+		}
+		for sig, l := range lits {
+			if l != 0 && s.Value(l) != expected[sig] {
+				t.Errorf("inputs %v: %s expected %v", inputs, n.Names[sig], expected[sig])
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sat
+
+import (
+	"github.com/petelliott/logiko/phdl/netlist"
+	"fmt"
+)
+
+// True gets a lit constrained to be true
+func (s *Solver) True() Lit {
+	if s.truth == 0 {
+		s.truth = s.NewVar()
+		s.AddClause(s.truth)
+	}
+	return s.truth
+}
+
+// And gets a new lit constrained to be the and of lits, by the Tseitin
+// encoding
+func (s *Solver) And(lits ...Lit) Lit {
+	if len(lits) == 0 {
+		return s.True()
+	}
+	out := s.NewVar()
+	clause := []Lit{out}
+	for _, l := range lits {
+		s.AddClause(-out, l)
+		clause = append(clause, -l)
+	}
+	s.AddClause(clause...)
+	return out
+}
+
+// Or gets a new lit constrained to be the or of lits
+func (s *Solver) Or(lits ...Lit) Lit {
+	inverted := make([]Lit, len(lits))
+	for idx, l := range lits {
+		inverted[idx] = -l
+	}
+	return -s.And(inverted...)
+}
+
+// Xor gets a new lit constrained to be the xor of a and b
+func (s *Solver) Xor(a, b Lit) Lit {
+	out := s.NewVar()
+	s.AddClause(-out, a, b)
+	s.AddClause(-out, -a, -b)
+	s.AddClause(out, -a, b)
+	s.AddClause(out, a, -b)
+	return out
+}
+
+// Encode constrains a lit for each signal of a netlist to the value of its
+// gate, giving the lits by signal. The bits of the args, in the order of
+// Inputs, and the Q of each latch are given by inputs and latches, or are new
+// variables if these are nil. buf and not gates, and inverting gates, use
+// the lits of their ins instead of new variables.
+//
+// Encoding the netlist again with latches set to the lits of each D unrolls
+// the design by a cycle.
+func Encode(s *Solver, n *netlist.Netlist, inputs, latches []Lit) []Lit {
+	lits := make([]Lit, n.Signals())
+	lits[netlist.False] = -s.True()
+	lits[netlist.True] = s.True()
+	idx := 0
+	for _, bits := range n.Inputs {
+		for _, bit := range bits {
+			if inputs == nil {
+				lits[bit] = s.NewVar()
+			} else {
+				lits[bit] = inputs[idx]
+			}
+			idx++
+		}
+	}
+	for idx, latch := range n.Latches {
+		if latches == nil {
+			lits[latch.Q] = s.NewVar()
+		} else {
+			lits[latch.Q] = latches[idx]
+		}
+	}
+
+	for _, gate := range n.Gates {
+		ins := make([]Lit, len(gate.Ins))
+		for i, in := range gate.Ins {
+			ins[i] = lits[in]
+		}
+
+		switch gate.Op {
+		case "buf":
+			lits[gate.Out] = ins[0]
+		case "not":
+			lits[gate.Out] = -ins[0]
+		case "and":
+			lits[gate.Out] = s.And(ins...)
+		case "nand":
+			lits[gate.Out] = -s.And(ins...)
+		case "or":
+			lits[gate.Out] = s.Or(ins...)
+		case "nor":
+			lits[gate.Out] = -s.Or(ins...)
+		case "xor":
+			lits[gate.Out] = s.Xor(ins[0], ins[1])
+		case "xnor":
+			lits[gate.Out] = -s.Xor(ins[0], ins[1])
+		default:
+			panic(fmt.Sprintf("unknown gate '%s'", gate.Op))
+		}
+	}
+	return lits
+}
@@ -0,0 +1,477 @@
+// Package schematic draws PHDL blocks as SVG schematics, laid out left to
+// right by logic level
+package schematic
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	margin    = 20
+	rowGap    = 30
+	pinPitch  = 20
+	trackGap  = 8
+	channel   = 24 // width of a channel between columns, without its tracks
+	portWidth = 70
+	gateWidth = 56
+	gateSize  = 40
+)
+
+type nodeKind int
+
+const (
+	inputNode nodeKind = iota
+	outputNode
+	stmtNode
+)
+
+type node struct {
+	kind  nodeKind
+	name  string
+	stmt  *phdl.AstStmt
+	level int
+	order float64 // vertical order within its column
+
+	x, y, w, h int
+	ins, outs  []int // pin y offsets from y
+}
+
+func (n *node) inPin(idx int) (int, int) {
+	return n.x, n.y + n.ins[idx]
+}
+
+func (n *node) outPin(idx int) (int, int) {
+	return n.x + n.w, n.y + n.outs[idx]
+}
+
+// end is a pin of a node connected to part of a conn
+type end struct {
+	node *node
+	pin  int
+	expr *phdl.AstExpr
+}
+
+type edge struct {
+	from, to *end
+	width    int // width of the reading port
+}
+
+type layout struct {
+	block   *phdl.AstBlock
+	nodes   []*node
+	columns [][]*node
+	edges   []*edge
+	colX    []int
+	colW    []int
+	tracks  []map[end]int // tracks of each channel, by driver
+	bottom  map[end]int   // tracks below the schematic, for other edges
+	height  int
+	width   int
+}
+
+// pins spaces n pins along a side of height h
+func pins(n, h int) []int {
+	ys := make([]int, n)
+	for i := range ys {
+		ys[i] = h * (i + 1) / (n + 1)
+	}
+	return ys
+}
+
+func newNode(kind nodeKind, name string, stmt *phdl.AstStmt) *node {
+	n := &node{kind: kind, name: name, stmt: stmt}
+	if kind == stmtNode {
+		// statements are at least one level after the inputs
+		n.level = 1
+	}
+	switch {
+	case kind != stmtNode:
+		n.w, n.h = portWidth, pinPitch
+		n.ins, n.outs = []int{pinPitch / 2}, []int{pinPitch / 2}
+	case stmt.Op.Builtin:
+		n.w, n.h = gateWidth, gateSize
+		n.ins, n.outs = pins(len(stmt.Op.Args), gateSize), pins(1, gateSize)
+	default:
+		ports := len(stmt.Op.Args)
+		if len(stmt.Op.Rets) > ports {
+			ports = len(stmt.Op.Rets)
+		}
+		n.w, n.h = 100, pinPitch*(ports+1)
+		n.ins = pins(len(stmt.Op.Args), n.h)
+		n.outs = pins(len(stmt.Op.Rets), n.h)
+	}
+	return n
+}
+
+// bitRange gets the lowest and highest bit of a conn an expr covers
+func bitRange(expr *phdl.AstExpr) (int, int) {
+	if !expr.HasIndex() {
+		return 0, expr.Conn.Width - 1
+	}
+	return expr.Lo, expr.Hi
+}
+
+func newLayout(block *phdl.AstBlock) *layout {
+	l := &layout{block: block, bottom: make(map[end]int)}
+	drivers := make(map[*phdl.AstConn][]*end)
+	readers := make(map[*phdl.AstConn][]*end)
+
+	for _, arg := range block.Args {
+		n := newNode(inputNode, arg.Name, nil)
+		l.nodes = append(l.nodes, n)
+		drivers[arg] = append(drivers[arg], &end{n, 0, &phdl.AstExpr{Conn: arg, Lo: -1}})
+	}
+
+	insts := block.InstanceNames()
+	stmts := make([]*node, 0)
+	for sidx, stmt := range block.Stmts {
+		n := newNode(stmtNode, insts[sidx], stmt)
+		l.nodes = append(l.nodes, n)
+		stmts = append(stmts, n)
+		for idx, arg := range stmt.Args {
+			if arg.Conn != nil {
+				readers[arg.Conn] = append(readers[arg.Conn], &end{n, idx, arg})
+			}
+		}
+		for idx, ret := range stmt.Rets {
+			drivers[ret.Conn] = append(drivers[ret.Conn], &end{n, idx, ret})
+		}
+	}
+
+	for _, ret := range block.Rets {
+		n := newNode(outputNode, ret.Name, nil)
+		l.nodes = append(l.nodes, n)
+		readers[ret] = append(readers[ret], &end{n, 0, &phdl.AstExpr{Conn: ret, Lo: -1}})
+	}
+
+	names := make([]string, 0, len(block.Vars))
+	for name := range block.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := make(map[*node][]*node)
+	for _, name := range names {
+		conn := block.Vars[name]
+		for _, r := range readers[conn] {
+			rlo, rhi := bitRange(r.expr)
+			for _, d := range drivers[conn] {
+				if dlo, dhi := bitRange(d.expr); dlo > rhi || dhi < rlo {
+					continue
+				}
+				width := conn.Width
+				if r.node.kind == stmtNode {
+					width = r.node.stmt.Op.Args[r.pin].Width
+				}
+				l.edges = append(l.edges, &edge{d, r, width})
+				sources[r.node] = append(sources[r.node], d.node)
+			}
+		}
+	}
+
+	// levels are the longest path from an input, ignoring back edges of
+	// cycles through memories
+	visiting := make(map[*node]bool)
+	done := make(map[*node]bool)
+	var level func(n *node) int
+	level = func(n *node) int {
+		if done[n] {
+			return n.level
+		}
+		visiting[n] = true
+		for _, src := range sources[n] {
+			if visiting[src] {
+				continue
+			}
+			if lvl := level(src) + 1; lvl > n.level {
+				n.level = lvl
+			}
+		}
+		visiting[n] = false
+		done[n] = true
+		return n.level
+	}
+
+	maxLevel := 0
+	for _, n := range stmts {
+		if lvl := level(n); lvl > maxLevel {
+			maxLevel = lvl
+		}
+	}
+	l.columns = make([][]*node, maxLevel+2)
+	for _, n := range l.nodes {
+		switch n.kind {
+		case inputNode:
+			n.level = 0
+		case outputNode:
+			n.level = maxLevel + 1
+		}
+		n.order = float64(len(l.columns[n.level]))
+		l.columns[n.level] = append(l.columns[n.level], n)
+	}
+
+	// order each column by the mean position of its sources
+	for _, col := range l.columns[1:] {
+		for _, n := range col {
+			sum, count := 0.0, 0
+			for _, src := range sources[n] {
+				if src.level < n.level {
+					sum += src.order
+					count++
+				}
+			}
+			if count != 0 {
+				n.order = sum / float64(count)
+			}
+		}
+		sort.SliceStable(col, func(i, j int) bool { return col[i].order < col[j].order })
+		for idx, n := range col {
+			n.order = float64(idx)
+		}
+	}
+
+	l.place()
+	return l
+}
+
+// place positions the columns and nodes, and assigns wires to tracks
+func (l *layout) place() {
+	l.tracks = make([]map[end]int, len(l.columns))
+	for i := range l.tracks {
+		l.tracks[i] = make(map[end]int)
+	}
+	track := func(tracks map[end]int, e *end) {
+		key := end{e.node, e.pin, nil}
+		if _, ok := tracks[key]; !ok {
+			tracks[key] = len(tracks)
+		}
+	}
+	for _, e := range l.edges {
+		if e.adjacent() {
+			track(l.tracks[e.to.node.level-1], e.from)
+		} else {
+			track(l.tracks[e.from.node.level], e.from)
+			track(l.bottom, e.from)
+			track(l.tracks[e.to.node.level-1], e.from)
+		}
+	}
+
+	x := margin
+	l.height = 0
+	for idx, col := range l.columns {
+		w, y := 0, margin
+		for _, n := range col {
+			if n.w > w {
+				w = n.w
+			}
+			n.y = y
+			y += n.h + rowGap
+		}
+		for _, n := range col {
+			n.x = x + (w-n.w)/2
+		}
+		if y > l.height {
+			l.height = y
+		}
+
+		l.colX = append(l.colX, x)
+		l.colW = append(l.colW, w)
+		x += w + channel + trackGap*len(l.tracks[idx])
+	}
+
+	l.width = x - channel - trackGap*len(l.tracks[len(l.columns)-1]) + margin
+	l.height += trackGap*len(l.bottom) + margin - rowGap
+}
+
+// trackX gets the x of the track of a driver in the channel after column col
+func (l *layout) trackX(col int, e *end) int {
+	return l.colX[col] + l.colW[col] + channel/2 + trackGap*l.tracks[col][end{e.node, e.pin, nil}]
+}
+
+func (l *layout) bottomY(e *end) int {
+	return l.height - margin - trackGap*(len(l.bottom)-1-l.bottom[end{e.node, e.pin, nil}])
+}
+
+// adjacent checks if an edge joins neighbouring columns. Other edges run
+// below the schematic, so they don't cross the nodes of other columns.
+func (e *edge) adjacent() bool {
+	return e.to.node.level == e.from.node.level+1
+}
+
+// route gets the corners of an orthogonal wire along an edge
+func (l *layout) route(e *edge) [][2]int {
+	x1, y1 := e.from.node.outPin(e.from.pin)
+	x2, y2 := e.to.node.inPin(e.to.pin)
+	from, to := e.from.node.level, e.to.node.level
+
+	if e.adjacent() {
+		tx := l.trackX(to-1, e.from)
+		return [][2]int{{x1, y1}, {tx, y1}, {tx, y2}, {x2, y2}}
+	}
+
+	tx1 := l.trackX(from, e.from)
+	by := l.bottomY(e.from)
+	tx2 := l.trackX(to-1, e.from)
+	return [][2]int{{x1, y1}, {tx1, y1}, {tx1, by}, {tx2, by}, {tx2, y2}, {x2, y2}}
+}
+
+// exprLabel labels the wire of an expr
+func exprLabel(expr *phdl.AstExpr) string {
+	if !expr.HasIndex() {
+		return expr.Conn.Name
+	} else if expr.Lo == expr.Hi {
+		return fmt.Sprintf("%s[%v]", expr.Conn.Name, expr.Lo)
+	}
+	return fmt.Sprintf("%s[%v..%v]", expr.Conn.Name, expr.Lo, expr.Hi)
+}
+
+const style = `
+	text { font-family: monospace; font-size: 11px; }
+	.sym { fill: white; stroke: black; stroke-width: 1.5; }
+	.wire { fill: none; stroke: #1f4e8c; stroke-width: 1; }
+	.bus { fill: none; stroke: #1f4e8c; stroke-width: 3; }
+	.label { fill: #555; font-size: 9px; }
+`
+
+// WriteBlock writes a standalone SVG schematic of a type checked block
+func WriteBlock(w io.Writer, block *phdl.AstBlock) error {
+	l := newLayout(block)
+
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"0 0 %v %v\">\n",
+		l.width, l.height, l.width, l.height)
+	fmt.Fprintf(w, "<title>%s</title>\n", html.EscapeString(block.Name))
+	fmt.Fprintf(w, "<style>%s</style>\n", style)
+
+	for _, e := range l.edges {
+		writeEdge(w, l, e)
+	}
+	for _, n := range l.nodes {
+		writeNode(w, n)
+	}
+
+	_, err := fmt.Fprintln(w, "</svg>")
+	return err
+}
+
+func writeEdge(w io.Writer, l *layout, e *edge) {
+	points := l.route(e)
+	coords := make([]string, 0)
+	for _, p := range points {
+		coords = append(coords, fmt.Sprintf("%v,%v", p[0], p[1]))
+	}
+
+	class := "wire"
+	if e.width > 1 {
+		class = "bus"
+	}
+	fmt.Fprintf(w, "<polyline class=\"%s\" points=\"%s\"/>\n", class, strings.Join(coords, " "))
+
+	// label the wire where it enters the reader
+	last := points[len(points)-2]
+	x2, y2 := points[len(points)-1][0], points[len(points)-1][1]
+	fmt.Fprintf(w, "<text class=\"label\" x=\"%v\" y=\"%v\">%s</text>\n",
+		last[0]+3, y2-3, html.EscapeString(exprLabel(e.to.expr)))
+
+	if e.width > 1 {
+		// width annotation on a slash across the bus
+		sx := x2 - 10
+		fmt.Fprintf(w, "<line class=\"wire\" x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\"/>\n",
+			sx-4, y2+5, sx+4, y2-5)
+		fmt.Fprintf(w, "<text class=\"label\" x=\"%v\" y=\"%v\">%v</text>\n",
+			sx-4, y2+14, e.width)
+	}
+}
+
+// gatePaths are the outlines of the gate symbols, in a 40x40 box
+var gatePaths = map[string]string{
+	"and": "M0,0 H20 A20,20 0 0 1 20,40 H0 Z",
+	"or":  "M0,0 Q12,20 0,40 Q28,40 40,20 Q28,0 0,0 Z",
+	"buf": "M0,0 L34,20 L0,40 Z",
+}
+
+func writeGate(w io.Writer, n *node) {
+	op := n.stmt.Op.Name
+	base := strings.TrimPrefix(strings.Replace(op, "xnor", "xor", 1), "n")
+	if op == "not" {
+		base = "buf"
+	}
+	invert := base != op
+
+	// leads from the pins to the body
+	for _, y := range n.ins {
+		fmt.Fprintf(w, "<line class=\"wire\" x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\"/>\n",
+			n.x, n.y+y, n.x+14, n.y+y)
+	}
+
+	bx := n.x + 6
+	path := gatePaths[base]
+	if base == "xor" {
+		path = gatePaths["or"]
+		fmt.Fprintf(w, "<path class=\"sym\" style=\"fill:none\" transform=\"translate(%v,%v)\" d=\"M-5,0 Q7,20 -5,40\"/>\n",
+			bx, n.y)
+	}
+	fmt.Fprintf(w, "<path class=\"sym\" transform=\"translate(%v,%v)\" d=\"%s\"/>\n",
+		bx, n.y, path)
+
+	end := bx + 40
+	if base == "buf" {
+		end = bx + 34
+	}
+	if invert {
+		fmt.Fprintf(w, "<circle class=\"sym\" cx=\"%v\" cy=\"%v\" r=\"3\"/>\n", end+3, n.y+gateSize/2)
+		end += 6
+	}
+	fmt.Fprintf(w, "<line class=\"wire\" x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\"/>\n",
+		end, n.y+gateSize/2, n.x+n.w, n.y+gateSize/2)
+}
+
+func writeNode(w io.Writer, n *node) {
+	switch n.kind {
+	case inputNode:
+		fmt.Fprintf(w, "<path class=\"sym\" d=\"M%v,%v h%v l10,10 l-10,10 h%v Z\"/>\n",
+			n.x, n.y, n.w-10, 10-n.w)
+		fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\">%s</text>\n",
+			n.x+4, n.y+14, html.EscapeString(n.name))
+		return
+	case outputNode:
+		fmt.Fprintf(w, "<path class=\"sym\" d=\"M%v,%v l10,-10 h%v v20 h%v Z\"/>\n",
+			n.x, n.y+10, n.w-10, 10-n.w)
+		fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\">%s</text>\n",
+			n.x+12, n.y+14, html.EscapeString(n.name))
+		return
+	}
+
+	fmt.Fprintf(w, "<g><title>%s</title>\n", html.EscapeString(n.name))
+	if n.stmt.Op.Builtin {
+		writeGate(w, n)
+	} else {
+		fmt.Fprintf(w, "<rect class=\"sym\" x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\"/>\n",
+			n.x, n.y, n.w, n.h)
+		fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\" text-anchor=\"middle\" font-weight=\"bold\">%s</text>\n",
+			n.x+n.w/2, n.y-4, html.EscapeString(n.stmt.Op.Name))
+		for idx, y := range n.ins {
+			fmt.Fprintf(w, "<text class=\"label\" x=\"%v\" y=\"%v\">%s</text>\n",
+				n.x+3, n.y+y+3, html.EscapeString(n.stmt.Op.Args[idx].Name))
+		}
+		for idx, y := range n.outs {
+			fmt.Fprintf(w, "<text class=\"label\" x=\"%v\" y=\"%v\" text-anchor=\"end\">%s</text>\n",
+				n.x+n.w-3, n.y+y+3, html.EscapeString(n.stmt.Op.Rets[idx].Name))
+		}
+	}
+
+	// literal args are written at their pin
+	for idx, arg := range n.stmt.Args {
+		if arg.Conn == nil {
+			x, y := n.inPin(idx)
+			fmt.Fprintf(w, "<text x=\"%v\" y=\"%v\" text-anchor=\"end\">%s</text>\n",
+				x-2, y+4, html.EscapeString(n.stmt.Op.Args[idx].Format(arg.Literal)))
+		}
+	}
+	fmt.Fprintln(w, "</g>")
+}
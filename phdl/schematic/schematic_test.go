@@ -0,0 +1,145 @@
+package schematic
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/petelliott/logiko/phdl/checks"
+	"io"
+	"strings"
+	"testing"
+)
+
+func columns(l *layout) [][]string {
+	cols := make([][]string, 0)
+	for _, col := range l.columns {
+		names := make([]string, 0)
+		for _, n := range col {
+			names = append(names, n.name)
+		}
+		cols = append(cols, names)
+	}
+	return cols
+}
+
+func TestLayoutLevels(t *testing.T) {
+	ast := checks.MustCompile(`
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) and -> c;
+		}
+
+		block full (a d1, b d1, cin d1) -> (s d1, cout d1) {
+			(t, cin) half -> s, c2;
+			(a, b) half -> t, c1;
+			(c1, c2) or -> cout;
+			(1) not -> unused;
+		}
+	`)
+
+	l := newLayout(ast.Blocks["full"])
+	got := columns(l)
+	expected := [][]string{
+		{"a", "b", "cin"},
+		{"half1", "not0"},
+		{"half0"},
+		{"or0"},
+		{"s", "cout"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected columns %v, got %v", expected, got)
+	}
+	for idx := range expected {
+		if strings.Join(got[idx], " ") != strings.Join(expected[idx], " ") {
+			t.Errorf("column %v: expected %v, got %v", idx, expected[idx], got[idx])
+		}
+	}
+
+	// columns don't overlap, and wires leave and enter at pins
+	for idx := 1; idx < len(l.colX); idx++ {
+		if l.colX[idx] <= l.colX[idx-1]+l.colW[idx-1] {
+			t.Errorf("column %v overlaps column %v", idx, idx-1)
+		}
+	}
+	for _, e := range l.edges {
+		points := l.route(e)
+		x1, y1 := e.from.node.outPin(e.from.pin)
+		x2, y2 := e.to.node.inPin(e.to.pin)
+		if points[0] != [2]int{x1, y1} || points[len(points)-1] != [2]int{x2, y2} {
+			t.Errorf("edge %s: route %v doesn't join its pins", exprLabel(e.to.expr), points)
+		}
+		for i := 1; i < len(points); i++ {
+			if points[i][0] != points[i-1][0] && points[i][1] != points[i-1][1] {
+				t.Errorf("edge %s: route %v isn't orthogonal", exprLabel(e.to.expr), points)
+			}
+		}
+	}
+}
+
+func TestLayoutCycle(t *testing.T) {
+	ast := checks.MustCompile(`
+		ram mem (addr d2, din d4, we d1) -> (dout d4);
+
+		block acc (addr d2, we d1) -> (o d4) {
+			(addr, o, we) mem -> o;
+		}
+	`)
+
+	l := newLayout(ast.Blocks["acc"])
+	if got := columns(l); len(got) != 3 {
+		t.Errorf("expected 3 columns, got %v", got)
+	}
+	if len(l.bottom) != 1 {
+		t.Fatalf("expected 1 bottom track, got %v", len(l.bottom))
+	}
+	for _, e := range l.edges {
+		if e.from.node == e.to.node {
+			points := l.route(e)
+			if len(points) != 6 {
+				t.Errorf("expected feedback wire along the bottom, got %v", points)
+			}
+		}
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	ast := checks.MustCompile(`
+		block pass (i d4) -> (o d4) {
+			(i[0]) buf -> o[0];
+		}
+
+		block top (x d4, y d1) -> (o d4, p d1) {
+			(x[0], y) nand -> p;
+			(x) pass -> o;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := WriteBlock(&b, ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the output is well formed XML
+	dec := xml.NewDecoder(bytes.NewReader(b.Bytes()))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("%s\n%s", err, b.String())
+		}
+	}
+
+	for _, s := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg"`,
+		`class="bus"`,
+		`d="` + gatePaths["and"] + `"`,
+		`<circle class="sym"`,
+		`>pass</text>`,
+		`>x[0]</text>`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected '%s' in:\n%s", s, b.String())
+		}
+	}
+}
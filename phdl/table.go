@@ -0,0 +1,279 @@
+package phdl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AstTable is the body of a table block. The outputs of the block are the
+// rets of the first row whose patterns match the inputs, or zero if no row
+// matches.
+type AstTable struct {
+	Rows []*AstTableRow
+}
+
+func (at AstTable) String() string {
+	return fmt.Sprint(at.Rows)
+}
+
+// Lookup gets the outputs of the table for args
+func (at AstTable) Lookup(args []int64) ([]int64, bool) {
+	for _, row := range at.Rows {
+		if row.Match(args) {
+			rets := make([]int64, len(row.Rets))
+			for idx, ret := range row.Rets {
+				rets[idx] = ret.Literal
+			}
+			return rets, true
+		}
+	}
+	return nil, false
+}
+
+type AstTableRow struct {
+	Args []*AstPattern
+	Rets []*AstExpr
+}
+
+func (atr AstTableRow) String() string {
+	return fmt.Sprintf(
+		"(%v %v)",
+		atr.Args,
+		atr.Rets,
+	)
+}
+
+// Match checks if every pattern of the row matches the arg at its position
+func (atr AstTableRow) Match(args []int64) bool {
+	for idx, pat := range atr.Args {
+		if !pat.Match(args[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AstPattern matches the bits of a value set in Mask against Value
+type AstPattern struct {
+	Value int64
+	Mask  int64 // -1 indicates every bit
+	Width int   // number of bits in the pattern, 0 for a literal
+	Expr  *AstExpr // non-nil for a literal
+}
+
+func (ap AstPattern) String() string {
+	if ap.Expr != nil {
+		return ap.Expr.String()
+	}
+
+	var b strings.Builder
+	b.WriteString("0b")
+	for i := ap.Width - 1; i >= 0; i-- {
+		if ap.Mask&(1<<uint(i)) == 0 {
+			b.WriteByte('x')
+		} else if ap.Value&(1<<uint(i)) != 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func (ap AstPattern) Match(value int64) bool {
+	return value&ap.Mask == ap.Value&ap.Mask
+}
+
+func compilePattern(astfile *AstFile, fakeblock *AstBlock, pat *Pattern) (*AstPattern, error) {
+	if pat.Expr != nil {
+		expr, err := CompileExpr(astfile, fakeblock, pat.Expr)
+		if err != nil {
+			return nil, err
+		} else if expr.Conn != nil {
+			return nil, fmt.Errorf("connections are not allowed in tables")
+		}
+		return &AstPattern{Value: expr.Literal, Mask: -1, Expr: expr}, nil
+	}
+
+	apat := &AstPattern{}
+	for _, c := range pat.Pattern[2:] {
+		if c == '_' {
+			continue
+		} else if apat.Width == 63 {
+			return nil, fmt.Errorf("pattern '%s' is too long", pat.Pattern)
+		}
+		apat.Value <<= 1
+		apat.Mask <<= 1
+		apat.Width++
+		switch c {
+		case '1':
+			apat.Value |= 1
+			apat.Mask |= 1
+		case '0':
+			apat.Mask |= 1
+		}
+	}
+	// bits above the pattern are zero
+	apat.Mask |= ^((int64(1) << uint(apat.Width)) - 1)
+	return apat, nil
+}
+
+func CompileTable(astfile *AstFile, table *TableBlock) (*AstBlock, error) {
+	ablock := &AstBlock{
+		Name: table.Ident.Value,
+		Args: make([]*AstConn, 0),
+		Rets: make([]*AstConn, 0),
+		Vars: make(map[string]*AstConn, 0),
+		Stmts: make([]*AstStmt, 0),
+		Table: &AstTable{Rows: make([]*AstTableRow, 0)},
+	}
+
+	for _, arg := range table.Args {
+		conn, err := declToConn(astfile, arg)
+		if err != nil {
+			return nil, fmt.Errorf("CompileTable: %s", err)
+		}
+		ablock.Vars[arg.Ident.Value] = conn
+		ablock.Args = append(ablock.Args, conn)
+	}
+
+	for _, ret := range table.Rets {
+		conn, err := declToConn(astfile, ret)
+		if err != nil {
+			return nil, fmt.Errorf("CompileTable: %s", err)
+		}
+		ablock.Vars[ret.Ident.Value] = conn
+		ablock.Rets = append(ablock.Rets, conn)
+	}
+
+	fakeblock := &AstBlock{Vars: make(map[string]*AstConn)}
+	for _, row := range table.Rows {
+		arow := &AstTableRow{
+			Args: make([]*AstPattern, 0),
+			Rets: make([]*AstExpr, 0),
+		}
+
+		for _, arg := range row.Args {
+			pat, err := compilePattern(astfile, fakeblock, arg)
+			if err != nil {
+				return nil, fmt.Errorf("CompileTable: %s", err)
+			}
+			arow.Args = append(arow.Args, pat)
+		}
+
+		for _, ret := range row.Rets {
+			expr, err := CompileExpr(astfile, fakeblock, ret)
+			if err != nil {
+				return nil, fmt.Errorf("CompileTable: %s", err)
+			} else if expr.Conn != nil {
+				return nil, fmt.Errorf(
+					"CompileTable: connections are not allowed in tables")
+			}
+			arow.Rets = append(arow.Rets, expr)
+		}
+
+		ablock.Table.Rows = append(ablock.Table.Rows, arow)
+	}
+
+	return ablock, nil
+}
+
+// SynthesizeTable builds a block equivalent to a type checked table block out
+// of builtin gates, as a sum of products over the bits of the args. Rows are
+// prioritized by only selecting a row if no earlier row matches.
+func SynthesizeTable(block *AstBlock) *AstBlock {
+	synth := &AstBlock{
+		Name: block.Name,
+		Args: block.Args,
+		Rets: block.Rets,
+		Vars: make(map[string]*AstConn),
+		Stmts: make([]*AstStmt, 0),
+	}
+	for name, conn := range block.Vars {
+		synth.Vars[name] = conn
+	}
+
+	ntemp := 0
+	temp := func() *AstExpr {
+		conn := &AstConn{Name: fmt.Sprintf("_t%d", ntemp), Width: 1}
+		ntemp++
+		synth.Vars[conn.Name] = conn
+		return &AstExpr{Conn: conn, Lo: -1}
+	}
+	gate := func(op string, rets *AstExpr, args ...*AstExpr) {
+		synth.Stmts = append(synth.Stmts, &AstStmt{
+			Args: args,
+			Op: Builtins[op],
+			Rets: []*AstExpr{rets},
+		})
+	}
+	// reduce combines exprs with op, or gives ident if there are none
+	reduce := func(op string, ident int64, exprs []*AstExpr) *AstExpr {
+		if len(exprs) == 0 {
+			return &AstExpr{Literal: ident, Lo: -1}
+		}
+		acc := exprs[0]
+		for _, expr := range exprs[1:] {
+			out := temp()
+			gate(op, out, acc, expr)
+			acc = out
+		}
+		return acc
+	}
+
+	// inverted args, created as needed
+	inverted := make(map[string]*AstExpr)
+	bit := func(conn *AstConn, idx int, value bool) *AstExpr {
+		expr := &AstExpr{Conn: conn, Lo: idx, Hi: idx}
+		if value {
+			return expr
+		}
+		key := fmt.Sprintf("%s[%d]", conn.Name, idx)
+		if _, ok := inverted[key]; !ok {
+			inverted[key] = temp()
+			gate("not", inverted[key], expr)
+		}
+		return inverted[key]
+	}
+
+	// selected[r] is set when row r matches, and no earlier row matches
+	selected := make([]*AstExpr, len(block.Table.Rows))
+	var covered *AstExpr
+	for r, row := range block.Table.Rows {
+		terms := make([]*AstExpr, 0)
+		for idx, pat := range row.Args {
+			conn := block.Args[idx]
+			for i := 0; i < conn.Width; i++ {
+				if pat.Mask&(1<<uint(i)) != 0 {
+					terms = append(terms, bit(conn, i, pat.Value&(1<<uint(i)) != 0))
+				}
+			}
+		}
+		match := reduce("and", 1, terms)
+
+		if covered == nil {
+			selected[r] = match
+			covered = match
+			continue
+		}
+
+		notcovered := temp()
+		gate("not", notcovered, covered)
+		selected[r] = reduce("and", 1, []*AstExpr{match, notcovered})
+		covered = reduce("or", 0, []*AstExpr{covered, match})
+	}
+
+	for idx, ret := range block.Rets {
+		for i := 0; i < ret.Width; i++ {
+			terms := make([]*AstExpr, 0)
+			for r, row := range block.Table.Rows {
+				if idx < len(row.Rets) && row.Rets[idx].Literal&(1<<uint(i)) != 0 {
+					terms = append(terms, selected[r])
+				}
+			}
+			gate("buf", &AstExpr{Conn: ret, Lo: i, Hi: i}, reduce("or", 0, terms))
+		}
+	}
+
+	return synth
+}
@@ -0,0 +1,419 @@
+/*
+Package tui simulates a PHDL block live in a full-screen terminal UI.
+
+The inputs of the block are editable fields, and its outputs, along with any
+selected internal signals, update as they change. A waveform of the recent
+cycles of every signal scrolls along the bottom. Keys:
+
+	up/down, k/j     select an input
+	left/right, h/l  select a bit of the input
+	space            toggle the selected bit
+	e, =             edit the value of the input, by number or enum value
+	s, enter         step the clock
+	n                step the clock 10 times
+	p                run or pause the clock
+	w                watch an internal signal, e.g. half0.nc
+	u                stop watching the last signal
+	r                cycle the radix between hex, bin and dec
+	q                quit
+*/
+package tui
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/repl"
+	"github.com/petelliott/logiko/simulator"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cycles of history kept for the waveform
+	maxHistory = 256
+	// columns of the waveform drawn per cycle
+	cellWidth = 4
+)
+
+var keyHelp = []string{
+	"j/k select  h/l bit  space toggle  e edit  w watch  u unwatch",
+	"s step  n step 10  p run/pause  r radix  q quit",
+}
+
+type model struct {
+	sim     *elab.Simulation
+	block   *phdl.AstBlock
+	watches []string // internal signals, after the ports
+	input   int      // index of the selected arg
+	bit     int      // selected bit of the selected arg
+	radix   string
+	cycle   int
+	running bool
+	quit    bool
+
+	// history is the value of each signal at each cycle, oldest first. a
+	// watched signal has no history from before it was watched, so its
+	// samples are aligned with the end of the waveform.
+	history map[string][]simulator.PortType
+
+	prompt  string // "edit" or "watch" while a line is being typed
+	line    string
+	message string
+}
+
+func newModel(block *phdl.AstBlock, watches []string) (*model, error) {
+	sim, err := elab.Simulate(block)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &model{
+		sim:     sim,
+		block:   block,
+		watches: make([]string, 0),
+		radix:   "hex",
+		history: make(map[string][]simulator.PortType),
+	}
+	m.sample(false)
+	for _, name := range watches {
+		err := m.watch(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// signals gets the names of all signals shown: args, rets, then watches
+func (m *model) signals() []string {
+	names := make([]string, 0, len(m.block.Args)+len(m.block.Rets)+len(m.watches))
+	for _, conn := range append(m.block.Args, m.block.Rets...) {
+		names = append(names, conn.Name)
+	}
+	return append(names, m.watches...)
+}
+
+// sample records the current value of every signal, as a new cycle or in
+// place of the current one
+func (m *model) sample(replace bool) {
+	for _, name := range m.signals() {
+		value, _ := m.sim.Read(name)
+		samples := m.history[name]
+		if replace && len(samples) > 0 {
+			samples[len(samples)-1] = value
+			continue
+		}
+		samples = append(samples, value)
+		if len(samples) > maxHistory {
+			samples = samples[len(samples)-maxHistory:]
+		}
+		m.history[name] = samples
+	}
+}
+
+func (m *model) step(n int) {
+	for i := 0; i < n; i++ {
+		m.sim.Step()
+		m.cycle++
+		m.sample(false)
+	}
+}
+
+func (m *model) watch(name string) error {
+	_, err := m.sim.Read(name)
+	if err != nil {
+		return err
+	}
+	for _, signal := range m.signals() {
+		if signal == name {
+			return fmt.Errorf("'%s' is already shown", name)
+		}
+	}
+	m.watches = append(m.watches, name)
+	value, _ := m.sim.Read(name)
+	m.history[name] = []simulator.PortType{value}
+	return nil
+}
+
+// write sets the selected input, and updates the current cycle
+func (m *model) write(value simulator.PortType) error {
+	err := m.sim.Write(m.block.Args[m.input].Name, value)
+	if err != nil {
+		return err
+	}
+	m.sample(true)
+	return nil
+}
+
+// key handles a key, named as by parseKeys
+func (m *model) key(k string) {
+	if m.prompt != "" {
+		m.promptKey(k)
+		return
+	}
+
+	m.message = ""
+	switch k {
+	case "q", "ctrl-c":
+		m.quit = true
+	case "up", "k":
+		if m.input > 0 {
+			m.input--
+			m.bit = 0
+		}
+	case "down", "j":
+		if m.input < len(m.block.Args)-1 {
+			m.input++
+			m.bit = 0
+		}
+	case "left", "h":
+		if len(m.block.Args) > 0 && m.bit < m.block.Args[m.input].Width-1 {
+			m.bit++
+		}
+	case "right", "l":
+		if m.bit > 0 {
+			m.bit--
+		}
+	case " ":
+		if len(m.block.Args) > 0 {
+			value, _ := m.sim.Read(m.block.Args[m.input].Name)
+			m.setError(m.write(value ^ (1 << uint(m.bit))))
+		}
+	case "e", "=":
+		if len(m.block.Args) > 0 {
+			m.prompt = "edit"
+			m.line = ""
+		}
+	case "w":
+		m.prompt = "watch"
+		m.line = ""
+	case "u":
+		if len(m.watches) > 0 {
+			name := m.watches[len(m.watches)-1]
+			m.watches = m.watches[:len(m.watches)-1]
+			delete(m.history, name)
+		}
+	case "s", "enter":
+		m.step(1)
+	case "n":
+		m.step(10)
+	case "p":
+		m.running = !m.running
+	case "r":
+		switch m.radix {
+		case "hex":
+			m.radix = "bin"
+		case "bin":
+			m.radix = "dec"
+		default:
+			m.radix = "hex"
+		}
+	}
+}
+
+// promptKey handles a key while a line is being typed
+func (m *model) promptKey(k string) {
+	switch k {
+	case "esc", "ctrl-c":
+		m.prompt = ""
+	case "backspace":
+		if len(m.line) > 0 {
+			m.line = m.line[:len(m.line)-1]
+		}
+	case "enter":
+		line := strings.TrimSpace(m.line)
+		if m.prompt == "edit" {
+			m.setError(m.edit(line))
+		} else if line != "" {
+			m.setError(m.watch(line))
+		}
+		m.prompt = ""
+	default:
+		if len(k) == 1 && k[0] > ' ' && k[0] < 0x7f {
+			m.line += k
+		}
+	}
+}
+
+// edit sets the selected input to a number, or a value of its enum
+func (m *model) edit(value string) error {
+	conn := m.block.Args[m.input]
+	if conn.Enum != nil && conn.Enum.Value(value) != nil {
+		return m.write(simulator.PortType(conn.Enum.Value(value).Value))
+	}
+	v, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value '%s'", value)
+	}
+	return m.write(simulator.PortType(v))
+}
+
+func (m *model) setError(err error) {
+	if err != nil {
+		m.message = "error: " + err.Error()
+	}
+}
+
+// format formats the value of a conn, with its enum value if it has one
+func (m *model) format(conn *phdl.AstConn, value simulator.PortType) string {
+	s := repl.Format(value, conn.Width, m.radix)
+	if conn.Enum != nil {
+		if symbol := conn.Enum.Symbol(int64(value)); symbol != nil {
+			s += " (" + symbol.Name + ")"
+		}
+	}
+	return s
+}
+
+// render draws the screen as lines of at most width columns
+func (m *model) render(width, height int) []string {
+	lines := make([]string, 0, height)
+	status := fmt.Sprintf("%s  cycle %d  radix %s", m.block.Name, m.cycle, m.radix)
+	if m.running {
+		status += "  running"
+	}
+	lines = append(lines, status, "")
+
+	nameWidth := 0
+	for _, name := range m.signals() {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+
+	row := func(selected bool, name string) string {
+		marker := "  "
+		if selected {
+			marker = "> "
+		}
+		conn, err := m.sim.Conn(name)
+		if err != nil {
+			return fmt.Sprintf("%s%-*s %s", marker, nameWidth, name, err)
+		}
+		value, _ := m.sim.Read(name)
+		return fmt.Sprintf("%s%-*s d%-3v %s", marker, nameWidth, name, conn.Width, m.format(conn, value))
+	}
+
+	lines = append(lines, "inputs")
+	for idx, conn := range m.block.Args {
+		line := row(idx == m.input, conn.Name)
+		if idx == m.input {
+			line += "  " + m.bits(conn)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "outputs")
+	for _, conn := range m.block.Rets {
+		lines = append(lines, row(false, conn.Name))
+	}
+	if len(m.watches) > 0 {
+		lines = append(lines, "signals")
+		for _, name := range m.watches {
+			lines = append(lines, row(false, name))
+		}
+	}
+
+	lines = append(lines, "", "waveform")
+	cycles := (width - nameWidth - 3) / cellWidth
+	if cycles < 1 {
+		cycles = 1
+	}
+	for _, name := range m.signals() {
+		conn, err := m.sim.Conn(name)
+		if err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %-*s %s", nameWidth, name, m.wave(conn, m.history[name], cycles)))
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, keyHelp...)
+	switch m.prompt {
+	case "edit":
+		lines = append(lines, fmt.Sprintf("%s = %s_", m.block.Args[m.input].Name, m.line))
+	case "watch":
+		lines = append(lines, fmt.Sprintf("watch %s_", m.line))
+	default:
+		lines = append(lines, m.message)
+	}
+
+	for idx, line := range lines {
+		if len(line) > width {
+			lines[idx] = line[:width]
+		}
+	}
+	if len(lines) > height {
+		// keep the prompt visible
+		lines = append(lines[:height-1], lines[len(lines)-1])
+	}
+	return lines
+}
+
+// bits draws the bits of an input, most significant first, with the selected
+// bit in brackets
+func (m *model) bits(conn *phdl.AstConn) string {
+	value, _ := m.sim.Read(conn.Name)
+	var b strings.Builder
+	for bit := conn.Width - 1; bit >= 0; bit-- {
+		digit := byte('0' + (value>>uint(bit))&1)
+		if bit == m.bit {
+			b.WriteString("[" + string(digit) + "]")
+		} else {
+			b.WriteByte(digit)
+		}
+	}
+	return b.String()
+}
+
+// wave draws the last cycles samples of a signal, cellWidth columns each. d1
+// signals are drawn as levels, with edges at transitions, and wider signals as
+// their value at each change.
+func (m *model) wave(conn *phdl.AstConn, samples []simulator.PortType, cycles int) string {
+	var b strings.Builder
+	start := m.cycle + 1 - cycles
+	if start < 0 {
+		start = 0
+	}
+	// samples of a watched signal end at the current cycle
+	offset := m.cycle + 1 - len(samples)
+
+	for cycle := start; cycle <= m.cycle; cycle++ {
+		idx := cycle - offset
+		if idx < 0 {
+			b.WriteString(strings.Repeat(" ", cellWidth))
+			continue
+		}
+		value := samples[idx]
+		changed := idx == 0 || samples[idx-1] != value
+
+		if conn.Width == 1 {
+			level := "_"
+			edge := "\\"
+			if value != 0 {
+				level = "-"
+				edge = "/"
+			}
+			if changed && idx != 0 {
+				b.WriteString(edge)
+			} else {
+				b.WriteString(level)
+			}
+			b.WriteString(strings.Repeat(level, cellWidth-1))
+		} else if changed {
+			s := strconv.FormatUint(uint64(value), 16)
+			if m.radix == "dec" {
+				s = strconv.FormatUint(uint64(value), 10)
+			} else if m.radix == "bin" {
+				s = strconv.FormatUint(uint64(value), 2)
+			}
+			if len(s) > cellWidth-1 {
+				s = s[:cellWidth-2] + "+"
+			}
+			b.WriteString("X" + s + strings.Repeat("=", cellWidth-1-len(s)))
+		} else {
+			b.WriteString(strings.Repeat("=", cellWidth))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,144 @@
+package tui
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// interval between steps of the clock while running
+const tick = 200 * time.Millisecond
+
+// Run shows a live simulation of block on the terminal until it is quit, with
+// the internal signals watches shown along with its ports
+func Run(block *phdl.AstBlock, watches []string) error {
+	m, err := newModel(block, watches)
+	if err != nil {
+		return err
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+
+	state, err := stty(tty, "-g")
+	if err != nil {
+		return err
+	}
+	_, err = stty(tty, "raw", "-echo")
+	if err != nil {
+		return err
+	}
+	defer stty(tty, strings.TrimSpace(state))
+
+	// the alternate screen, without a cursor
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(tty, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	draw(tty, m)
+	for !m.quit {
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			m.key(k)
+		case <-ticker.C:
+			if !m.running {
+				continue
+			}
+			m.step(1)
+		}
+		draw(tty, m)
+	}
+	return nil
+}
+
+// stty runs stty on the terminal
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty: %v", err)
+	}
+	return string(out), nil
+}
+
+// size gets the rows and columns of the terminal, or 24x80
+func size(tty *os.File) (int, int) {
+	out, err := stty(tty, "size")
+	fields := strings.Fields(out)
+	if err != nil || len(fields) != 2 {
+		return 24, 80
+	}
+	rows, err1 := strconv.Atoi(fields[0])
+	cols, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || rows == 0 || cols == 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+func draw(tty *os.File, m *model) {
+	rows, cols := size(tty)
+	lines := m.render(cols, rows)
+
+	// in raw mode a newline does not return the cursor
+	fmt.Fprint(tty, "\x1b[H\x1b[2J"+strings.Join(lines, "\r\n"))
+}
+
+// readKeys sends the keys read from r, until it is closed
+func readKeys(r io.Reader, keys chan<- string) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+		if err != nil {
+			close(keys)
+			return
+		}
+	}
+}
+
+// parseKeys names the keys in bytes read from a raw terminal. printable keys
+// are themselves, and others are up, down, left, right, enter, esc,
+// backspace, or ctrl-c.
+func parseKeys(b []byte) []string {
+	keys := make([]string, 0, len(b))
+	arrows := map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left"}
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case 0x1b:
+			if i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') && arrows[b[i+2]] != "" {
+				keys = append(keys, arrows[b[i+2]])
+				i += 2
+			} else {
+				keys = append(keys, "esc")
+			}
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			keys = append(keys, string(b[i]))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,130 @@
+package tui
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const prog = `
+	enum mode { hold, count }
+	reg r (d d2) -> (q d2);
+
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) nand -> nc;
+		(nc) not -> c;
+		assert (s, c) nand;
+	}
+
+	block counter (m mode, x d2) -> (q d2, s d1) {
+		(x[0], x[1]) half -> s, c;
+		(n) r -> q;
+		(q[0], m) xor -> n[0];
+		(q[0], m) and -> k;
+		(q[1], k) xor -> n[1];
+	}
+`
+
+func keys(m *model, s ...string) {
+	for _, k := range s {
+		m.key(k)
+	}
+}
+
+func screen(m *model) string {
+	return strings.Join(m.render(80, 40), "\n")
+}
+
+func TestModel(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	m, err := newModel(ast.Blocks["counter"], []string{"half0.nc", "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// toggle the high bit of x, step, then set m to count by name
+	keys(m, "down", "left", " ", "s", "up", "e", "c", "o", "u", "n", "t", "enter")
+	keys(m, "s", "w", "k", "enter", "r")
+
+	out := screen(m)
+	for _, expected := range []string{
+		"counter  cycle 2  radix bin\n",
+		"> m        d1   0b1 (count)  [1]\n",
+		"  x        d2   0b10\n",
+		"outputs\n  q        d2   0b01\n  s        d1   0b1\n",
+		"signals\n  half0.nc d1   0b1\n  n        d2   0b10\n  k        d1   0b1\n",
+		"  m        ____/-------\n",
+		"  x        X10=========\n",
+		"  q        X0======X1==\n",
+		"  s        ------------\n",
+		"  half0.nc ------------\n",
+		"  n        X0==X1==X10=\n",
+		"  k                ----\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected '%s' in:\n%s", expected, out)
+		}
+	}
+
+	keys(m, "u", "j", "e", "5", "enter")
+	out = screen(m)
+	if !strings.Contains(out, "error: 5 does not fit in 'x' (d2)") {
+		t.Errorf("expected an error in:\n%s", out)
+	}
+	if strings.Contains(out, "  k ") {
+		t.Errorf("expected k to be unwatched:\n%s", out)
+	}
+
+	keys(m, "w", "y", "enter")
+	if !strings.Contains(screen(m), "error: no conn 'y'") {
+		t.Errorf("expected an error in:\n%s", screen(m))
+	}
+
+	// the nets of asserts are not signals
+	_, err = newModel(ast.Blocks["counter"], []string{"half0.$assert0"})
+	if err == nil || err.Error() != "no conn 'half0.$assert0'" {
+		t.Errorf("expected no conn, got %v", err)
+	}
+
+	keys(m, "n")
+	if m.cycle != 12 || m.quit {
+		t.Errorf("expected cycle 12, got %v", m.cycle)
+	}
+	keys(m, "q")
+	if !m.quit {
+		t.Error("expected to quit")
+	}
+}
+
+func TestRenderSize(t *testing.T) {
+	ast := checks.MustCompile(prog)
+	m, err := newModel(ast.Blocks["counter"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.step(100)
+	m.key("e")
+
+	lines := m.render(30, 10)
+	if len(lines) != 10 {
+		t.Errorf("expected 10 lines, got %v", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) > 30 {
+			t.Errorf("line longer than 30: '%s'", line)
+		}
+	}
+	if lines[9] != "m = _" {
+		t.Errorf("expected the prompt last, got '%s'", lines[9])
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("a \x1b[A\x1b[D\r\x7f\x1b\x03"))
+	expected := []string{"a", " ", "up", "left", "enter", "backspace", "esc", "ctrl-c"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
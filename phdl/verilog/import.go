@@ -0,0 +1,1075 @@
+package verilog
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// gates maps verilog gate primitives, and the internal gate cells written by
+// yosys, to PHDL builtins
+var gates = map[string]string{
+	"buf": "buf", "not": "not", "and": "and", "or": "or", "xor": "xor",
+	"nand": "nand", "nor": "nor", "xnor": "xnor",
+	"$_BUF_": "buf", "$_NOT_": "not", "$_AND_": "and", "$_OR_": "or",
+	"$_XOR_": "xor", "$_NAND_": "nand", "$_NOR_": "nor", "$_XNOR_": "xnor",
+}
+
+// operators maps binary verilog operators to PHDL builtins
+var operators = map[string]string{
+	"&": "and", "|": "or", "^": "xor", "~^": "xnor",
+}
+
+// vexpr is a parsed verilog expression: *vident, *vnumber, *vconcat,
+// *vunary or *vbinary
+type vexpr interface{}
+
+type vident struct {
+	name   string
+	index  bool
+	hi, lo int
+	line   int
+}
+
+type vnumber struct {
+	width int
+	value int64
+}
+
+type vconcat struct {
+	parts []vexpr // most significant first
+}
+
+type vunary struct {
+	op string
+	x  vexpr
+}
+
+type vbinary struct {
+	op   string
+	l, r vexpr
+}
+
+type vrange struct {
+	hi, lo int
+}
+
+type vconn struct {
+	port string // empty for positional connections
+	expr vexpr  // nil for unconnected ports
+}
+
+type vinst struct {
+	module string
+	name   string
+	conns  []vconn
+	line   int
+}
+
+type vassign struct {
+	lhs, rhs vexpr
+	line     int
+}
+
+type vmodule struct {
+	name    string
+	ports   []string
+	dirs    map[string]string
+	nets    map[string]vrange
+	assigns []vassign
+	insts   []vinst
+	line    int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.typ != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %v: %s", p.peek().line, fmt.Sprintf(format, args...))
+}
+
+// accept consumes the next token if it is value
+func (p *parser) accept(value string) bool {
+	tok := p.peek()
+	if tok.typ != tokEOF && tok.typ != tokNumber && tok.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(value string) error {
+	if !p.accept(value) {
+		return p.errorf("expected '%s', got %s", value, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.peek()
+	if tok.typ != tokIdent {
+		return "", p.errorf("expected identifier, got %s", tok)
+	}
+	p.pos++
+	return tok.value, nil
+}
+
+func (p *parser) integer() (int, error) {
+	tok := p.peek()
+	if tok.typ != tokNumber {
+		return 0, p.errorf("expected number, got %s", tok)
+	}
+	num, err := parseNumber(tok.value)
+	if err != nil {
+		return 0, p.errorf("%s", err)
+	}
+	p.pos++
+	return int(num.value), nil
+}
+
+// parseNumber parses a verilog integer literal. x and z bits are read as 0.
+func parseNumber(lit string) (*vnumber, error) {
+	lit = strings.Replace(lit, "_", "", -1)
+	idx := strings.IndexByte(lit, '\'')
+	if idx == -1 {
+		value, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", lit)
+		}
+		return &vnumber{32, value}, nil
+	}
+
+	width := 32
+	if idx != 0 {
+		var err error
+		width, err = strconv.Atoi(lit[:idx])
+		if err != nil || width == 0 || width > 64 {
+			return nil, fmt.Errorf("invalid width in '%s'", lit)
+		}
+	}
+
+	digits := strings.TrimLeft(lit[idx+1:], "sS")
+	if digits == "" {
+		return nil, fmt.Errorf("invalid number '%s'", lit)
+	}
+	base := 10
+	switch strings.ToLower(digits[:1]) {
+	case "b":
+		base = 2
+	case "o":
+		base = 8
+	case "h":
+		base = 16
+	}
+	if strings.IndexByte("bodhBODH", digits[0]) != -1 {
+		digits = digits[1:]
+	}
+	digits = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("xXzZ?", r) {
+			return '0'
+		}
+		return r
+	}, digits)
+
+	value, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number '%s'", lit)
+	}
+	if width < 64 {
+		value &= (uint64(1) << uint(width)) - 1
+	}
+	return &vnumber{width, int64(value)}, nil
+}
+
+func (p *parser) parseRange() (vrange, error) {
+	if !p.accept("[") {
+		return vrange{0, 0}, nil
+	}
+	hi, err := p.integer()
+	if err != nil {
+		return vrange{}, err
+	}
+	if err := p.expect(":"); err != nil {
+		return vrange{}, err
+	}
+	lo, err := p.integer()
+	if err != nil {
+		return vrange{}, err
+	}
+	if hi < lo {
+		return vrange{}, p.errorf("ascending range [%v:%v] is not supported", hi, lo)
+	}
+	return vrange{hi, lo}, p.expect("]")
+}
+
+func (p *parser) parseModule() (*vmodule, error) {
+	mod := &vmodule{
+		ports: make([]string, 0),
+		dirs:  make(map[string]string),
+		nets:  make(map[string]vrange),
+		line:  p.peek().line,
+	}
+
+	var err error
+	if mod.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.peek().value == "#" {
+		return nil, p.errorf("parameters are not supported")
+	}
+
+	if p.accept("(") && !p.accept(")") {
+		dir := ""
+		var rng vrange
+		for {
+			if d := p.peek().value; d == "input" || d == "output" || d == "inout" {
+				// ANSI ports continue with the previous direction and range
+				p.next()
+				if dir, rng, err = p.parseKind(d); err != nil {
+					return nil, err
+				}
+			}
+
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			mod.ports = append(mod.ports, name)
+			if dir != "" {
+				mod.dirs[name] = dir
+				mod.nets[name] = rng
+			}
+
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+
+	for !p.accept("endmodule") {
+		tok := p.peek()
+		if tok.typ != tokIdent {
+			return nil, p.errorf("unexpected %s", tok)
+		}
+
+		switch tok.value {
+		case "input", "output", "inout", "wire", "reg":
+			p.next()
+			err = p.parseDecl(mod, tok.value)
+		case "assign":
+			p.next()
+			err = p.parseAssigns(mod)
+		case "module", "always", "initial", "parameter", "localparam",
+			"generate", "function", "task", "defparam":
+			err = p.errorf("'%s' is not supported", tok.value)
+		default:
+			err = p.parseInsts(mod)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return mod, nil
+}
+
+// parseKind parses the direction, type and range of a declaration of kind
+func (p *parser) parseKind(kind string) (string, vrange, error) {
+	if kind == "inout" {
+		return "", vrange{}, p.errorf("inout ports are not supported")
+	}
+	dir := ""
+	if kind == "input" || kind == "output" {
+		dir = kind
+		if !p.accept("wire") {
+			p.accept("reg")
+		}
+	}
+	p.accept("signed")
+
+	rng, err := p.parseRange()
+	return dir, rng, err
+}
+
+// parseDecl parses a port or net declaration in a module body
+func (p *parser) parseDecl(mod *vmodule, kind string) error {
+	dir, rng, err := p.parseKind(kind)
+	if err != nil {
+		return err
+	}
+
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return err
+		}
+		// 'output [3:0] x; wire [3:0] x;' declares the same net twice
+		mod.nets[name] = rng
+		if dir != "" {
+			mod.dirs[name] = dir
+		}
+
+		if p.accept("=") {
+			line := p.peek().line
+			rhs, err := p.parseExpr()
+			if err != nil {
+				return err
+			}
+			mod.assigns = append(mod.assigns, vassign{
+				&vident{name: name, line: line}, rhs, line})
+		}
+
+		if !p.accept(",") {
+			break
+		}
+	}
+	return p.expect(";")
+}
+
+func (p *parser) parseAssigns(mod *vmodule) error {
+	for {
+		line := p.peek().line
+		lhs, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		rhs, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		mod.assigns = append(mod.assigns, vassign{lhs, rhs, line})
+
+		if !p.accept(",") {
+			break
+		}
+	}
+	return p.expect(";")
+}
+
+func (p *parser) parseInsts(mod *vmodule) error {
+	module, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if p.peek().value == "#" {
+		return p.errorf("parameters are not supported")
+	}
+
+	for {
+		inst := vinst{module: module, conns: make([]vconn, 0), line: p.peek().line}
+		if p.peek().typ == tokIdent {
+			inst.name = p.next().value
+		} else if _, ok := gates[module]; !ok {
+			return p.errorf("expected instance name, got %s", p.peek())
+		}
+		if p.peek().value == "[" {
+			return p.errorf("instance arrays are not supported")
+		}
+
+		if err := p.expect("("); err != nil {
+			return err
+		}
+		if !p.accept(")") {
+			for {
+				conn := vconn{}
+				if p.accept(".") {
+					if conn.port, err = p.ident(); err != nil {
+						return err
+					}
+					if err := p.expect("("); err != nil {
+						return err
+					}
+					if p.peek().value != ")" {
+						if conn.expr, err = p.parseExpr(); err != nil {
+							return err
+						}
+					}
+					if err := p.expect(")"); err != nil {
+						return err
+					}
+				} else if p.peek().value != "," && p.peek().value != ")" {
+					if conn.expr, err = p.parseExpr(); err != nil {
+						return err
+					}
+				}
+				inst.conns = append(inst.conns, conn)
+
+				if !p.accept(",") {
+					break
+				}
+			}
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+		}
+		mod.insts = append(mod.insts, inst)
+
+		if !p.accept(",") {
+			break
+		}
+	}
+	return p.expect(";")
+}
+
+// parseExpr parses an expression, with verilog's precedence for the bitwise
+// operators: & then ^ and ~^ then |
+func (p *parser) parseExpr() (vexpr, error) {
+	return p.parseBinary(0)
+}
+
+var precedence = [][]string{{"|"}, {"^", "~^"}, {"&"}}
+
+func (p *parser) parseBinary(level int) (vexpr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range precedence[level] {
+			if p.peek().typ == tokPunct && p.peek().value == o {
+				op = o
+			}
+		}
+		if op == "" {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &vbinary{op, l, r}
+	}
+}
+
+func (p *parser) parseUnary() (vexpr, error) {
+	tok := p.peek()
+	switch {
+	case tok.typ == tokPunct && tok.value == "~":
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &vunary{"~", x}, nil
+	case tok.typ == tokPunct && tok.value == "(":
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	case tok.typ == tokPunct && tok.value == "{":
+		p.next()
+		concat := &vconcat{parts: make([]vexpr, 0)}
+		for {
+			part, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().value == "{" {
+				return nil, p.errorf("replication is not supported")
+			}
+			concat.parts = append(concat.parts, part)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return concat, p.expect("}")
+	case tok.typ == tokNumber:
+		p.next()
+		num, err := parseNumber(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %s", tok.line, err)
+		}
+		return num, nil
+	case tok.typ == tokIdent:
+		p.next()
+		id := &vident{name: tok.value, line: tok.line}
+		if p.accept("[") {
+			var err error
+			id.index = true
+			if id.hi, err = p.integer(); err != nil {
+				return nil, err
+			}
+			id.lo = id.hi
+			if p.accept(":") {
+				if id.lo, err = p.integer(); err != nil {
+					return nil, err
+				}
+				if id.hi < id.lo {
+					return nil, p.errorf("ascending range [%v:%v] is not supported",
+						id.hi, id.lo)
+				}
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		return id, nil
+	}
+	return nil, p.errorf("unexpected %s in expression", tok)
+}
+
+// Import reads the modules of a structural verilog netlist, such as one
+// written by yosys, into a PHDL file. Supported are module port and net
+// declarations, continuous assignments of concatenations, slices and the
+// bitwise operators, gate primitives, and module instances.
+func Import(r io.Reader) (*phdl.AstFile, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Import: %s", err)
+	}
+	tokens, err := lex(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("Import: %s", err)
+	}
+
+	p := &parser{tokens: tokens}
+	mods := make(map[string]*vmodule)
+	order := make([]string, 0)
+	for p.peek().typ != tokEOF {
+		if err := p.expect("module"); err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+		mod, err := p.parseModule()
+		if err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+		if _, ok := mods[mod.name]; ok {
+			return nil, fmt.Errorf("Import: line %v: module '%s' redefined",
+				mod.line, mod.name)
+		}
+		mods[mod.name] = mod
+		order = append(order, mod.name)
+	}
+
+	imp := &importer{
+		file: &phdl.AstFile{
+			Blocks:  make(map[string]*phdl.AstBlock),
+			Tests:   make(map[string]*phdl.AstTest),
+			Bundles: make(map[string]*phdl.AstBundle),
+			Enums:   make(map[string]*phdl.AstEnum),
+		},
+		mods:    mods,
+		blocks:  make(map[string]*phdl.AstBlock),
+		ports:   make(map[string]map[string]*phdl.AstConn),
+		pending: make(map[string]bool),
+		names:   phdl.NewNamer(),
+	}
+	for _, name := range order {
+		if _, err := imp.module(name); err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+	}
+	return imp.file, nil
+}
+
+type importer struct {
+	file    *phdl.AstFile
+	mods    map[string]*vmodule
+	blocks  map[string]*phdl.AstBlock // by verilog name
+	ports   map[string]map[string]*phdl.AstConn // by verilog module and port
+	pending map[string]bool
+	names   *phdl.Namer
+}
+
+// module converts a module, after the modules it instantiates
+func (imp *importer) module(vname string) (*phdl.AstBlock, error) {
+	if block, ok := imp.blocks[vname]; ok {
+		return block, nil
+	} else if imp.pending[vname] {
+		return nil, fmt.Errorf("module '%s' instantiates itself", vname)
+	}
+	mod := imp.mods[vname]
+	imp.pending[vname] = true
+
+	block := &phdl.AstBlock{
+		Name:  imp.names.Name(vname),
+		Args:  make([]*phdl.AstConn, 0),
+		Rets:  make([]*phdl.AstConn, 0),
+		Vars:  make(map[string]*phdl.AstConn),
+		Stmts: make([]*phdl.AstStmt, 0),
+	}
+	m := &lowering{
+		imp:   imp,
+		mod:   mod,
+		block: block,
+		names: phdl.NewNamer(),
+		conns: make(map[string]*phdl.AstConn),
+	}
+
+	for _, port := range mod.ports {
+		dir, ok := mod.dirs[port]
+		if !ok {
+			return nil, fmt.Errorf("line %v: port '%s' of module '%s' has no direction",
+				mod.line, port, vname)
+		}
+		conn := m.conn(port)
+		if dir == "input" {
+			block.Args = append(block.Args, conn)
+		} else {
+			block.Rets = append(block.Rets, conn)
+		}
+	}
+	for name := range mod.dirs {
+		if m.conns[name] == nil {
+			return nil, fmt.Errorf("line %v: '%s' is not a port of module '%s'",
+				mod.line, name, vname)
+		}
+	}
+
+	for _, assign := range mod.assigns {
+		if err := m.assign(assign); err != nil {
+			return nil, err
+		}
+	}
+	for _, inst := range mod.insts {
+		if err := m.inst(inst); err != nil {
+			return nil, err
+		}
+	}
+
+	imp.blocks[vname] = block
+	imp.ports[vname] = m.conns
+	imp.file.Blocks[block.Name] = block
+	delete(imp.pending, vname)
+	return block, nil
+}
+
+// lowering converts the body of one module into PHDL statements. PHDL has no
+// concatenation or operators, so these are lowered a bit at a time through
+// builtins.
+type lowering struct {
+	imp   *importer
+	mod   *vmodule
+	block *phdl.AstBlock
+	names *phdl.Namer
+	conns map[string]*phdl.AstConn // by verilog name
+}
+
+// conn gets the conn of a net, implicitly declaring undeclared nets as d1
+func (m *lowering) conn(vname string) *phdl.AstConn {
+	if conn, ok := m.conns[vname]; ok {
+		return conn
+	}
+	rng := m.mod.nets[vname]
+	conn := &phdl.AstConn{Name: m.names.Name(vname), Width: rng.hi - rng.lo + 1}
+	m.conns[vname] = conn
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func (m *lowering) temp(width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Temp(), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func (m *lowering) gate(op string, args []*phdl.AstExpr, ret *phdl.AstExpr) {
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: args,
+		Op:   phdl.Builtins[op],
+		Rets: []*phdl.AstExpr{ret},
+	})
+}
+
+func whole(conn *phdl.AstConn) *phdl.AstExpr {
+	return &phdl.AstExpr{Conn: conn, Lo: -1}
+}
+
+func literal(value int64) *phdl.AstExpr {
+	return &phdl.AstExpr{Literal: value, Lo: -1}
+}
+
+// slice gets a contiguous PHDL expr for an identifier, which may be indexed
+func (m *lowering) slice(id *vident) (*phdl.AstExpr, error) {
+	conn := m.conn(id.name)
+	if !id.index {
+		return whole(conn), nil
+	}
+	rng := m.mod.nets[id.name]
+	if id.lo < rng.lo || id.hi > rng.hi {
+		return nil, fmt.Errorf("line %v: index [%v:%v] out of range of '%s' [%v:%v]",
+			id.line, id.hi, id.lo, id.name, rng.hi, rng.lo)
+	}
+	return &phdl.AstExpr{Conn: conn, Lo: id.lo - rng.lo, Hi: id.hi - rng.lo}, nil
+}
+
+func (m *lowering) width(e vexpr) int {
+	switch e := e.(type) {
+	case *vident:
+		if e.index {
+			return e.hi - e.lo + 1
+		}
+		rng := m.mod.nets[e.name]
+		return rng.hi - rng.lo + 1
+	case *vnumber:
+		return e.width
+	case *vconcat:
+		width := 0
+		for _, part := range e.parts {
+			width += m.width(part)
+		}
+		return width
+	case *vunary:
+		return m.width(e.x)
+	case *vbinary:
+		l, r := m.width(e.l), m.width(e.r)
+		if l > r {
+			return l
+		}
+		return r
+	}
+	panic("unreachable")
+}
+
+// bit gets bit i of e as a d1 expr, zero extending e. If dst is not nil, the
+// bit is driven onto dst instead.
+func (m *lowering) bit(e vexpr, i int, dst *phdl.AstExpr) (*phdl.AstExpr, error) {
+	var src *phdl.AstExpr
+	switch e := e.(type) {
+	case *vident:
+		expr, err := m.slice(e)
+		if err != nil {
+			return nil, err
+		}
+		if i >= m.width(e) {
+			src = literal(0)
+		} else if expr.Conn.Width == 1 {
+			src = expr
+		} else {
+			lo := i
+			if expr.HasIndex() {
+				lo += expr.Lo
+			}
+			src = &phdl.AstExpr{Conn: expr.Conn, Lo: lo, Hi: lo}
+		}
+	case *vnumber:
+		src = literal(0)
+		if i < 64 {
+			src = literal((e.value >> uint(i)) & 1)
+		}
+	case *vconcat:
+		src = literal(0)
+		for idx := len(e.parts) - 1; idx >= 0; idx-- {
+			width := m.width(e.parts[idx])
+			if i < width {
+				return m.bit(e.parts[idx], i, dst)
+			}
+			i -= width
+		}
+	case *vunary, *vbinary:
+		out := dst
+		if out == nil {
+			out = whole(m.temp(1))
+		}
+		if u, ok := e.(*vunary); ok {
+			x, err := m.bit(u.x, i, nil)
+			if err != nil {
+				return nil, err
+			}
+			m.gate("not", []*phdl.AstExpr{x}, out)
+		} else {
+			b := e.(*vbinary)
+			l, err := m.bit(b.l, i, nil)
+			if err != nil {
+				return nil, err
+			}
+			r, err := m.bit(b.r, i, nil)
+			if err != nil {
+				return nil, err
+			}
+			m.gate(operators[b.op], []*phdl.AstExpr{l, r}, out)
+		}
+		return out, nil
+	}
+
+	if dst != nil {
+		m.gate("buf", []*phdl.AstExpr{src}, dst)
+		return dst, nil
+	}
+	return src, nil
+}
+
+// targets gets the d1 exprs for each bit of an assignable expr, least
+// significant first
+func (m *lowering) targets(e vexpr, line int) ([]*phdl.AstExpr, error) {
+	switch e := e.(type) {
+	case *vident:
+		bits := make([]*phdl.AstExpr, 0)
+		for i := 0; i < m.width(e); i++ {
+			bit, err := m.bit(e, i, nil)
+			if err != nil {
+				return nil, err
+			}
+			bits = append(bits, bit)
+		}
+		return bits, nil
+	case *vconcat:
+		bits := make([]*phdl.AstExpr, 0)
+		for idx := len(e.parts) - 1; idx >= 0; idx-- {
+			part, err := m.targets(e.parts[idx], line)
+			if err != nil {
+				return nil, err
+			}
+			bits = append(bits, part...)
+		}
+		return bits, nil
+	}
+	return nil, fmt.Errorf("line %v: cannot assign to expression", line)
+}
+
+func (m *lowering) assign(assign vassign) error {
+	bits, err := m.targets(assign.lhs, assign.line)
+	if err != nil {
+		return err
+	}
+	for i, bit := range bits {
+		if _, err := m.bit(assign.rhs, i, bit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// input gets an expr for e connected to an input port of width. Exprs that
+// aren't contiguous are assembled in a temporary conn.
+func (m *lowering) input(e vexpr, width int) (*phdl.AstExpr, error) {
+	switch e := e.(type) {
+	case nil:
+		return literal(0), nil
+	case *vident:
+		if m.width(e) == width {
+			return m.slice(e)
+		}
+	case *vnumber:
+		return literal(e.value), nil
+	}
+
+	tmp := m.temp(width)
+	for i := 0; i < width; i++ {
+		dst := &phdl.AstExpr{Conn: tmp, Lo: i, Hi: i}
+		if width == 1 {
+			dst = whole(tmp)
+		}
+		if _, err := m.bit(e, i, dst); err != nil {
+			return nil, err
+		}
+	}
+	return whole(tmp), nil
+}
+
+// output gets an expr for e connected to an output port of width. Exprs that
+// aren't contiguous are driven from a temporary conn.
+func (m *lowering) output(e vexpr, width int, line int) (*phdl.AstExpr, error) {
+	if e == nil {
+		return whole(m.temp(width)), nil
+	} else if id, ok := e.(*vident); ok && m.width(id) == width {
+		return m.slice(id)
+	}
+
+	bits, err := m.targets(e, line)
+	if err != nil {
+		return nil, err
+	}
+	tmp := m.temp(width)
+	for i, bit := range bits {
+		src := literal(0)
+		if width == 1 && i == 0 {
+			src = whole(tmp)
+		} else if i < width {
+			src = &phdl.AstExpr{Conn: tmp, Lo: i, Hi: i}
+		}
+		m.gate("buf", []*phdl.AstExpr{src}, bit)
+	}
+	return whole(tmp), nil
+}
+
+func (m *lowering) inst(inst vinst) error {
+	if builtin, ok := gates[inst.module]; ok {
+		if strings.HasPrefix(inst.module, "$") {
+			return m.cell(inst, builtin)
+		}
+		return m.primitive(inst, builtin)
+	}
+
+	if _, ok := m.imp.mods[inst.module]; !ok {
+		return fmt.Errorf("line %v: module '%s' not defined", inst.line, inst.module)
+	}
+	op, err := m.imp.module(inst.module)
+	if err != nil {
+		return err
+	}
+	sub := m.imp.mods[inst.module]
+
+	ports := m.imp.ports[inst.module]
+	index := make(map[string]int)
+	for idx, conn := range op.Args {
+		index[conn.Name] = idx
+	}
+	for idx, conn := range op.Rets {
+		index[conn.Name] = idx
+	}
+
+	exprs := make(map[string]vexpr)
+	for idx, conn := range inst.conns {
+		port := conn.port
+		if port == "" {
+			if idx >= len(sub.ports) {
+				return fmt.Errorf("line %v: too many connections to '%s'",
+					inst.line, inst.module)
+			}
+			port = sub.ports[idx]
+		} else if _, ok := sub.dirs[port]; !ok {
+			return fmt.Errorf("line %v: module '%s' has no port '%s'",
+				inst.line, inst.module, port)
+		}
+		exprs[port] = conn.expr
+	}
+
+	stmt := &phdl.AstStmt{
+		Args: make([]*phdl.AstExpr, len(op.Args)),
+		Op:   op,
+		Rets: make([]*phdl.AstExpr, len(op.Rets)),
+	}
+	outputs := make([]string, 0)
+	for _, port := range sub.ports {
+		conn := ports[port]
+		if sub.dirs[port] == "input" {
+			stmt.Args[index[conn.Name]], err = m.input(exprs[port], conn.Width)
+			if err != nil {
+				return err
+			}
+		} else {
+			outputs = append(outputs, port)
+		}
+	}
+	m.block.Stmts = append(m.block.Stmts, stmt)
+
+	// outputs are lowered after the statement, so that the bufs driving
+	// concatenations follow the instance
+	for _, port := range outputs {
+		conn := ports[port]
+		stmt.Rets[index[conn.Name]], err = m.output(exprs[port], conn.Width, inst.line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// primitive lowers a gate primitive. buf and not have any number of outputs
+// followed by one input, the others one output followed by any number of
+// inputs, which are chained.
+func (m *lowering) primitive(inst vinst, op string) error {
+	for _, conn := range inst.conns {
+		if conn.port != "" {
+			return fmt.Errorf("line %v: gate '%s' has no named ports",
+				inst.line, inst.module)
+		}
+	}
+	if len(inst.conns) < 2 || (op != "buf" && op != "not" && len(inst.conns) < 3) {
+		return fmt.Errorf("line %v: too few connections to gate '%s'",
+			inst.line, inst.module)
+	}
+
+	if op == "buf" || op == "not" {
+		in, err := m.input(inst.conns[len(inst.conns)-1].expr, 1)
+		if err != nil {
+			return err
+		}
+		for _, conn := range inst.conns[:len(inst.conns)-1] {
+			out, err := m.output(conn.expr, 1, inst.line)
+			if err != nil {
+				return err
+			}
+			m.gate(op, []*phdl.AstExpr{in}, out)
+		}
+		return nil
+	}
+
+	ins := make([]*phdl.AstExpr, 0)
+	for _, conn := range inst.conns[1:] {
+		in, err := m.input(conn.expr, 1)
+		if err != nil {
+			return err
+		}
+		ins = append(ins, in)
+	}
+
+	// inverting gates invert only the last of the chain
+	base := strings.TrimPrefix(strings.Replace(op, "xnor", "xor", 1), "n")
+	acc := ins[0]
+	for _, in := range ins[1 : len(ins)-1] {
+		tmp := whole(m.temp(1))
+		m.gate(base, []*phdl.AstExpr{acc, in}, tmp)
+		acc = tmp
+	}
+
+	out, err := m.output(inst.conns[0].expr, 1, inst.line)
+	if err != nil {
+		return err
+	}
+	m.gate(op, []*phdl.AstExpr{acc, ins[len(ins)-1]}, out)
+	return nil
+}
+
+// cell lowers a yosys gate cell, with inputs A and B and output Y
+func (m *lowering) cell(inst vinst, op string) error {
+	exprs := make(map[string]vexpr)
+	for _, conn := range inst.conns {
+		if conn.port != "A" && conn.port != "B" && conn.port != "Y" {
+			return fmt.Errorf("line %v: cell '%s' has no port '%s'",
+				inst.line, inst.module, conn.port)
+		}
+		exprs[conn.port] = conn.expr
+	}
+
+	ports := []string{"A", "B"}[:len(phdl.Builtins[op].Args)]
+	args := make([]*phdl.AstExpr, 0)
+	for _, port := range ports {
+		in, err := m.input(exprs[port], 1)
+		if err != nil {
+			return err
+		}
+		args = append(args, in)
+	}
+	out, err := m.output(exprs["Y"], 1, inst.line)
+	if err != nil {
+		return err
+	}
+	m.gate(op, args, out)
+	return nil
+}
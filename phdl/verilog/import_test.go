@@ -0,0 +1,204 @@
+package verilog
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/format"
+	"github.com/petelliott/logiko/simulator"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func importSim(t *testing.T, src string, top string) *simulator.Sim {
+	t.Helper()
+
+	ast, err := Import(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comp, err := elab.Elaborate(ast.Blocks[top])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return simulator.NewSim(comp)
+}
+
+func TestImportInstances(t *testing.T) {
+	sim := importSim(t, `
+		// full adder from gate primitives
+		module full (a, b, cin, s, cout);
+			input a, b, cin;
+			output s, cout;
+			wire t1, t2, t3;
+
+			xor x (s, a, b, cin);
+			and (t1, a, b), (t2, a, cin), (t3, b, cin);
+			or (cout, t1, t2, t3);
+		endmodule
+
+		module adder2 (input [1:0] a, b, input cin, output [2:0] s);
+			wire c;
+			full f0 (.a(a[0]), .b(b[0]), .cin(cin), .s(s[0]), .cout(c));
+			full f1 (a[1], b[1], c, s[1], s[2]);
+		endmodule
+	`, "adder2")
+
+	for a := simulator.PortType(0); a < 4; a++ {
+		for b := simulator.PortType(0); b < 4; b++ {
+			for cin := simulator.PortType(0); cin < 2; cin++ {
+				sim.Write(0, a)
+				sim.Write(1, b)
+				sim.Write(2, cin)
+				if got := sim.Read(0); got != a+b+cin {
+					t.Errorf("%v+%v+%v: expected %v, got %v", a, b, cin, a+b+cin, got)
+				}
+			}
+		}
+	}
+}
+
+func TestImportAssign(t *testing.T) {
+	sim := importSim(t, `
+		module swap (
+			input wire [7:0] x,
+			input wire [3:0] y,
+			output wire [7:0] o,
+			output wire [3:0] p
+		);
+			wire [4:1] n;
+			assign o = {x[3:0], x[7:4]}, n = ~y;
+			assign {p[3], p[2:0]} = (n[4:1] & 4'b1x10) ^ 4'd1;
+		endmodule
+	`, "swap")
+
+	sim.Write(0, 0xa5)
+	sim.Write(1, 0x3)
+	expect := func(port int, expected simulator.PortType) {
+		t.Helper()
+		if got := sim.Read(port); got != expected {
+			t.Errorf("port %v: expected %#x, got %#x", port, expected, got)
+		}
+	}
+	expect(0, 0x5a)
+	expect(1, (^simulator.PortType(0x3)&0x8)^1)
+}
+
+func TestImportCells(t *testing.T) {
+	sim := importSim(t, `
+		(* top = 1 *)
+		module \mux$1 (s, a, b, y);
+			input s;
+			input a;
+			input b;
+			output y;
+			wire _0_;
+			wire \and.a ;
+			wire \and.b ;
+			\$_NOT_ _1_ (.A(s), .Y(_0_));
+			\$_AND_ _2_ (.A(_0_), .B(a), .Y(\and.a ));
+			\$_AND_ _3_ (.A(s), .B(b), .Y(\and.b ));
+			\$_OR_ _4_ (.A(\and.a ), .B(\and.b ), .Y(y));
+		endmodule
+	`, "_mux_1")
+
+	for s := simulator.PortType(0); s < 2; s++ {
+		sim.Write(0, s)
+		sim.Write(1, 0)
+		sim.Write(2, 1)
+		if got := sim.Read(0); got != s {
+			t.Errorf("s=%v: expected %v, got %v", s, s, got)
+		}
+	}
+}
+
+func TestImportNames(t *testing.T) {
+	ast, err := Import(strings.NewReader(`
+		module block (input d1, input _t0, output test);
+			wire [3:0] w;
+			assign test = d1 ^ _t0;
+		endmodule
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, ok := ast.Blocks["_block"]
+	if !ok {
+		t.Fatalf("expected block '_block', got %v", ast.Blocks)
+	}
+	names := make([]string, 0)
+	for _, conn := range append(block.Args, block.Rets...) {
+		names = append(names, conn.Name)
+	}
+	if strings.Join(names, " ") != "_d1 _t0 _test" {
+		t.Errorf("unexpected port names %v", names)
+	}
+	if _, ok := block.Vars["_t0_1"]; ok {
+		t.Errorf("temporary renamed a port: %v", block.Vars)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, src := range []string{
+		"module m (a); input a; always @* ; endmodule",
+		"module m (a); inout a; endmodule",
+		"module m (input [0:3] a); endmodule",
+		"module m (a); endmodule",
+		"module m (); n x (); endmodule",
+		"module m (); m x (); endmodule",
+		"module m (input [3:0] a); wire b; assign b = a[4]; endmodule",
+		"module m (input a); assign a & a = a; endmodule",
+		"module m (); endmodule module m (); endmodule",
+	} {
+		_, err := Import(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error importing '%s'", src)
+		}
+	}
+}
+
+func TestImportSource(t *testing.T) {
+	ast, err := Import(strings.NewReader(`
+		module full (input a, b, cin, output s, cout);
+			assign s = a ^ b ^ cin, cout = (a & b) | (cin & (a ^ b));
+		endmodule
+
+		module adder2 (input [1:0] a, b, input cin, output [2:0] s);
+			wire c;
+			full f0 (a[0], b[0], cin, s[0], c);
+			full f1 (a[1], b[1], c, s[1], s[2]);
+		endmodule
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the imported file written as PHDL compiles to an equivalent block
+	var b bytes.Buffer
+	err = format.FprintFile(&b, ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adder2 := checks.MustCompile(b.String()).Blocks["adder2"]
+	ref, err := elab.BlockReference(adder2, ast.Blocks["adder2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := elab.Exhaustive(adder2, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
@@ -0,0 +1,111 @@
+package verilog
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	line  int
+}
+
+func (t token) String() string {
+	if t.typ == tokEOF {
+		return "end of file"
+	}
+	return fmt.Sprintf("'%s'", t.value)
+}
+
+// lex splits verilog source into tokens, dropping comments, attributes and
+// compiler directives
+func lex(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	line := 1
+	i := 0
+
+	isIdent := func(c byte, first bool) bool {
+		return c == '_' || unicode.IsLetter(rune(c)) ||
+			(!first && (c == '$' || unicode.IsDigit(rune(c))))
+	}
+
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//") || c == '`':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*") || strings.HasPrefix(src[i:], "(*"):
+			end := "*/"
+			if c == '(' {
+				end = "*)"
+			}
+			idx := strings.Index(src[i+2:], end)
+			if idx == -1 {
+				return nil, fmt.Errorf("line %v: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+idx], "\n")
+			i += idx + 4
+		case c == '\\':
+			// escaped identifiers end at whitespace
+			start := i + 1
+			for i < len(src) && !unicode.IsSpace(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], line})
+		case isIdent(c, true) || c == '$':
+			start := i
+			for i < len(src) && isIdent(src[i], false) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], line})
+		case unicode.IsDigit(rune(c)) || c == '\'':
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			if i < len(src) && src[i] == '\'' {
+				i++
+				if i < len(src) && (src[i] == 's' || src[i] == 'S') {
+					i++
+				}
+				if i < len(src) && strings.IndexByte("bodhBODH", src[i]) != -1 {
+					i++
+				}
+				for i < len(src) && (strings.IndexByte("xXzZ?_", src[i]) != -1 ||
+					unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i], line})
+		case strings.HasPrefix(src[i:], "~^") || strings.HasPrefix(src[i:], "^~"):
+			tokens = append(tokens, token{tokPunct, "~^", line})
+			i += 2
+		case strings.IndexByte("()[]{},;:=.#~&|^!", c) != -1:
+			tokens = append(tokens, token{tokPunct, string(c), line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %v: unexpected character '%c'", line, c)
+		}
+	}
+
+	tokens = append(tokens, token{tokEOF, "", line})
+	return tokens, nil
+}
@@ -0,0 +1,331 @@
+// Package verilog translates between PHDL and verilog
+package verilog
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Clock is the name of the port added to modules of sequential blocks, since
+// the clock is implicit in PHDL
+const Clock = "clk"
+
+var keywords = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		always and assign automatic begin buf bufif0 bufif1 case casex casez
+		cell cmos config deassign default defparam design disable edge else end
+		endcase endconfig endfunction endgenerate endmodule endprimitive
+		endspecify endtable endtask event for force forever fork function
+		generate genvar highz0 highz1 if ifnone incdir include initial inout
+		input instance integer join large liblist library localparam
+		macromodule medium module nand negedge nmos nor noshowcancelled not
+		notif0 notif1 or output parameter pmos posedge primitive pull0 pull1
+		pulldown pullup pulsestyle_onevent pulsestyle_ondetect rcmos real
+		realtime reg release repeat rnmos rpmos rtran rtranif0 rtranif1
+		scalared showcancelled signed small specify specparam strong0 strong1
+		supply0 supply1 table task time tran tranif0 tranif1 tri tri0 tri1
+		triand trior trireg unsigned use uwire vectored wait wand weak0 weak1
+		while wire wor xnor xor`) {
+		keywords[kw] = true
+	}
+}
+
+// Ident escapes name if it is a verilog keyword
+func Ident(name string) string {
+	if keywords[name] {
+		return "\\" + name + " "
+	}
+	return name
+}
+
+func rangeDecl(width int) string {
+	if width == 1 {
+		return ""
+	}
+	return fmt.Sprintf("[%v:0] ", width-1)
+}
+
+// Literal formats value as a sized verilog literal
+func Literal(width int, value int64) string {
+	if width < 64 {
+		value &= (int64(1) << uint(width)) - 1
+	}
+	return fmt.Sprintf("%v'd%v", width, uint64(value))
+}
+
+// Expr formats an expr connected to a port of width
+func Expr(width int, expr *phdl.AstExpr) string {
+	if expr.Conn == nil {
+		return Literal(width, expr.Literal)
+	} else if !expr.HasIndex() {
+		return Ident(expr.Conn.Name)
+	} else if expr.Lo == expr.Hi {
+		return fmt.Sprintf("%s[%v]", Ident(expr.Conn.Name), expr.Lo)
+	}
+	return fmt.Sprintf("%s[%v:%v]", Ident(expr.Conn.Name), expr.Hi, expr.Lo)
+}
+
+// WriteFile writes a module for every block in file, in order of name.
+// Extern blocks are expected to be provided by another verilog source.
+func WriteFile(w io.Writer, file *phdl.AstFile) error {
+	names := make([]string, 0, len(file.Blocks))
+	for name := range file.Blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for idx, name := range names {
+		if idx != 0 {
+			fmt.Fprintln(w)
+		}
+		err := WriteBlock(w, file.Blocks[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBlock writes a module for a type checked block
+func WriteBlock(w io.Writer, block *phdl.AstBlock) error {
+	if block.Extern {
+		_, err := fmt.Fprintf(w, "// extern module %s\n", Ident(block.Name))
+		return err
+	}
+
+	clocked := block.Sequential()
+	if _, ok := block.Vars[Clock]; ok && clocked {
+		return fmt.Errorf("block '%s': conn '%s' conflicts with clock port",
+			block.Name, Clock)
+	}
+
+	outtype := "wire"
+	if block.Table != nil || (block.Mem != nil && block.Mem.Kind != "rom") {
+		outtype = "reg"
+	}
+
+	ports := make([]string, 0)
+	if clocked {
+		ports = append(ports, "input wire "+Clock)
+	}
+	for _, arg := range block.Args {
+		ports = append(ports, fmt.Sprintf("input wire %s%s",
+			rangeDecl(arg.Width), Ident(arg.Name)))
+	}
+	for _, ret := range block.Rets {
+		ports = append(ports, fmt.Sprintf("output %s %s%s",
+			outtype, rangeDecl(ret.Width), Ident(ret.Name)))
+	}
+
+	fmt.Fprintf(w, "module %s (", Ident(block.Name))
+	if len(ports) != 0 {
+		fmt.Fprintf(w, "\n\t%s\n", strings.Join(ports, ",\n\t"))
+	}
+	fmt.Fprintln(w, ");")
+
+	var err error
+	if block.Mem != nil {
+		err = writeMemory(w, block)
+	} else if block.Table != nil {
+		err = writeTable(w, block)
+	} else {
+		err = writeStmts(w, block)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, "endmodule")
+	return err
+}
+
+func isPort(block *phdl.AstBlock, name string) bool {
+	for _, conn := range append(block.Args, block.Rets...) {
+		if conn.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func writeStmts(w io.Writer, block *phdl.AstBlock) error {
+	names := make([]string, 0)
+	for name := range block.Vars {
+		if !isPort(block, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "\twire %s%s;\n", rangeDecl(block.Vars[name].Width), Ident(name))
+	}
+	if len(names) != 0 && len(block.Stmts) != 0 {
+		fmt.Fprintln(w)
+	}
+
+	insts := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		inst := insts[sidx]
+
+		conns := make([]string, 0)
+		if stmt.Op.Builtin {
+			// gate primitives take the output first, by position
+			for idx, ret := range stmt.Rets {
+				conns = append(conns, Expr(stmt.Op.Rets[idx].Width, ret))
+			}
+			for idx, arg := range stmt.Args {
+				conns = append(conns, Expr(stmt.Op.Args[idx].Width, arg))
+			}
+		} else {
+			if stmt.Op.Sequential() {
+				conns = append(conns, fmt.Sprintf(".%s(%s)", Clock, Clock))
+			}
+			for idx, arg := range stmt.Args {
+				port := stmt.Op.Args[idx]
+				conns = append(conns, fmt.Sprintf(".%s(%s)",
+					Ident(port.Name), Expr(port.Width, arg)))
+			}
+			for idx, ret := range stmt.Rets {
+				port := stmt.Op.Rets[idx]
+				conns = append(conns, fmt.Sprintf(".%s(%s)",
+					Ident(port.Name), Expr(port.Width, ret)))
+			}
+		}
+
+		op := Ident(stmt.Op.Name)
+		if stmt.Op.Builtin {
+			op = stmt.Op.Name
+		}
+		_, err := fmt.Fprintf(w, "\t%s %s (%s);\n",
+			op, Ident(inst), strings.Join(conns, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMemory(w io.Writer, block *phdl.AstBlock) error {
+	if block.Mem.Kind == "reg" {
+		return writeRegister(w, block)
+	}
+
+	addr := block.Args[0]
+	data := block.Rets[0]
+	size := 1 << uint(addr.Width)
+
+	fmt.Fprintf(w, "\treg %s_mem [0:%v];\n", rangeDecl(data.Width), size-1)
+
+	if block.Mem.File != "" {
+		task := "$readmemh"
+		if block.Mem.Format == "bin" {
+			task = "$readmemb"
+		}
+		fmt.Fprintf(w, "\tinitial %s(%q, _mem);\n", task, block.Mem.File)
+	} else if len(block.Mem.Init) != 0 {
+		fmt.Fprintln(w, "\tinitial begin")
+		for idx, value := range block.Mem.Init {
+			fmt.Fprintf(w, "\t\t_mem[%v] = %s;\n", idx, Literal(data.Width, value))
+		}
+		fmt.Fprintln(w, "\tend")
+	}
+
+	if block.Mem.Kind == "rom" {
+		_, err := fmt.Fprintf(w, "\tassign %s = _mem[%s];\n",
+			Ident(data.Name), Ident(addr.Name))
+		return err
+	}
+
+	din := block.Args[1]
+	we := block.Args[2]
+	fmt.Fprintf(w, "\talways @(posedge %s) begin\n", Clock)
+	fmt.Fprintf(w, "\t\t%s <= _mem[%s];\n", Ident(data.Name), Ident(addr.Name))
+	fmt.Fprintf(w, "\t\tif (%s)\n", Ident(we.Name))
+	fmt.Fprintf(w, "\t\t\t_mem[%s] <= %s;\n", Ident(addr.Name), Ident(din.Name))
+	_, err := fmt.Fprintln(w, "\tend")
+	return err
+}
+
+func writeRegister(w io.Writer, block *phdl.AstBlock) error {
+	d := block.Args[0]
+	q := block.Rets[0]
+
+	var init int64
+	if len(block.Mem.Init) != 0 {
+		init = block.Mem.Init[0]
+	}
+	fmt.Fprintf(w, "\tinitial %s = %s;\n", Ident(q.Name), Literal(q.Width, init))
+	fmt.Fprintf(w, "\talways @(posedge %s)\n", Clock)
+	_, err := fmt.Fprintf(w, "\t\t%s <= %s;\n", Ident(q.Name), Ident(d.Name))
+	return err
+}
+
+// patternBits formats a pattern of width as verilog binary digits
+func patternBits(width int, pat *phdl.AstPattern) string {
+	var b strings.Builder
+	for i := width - 1; i >= 0; i-- {
+		bit := int64(1) << uint(i)
+		if pat.Mask&bit == 0 {
+			b.WriteByte('?')
+		} else if pat.Value&bit != 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func writeTable(w io.Writer, block *phdl.AstBlock) error {
+	sel := make([]string, 0)
+	width := 0
+	for _, arg := range block.Args {
+		sel = append(sel, Ident(arg.Name))
+		width += arg.Width
+	}
+	outs := make([]string, 0)
+	for _, ret := range block.Rets {
+		outs = append(outs, Ident(ret.Name))
+	}
+
+	assign := func(values []string) string {
+		if len(outs) == 1 {
+			return fmt.Sprintf("%s = %s;", outs[0], values[0])
+		}
+		return fmt.Sprintf("{%s} = {%s};",
+			strings.Join(outs, ", "), strings.Join(values, ", "))
+	}
+
+	if len(outs) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(w, "\talways @* begin")
+	fmt.Fprintf(w, "\t\tcasez ({%s})\n", strings.Join(sel, ", "))
+	for _, row := range block.Table.Rows {
+		pat := make([]string, 0)
+		for idx, arg := range row.Args {
+			pat = append(pat, patternBits(block.Args[idx].Width, arg))
+		}
+		values := make([]string, 0)
+		for idx, ret := range row.Rets {
+			values = append(values, Expr(block.Rets[idx].Width, ret))
+		}
+		fmt.Fprintf(w, "\t\t%v'b%s: %s\n", width, strings.Join(pat, "_"), assign(values))
+	}
+
+	zeros := make([]string, 0)
+	for _, ret := range block.Rets {
+		zeros = append(zeros, Literal(ret.Width, 0))
+	}
+	fmt.Fprintf(w, "\t\tdefault: %s\n", assign(zeros))
+	fmt.Fprintln(w, "\t\tendcase")
+	_, err := fmt.Fprintln(w, "\tend")
+	return err
+}
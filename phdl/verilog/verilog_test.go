@@ -0,0 +1,179 @@
+package verilog
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"strings"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	ast := checks.MustCompile(`
+		block wire (input d2, b d3) -> (o d1) {}
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) and -> c;
+		}
+
+		block add2 (a d2, b d2) -> (s d3) {
+			(a[0], b[0]) half -> s[0], c;
+			(a[1], b[1]) half -> x, c2;
+			(x, c) half -> s[1], c3;
+			(c2, c3) or -> s[2];
+			(a, 5) wire -> o;
+		}
+	`)
+
+	var b strings.Builder
+	err := WriteFile(&b, ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `module add2 (
+	input wire [1:0] a,
+	input wire [1:0] b,
+	output wire [2:0] s
+);
+	wire c;
+	wire c2;
+	wire c3;
+	wire o;
+	wire x;
+
+	half half0 (.a(a[0]), .b(b[0]), .s(s[0]), .c(c));
+	half half1 (.a(a[1]), .b(b[1]), .s(x), .c(c2));
+	half half2 (.a(x), .b(c), .s(s[1]), .c(c3));
+	or or0 (s[2], c2, c3);
+	\wire  wire0 (.\input (a), .b(3'd5), .o(o));
+endmodule
+
+module half (
+	input wire a,
+	input wire b,
+	output wire s,
+	output wire c
+);
+	xor xor0 (s, a, b);
+	and and0 (c, a, b);
+endmodule
+
+module \wire  (
+	input wire [1:0] \input ,
+	input wire [2:0] b,
+	output wire o
+);
+endmodule
+`
+	if b.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, b.String())
+	}
+}
+
+func TestWriteBlockPrimitives(t *testing.T) {
+	ast := checks.MustCompile(`
+		enum op { add, sub }
+		rom r (a d2) -> (d d4) = { 1, 2 };
+		rom f (a d8) -> (d d16) = bin "f.bin";
+		ram m (a d2, din d4, we d1) -> (dout d4);
+		reg q (d d4) -> (q d4) = { 9 };
+		table t (a d2, b d1) -> (o d4, p op) {
+			0b1x, 1 ==> 3, sub;
+			0, 0 ==> 1, add;
+		}
+		extern block e (a d1) -> (b d1);
+		block top (a d2) -> (o d4) {
+			(a, 1, 1) m -> o;
+		}
+	`)
+
+	Expect := func(name string, expected string) {
+		t.Helper()
+
+		var b strings.Builder
+		err := WriteBlock(&b, ast.Blocks[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != expected {
+			t.Errorf("expected/got:\n%s\n%s\n", expected, b.String())
+		}
+	}
+
+	Expect("r", `module r (
+	input wire [1:0] a,
+	output wire [3:0] d
+);
+	reg [3:0] _mem [0:3];
+	initial begin
+		_mem[0] = 4'd1;
+		_mem[1] = 4'd2;
+	end
+	assign d = _mem[a];
+endmodule
+`)
+
+	Expect("f", `module f (
+	input wire [7:0] a,
+	output wire [15:0] d
+);
+	reg [15:0] _mem [0:255];
+	initial $readmemb("f.bin", _mem);
+	assign d = _mem[a];
+endmodule
+`)
+
+	Expect("m", `module m (
+	input wire clk,
+	input wire [1:0] a,
+	input wire [3:0] din,
+	input wire we,
+	output reg [3:0] dout
+);
+	reg [3:0] _mem [0:3];
+	always @(posedge clk) begin
+		dout <= _mem[a];
+		if (we)
+			_mem[a] <= din;
+	end
+endmodule
+`)
+
+	Expect("q", `module q (
+	input wire clk,
+	input wire [3:0] d,
+	output reg [3:0] q
+);
+	initial q = 4'd9;
+	always @(posedge clk)
+		q <= d;
+endmodule
+`)
+
+	Expect("t", `module t (
+	input wire [1:0] a,
+	input wire b,
+	output reg [3:0] o,
+	output reg p
+);
+	always @* begin
+		casez ({a, b})
+		3'b1?_1: {o, p} = {4'd3, 1'd1};
+		3'b00_0: {o, p} = {4'd1, 1'd0};
+		default: {o, p} = {4'd0, 1'd0};
+		endcase
+	end
+endmodule
+`)
+
+	Expect("e", "// extern module e\n")
+
+	Expect("top", `module top (
+	input wire clk,
+	input wire [1:0] a,
+	output wire [3:0] o
+);
+	m m0 (.clk(clk), .a(a), .din(4'd1), .we(1'd1), .dout(o));
+endmodule
+`)
+}
@@ -0,0 +1,559 @@
+// Package vhdl translates PHDL to VHDL-93
+package vhdl
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Clock is the name of the port added to entities of sequential blocks, since
+// the clock is implicit in PHDL
+const Clock = "clk"
+
+const header = `library ieee;
+use ieee.std_logic_1164.all;
+use ieee.numeric_std.all;
+`
+
+var keywords = map[string]bool{}
+
+func init() {
+	for _, kw := range strings.Fields(`
+		abs access after alias all and architecture array assert attribute
+		begin block body buffer bus case component configuration constant
+		disconnect downto else elsif end entity exit file for function generate
+		generic group guarded if impure in inertial inout is label library
+		linkage literal loop map mod nand new next nor not null of on open or
+		others out package port postponed procedure process pure range record
+		register reject rem report return rol ror select severity signal shared
+		sla sll sra srl subtype then to transport type unaffected units until
+		use variable wait when while with xnor xor`) {
+		keywords[kw] = true
+	}
+}
+
+// Ident formats name as a VHDL identifier, using an extended identifier if
+// name is a keyword or is not a basic identifier
+func Ident(name string) string {
+	lower := strings.ToLower(name)
+	if keywords[lower] || strings.HasPrefix(name, "_") ||
+		strings.HasSuffix(name, "_") || strings.Contains(name, "__") {
+		return "\\" + name + "\\"
+	}
+	return name
+}
+
+func vectorType(width int) string {
+	return fmt.Sprintf("std_logic_vector(%v downto 0)", width-1)
+}
+
+// Literal formats value as a std_logic_vector of width
+func Literal(width int, value int64) string {
+	var b strings.Builder
+	for i := width - 1; i >= 0; i-- {
+		if i < 64 && value&(int64(1)<<uint(i)) != 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return fmt.Sprintf("std_logic_vector'(\"%s\")", b.String())
+}
+
+// Expr formats an expr connected to a port of width. Indexes are always
+// ranges, so that every expr is a std_logic_vector.
+func Expr(width int, expr *phdl.AstExpr) string {
+	return renamedExpr(width, expr, nil)
+}
+
+// renamedExpr formats an expr, using the signal in rename for conns in it
+func renamedExpr(width int, expr *phdl.AstExpr, rename map[string]string) string {
+	if expr.Conn == nil {
+		return Literal(width, expr.Literal)
+	}
+
+	name := expr.Conn.Name
+	if r, ok := rename[name]; ok {
+		name = r
+	}
+	if !expr.HasIndex() {
+		return Ident(name)
+	}
+	return fmt.Sprintf("%s(%v downto %v)", Ident(name), expr.Hi, expr.Lo)
+}
+
+func sortedBlocks(file *phdl.AstFile) []*phdl.AstBlock {
+	names := make([]string, 0, len(file.Blocks))
+	for name := range file.Blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	blocks := make([]*phdl.AstBlock, len(names))
+	for idx, name := range names {
+		blocks[idx] = file.Blocks[name]
+	}
+	return blocks
+}
+
+// WriteFile writes an entity and architecture for every block in file, then
+// a test bench for every test, each in order of name. Extern blocks are
+// expected to be provided by another VHDL source.
+func WriteFile(w io.Writer, file *phdl.AstFile) error {
+	first := true
+	for _, block := range sortedBlocks(file) {
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+
+		err := WriteBlock(w, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(file.Tests))
+	for name := range file.Tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+
+		err := WriteTest(w, file.Tests[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ports gets the port declarations of a block
+func ports(block *phdl.AstBlock) []string {
+	decls := make([]string, 0)
+	if block.Sequential() {
+		decls = append(decls, Clock+" : in std_logic")
+	}
+	for _, arg := range block.Args {
+		decls = append(decls, fmt.Sprintf("%s : in %s",
+			Ident(arg.Name), vectorType(arg.Width)))
+	}
+	for _, ret := range block.Rets {
+		decls = append(decls, fmt.Sprintf("%s : out %s",
+			Ident(ret.Name), vectorType(ret.Width)))
+	}
+	return decls
+}
+
+func writePorts(w io.Writer, indent string, block *phdl.AstBlock) {
+	decls := ports(block)
+	if len(decls) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%sport (\n%s\t%s\n%s);\n", indent, indent,
+		strings.Join(decls, ";\n\t"+indent), indent)
+}
+
+// WriteBlock writes an entity and architecture for a type checked block
+func WriteBlock(w io.Writer, block *phdl.AstBlock) error {
+	if block.Extern {
+		_, err := fmt.Fprintf(w, "-- extern entity %s\n", Ident(block.Name))
+		return err
+	}
+
+	if _, ok := block.Vars[Clock]; ok && block.Sequential() {
+		return fmt.Errorf("block '%s': conn '%s' conflicts with clock port",
+			block.Name, Clock)
+	}
+
+	name := Ident(block.Name)
+	fmt.Fprint(w, header)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "entity %s is\n", name)
+	writePorts(w, "\t", block)
+	fmt.Fprintf(w, "end entity %s;\n\n", name)
+
+	var err error
+	if block.Mem != nil {
+		err = writeMemory(w, block)
+	} else if block.Table != nil {
+		err = writeTable(w, block)
+	} else {
+		err = writeStmts(w, block)
+	}
+	return err
+}
+
+func isRet(block *phdl.AstBlock, name string) bool {
+	for _, conn := range block.Rets {
+		if conn.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isPort(block *phdl.AstBlock, name string) bool {
+	for _, conn := range append(block.Args, block.Rets...) {
+		if conn.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+var operators = map[string]string{
+	"and": "and", "or": "or", "xor": "xor",
+	"nand": "nand", "nor": "nor", "xnor": "xnor",
+}
+
+func writeStmts(w io.Writer, block *phdl.AstBlock) error {
+	fmt.Fprintf(w, "architecture structural of %s is\n", Ident(block.Name))
+
+	// one component declaration per block used
+	declared := make(map[*phdl.AstBlock]bool)
+	for _, stmt := range block.Stmts {
+		if stmt.Op.Builtin || declared[stmt.Op] {
+			continue
+		}
+		declared[stmt.Op] = true
+
+		fmt.Fprintf(w, "\tcomponent %s is\n", Ident(stmt.Op.Name))
+		writePorts(w, "\t\t", stmt.Op)
+		fmt.Fprintln(w, "\tend component;")
+	}
+
+	// out ports cannot be read in VHDL-93, so rets that are read are driven
+	// through a signal
+	rename := make(map[string]string)
+	for _, stmt := range block.Stmts {
+		for _, arg := range stmt.Args {
+			if arg.Conn != nil && isRet(block, arg.Conn.Name) {
+				rename[arg.Conn.Name] = arg.Conn.Name + "_int"
+			}
+		}
+	}
+
+	signals := make(map[string]int)
+	for name, conn := range block.Vars {
+		if !isPort(block, name) {
+			signals[name] = conn.Width
+		} else if r, ok := rename[name]; ok {
+			signals[r] = conn.Width
+		}
+	}
+	names := make([]string, 0, len(signals))
+	for name := range signals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\tsignal %s : %s;\n", Ident(name), vectorType(signals[name]))
+	}
+
+	fmt.Fprintln(w, "begin")
+
+	for _, ret := range block.Rets {
+		if r, ok := rename[ret.Name]; ok {
+			fmt.Fprintf(w, "\t%s <= %s;\n", Ident(ret.Name), Ident(r))
+		}
+	}
+
+	insts := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		if stmt.Op.Builtin {
+			writeGate(w, insts[sidx], stmt, rename)
+			continue
+		}
+
+		conns := make([]string, 0)
+		if stmt.Op.Sequential() {
+			conns = append(conns, fmt.Sprintf("%s => %s", Clock, Clock))
+		}
+		for idx, arg := range stmt.Args {
+			port := stmt.Op.Args[idx]
+			conns = append(conns, fmt.Sprintf("%s => %s",
+				Ident(port.Name), renamedExpr(port.Width, arg, rename)))
+		}
+		for idx, ret := range stmt.Rets {
+			port := stmt.Op.Rets[idx]
+			conns = append(conns, fmt.Sprintf("%s => %s",
+				Ident(port.Name), renamedExpr(port.Width, ret, rename)))
+		}
+
+		fmt.Fprintf(w, "\t%s : %s", Ident(insts[sidx]), Ident(stmt.Op.Name))
+		if len(conns) != 0 {
+			fmt.Fprintf(w, " port map (%s)", strings.Join(conns, ", "))
+		}
+		fmt.Fprintln(w, ";")
+	}
+
+	_, err := fmt.Fprintln(w, "end architecture structural;")
+	return err
+}
+
+// writeGate writes a builtin as a concurrent signal assignment
+func writeGate(w io.Writer, inst string, stmt *phdl.AstStmt, rename map[string]string) {
+	if len(stmt.Rets) == 0 {
+		return
+	}
+
+	args := make([]string, len(stmt.Op.Args))
+	for idx := range args {
+		args[idx] = Literal(1, 0)
+		if idx < len(stmt.Args) {
+			args[idx] = renamedExpr(1, stmt.Args[idx], rename)
+		}
+	}
+
+	var value string
+	switch stmt.Op.Name {
+	case "buf":
+		value = args[0]
+	case "not":
+		value = "not " + args[0]
+	default:
+		value = fmt.Sprintf("%s %s %s", args[0], operators[stmt.Op.Name], args[1])
+	}
+
+	fmt.Fprintf(w, "\t%s : %s <= %s;\n", Ident(inst),
+		renamedExpr(1, stmt.Rets[0], rename), value)
+}
+
+// writeMemory writes the architecture of a memory. The contents of memory
+// files are read and written as the initial value of the memory.
+func writeMemory(w io.Writer, block *phdl.AstBlock) error {
+	if block.Mem.Kind == "reg" {
+		return writeRegister(w, block)
+	}
+
+	addr := block.Args[0]
+	data := block.Rets[0]
+	size := 1 << uint(addr.Width)
+
+	init, err := block.Mem.Contents()
+	if err != nil {
+		return fmt.Errorf("block '%s': %s", block.Name, err)
+	} else if len(init) > size {
+		return fmt.Errorf("block '%s': %v words do not fit in %v words",
+			block.Name, len(init), size)
+	}
+
+	fmt.Fprintf(w, "architecture behavioral of %s is\n", Ident(block.Name))
+	fmt.Fprintf(w, "\ttype mem_t is array (0 to %v) of %s;\n", size-1, vectorType(data.Width))
+
+	values := make([]string, 0)
+	for idx, value := range init {
+		values = append(values, fmt.Sprintf("%v => %s", idx, Literal(data.Width, value)))
+	}
+	values = append(values, "others => (others => '0')")
+
+	kind := "constant"
+	if block.Mem.Kind == "ram" {
+		kind = "signal"
+	}
+	fmt.Fprintf(w, "\t%s mem : mem_t := (\n\t\t%s\n\t);\n",
+		kind, strings.Join(values, ",\n\t\t"))
+	fmt.Fprintln(w, "begin")
+
+	if block.Mem.Kind == "rom" {
+		fmt.Fprintf(w, "\t%s <= mem(to_integer(unsigned(%s)));\n",
+			Ident(data.Name), Ident(addr.Name))
+	} else {
+		din := block.Args[1]
+		we := block.Args[2]
+		fmt.Fprintf(w, "\tprocess (%s)\n\tbegin\n", Clock)
+		fmt.Fprintf(w, "\t\tif rising_edge(%s) then\n", Clock)
+		fmt.Fprintf(w, "\t\t\t%s <= mem(to_integer(unsigned(%s)));\n",
+			Ident(data.Name), Ident(addr.Name))
+		fmt.Fprintf(w, "\t\t\tif %s = \"1\" then\n", Ident(we.Name))
+		fmt.Fprintf(w, "\t\t\t\tmem(to_integer(unsigned(%s))) <= %s;\n",
+			Ident(addr.Name), Ident(din.Name))
+		fmt.Fprintln(w, "\t\t\tend if;")
+		fmt.Fprintln(w, "\t\tend if;")
+		fmt.Fprintln(w, "\tend process;")
+	}
+
+	_, err = fmt.Fprintln(w, "end architecture behavioral;")
+	return err
+}
+
+// writeRegister writes the architecture of a register, which holds its value
+// in a signal, since out ports have no initial value
+func writeRegister(w io.Writer, block *phdl.AstBlock) error {
+	d := block.Args[0]
+	q := block.Rets[0]
+
+	var init int64
+	if len(block.Mem.Init) != 0 {
+		init = block.Mem.Init[0]
+	}
+
+	fmt.Fprintf(w, "architecture behavioral of %s is\n", Ident(block.Name))
+	fmt.Fprintf(w, "\tsignal state : %s := %s;\n", vectorType(q.Width), Literal(q.Width, init))
+	fmt.Fprintln(w, "begin")
+	fmt.Fprintf(w, "\t%s <= state;\n", Ident(q.Name))
+	fmt.Fprintf(w, "\tprocess (%s)\n\tbegin\n", Clock)
+	fmt.Fprintf(w, "\t\tif rising_edge(%s) then\n", Clock)
+	fmt.Fprintf(w, "\t\t\tstate <= %s;\n", Ident(d.Name))
+	fmt.Fprintln(w, "\t\tend if;")
+	fmt.Fprintln(w, "\tend process;")
+	_, err := fmt.Fprintln(w, "end architecture behavioral;")
+	return err
+}
+
+// patternBits formats a pattern of width as std_match bits
+func patternBits(width int, pat *phdl.AstPattern) string {
+	var b strings.Builder
+	for i := width - 1; i >= 0; i-- {
+		bit := int64(1) << uint(i)
+		if pat.Mask&bit == 0 {
+			b.WriteByte('-')
+		} else if pat.Value&bit != 0 {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func writeTable(w io.Writer, block *phdl.AstBlock) error {
+	fmt.Fprintf(w, "architecture behavioral of %s is\n", Ident(block.Name))
+	fmt.Fprintln(w, "begin")
+
+	sens := make([]string, 0)
+	width := 0
+	for _, arg := range block.Args {
+		sens = append(sens, Ident(arg.Name))
+		width += arg.Width
+	}
+
+	assign := func(indent string, values []string) {
+		for idx, ret := range block.Rets {
+			fmt.Fprintf(w, "%s%s <= %s;\n", indent, Ident(ret.Name), values[idx])
+		}
+	}
+
+	fmt.Fprint(w, "\tprocess")
+	if len(sens) != 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(sens, ", "))
+	}
+	fmt.Fprintln(w)
+	if width != 0 {
+		fmt.Fprintf(w, "\t\tvariable sel : %s;\n", vectorType(width))
+	}
+	fmt.Fprintln(w, "\tbegin")
+	if width != 0 {
+		fmt.Fprintf(w, "\t\tsel := %s;\n", strings.Join(sens, " & "))
+	}
+
+	for ridx, row := range block.Table.Rows {
+		pat := ""
+		for idx, arg := range row.Args {
+			pat += patternBits(block.Args[idx].Width, arg)
+		}
+		values := make([]string, 0)
+		for idx, ret := range row.Rets {
+			values = append(values, Expr(block.Rets[idx].Width, ret))
+		}
+
+		keyword := "elsif"
+		if ridx == 0 {
+			keyword = "if"
+		}
+		cond := "true"
+		if width != 0 {
+			cond = fmt.Sprintf("std_match(sel, \"%s\")", pat)
+		}
+		fmt.Fprintf(w, "\t\t%s %s then\n", keyword, cond)
+		assign("\t\t\t", values)
+	}
+
+	zeros := make([]string, len(block.Rets))
+	for idx := range zeros {
+		zeros[idx] = "(others => '0')"
+	}
+	if len(block.Table.Rows) == 0 {
+		assign("\t\t", zeros)
+	} else {
+		fmt.Fprintln(w, "\t\telse")
+		assign("\t\t\t", zeros)
+		fmt.Fprintln(w, "\t\tend if;")
+	}
+	fmt.Fprintln(w, "\tend process;")
+
+	_, err := fmt.Fprintln(w, "end architecture behavioral;")
+	return err
+}
+
+// WriteTest writes a test bench that drives the vectors of a test into its
+// block and asserts the expected outputs. Each vector is held for 10 ns, and
+// for sequential blocks is followed by a 10 ns clock cycle, as in
+// elab.RunTest.
+func WriteTest(w io.Writer, test *phdl.AstTest) error {
+	block := test.Block
+	name := Ident(test.Name + "_tb")
+
+	fmt.Fprint(w, header)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "entity %s is\nend entity %s;\n\n", name, name)
+	fmt.Fprintf(w, "architecture test of %s is\n", name)
+	fmt.Fprintf(w, "\tcomponent %s is\n", Ident(block.Name))
+	writePorts(w, "\t\t", block)
+	fmt.Fprintln(w, "\tend component;")
+
+	conns := make([]string, 0)
+	if block.Sequential() {
+		fmt.Fprintf(w, "\tsignal %s : std_logic := '0';\n", Clock)
+		conns = append(conns, fmt.Sprintf("%s => %s", Clock, Clock))
+	}
+	for _, conn := range append(block.Args, block.Rets...) {
+		fmt.Fprintf(w, "\tsignal %s : %s;\n", Ident(conn.Name), vectorType(conn.Width))
+		conns = append(conns, fmt.Sprintf("%s => %s", Ident(conn.Name), Ident(conn.Name)))
+	}
+
+	fmt.Fprintln(w, "begin")
+	fmt.Fprintf(w, "\tdut : %s", Ident(block.Name))
+	if len(conns) != 0 {
+		fmt.Fprintf(w, " port map (%s)", strings.Join(conns, ", "))
+	}
+	fmt.Fprintln(w, ";")
+
+	fmt.Fprintln(w, "\tprocess")
+	fmt.Fprintln(w, "\tbegin")
+	for sidx, stmt := range test.Stmts {
+		for idx, arg := range stmt.Args {
+			if idx < len(block.Args) {
+				port := block.Args[idx]
+				fmt.Fprintf(w, "\t\t%s <= %s;\n", Ident(port.Name), Expr(port.Width, arg))
+			}
+		}
+		fmt.Fprintln(w, "\t\twait for 10 ns;")
+		for idx, ret := range stmt.Rets {
+			if idx < len(block.Rets) {
+				port := block.Rets[idx]
+				fmt.Fprintf(w, "\t\tassert %s = %s\n", Ident(port.Name), Expr(port.Width, ret))
+				fmt.Fprintf(w, "\t\t\treport \"%s: vector %v: %s expected %s\" severity error;\n",
+					test.Name, sidx, port.Name, port.Format(ret.Literal))
+			}
+		}
+		if block.Sequential() {
+			fmt.Fprintf(w, "\t\t%s <= '1';\n\t\twait for 5 ns;\n", Clock)
+			fmt.Fprintf(w, "\t\t%s <= '0';\n\t\twait for 5 ns;\n", Clock)
+		}
+	}
+	fmt.Fprintln(w, "\t\twait;")
+	fmt.Fprintln(w, "\tend process;")
+
+	_, err := fmt.Fprintln(w, "end architecture test;")
+	return err
+}
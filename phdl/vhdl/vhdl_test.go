@@ -0,0 +1,204 @@
+package vhdl
+
+import (
+	"github.com/petelliott/logiko/phdl/checks"
+	"strings"
+	"testing"
+)
+
+const prog = `
+	enum op { add, sub }
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) and -> c;
+		(c) not -> nc;
+	}
+	rom r (a d1) -> (d d4) = { 3 };
+	ram m (a d1, din d4, we d1) -> (dout d4);
+	reg g (d d2) -> (q d2) = { 2 };
+	table t (a d2, b d1) -> (o op) {
+		0b1x, 1 ==> sub;
+	}
+	block top (a d1, b d1) -> (s d1, q d4) {
+		(a, b) half -> s, c;
+		(s, 1) half -> x, y;
+		(a, 5, c) m -> q;
+	}
+	test ht(half) {
+		0, 1 ==> 1, 0;
+	}
+	test gt(g) {
+		1 ==> 2;
+		0 ==> 1;
+	}
+`
+
+func TestWriteBlock(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	var b strings.Builder
+	err := WriteBlock(&b, ast.Blocks["half"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := header + `
+entity half is
+	port (
+		a : in std_logic_vector(0 downto 0);
+		b : in std_logic_vector(0 downto 0);
+		s : out std_logic_vector(0 downto 0);
+		c : out std_logic_vector(0 downto 0)
+	);
+end entity half;
+
+architecture structural of half is
+	signal c_int : std_logic_vector(0 downto 0);
+	signal nc : std_logic_vector(0 downto 0);
+begin
+	c <= c_int;
+	xor0 : s <= a xor b;
+	and0 : c_int <= a and b;
+	not0 : nc <= not c_int;
+end architecture structural;
+`
+	if b.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, b.String())
+	}
+
+	Contains := func(name string, parts ...string) {
+		t.Helper()
+
+		var b strings.Builder
+		err := WriteBlock(&b, ast.Blocks[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range parts {
+			if !strings.Contains(b.String(), part) {
+				t.Errorf("%s: expected to contain:\n%s\ngot:\n%s\n", name, part, b.String())
+			}
+		}
+	}
+
+	Contains("r",
+		"constant mem : mem_t := (\n\t\t0 => std_logic_vector'(\"0011\"),\n\t\tothers => (others => '0')\n\t);",
+		"d <= mem(to_integer(unsigned(a)));")
+	Contains("m",
+		"clk : in std_logic;",
+		"if rising_edge(clk) then",
+		"mem(to_integer(unsigned(a))) <= din;")
+	Contains("g",
+		"clk : in std_logic;",
+		"signal state : std_logic_vector(1 downto 0) := std_logic_vector'(\"10\");",
+		"q <= state;",
+		"state <= d;")
+	Contains("t",
+		"sel := a & b;",
+		"if std_match(sel, \"1-1\") then\n\t\t\to <= std_logic_vector'(\"1\");")
+	Contains("top",
+		"\tcomponent m is\n\t\tport (\n\t\t\tclk : in std_logic;",
+		"half1 : half port map (a => s_int, b => std_logic_vector'(\"1\"), s => x, c => y);",
+		"m0 : m port map (clk => clk, a => a, din => std_logic_vector'(\"0101\"), we => c, dout => q);")
+}
+
+func TestWriteTest(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	var b strings.Builder
+	err := WriteTest(&b, ast.Tests["ht"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := header + `
+entity ht_tb is
+end entity ht_tb;
+
+architecture test of ht_tb is
+	component half is
+		port (
+			a : in std_logic_vector(0 downto 0);
+			b : in std_logic_vector(0 downto 0);
+			s : out std_logic_vector(0 downto 0);
+			c : out std_logic_vector(0 downto 0)
+		);
+	end component;
+	signal a : std_logic_vector(0 downto 0);
+	signal b : std_logic_vector(0 downto 0);
+	signal s : std_logic_vector(0 downto 0);
+	signal c : std_logic_vector(0 downto 0);
+begin
+	dut : half port map (a => a, b => b, s => s, c => c);
+	process
+	begin
+		a <= std_logic_vector'("0");
+		b <= std_logic_vector'("1");
+		wait for 10 ns;
+		assert s = std_logic_vector'("1")
+			report "ht: vector 0: s expected 1" severity error;
+		assert c = std_logic_vector'("0")
+			report "ht: vector 0: c expected 0" severity error;
+		wait;
+	end process;
+end architecture test;
+`
+	if b.String() != expected {
+		t.Errorf("expected/got:\n%s\n%s\n", expected, b.String())
+	}
+}
+
+func TestWriteTestSequential(t *testing.T) {
+	ast := checks.MustCompile(prog)
+
+	var b strings.Builder
+	err := WriteTest(&b, ast.Tests["gt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the clock steps after the asserts of each vector
+	expected := `	signal clk : std_logic := '0';
+	signal d : std_logic_vector(1 downto 0);
+	signal q : std_logic_vector(1 downto 0);
+begin
+	dut : g port map (clk => clk, d => d, q => q);
+	process
+	begin
+		d <= std_logic_vector'("01");
+		wait for 10 ns;
+		assert q = std_logic_vector'("10")
+			report "gt: vector 0: q expected 2" severity error;
+		clk <= '1';
+		wait for 5 ns;
+		clk <= '0';
+		wait for 5 ns;
+		d <= std_logic_vector'("00");
+		wait for 10 ns;
+		assert q = std_logic_vector'("01")
+			report "gt: vector 1: q expected 1" severity error;
+		clk <= '1';
+		wait for 5 ns;
+		clk <= '0';
+		wait for 5 ns;
+		wait;
+	end process;
+end architecture test;
+`
+	if !strings.HasSuffix(b.String(), expected) {
+		t.Errorf("expected suffix/got:\n%s\n%s\n", expected, b.String())
+	}
+}
+
+func TestIdent(t *testing.T) {
+	for name, expected := range map[string]string{
+		"a":     "a",
+		"Entity": "\\Entity\\",
+		"_t0":   "\\_t0\\",
+		"a__b":  "\\a__b\\",
+	} {
+		if Ident(name) != expected {
+			t.Errorf("expected %s, got %s", expected, Ident(name))
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package web
+
+// the assets of the page, kept in the binary so it works offline
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>logiko</title>
+<link rel="stylesheet" href="/style.css">
+</head>
+<body>
+<header>
+	<h1>logiko</h1>
+	<span id="file"></span>
+	<label>block <select id="block"></select></label>
+	<label>radix <select id="radix">
+		<option>hex</option><option>dec</option><option>bin</option>
+	</select></label>
+	<span id="status"></span>
+</header>
+<div id="error"></div>
+<main>
+	<section id="schematic"></section>
+	<section id="panel">
+		<h2>inputs</h2>
+		<table id="inputs"></table>
+		<h2>outputs</h2>
+		<table id="outputs"></table>
+		<h2>signals</h2>
+		<table id="watches"></table>
+		<form id="watch">
+			<input id="watch-name" list="conns" placeholder="half0.nc">
+			<datalist id="conns"></datalist>
+			<button>watch</button>
+		</form>
+		<h2>clock</h2>
+		<div>
+			cycle <span id="cycle">0</span>
+			<button id="step">step</button>
+			<button id="step10">step 10</button>
+			<button id="run">run</button>
+		</div>
+	</section>
+</main>
+<section>
+	<h2>waveform</h2>
+	<div id="wave"></div>
+</section>
+<section>
+	<h2>tests</h2>
+	<select id="test"></select>
+	<button id="run-test">run</button>
+	<span id="test-summary"></span>
+	<table id="vectors"></table>
+	<ul id="failures"></ul>
+</section>
+<script src="/app.js"></script>
+</body>
+</html>
+`
+
+const styleCSS = `body { font-family: sans-serif; margin: 0 1em; color: #222; }
+header { display: flex; align-items: baseline; gap: 1.5em; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1em; margin: 1em 0 0.3em; }
+main { display: flex; gap: 2em; align-items: flex-start; }
+#schematic { flex: 1; overflow: auto; border: 1px solid #ddd; min-height: 200px; }
+#schematic svg { max-width: 100%; height: auto; }
+#panel { min-width: 22em; }
+#error { color: #b00; white-space: pre-wrap; font-family: monospace; }
+#status { color: #888; }
+table { border-collapse: collapse; font-family: monospace; }
+td, th { padding: 0.1em 0.6em; text-align: left; }
+button.bit { width: 1.8em; font-family: monospace; }
+button.bit.on { background: #1f4e8c; color: white; }
+.value { min-width: 6em; }
+#wave { overflow-x: auto; border: 1px solid #ddd; }
+#wave text { font-family: monospace; font-size: 11px; }
+#wave .line { fill: none; stroke: #1f4e8c; stroke-width: 1.5; }
+#vectors tr.failed { background: #fdd; }
+#vectors td.fail { color: #b00; font-weight: bold; }
+#test-summary.pass { color: #080; }
+#test-summary.fail { color: #b00; }
+`
+
+const appJS = `"use strict";
+
+(function () {
+	var design = null;
+	var block = null;
+	var ws = null;
+	var timer = null;
+	var last = null;
+
+	// the values of each signal by cycle, oldest first. the samples of a
+	// signal end at the current cycle.
+	var history = {};
+	var cycle = -1;
+	var maxHistory = 64;
+
+	function $(id) {
+		return document.getElementById(id);
+	}
+
+	function el(tag, text, cls) {
+		var e = document.createElement(tag);
+		if (text !== undefined) {
+			e.textContent = text;
+		}
+		if (cls) {
+			e.className = cls;
+		}
+		return e;
+	}
+
+	function send(cmd) {
+		if (ws && ws.readyState === WebSocket.OPEN) {
+			ws.send(JSON.stringify(cmd));
+		}
+	}
+
+	function format(value, port) {
+		if (port && port.symbols) {
+			for (var i = 0; i < port.symbols.length; i++) {
+				if (port.symbols[i].value === value) {
+					return port.symbols[i].name;
+				}
+			}
+		}
+		var width = port ? port.width : 1;
+		var radix = $("radix").value;
+		if (radix === "dec") {
+			return String(value);
+		} else if (radix === "bin") {
+			return "0b" + value.toString(2).padStart(width, "0");
+		}
+		return "0x" + value.toString(16).padStart(Math.ceil(width / 4), "0");
+	}
+
+	// port gets a port of the block, or a watched signal as a port
+	function port(name) {
+		var ports = block.args.concat(block.rets);
+		for (var i = 0; i < ports.length; i++) {
+			if (ports[i].name === name) {
+				return ports[i];
+			}
+		}
+		if (last && last.widths[name]) {
+			return {name: name, width: last.widths[name]};
+		}
+		return null;
+	}
+
+	function load() {
+		fetch("/api/design").then(function (r) {
+			return r.json();
+		}).then(function (d) {
+			if (d.error) {
+				$("error").textContent = d.error;
+				return;
+			}
+			design = d;
+			$("file").textContent = d.file;
+			var select = $("block");
+			select.innerHTML = "";
+			d.blocks.forEach(function (b) {
+				select.appendChild(el("option", b.name));
+			});
+			var tests = $("test");
+			tests.innerHTML = "";
+			d.tests.forEach(function (t) {
+				tests.appendChild(el("option", t.name + " (" + t.block + ")")).value = t.name;
+			});
+			if (d.blocks.length > 0) {
+				selectBlock(d.blocks[0].name);
+			}
+		});
+	}
+
+	function selectBlock(name) {
+		block = design.blocks.filter(function (b) {
+			return b.name === name;
+		})[0];
+		history = {};
+		cycle = -1;
+		last = null;
+		stop();
+
+		fetch("/api/schematic?block=" + encodeURIComponent(name)).then(function (r) {
+			return r.text();
+		}).then(function (svg) {
+			$("schematic").innerHTML = svg.replace(/^<\?xml[^>]*>/, "");
+		});
+
+		var conns = $("conns");
+		conns.innerHTML = "";
+		block.conns.concat(block.instances.map(function (i) {
+			return i + ".";
+		})).forEach(function (c) {
+			conns.appendChild(el("option")).value = c;
+		});
+
+		renderInputs();
+		send({op: "start", block: name});
+	}
+
+	function renderInputs() {
+		var table = $("inputs");
+		table.innerHTML = "";
+		block.args.forEach(function (arg) {
+			var row = table.insertRow();
+			row.insertCell().textContent = arg.name;
+			var bits = row.insertCell();
+			if (arg.width <= 16 && !arg.symbols) {
+				for (var bit = arg.width - 1; bit >= 0; bit--) {
+					var b = el("button", "0", "bit");
+					b.dataset.bit = bit;
+					b.onclick = toggle(arg, bit);
+					bits.appendChild(b);
+				}
+			}
+
+			var cell = row.insertCell();
+			var input;
+			if (arg.symbols) {
+				input = el("select");
+				arg.symbols.forEach(function (s) {
+					input.appendChild(el("option", s.name)).value = s.value;
+				});
+			} else {
+				input = el("input", undefined, "value");
+				input.size = 10;
+			}
+			input.id = "arg-" + arg.name;
+			input.onchange = function () {
+				var value = Number(input.value);
+				if (isNaN(value)) {
+					$("error").textContent = "invalid value '" + input.value + "'";
+					return;
+				}
+				send({op: "write", name: arg.name, value: value});
+			};
+			cell.appendChild(input);
+		});
+	}
+
+	function toggle(arg, bit) {
+		return function () {
+			var value = last ? last.values[arg.name] : 0;
+			send({op: "write", name: arg.name, value: value ^ (1 << bit)});
+		};
+	}
+
+	function update(st) {
+		$("error").textContent = st.error || "";
+		if (!block || st.block !== block.name) {
+			return;
+		}
+		last = st;
+
+		var names = block.args.concat(block.rets).map(function (p) {
+			return p.name;
+		}).concat(st.watches);
+		var replace = st.cycle === cycle;
+		names.forEach(function (name) {
+			var samples = history[name] || [];
+			if (replace && samples.length > 0) {
+				samples[samples.length - 1] = st.values[name];
+			} else {
+				samples.push(st.values[name]);
+			}
+			history[name] = samples.slice(-maxHistory);
+		});
+		cycle = st.cycle;
+
+		render();
+	}
+
+	function render() {
+		var st = last;
+		if (!st) {
+			return;
+		}
+		$("cycle").textContent = st.cycle;
+
+		block.args.forEach(function (arg) {
+			var value = st.values[arg.name];
+			var input = $("arg-" + arg.name);
+			if (document.activeElement !== input) {
+				input.value = arg.symbols ? value : format(value, arg);
+			}
+			var buttons = input.parentNode.previousSibling.children;
+			for (var i = 0; i < buttons.length; i++) {
+				var on = (value >> buttons[i].dataset.bit) & 1;
+				buttons[i].textContent = on;
+				buttons[i].className = on ? "bit on" : "bit";
+			}
+		});
+
+		var outputs = $("outputs");
+		outputs.innerHTML = "";
+		block.rets.forEach(function (ret) {
+			var row = outputs.insertRow();
+			row.insertCell().textContent = ret.name;
+			row.insertCell().textContent = format(st.values[ret.name], ret);
+		});
+
+		var watches = $("watches");
+		watches.innerHTML = "";
+		st.watches.forEach(function (name) {
+			var row = watches.insertRow();
+			row.insertCell().textContent = name;
+			row.insertCell().textContent = format(st.values[name], port(name));
+			var remove = el("button", "x");
+			remove.onclick = function () {
+				delete history[name];
+				send({op: "unwatch", name: name});
+			};
+			row.insertCell().appendChild(remove);
+		});
+
+		renderWave(st);
+	}
+
+	// renderWave draws the history of every signal as an SVG waveform, with
+	// d1 signals as levels and wider ones as their value at each change
+	function renderWave(st) {
+		var cell = 24, row = 26, label = 90;
+		var names = Object.keys(history).filter(function (name) {
+			return port(name);
+		});
+		var cycles = 0;
+		names.forEach(function (name) {
+			cycles = Math.max(cycles, history[name].length);
+		});
+
+		var svg = [];
+		names.forEach(function (name, idx) {
+			var samples = history[name];
+			var p = port(name);
+			var wide = p.width > 1;
+			var top = idx * row + 4, bottom = top + row - 8;
+			var x0 = label + (cycles - samples.length) * cell;
+			svg.push("<text x='0' y='" + (bottom - 4) + "'>" + name + "</text>");
+
+			if (!wide) {
+				var points = [];
+				samples.forEach(function (v, i) {
+					var y = v ? top : bottom;
+					points.push((x0 + i * cell) + "," + y, (x0 + (i + 1) * cell) + "," + y);
+				});
+				svg.push("<polyline class='line' points='" + points.join(" ") + "'/>");
+				return;
+			}
+			samples.forEach(function (v, i) {
+				var x = x0 + i * cell;
+				var mid = (top + bottom) / 2;
+				if (i === 0 || samples[i - 1] !== v) {
+					svg.push("<polyline class='line' points='" + x + "," + mid + " " + (x + 3) + "," + top +
+						" " + (x + cell) + "," + top + "'/>");
+					svg.push("<polyline class='line' points='" + x + "," + mid + " " + (x + 3) + "," + bottom +
+						" " + (x + cell) + "," + bottom + "'/>");
+					svg.push("<text x='" + (x + 4) + "' y='" + (mid + 4) + "'>" + format(v, p).replace(/^0x/, "") + "</text>");
+				} else {
+					svg.push("<polyline class='line' points='" + x + "," + top + " " + (x + cell) + "," + top + "'/>");
+					svg.push("<polyline class='line' points='" + x + "," + bottom + " " + (x + cell) + "," + bottom + "'/>");
+				}
+			});
+		});
+
+		var width = label + cycles * cell + 10, height = names.length * row + 4;
+		$("wave").innerHTML = "<svg xmlns='http://www.w3.org/2000/svg' width='" + width + "' height='" + height + "'>" +
+			svg.join("") + "</svg>";
+		$("wave").scrollLeft = width;
+	}
+
+	function stop() {
+		if (timer) {
+			clearInterval(timer);
+			timer = null;
+		}
+		$("run").textContent = "run";
+	}
+
+	function runTest() {
+		var name = $("test").value;
+		if (!name) {
+			return;
+		}
+		fetch("/api/test?name=" + encodeURIComponent(name)).then(function (r) {
+			return r.json();
+		}).then(function (res) {
+			var summary = $("test-summary");
+			var table = $("vectors");
+			var failures = $("failures");
+			table.innerHTML = "";
+			failures.innerHTML = "";
+			if (res.error) {
+				summary.textContent = res.error;
+				summary.className = "fail";
+				return;
+			}
+			summary.textContent = res.failures.length === 0 ? "passed" :
+				res.failures.length + " of " + res.vectors.length + " vectors failed";
+			summary.className = res.failures.length === 0 ? "pass" : "fail";
+
+			var head = table.insertRow();
+			head.appendChild(el("th", "#"));
+			res.args.forEach(function (a) {
+				head.appendChild(el("th", a));
+			});
+			res.rets.forEach(function (r) {
+				head.appendChild(el("th", r));
+			});
+			res.vectors.forEach(function (v, idx) {
+				var row = table.insertRow();
+				row.insertCell().textContent = idx;
+				v.args.forEach(function (a) {
+					row.insertCell().textContent = a;
+				});
+				v.got.forEach(function (got, i) {
+					var c = row.insertCell();
+					if (v.failed[i]) {
+						c.textContent = got + " (expected " + v.expected[i] + ")";
+						c.className = "fail";
+						row.className = "failed";
+					} else {
+						c.textContent = got;
+					}
+				});
+			});
+			res.failures.forEach(function (f) {
+				failures.appendChild(el("li", f));
+			});
+		});
+	}
+
+	function connect() {
+		var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+		ws = new WebSocket(scheme + location.host + "/api/sim");
+		ws.onopen = function () {
+			$("status").textContent = "connected";
+			load();
+		};
+		ws.onclose = function () {
+			$("status").textContent = "disconnected, reload to reconnect";
+			stop();
+		};
+		ws.onmessage = function (e) {
+			update(JSON.parse(e.data));
+		};
+	}
+
+	$("block").onchange = function () {
+		selectBlock($("block").value);
+	};
+	$("radix").onchange = render;
+	$("step").onclick = function () {
+		send({op: "step", count: 1});
+	};
+	$("step10").onclick = function () {
+		send({op: "step", count: 10});
+	};
+	$("run").onclick = function () {
+		if (timer) {
+			stop();
+			return;
+		}
+		timer = setInterval(function () {
+			send({op: "step", count: 1});
+		}, 250);
+		$("run").textContent = "pause";
+	};
+	$("watch").onsubmit = function (e) {
+		e.preventDefault();
+		send({op: "watch", name: $("watch-name").value.trim()});
+		$("watch-name").value = "";
+	};
+	$("run-test").onclick = runTest;
+
+	connect();
+})();
+`
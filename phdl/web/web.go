@@ -0,0 +1,414 @@
+/*
+Package web serves a simulator for a PHDL file to the browser.
+
+The page draws the schematic of a block, with its inputs as toggles, and its
+outputs, watched internal signals, and a waveform of recent cycles updating
+live over a websocket. The tests of the file run on the server, and their
+failed vectors are highlighted. Every asset is in the binary, so the page
+works offline.
+
+The file is compiled again each time the page loads, so edits to it are
+picked up by reloading. Routes:
+
+	/                 the page, with /app.js and /style.css
+	/api/design       the blocks and tests of the file, as JSON
+	/api/schematic    the SVG schematic of ?block=
+	/api/test         the results of running ?name=, as JSON
+	/api/sim          the websocket simulating a block
+*/
+package web
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/schematic"
+	"github.com/petelliott/logiko/simulator"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+type server struct {
+	path string
+}
+
+// Handler serves the simulator of the PHDL file at path
+func Handler(path string) http.Handler {
+	s := &server{path}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", index)
+	mux.HandleFunc("/app.js", asset("application/javascript", appJS))
+	mux.HandleFunc("/style.css", asset("text/css", styleCSS))
+	mux.HandleFunc("/api/design", s.design)
+	mux.HandleFunc("/api/schematic", s.schematic)
+	mux.HandleFunc("/api/test", s.test)
+	mux.HandleFunc("/api/sim", s.sim)
+	return mux
+}
+
+func asset(contentType, content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, content)
+	}
+}
+
+// index serves the page, which is the only route not matched exactly
+func index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	asset("text/html; charset=utf-8", indexHTML)(w, r)
+}
+
+// load parses, compiles and type checks the file
+func (s *server) load() (*phdl.AstFile, error) {
+	return checks.CompilePath(s.path)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+type jsonSymbol struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+type jsonPort struct {
+	Name    string       `json:"name"`
+	Width   int          `json:"width"`
+	Symbols []jsonSymbol `json:"symbols,omitempty"`
+}
+
+type jsonBlock struct {
+	Name       string     `json:"name"`
+	Sequential bool       `json:"sequential"`
+	Args       []jsonPort `json:"args"`
+	Rets       []jsonPort `json:"rets"`
+	Conns      []string   `json:"conns"`
+	Instances  []string   `json:"instances"`
+}
+
+type jsonTest struct {
+	Name  string `json:"name"`
+	Block string `json:"block"`
+}
+
+type jsonDesign struct {
+	File   string      `json:"file"`
+	Blocks []jsonBlock `json:"blocks"`
+	Tests  []jsonTest  `json:"tests"`
+}
+
+func ports(conns []*phdl.AstConn) []jsonPort {
+	ports := make([]jsonPort, len(conns))
+	for idx, conn := range conns {
+		ports[idx] = jsonPort{Name: conn.Name, Width: conn.Width}
+		if conn.Enum != nil {
+			for _, value := range conn.Enum.Values {
+				ports[idx].Symbols = append(ports[idx].Symbols, jsonSymbol{value.Name, value.Value})
+			}
+		}
+	}
+	return ports
+}
+
+func (s *server) design(w http.ResponseWriter, r *http.Request) {
+	ast, err := s.load()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	d := jsonDesign{
+		File:   s.path,
+		Blocks: make([]jsonBlock, 0, len(ast.Blocks)),
+		Tests:  make([]jsonTest, 0, len(ast.Tests)),
+	}
+	for _, block := range ast.Blocks {
+		isPort := make(map[*phdl.AstConn]bool)
+		for _, conn := range append(block.Args, block.Rets...) {
+			isPort[conn] = true
+		}
+		conns := make([]string, 0)
+		for name, conn := range block.Vars {
+			if !isPort[conn] {
+				conns = append(conns, name)
+			}
+		}
+		sort.Strings(conns)
+
+		d.Blocks = append(d.Blocks, jsonBlock{
+			Name:       block.Name,
+			Sequential: block.Sequential(),
+			Args:       ports(block.Args),
+			Rets:       ports(block.Rets),
+			Conns:      conns,
+			Instances:  block.InstanceNames(),
+		})
+	}
+	for _, test := range ast.Tests {
+		d.Tests = append(d.Tests, jsonTest{test.Name, test.Block.Name})
+	}
+	sort.Slice(d.Blocks, func(i, j int) bool { return d.Blocks[i].Name < d.Blocks[j].Name })
+	sort.Slice(d.Tests, func(i, j int) bool { return d.Tests[i].Name < d.Tests[j].Name })
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *server) schematic(w http.ResponseWriter, r *http.Request) {
+	ast, err := s.load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	name := r.URL.Query().Get("block")
+	block, ok := ast.Blocks[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("block '%s' not defined", name), http.StatusNotFound)
+		return
+	}
+
+	var b bytes.Buffer
+	err = schematic.WriteBlock(&b, block)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(b.Bytes())
+}
+
+type jsonVector struct {
+	Args     []int64 `json:"args"`
+	Expected []int64 `json:"expected"`
+	Got      []int64 `json:"got"`
+	Failed   []bool  `json:"failed"`
+}
+
+type jsonResult struct {
+	Name     string       `json:"name"`
+	Block    string       `json:"block"`
+	Args     []string     `json:"args"`
+	Rets     []string     `json:"rets"`
+	Vectors  []jsonVector `json:"vectors"`
+	Failures []string     `json:"failures"`
+}
+
+func values(values []simulator.PortType) []int64 {
+	ints := make([]int64, len(values))
+	for idx, value := range values {
+		ints[idx] = int64(value)
+	}
+	return ints
+}
+
+func (s *server) test(w http.ResponseWriter, r *http.Request) {
+	ast, err := s.load()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	test, ok := ast.Tests[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("test '%s' not defined", name))
+		return
+	}
+
+	results, failures, err := elab.RunTest(test)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	res := jsonResult{
+		Name:     test.Name,
+		Block:    test.Block.Name,
+		Args:     make([]string, 0, len(test.Block.Args)),
+		Rets:     make([]string, 0, len(test.Block.Rets)),
+		Vectors:  make([]jsonVector, len(results)),
+		Failures: make([]string, len(failures)),
+	}
+	for _, conn := range test.Block.Args {
+		res.Args = append(res.Args, conn.Name)
+	}
+	for _, conn := range test.Block.Rets {
+		res.Rets = append(res.Rets, conn.Name)
+	}
+	for idx, result := range results {
+		vector := jsonVector{
+			Args:     make([]int64, 0),
+			Expected: values(result.Expected),
+			Got:      values(result.Got),
+			Failed:   make([]bool, len(result.Got)),
+		}
+		for _, arg := range test.Stmts[idx].Args {
+			vector.Args = append(vector.Args, arg.Literal)
+		}
+		for i := range result.Got {
+			vector.Failed[i] = result.Got[i] != result.Expected[i]
+		}
+		res.Vectors[idx] = vector
+	}
+	for idx, failure := range failures {
+		res.Failures[idx] = failure.Error()
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// command is a message from the page to a simulation
+type command struct {
+	Op    string `json:"op"` // start, write, step, watch or unwatch
+	Block string `json:"block"`
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+	Count int    `json:"count"`
+}
+
+// state is the message sent after each command
+type state struct {
+	Block   string           `json:"block"`
+	Cycle   int              `json:"cycle"`
+	Values  map[string]int64 `json:"values"`
+	Watches []string         `json:"watches"`
+	Widths  map[string]int   `json:"widths"` // of the watches
+	Error   string           `json:"error,omitempty"`
+}
+
+// session is the simulation of a websocket
+type session struct {
+	server  *server
+	sim     *elab.Simulation
+	cycle   int
+	watches []string
+}
+
+func (s *server) sim(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ss := &session{server: s, watches: make([]string, 0)}
+	for {
+		msg, err := conn.read()
+		if err != nil {
+			return
+		}
+
+		var cmd command
+		err = json.Unmarshal(msg, &cmd)
+		if err == nil {
+			err = ss.exec(cmd)
+		}
+		st := ss.state()
+		if err != nil {
+			st.Error = err.Error()
+		}
+		err = conn.writeJSON(st)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ss *session) exec(cmd command) error {
+	if cmd.Op == "start" {
+		ast, err := ss.server.load()
+		if err != nil {
+			return err
+		}
+		block, ok := ast.Blocks[cmd.Block]
+		if !ok {
+			return fmt.Errorf("block '%s' not defined", cmd.Block)
+		}
+		sim, err := elab.Simulate(block)
+		if err != nil {
+			return err
+		}
+		ss.sim = sim
+		ss.cycle = 0
+		ss.watches = make([]string, 0)
+		return nil
+	} else if ss.sim == nil {
+		return fmt.Errorf("no block is being simulated")
+	}
+
+	switch cmd.Op {
+	case "write":
+		return ss.sim.Write(cmd.Name, simulator.PortType(cmd.Value))
+	case "step":
+		if cmd.Count < 1 {
+			cmd.Count = 1
+		}
+		for i := 0; i < cmd.Count; i++ {
+			ss.sim.Step()
+		}
+		ss.cycle += cmd.Count
+	case "watch":
+		_, err := ss.sim.Read(cmd.Name)
+		if err != nil {
+			return err
+		}
+		for _, watch := range ss.watches {
+			if watch == cmd.Name {
+				return nil
+			}
+		}
+		ss.watches = append(ss.watches, cmd.Name)
+	case "unwatch":
+		watches := make([]string, 0, len(ss.watches))
+		for _, watch := range ss.watches {
+			if watch != cmd.Name {
+				watches = append(watches, watch)
+			}
+		}
+		ss.watches = watches
+	default:
+		return fmt.Errorf("unknown op '%s'", cmd.Op)
+	}
+	return nil
+}
+
+func (ss *session) state() state {
+	st := state{
+		Values:  make(map[string]int64),
+		Watches: ss.watches,
+		Widths:  make(map[string]int),
+		Cycle:   ss.cycle,
+	}
+	if ss.sim == nil {
+		return st
+	}
+
+	st.Block = ss.sim.Block.Name
+	for _, conn := range append(ss.sim.Block.Args, ss.sim.Block.Rets...) {
+		value, _ := ss.sim.Read(conn.Name)
+		st.Values[conn.Name] = int64(value)
+	}
+	for _, name := range ss.watches {
+		conn, err := ss.sim.Conn(name)
+		if err != nil {
+			continue
+		}
+		value, _ := ss.sim.Read(name)
+		st.Values[name] = int64(value)
+		st.Widths[name] = conn.Width
+	}
+	return st
+}
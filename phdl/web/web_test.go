@@ -0,0 +1,200 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const source = `
+	enum mode { hold, count }
+	reg r (d d2) -> (q d2);
+
+	block half (a d1, b d1) -> (s d1, c d1) {
+		(a, b) xor -> s;
+		(a, b) nand -> nc;
+		(nc) not -> c;
+		assert (s, c) nand;
+	}
+
+	block counter (m mode) -> (q d2) {
+		(n) r -> q;
+		(q[0], m) half -> n[0], k;
+		(q[1], k) xor -> n[1];
+	}
+
+	test ht(half) {
+		0, 0 ==> 0, 0;
+		1, 1 ==> 1, 1;
+	}
+`
+
+func serve(t *testing.T) (*httptest.Server, func()) {
+	t.Helper()
+	file, err := ioutil.TempFile("", "*.phdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(source)
+	file.Close()
+
+	server := httptest.NewServer(Handler(file.Name()))
+	return server, func() {
+		server.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func get(t *testing.T, url string, v interface{}) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestHandler(t *testing.T) {
+	server, done := serve(t)
+	defer done()
+
+	for path, status := range map[string]int{
+		"/":                           200,
+		"/app.js":                     200,
+		"/style.css":                  200,
+		"/api/schematic?block=half":   200,
+		"/api/schematic?block=nope":   404,
+		"/favicon.ico":                404,
+	} {
+		if resp := get(t, server.URL+path, nil); resp.StatusCode != status {
+			t.Errorf("%s: expected %v, got %v", path, status, resp.StatusCode)
+		}
+	}
+
+	var d jsonDesign
+	get(t, server.URL+"/api/design", &d)
+	if len(d.Blocks) != 3 || d.Blocks[0].Name != "counter" || len(d.Tests) != 1 {
+		t.Fatalf("unexpected design %+v", d)
+	}
+	counter := d.Blocks[0]
+	if !counter.Sequential || len(counter.Args[0].Symbols) != 2 || counter.Args[0].Symbols[1].Name != "count" ||
+		strings.Join(counter.Conns, " ") != "k n" || strings.Join(counter.Instances, " ") != "r0 half0 xor0" {
+		t.Errorf("unexpected block %+v", counter)
+	}
+
+	var res jsonResult
+	get(t, server.URL+"/api/test?name=ht", &res)
+	if len(res.Vectors) != 2 || res.Vectors[0].Failed[0] || !res.Vectors[1].Failed[0] || res.Vectors[1].Failed[1] {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if len(res.Failures) != 1 || res.Failures[0] != "vector 1: s expected 1, got 0" {
+		t.Errorf("unexpected failures %v", res.Failures)
+	}
+
+	var e map[string]string
+	if resp := get(t, server.URL+"/api/test?name=nope", &e); resp.StatusCode != 404 || e["error"] != "test 'nope' not defined" {
+		t.Errorf("unexpected error %v", e)
+	}
+}
+
+// dial opens a websocket, writing messages masked as a browser does
+func dial(t *testing.T, server *httptest.Server, origin string) (*wsConn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := server.Listener.Addr().String()
+	conn.Write([]byte("GET /api/sim HTTP/1.1\r\nHost: " + host + "\r\nOrigin: " + origin +
+		"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	resp, err := http.ReadResponse(rw.Reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsConn{conn, rw}, resp
+}
+
+func (c *wsConn) send(t *testing.T, cmd string) state {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opText, 0x80 | byte(len(cmd))}
+	frame = append(frame, mask...)
+	for i := range cmd {
+		frame = append(frame, cmd[i]^mask[i%4])
+	}
+	c.conn.Write(frame)
+
+	msg, err := c.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st state
+	err = json.Unmarshal(msg, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestSim(t *testing.T) {
+	server, done := serve(t)
+	defer done()
+
+	_, resp := dial(t, server, "http://evil.example")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a cross origin websocket to be refused, got %v", resp.StatusCode)
+	}
+
+	ws, resp := dial(t, server, server.URL)
+	defer ws.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake %v", resp)
+	}
+
+	if st := ws.send(t, `{"op":"step"}`); st.Error != "no block is being simulated" {
+		t.Errorf("unexpected state %+v", st)
+	}
+	if st := ws.send(t, `{"op":"start","block":"counter"}`); st.Block != "counter" || st.Values["q"] != 0 {
+		t.Errorf("unexpected state %+v", st)
+	}
+	ws.send(t, `{"op":"write","name":"m","value":1}`)
+	ws.send(t, `{"op":"watch","name":"half0.nc"}`)
+	st := ws.send(t, `{"op":"step","count":3}`)
+	if st.Cycle != 3 || st.Values["q"] != 3 || st.Values["half0.nc"] != 0 || st.Widths["half0.nc"] != 1 {
+		t.Errorf("unexpected state %+v", st)
+	}
+
+	for cmd, expected := range map[string]string{
+		`{"op":"write","name":"m","value":2}`:    "2 does not fit in 'm' (d1)",
+		`{"op":"watch","name":"y"}`:              "no conn 'y'",
+		`{"op":"watch","name":"half0.$assert0"}`: "no conn 'half0.$assert0'",
+		`{"op":"frobnicate"}`:                    "unknown op 'frobnicate'",
+		`{"op":"start","block":"nope"}`:          "block 'nope' not defined",
+	} {
+		if st := ws.send(t, cmd); st.Error != expected {
+			t.Errorf("%s: expected error '%s', got '%s'", cmd, expected, st.Error)
+		}
+	}
+
+	st = ws.send(t, `{"op":"unwatch","name":"half0.nc"}`)
+	if len(st.Watches) != 0 || st.Cycle != 3 {
+		t.Errorf("unexpected state %+v", st)
+	}
+}
@@ -0,0 +1,174 @@
+package web
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// the subset of RFC 6455 websockets used by the simulator: a server reading
+// text messages and writing them unfragmented
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// the largest message read, far more than any command needs
+const maxMessage = 1 << 16
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// upgrade switches an HTTP request to a websocket. Requests from pages of
+// other sites are refused, since the server simulates local files.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a websocket", http.StatusBadRequest)
+		return nil, errors.New("upgrade: expected a websocket")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			http.Error(w, "cross origin websocket", http.StatusForbidden)
+			return nil, fmt.Errorf("upgrade: cross origin websocket from '%s'", origin)
+		}
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websockets not supported", http.StatusInternalServerError)
+		return nil, errors.New("upgrade: websockets not supported")
+	}
+
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprint(rw, "Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(rw, "Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	err = rw.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn, rw}, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// read reads the next message, answering pings. It gives io.EOF once the
+// websocket is closed.
+func (c *wsConn) read() ([]byte, error) {
+	msg := make([]byte, 0)
+	for {
+		header := make([]byte, 2)
+		_, err := io.ReadFull(c.rw, header)
+		if err != nil {
+			return nil, err
+		}
+		fin := header[0]&0x80 != 0
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+
+		length := uint64(header[1] & 0x7f)
+		if length == 126 {
+			ext := make([]byte, 2)
+			_, err = io.ReadFull(c.rw, ext)
+			length = uint64(binary.BigEndian.Uint16(ext))
+		} else if length == 127 {
+			ext := make([]byte, 8)
+			_, err = io.ReadFull(c.rw, ext)
+			length = binary.BigEndian.Uint64(ext)
+		}
+		if err != nil {
+			return nil, err
+		} else if length+uint64(len(msg)) > maxMessage {
+			c.write(opClose, []byte{0x03, 0xf1}) // 1009, message too big
+			return nil, fmt.Errorf("read: message longer than %v", maxMessage)
+		}
+
+		mask := make([]byte, 4)
+		if masked {
+			_, err = io.ReadFull(c.rw, mask)
+			if err != nil {
+				return nil, err
+			}
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(c.rw, payload)
+		if err != nil {
+			return nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i%4]
+			}
+		}
+
+		switch opcode {
+		case opClose:
+			c.write(opClose, nil)
+			return nil, io.EOF
+		case opPing:
+			c.write(opPong, payload)
+		case opPong:
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("read: unknown opcode %v", opcode)
+		}
+	}
+}
+
+// write writes an unfragmented frame
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+	length := len(payload)
+	if length < 126 {
+		header = append(header, byte(length))
+	} else if length <= 0xffff {
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	} else {
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	c.rw.Write(header)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// writeJSON writes v as a JSON text message
+func (c *wsConn) writeJSON(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.write(opText, body)
+}
@@ -0,0 +1,584 @@
+package yosys
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonBit is a net number, or one of the constants "0", "1", "x" and "z"
+type jsonBit struct {
+	net      int
+	constant string
+}
+
+func (b *jsonBit) UnmarshalJSON(data []byte) error {
+	if len(data) != 0 && data[0] == '"' {
+		return json.Unmarshal(data, &b.constant)
+	}
+	return json.Unmarshal(data, &b.net)
+}
+
+type jsonPort struct {
+	Direction string    `json:"direction"`
+	Bits      []jsonBit `json:"bits"`
+}
+
+type jsonCell struct {
+	Type        string                 `json:"type"`
+	Parameters  map[string]interface{} `json:"parameters"`
+	Connections map[string][]jsonBit   `json:"connections"`
+}
+
+type jsonNet struct {
+	HideName   int                    `json:"hide_name"`
+	Bits       []jsonBit              `json:"bits"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type jsonModule struct {
+	Attributes map[string]interface{} `json:"attributes"`
+	Ports      json.RawMessage        `json:"ports"`
+	Cells      map[string]*jsonCell   `json:"cells"`
+	Netnames   map[string]*jsonNet    `json:"netnames"`
+	Memories   map[string]interface{} `json:"memories"`
+
+	portOrder []string
+	ports     map[string]*jsonPort
+}
+
+type jsonNetlist struct {
+	Modules map[string]*jsonModule `json:"modules"`
+}
+
+// keys gets the keys of a JSON object in order
+func keys(raw json.RawMessage) ([]string, error) {
+	keys := make([]string, 0)
+	if len(raw) == 0 {
+		return keys, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Import reads the modules of a yosys JSON netlist into a PHDL file. Modules
+// may use each other, the gate cells of yosys, and the bitwise, mux and
+// positive edge $dff cells of its coarse cell library. Ports, and the nets
+// named in netnames where possible, become conns of the same name. Inputs
+// that only clock flip-flops are dropped, since PHDL has one implicit clock.
+// Blackbox modules can't be imported.
+func Import(r io.Reader) (*phdl.AstFile, error) {
+	netlist := &jsonNetlist{}
+	err := json.NewDecoder(r).Decode(netlist)
+	if err != nil {
+		return nil, fmt.Errorf("Import: %s", err)
+	}
+
+	names := make([]string, 0, len(netlist.Modules))
+	for name, mod := range netlist.Modules {
+		names = append(names, name)
+		if mod.portOrder, err = keys(mod.Ports); err != nil {
+			return nil, fmt.Errorf("Import: module '%s': %s", name, err)
+		}
+		mod.ports = make(map[string]*jsonPort)
+		if len(mod.Ports) != 0 {
+			if err := json.Unmarshal(mod.Ports, &mod.ports); err != nil {
+				return nil, fmt.Errorf("Import: module '%s': %s", name, err)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	imp := &importer{
+		file: &phdl.AstFile{
+			Blocks:  make(map[string]*phdl.AstBlock),
+			Tests:   make(map[string]*phdl.AstTest),
+			Bundles: make(map[string]*phdl.AstBundle),
+			Enums:   make(map[string]*phdl.AstEnum),
+		},
+		mods:    netlist.Modules,
+		blocks:  make(map[string]*phdl.AstBlock),
+		ports:   make(map[string]map[string]*phdl.AstConn),
+		clocks:  make(map[string]map[string]bool),
+		regs:    make(map[[2]int64]*phdl.AstBlock),
+		pending: make(map[string]bool),
+		names:   phdl.NewNamer(),
+	}
+	for _, name := range names {
+		if _, err := imp.module(name); err != nil {
+			return nil, fmt.Errorf("Import: %s", err)
+		}
+	}
+	return imp.file, nil
+}
+
+type importer struct {
+	file    *phdl.AstFile
+	mods    map[string]*jsonModule
+	blocks  map[string]*phdl.AstBlock           // by yosys name
+	ports   map[string]map[string]*phdl.AstConn // by yosys module and port
+	clocks  map[string]map[string]bool          // clock inputs by yosys module
+	regs    map[[2]int64]*phdl.AstBlock         // by width and initial value
+	pending map[string]bool
+	names   *phdl.Namer
+}
+
+// module converts a module, after the modules it uses
+func (imp *importer) module(name string) (*phdl.AstBlock, error) {
+	if block, ok := imp.blocks[name]; ok {
+		return block, nil
+	} else if imp.pending[name] {
+		return nil, fmt.Errorf("module '%s' uses itself", name)
+	}
+	mod := imp.mods[name]
+	imp.pending[name] = true
+
+	if len(mod.Memories) != 0 {
+		return nil, fmt.Errorf("module '%s': memories are not supported", name)
+	} else if number(mod.Attributes["blackbox"]) != 0 {
+		return nil, fmt.Errorf("module '%s': blackbox modules are not supported", name)
+	}
+
+	block := &phdl.AstBlock{
+		Name:  imp.names.Name(name),
+		Args:  make([]*phdl.AstConn, 0),
+		Rets:  make([]*phdl.AstConn, 0),
+		Vars:  make(map[string]*phdl.AstConn),
+		Stmts: make([]*phdl.AstStmt, 0),
+	}
+	m := &lowering{
+		imp:   imp,
+		block: block,
+		names: phdl.NewNamer(),
+		nets:  make(map[int]*phdl.AstExpr),
+		inits: make(map[int]bool),
+	}
+
+	clocked, err := imp.clockNets(mod)
+	if err != nil {
+		return nil, fmt.Errorf("module '%s': %s", name, err)
+	}
+	clocks := make(map[string]bool)
+	ports := make(map[string]*phdl.AstConn)
+	aliases := make([][2]*phdl.AstExpr, 0)
+	for _, dir := range []string{"input", "output"} {
+		for _, pname := range mod.portOrder {
+			port := mod.ports[pname]
+			if port.Direction != "input" && port.Direction != "output" {
+				return nil, fmt.Errorf("module '%s': port '%s' has unsupported direction '%s'",
+					name, pname, port.Direction)
+			} else if port.Direction != dir {
+				continue
+			} else if dir == "input" && clocked.all(port.Bits) {
+				clocks[pname] = true
+				continue
+			}
+
+			conn := m.conn(pname, len(port.Bits))
+			ports[pname] = conn
+			if dir == "input" {
+				block.Args = append(block.Args, conn)
+			} else {
+				block.Rets = append(block.Rets, conn)
+			}
+
+			for i, bit := range port.Bits {
+				expr := connBit(conn, i)
+				if bit.constant != "" || m.nets[bit.net] != nil {
+					// outputs that are constant or the net of another port
+					// are driven by a buf
+					if dir == "output" {
+						aliases = append(aliases, [2]*phdl.AstExpr{m.bit(bit), expr})
+					}
+				} else {
+					m.nets[bit.net] = expr
+				}
+			}
+		}
+	}
+
+	netnames := make([]string, 0, len(mod.Netnames))
+	for nname := range mod.Netnames {
+		netnames = append(netnames, nname)
+	}
+	sort.Strings(netnames)
+	for _, nname := range netnames {
+		net := mod.Netnames[nname]
+		if init, ok := net.Attributes["init"].(string); ok {
+			for i, bit := range net.Bits {
+				if i < len(init) && init[len(init)-1-i] == '1' {
+					m.inits[bit.net] = true
+				}
+			}
+		}
+		if net.HideName == 0 && m.unnamed(net.Bits) {
+			conn := m.conn(nname, len(net.Bits))
+			for i, bit := range net.Bits {
+				m.nets[bit.net] = connBit(conn, i)
+			}
+		}
+	}
+
+	cellNames := make([]string, 0, len(mod.Cells))
+	for cname := range mod.Cells {
+		cellNames = append(cellNames, cname)
+	}
+	sort.Strings(cellNames)
+	for _, cname := range cellNames {
+		if err := m.cell(cname, mod.Cells[cname]); err != nil {
+			return nil, fmt.Errorf("module '%s': cell '%s': %s", name, cname, err)
+		}
+	}
+
+	for _, alias := range aliases {
+		m.gate("buf", alias[1], alias[0])
+	}
+
+	imp.blocks[name] = block
+	imp.ports[name] = ports
+	imp.clocks[name] = clocks
+	imp.file.Blocks[block.Name] = block
+	delete(imp.pending, name)
+	return block, nil
+}
+
+// lowering converts the cells of one module into PHDL statements
+type lowering struct {
+	imp   *importer
+	block *phdl.AstBlock
+	names *phdl.Namer
+	nets  map[int]*phdl.AstExpr // the d1 expr of each net
+	inits map[int]bool          // nets with an init attribute of 1
+}
+
+func (m *lowering) conn(name string, width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Name(name), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+func (m *lowering) temp(width int) *phdl.AstConn {
+	conn := &phdl.AstConn{Name: m.names.Temp(), Width: width}
+	m.block.Vars[conn.Name] = conn
+	return conn
+}
+
+// unnamed checks if bits are distinct nets without a conn
+func (m *lowering) unnamed(bits []jsonBit) bool {
+	seen := make(map[int]bool)
+	for _, bit := range bits {
+		if bit.constant != "" || m.nets[bit.net] != nil || seen[bit.net] {
+			return false
+		}
+		seen[bit.net] = true
+	}
+	return len(bits) != 0
+}
+
+func connBit(conn *phdl.AstConn, i int) *phdl.AstExpr {
+	if conn.Width == 1 {
+		return &phdl.AstExpr{Conn: conn, Lo: -1}
+	}
+	return &phdl.AstExpr{Conn: conn, Lo: i, Hi: i}
+}
+
+func literal(value int64) *phdl.AstExpr {
+	return &phdl.AstExpr{Literal: value, Lo: -1}
+}
+
+// bit gets the d1 expr of a bit. Nets without a conn get a temporary one,
+// and undefined constants are read as 0.
+func (m *lowering) bit(bit jsonBit) *phdl.AstExpr {
+	if bit.constant == "1" {
+		return literal(1)
+	} else if bit.constant != "" {
+		return literal(0)
+	}
+	if _, ok := m.nets[bit.net]; !ok {
+		m.nets[bit.net] = connBit(m.temp(1), 0)
+	}
+	return m.nets[bit.net]
+}
+
+// bitAt gets bit i of bits, extended with the sign bit if signed, or with 0
+func (m *lowering) bitAt(bits []jsonBit, i int, signed bool) *phdl.AstExpr {
+	if i < len(bits) {
+		return m.bit(bits[i])
+	} else if signed && len(bits) != 0 {
+		return m.bit(bits[len(bits)-1])
+	}
+	return literal(0)
+}
+
+func (m *lowering) gate(op string, ret *phdl.AstExpr, args ...*phdl.AstExpr) {
+	m.block.Stmts = append(m.block.Stmts, &phdl.AstStmt{
+		Args: args,
+		Op:   phdl.Builtins[op],
+		Rets: []*phdl.AstExpr{ret},
+	})
+}
+
+// input gets an expr for bits connected to an input port of width
+func (m *lowering) input(bits []jsonBit, width int) *phdl.AstExpr {
+	exprs := make([]*phdl.AstExpr, width)
+	for i := range exprs {
+		exprs[i] = m.bitAt(bits, i, false)
+	}
+	if expr := phdl.JoinBits(exprs); expr != nil {
+		return expr
+	}
+
+	tmp := m.temp(width)
+	for i, expr := range exprs {
+		m.gate("buf", connBit(tmp, i), expr)
+	}
+	return &phdl.AstExpr{Conn: tmp, Lo: -1}
+}
+
+// output gets an expr for bits connected to an output port of width
+func (m *lowering) output(bits []jsonBit, width int) *phdl.AstExpr {
+	exprs := make([]*phdl.AstExpr, 0)
+	for i := 0; i < width && i < len(bits); i++ {
+		if bits[i].constant != "" {
+			exprs = nil
+			break
+		}
+		exprs = append(exprs, m.bit(bits[i]))
+	}
+	if len(exprs) == width {
+		if expr := phdl.JoinBits(exprs); expr != nil {
+			return expr
+		}
+	}
+
+	tmp := m.temp(width)
+	for i := 0; i < width && i < len(bits); i++ {
+		if bits[i].constant == "" {
+			m.gate("buf", m.bit(bits[i]), connBit(tmp, i))
+		}
+	}
+	return &phdl.AstExpr{Conn: tmp, Lo: -1}
+}
+
+// number gets an integer parameter or attribute, which yosys writes as a
+// number or a binary string
+func number(value interface{}) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 2, 64)
+		return n
+	}
+	return 0
+}
+
+// mux drives y with b if s, otherwise a
+func (m *lowering) mux(y, a, b, s *phdl.AstExpr) {
+	ns := connBit(m.temp(1), 0)
+	ta := connBit(m.temp(1), 0)
+	tb := connBit(m.temp(1), 0)
+	m.gate("not", ns, s)
+	m.gate("and", ta, a, ns)
+	m.gate("and", tb, b, s)
+	m.gate("or", y, ta, tb)
+}
+
+var gateCells = map[string]string{
+	"$_BUF_": "buf", "$_NOT_": "not", "$_AND_": "and", "$_OR_": "or",
+	"$_XOR_": "xor", "$_NAND_": "nand", "$_NOR_": "nor", "$_XNOR_": "xnor",
+	"$pos": "buf", "$not": "not", "$and": "and", "$or": "or",
+	"$xor": "xor", "$xnor": "xnor",
+}
+
+func (m *lowering) cell(name string, cell *jsonCell) error {
+	if _, ok := m.imp.mods[cell.Type]; ok {
+		return m.instance(cell)
+	}
+
+	if dffCells[cell.Type] {
+		return m.dff(cell)
+	}
+
+	conns := cell.Connections
+	y := conns["Y"]
+	if len(y) == 0 {
+		return fmt.Errorf("no output Y")
+	}
+
+	if op, ok := gateCells[cell.Type]; ok {
+		// gate cells are one bit, coarse cells are bitwise over Y
+		signed := number(cell.Parameters["A_SIGNED"]) != 0 && number(cell.Parameters["B_SIGNED"]) != 0
+		if op == "buf" || op == "not" {
+			signed = number(cell.Parameters["A_SIGNED"]) != 0
+		}
+		for i, bit := range y {
+			if bit.constant != "" {
+				continue
+			}
+			args := []*phdl.AstExpr{m.bitAt(conns["A"], i, signed)}
+			if len(phdl.Builtins[op].Args) == 2 {
+				args = append(args, m.bitAt(conns["B"], i, signed))
+			}
+			m.gate(op, m.bit(bit), args...)
+		}
+		return nil
+	}
+
+	switch cell.Type {
+	case "$_ANDNOT_", "$_ORNOT_":
+		nb := connBit(m.temp(1), 0)
+		m.gate("not", nb, m.bitAt(conns["B"], 0, false))
+		op := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(cell.Type, "$_"), "NOT_"))
+		m.gate(op, m.bit(y[0]), m.bitAt(conns["A"], 0, false), nb)
+	case "$_MUX_", "$mux":
+		s := m.bitAt(conns["S"], 0, false)
+		for i, bit := range y {
+			if bit.constant == "" {
+				m.mux(m.bit(bit), m.bitAt(conns["A"], i, false), m.bitAt(conns["B"], i, false), s)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported cell type '%s'", cell.Type)
+	}
+	return nil
+}
+
+// clockNets are the nets that clock the flip-flops of a module
+type clockNets map[int]bool
+
+func (cn clockNets) all(bits []jsonBit) bool {
+	for _, bit := range bits {
+		if bit.constant != "" || !cn[bit.net] {
+			return false
+		}
+	}
+	return len(bits) != 0
+}
+
+// clockNets finds the nets connected to the clocks of the flip-flops of a
+// module, and the clock inputs of the modules it uses
+func (imp *importer) clockNets(mod *jsonModule) (clockNets, error) {
+	nets := make(clockNets)
+	for _, cell := range mod.Cells {
+		clocks := make([]string, 0)
+		if dffCells[cell.Type] {
+			clocks = append(clocks, "CLK", "C")
+		} else if _, ok := imp.mods[cell.Type]; ok {
+			if _, err := imp.module(cell.Type); err != nil {
+				return nil, err
+			}
+			for port := range imp.clocks[cell.Type] {
+				clocks = append(clocks, port)
+			}
+		}
+		for _, port := range clocks {
+			for _, bit := range cell.Connections[port] {
+				if bit.constant == "" {
+					nets[bit.net] = true
+				}
+			}
+		}
+	}
+	return nets, nil
+}
+
+var dffCells = map[string]bool{"$dff": true, "$_DFF_P_": true}
+
+// dff converts a positive edge flip-flop into a reg of its width and initial
+// value, which is clocked by the implicit clock
+func (m *lowering) dff(cell *jsonCell) error {
+	d, q := cell.Connections["D"], cell.Connections["Q"]
+	if len(q) == 0 {
+		return fmt.Errorf("no output Q")
+	} else if pol, ok := cell.Parameters["CLK_POLARITY"]; ok && number(pol) == 0 {
+		return fmt.Errorf("negative edge clocks are not supported")
+	}
+
+	var init int64
+	for i, bit := range q {
+		if bit.constant == "" && m.inits[bit.net] {
+			init |= 1 << uint(i)
+		}
+	}
+	key := [2]int64{int64(len(q)), init}
+	block, ok := m.imp.regs[key]
+	if !ok {
+		block = &phdl.AstBlock{
+			Name:  m.imp.names.Fresh(fmt.Sprintf("dff%v_%v", len(q), init)),
+			Args:  []*phdl.AstConn{{Name: "d", Width: len(q)}},
+			Rets:  []*phdl.AstConn{{Name: "q", Width: len(q)}},
+			Vars:  map[string]*phdl.AstConn{},
+			Stmts: make([]*phdl.AstStmt, 0),
+			Mem:   &phdl.AstMem{Kind: "reg", Init: []int64{init}},
+		}
+		block.Vars["d"] = block.Args[0]
+		block.Vars["q"] = block.Rets[0]
+		m.imp.regs[key] = block
+		m.imp.file.Blocks[block.Name] = block
+	}
+
+	stmt := &phdl.AstStmt{
+		Args: []*phdl.AstExpr{m.input(d, len(q))},
+		Op:   block,
+		Rets: make([]*phdl.AstExpr, 0),
+	}
+	m.block.Stmts = append(m.block.Stmts, stmt)
+	stmt.Rets = append(stmt.Rets, m.output(q, len(q)))
+	return nil
+}
+
+func (m *lowering) instance(cell *jsonCell) error {
+	op, err := m.imp.module(cell.Type)
+	if err != nil {
+		return err
+	}
+	ports := m.imp.ports[cell.Type]
+	clocks := m.imp.clocks[cell.Type]
+	for port := range cell.Connections {
+		if _, ok := ports[port]; !ok && !clocks[port] {
+			return fmt.Errorf("module '%s' has no port '%s'", cell.Type, port)
+		}
+	}
+
+	stmt := &phdl.AstStmt{
+		Args: make([]*phdl.AstExpr, 0),
+		Op:   op,
+		Rets: make([]*phdl.AstExpr, 0),
+	}
+	sub := m.imp.mods[cell.Type]
+	for _, name := range sub.portOrder {
+		if sub.ports[name].Direction == "input" && !clocks[name] {
+			stmt.Args = append(stmt.Args, m.input(cell.Connections[name], ports[name].Width))
+		}
+	}
+	m.block.Stmts = append(m.block.Stmts, stmt)
+	for _, name := range sub.portOrder {
+		if sub.ports[name].Direction == "output" {
+			stmt.Rets = append(stmt.Rets, m.output(cell.Connections[name], ports[name].Width))
+		}
+	}
+	return nil
+}
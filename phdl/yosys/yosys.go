@@ -0,0 +1,295 @@
+// Package yosys translates between PHDL and the JSON netlists of yosys
+package yosys
+
+import (
+	"github.com/petelliott/logiko/phdl"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// cells maps builtins to the internal gate cells of yosys
+var cells = map[string]string{
+	"buf": "$_BUF_", "not": "$_NOT_", "and": "$_AND_", "or": "$_OR_",
+	"xor": "$_XOR_", "nand": "$_NAND_", "nor": "$_NOR_", "xnor": "$_XNOR_",
+}
+
+// cellPorts are the ports of gate cells, in the order of the ports of the
+// builtins, followed by the output
+var cellPorts = []string{"A", "B"}
+
+const cellOutput = "Y"
+
+// Clock is the name of the input added to modules of sequential blocks, which
+// clocks every flip-flop
+const Clock = "clk"
+
+// attrTrue is a true attribute, which yosys writes as a 32 bit binary string
+const attrTrue = "00000000000000000000000000000001"
+
+// object is a JSON object that keeps the order of its keys, since yosys
+// orders ports by their position in the netlist
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for idx, m := range o {
+		if idx != 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Write writes every block of file as a module of a yosys JSON netlist. Table
+// blocks are synthesized to gates, regs become a $dff cell, and roms, rams and
+// extern blocks are written as blackboxes. Sequential blocks get an extra
+// input clocking their flip-flops. If top is not empty, the module of that
+// block is marked as the top module.
+func Write(w io.Writer, file *phdl.AstFile, top string) error {
+	names := make([]string, 0, len(file.Blocks))
+	for name := range file.Blocks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if _, ok := file.Blocks[top]; top != "" && !ok {
+		return fmt.Errorf("Write: block '%s' not defined", top)
+	}
+
+	modules := make(object, 0)
+	for _, name := range names {
+		block := file.Blocks[name]
+		if _, ok := block.Vars[Clock]; ok && block.Sequential() {
+			return fmt.Errorf("Write: block '%s': conn '%s' conflicts with clock input",
+				name, Clock)
+		}
+		modules = append(modules, member{name, exportBlock(block, name == top)})
+	}
+
+	out, err := json.MarshalIndent(object{
+		{"creator", "logiko"},
+		{"modules", modules},
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Write: %s", err)
+	}
+	out = append(out, '\n')
+	_, err = w.Write(out)
+	return err
+}
+
+// netBits numbers the bits of the conns of a block as yosys nets, which start
+// at 2 since 0 and 1 are taken by constants in the yosys format
+type netBits map[*phdl.AstConn]int
+
+func newNetBits(block *phdl.AstBlock, clock *phdl.AstConn) netBits {
+	names := make([]string, 0, len(block.Vars))
+	for name := range block.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// ports first, so their nets are numbered in declaration order
+	conns := make([]*phdl.AstConn, 0)
+	if clock != nil {
+		conns = append(conns, clock)
+	}
+	conns = append(conns, block.Args...)
+	conns = append(conns, block.Rets...)
+	for _, name := range names {
+		conns = append(conns, block.Vars[name])
+	}
+
+	bits := make(netBits)
+	next := 2
+	for _, conn := range conns {
+		if _, ok := bits[conn]; !ok {
+			bits[conn] = next
+			next += conn.Width
+		}
+	}
+	return bits
+}
+
+func (nb netBits) conn(conn *phdl.AstConn) []interface{} {
+	bits := make([]interface{}, conn.Width)
+	for i := range bits {
+		bits[i] = nb[conn] + i
+	}
+	return bits
+}
+
+// expr gets the bits of an expr connected to a port of width
+func (nb netBits) expr(expr *phdl.AstExpr, width int) []interface{} {
+	bits := make([]interface{}, width)
+	for i := range bits {
+		if expr.Conn == nil {
+			bits[i] = fmt.Sprint((expr.Literal >> uint(i)) & 1)
+		} else if expr.HasIndex() {
+			bits[i] = nb[expr.Conn] + expr.Lo + i
+		} else {
+			bits[i] = nb[expr.Conn] + i
+		}
+	}
+	return bits
+}
+
+func exportBlock(block *phdl.AstBlock, top bool) object {
+	attrs := make(object, 0)
+	if top {
+		attrs = append(attrs, member{"top", attrTrue})
+	}
+	blackbox := block.Extern || (block.Mem != nil && block.Mem.Kind != "reg")
+	if blackbox {
+		attrs = append(attrs, member{"blackbox", attrTrue})
+	}
+	if block.Table != nil {
+		block = phdl.SynthesizeTable(block)
+	}
+
+	var clock *phdl.AstConn
+	if block.Sequential() {
+		clock = &phdl.AstConn{Name: Clock, Width: 1}
+	}
+	bits := newNetBits(block, clock)
+
+	ports := make(object, 0)
+	if clock != nil {
+		ports = append(ports, member{Clock, object{
+			{"direction", "input"}, {"bits", bits.conn(clock)},
+		}})
+	}
+	for _, arg := range block.Args {
+		ports = append(ports, member{arg.Name, object{
+			{"direction", "input"}, {"bits", bits.conn(arg)},
+		}})
+	}
+	for _, ret := range block.Rets {
+		ports = append(ports, member{ret.Name, object{
+			{"direction", "output"}, {"bits", bits.conn(ret)},
+		}})
+	}
+
+	mod := object{{"attributes", attrs}, {"ports", ports}}
+	if blackbox {
+		return append(mod, member{"cells", object{}}, member{"netnames", object{}})
+	} else if block.Mem != nil {
+		return append(mod, exportReg(block, bits, clock)...)
+	}
+
+	cellObjs := make(object, 0)
+	insts := block.InstanceNames()
+	for sidx, stmt := range block.Stmts {
+		dirs := make(object, 0)
+		conns := make(object, 0)
+		typ := stmt.Op.Name
+
+		if stmt.Op.Builtin {
+			typ = cells[stmt.Op.Name]
+			for idx, arg := range stmt.Args {
+				dirs = append(dirs, member{cellPorts[idx], "input"})
+				conns = append(conns, member{cellPorts[idx], bits.expr(arg, 1)})
+			}
+			dirs = append(dirs, member{cellOutput, "output"})
+			conns = append(conns, member{cellOutput, bits.expr(stmt.Rets[0], 1)})
+		} else {
+			if stmt.Op.Sequential() {
+				dirs = append(dirs, member{Clock, "input"})
+				conns = append(conns, member{Clock, bits.conn(clock)})
+			}
+			for idx, arg := range stmt.Args {
+				port := stmt.Op.Args[idx]
+				dirs = append(dirs, member{port.Name, "input"})
+				conns = append(conns, member{port.Name, bits.expr(arg, port.Width)})
+			}
+			for idx, ret := range stmt.Rets {
+				port := stmt.Op.Rets[idx]
+				dirs = append(dirs, member{port.Name, "output"})
+				conns = append(conns, member{port.Name, bits.expr(ret, port.Width)})
+			}
+		}
+
+		cellObjs = append(cellObjs, member{insts[sidx], object{
+			{"hide_name", 0},
+			{"type", typ},
+			{"parameters", object{}},
+			{"attributes", object{}},
+			{"port_directions", dirs},
+			{"connections", conns},
+		}})
+	}
+
+	names := make([]string, 0, len(block.Vars))
+	for name := range block.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	netnames := make(object, 0)
+	for _, name := range names {
+		hide := 0
+		if name[0] == '_' {
+			hide = 1
+		}
+		netnames = append(netnames, member{name, object{
+			{"hide_name", hide},
+			{"bits", bits.conn(block.Vars[name])},
+			{"attributes", object{}},
+		}})
+	}
+
+	return append(mod, member{"cells", cellObjs}, member{"netnames", netnames})
+}
+
+// exportReg gets the cells and netnames of a reg, which is a $dff cell with
+// the initial value as the init attribute of q
+func exportReg(block *phdl.AstBlock, bits netBits, clock *phdl.AstConn) []member {
+	d, q := block.Args[0], block.Rets[0]
+	var init int64
+	if len(block.Mem.Init) != 0 {
+		init = block.Mem.Init[0]
+	}
+
+	cell := object{
+		{"hide_name", 1},
+		{"type", "$dff"},
+		{"parameters", object{
+			{"CLK_POLARITY", attrTrue},
+			{"WIDTH", fmt.Sprintf("%032b", q.Width)},
+		}},
+		{"attributes", object{}},
+		{"port_directions", object{{"CLK", "input"}, {"D", "input"}, {"Q", "output"}}},
+		{"connections", object{
+			{"CLK", bits.conn(clock)}, {"D", bits.conn(d)}, {"Q", bits.conn(q)},
+		}},
+	}
+	netnames := object{
+		{Clock, object{{"hide_name", 0}, {"bits", bits.conn(clock)}, {"attributes", object{}}}},
+		{d.Name, object{{"hide_name", 0}, {"bits", bits.conn(d)}, {"attributes", object{}}}},
+		{q.Name, object{{"hide_name", 0}, {"bits", bits.conn(q)}, {"attributes", object{
+			{"init", fmt.Sprintf("%0*b", q.Width, uint64(init)&(1<<uint(q.Width)-1))},
+		}}}},
+	}
+	return []member{{"cells", object{{"$dff", cell}}}, {"netnames", netnames}}
+}
@@ -0,0 +1,263 @@
+package yosys
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/petelliott/logiko/phdl/checks"
+	"github.com/petelliott/logiko/phdl/elab"
+	"github.com/petelliott/logiko/phdl/format"
+	"github.com/petelliott/logiko/simulator"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	ast := checks.MustCompile(`
+		table maj (a d1, b d1, c d1) -> (o d1) {
+			1, 1, 0b- ==> 1;
+			1, 0b-, 1 ==> 1;
+			0b-, 1, 1 ==> 1;
+			0b-, 0b-, 0b- ==> 0;
+		}
+
+		block half (a d1, b d1) -> (s d1, c d1) {
+			(a, b) xor -> s;
+			(a, b) nand -> nc;
+			(nc) not -> c;
+		}
+
+		block full (cin d1, a d1, b d1) -> (s d1, cout d1, m d1, v d3) {
+			(a, b) half -> t, c1;
+			(t, cin) half -> s, c2;
+			(c1, c2) or -> cout;
+			(cin, a, b) maj -> m;
+			(1) buf -> v[2];
+			(t) buf -> v[0];
+			(0) buf -> v[1];
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Write(&b, ast, "full")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ports keep their order
+	for _, s := range []string{`"top": "` + attrTrue + `"`, `"type": "$_NAND_"`} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected '%s' in:\n%s", s, b.String())
+		}
+	}
+	if strings.Index(b.String(), `"cin": {`) > strings.Index(b.String(), `"a": {`) {
+		t.Errorf("ports out of order:\n%s", b.String())
+	}
+
+	imported, err := Import(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := imported.Blocks["full"]
+	names := make([]string, 0)
+	for _, conn := range append(full.Args, full.Rets...) {
+		names = append(names, conn.Name)
+	}
+	if strings.Join(names, " ") != "cin a b s cout m v" {
+		t.Errorf("unexpected ports %v", names)
+	}
+
+	ref, err := elab.BlockReference(full, ast.Blocks["full"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := elab.Exhaustive(full, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
+
+func TestRoundTripSequential(t *testing.T) {
+	ast := checks.MustCompile(`
+		reg r (d d2) -> (q d2) = { 1 };
+
+		block counter (e d1) -> (q d2) {
+			(n) r -> q;
+			(q[0], e) xor -> n[0];
+			(q[0], e) and -> k;
+			(q[1], k) xor -> n[1];
+		}
+
+		block top (e d1) -> (q d2, p d1) {
+			(e) counter -> q;
+			(q[1], q[0]) and -> p;
+		}
+	`)
+
+	var b bytes.Buffer
+	err := Write(&b, ast, "top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"type": "$dff"`) || strings.Contains(b.String(), `"blackbox"`) {
+		t.Errorf("expected a $dff cell and no blackboxes in:\n%s", b.String())
+	}
+
+	imported, err := Import(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := imported.Blocks["top"]
+	if len(top.Args) != 1 || top.Args[0].Name != "e" {
+		t.Errorf("expected clock to be dropped, got args %v", top.Args)
+	}
+	orig, err := elab.Simulate(ast.Blocks["top"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := elab.Simulate(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		e := simulator.PortType(1)
+		if i%3 == 2 {
+			e = 0
+		}
+		orig.Write("e", e)
+		sim.Write("e", e)
+		for _, name := range []string{"q", "p"} {
+			expected, _ := orig.Read(name)
+			if got, _ := sim.Read(name); got != expected {
+				t.Errorf("cycle %v: expected %s=%v, got %v", i, name, expected, got)
+			}
+		}
+		orig.Step()
+		sim.Step()
+	}
+}
+
+func TestImport(t *testing.T) {
+	netlist := map[string]interface{}{
+		"modules": map[string]interface{}{
+			"top": map[string]interface{}{
+				"ports": map[string]interface{}{
+					"s": map[string]interface{}{"direction": "input", "bits": []interface{}{2}},
+					"a": map[string]interface{}{"direction": "input", "bits": []interface{}{3, 4}},
+					"b": map[string]interface{}{"direction": "input", "bits": []interface{}{5, 6}},
+					"y": map[string]interface{}{"direction": "output", "bits": []interface{}{7, 8}},
+					"z": map[string]interface{}{"direction": "output", "bits": []interface{}{2, "1", "x"}},
+				},
+				"cells": map[string]interface{}{
+					"$mux$1": map[string]interface{}{
+						"type": "$mux",
+						"connections": map[string]interface{}{
+							"A": []interface{}{3, 4}, "B": []interface{}{5, 6},
+							"S": []interface{}{2}, "Y": []interface{}{9, 8},
+						},
+					},
+					"$andnot$2": map[string]interface{}{
+						"type": "$_ANDNOT_",
+						"connections": map[string]interface{}{
+							"A": []interface{}{9}, "B": []interface{}{4}, "Y": []interface{}{7},
+						},
+					},
+				},
+				"netnames": map[string]interface{}{
+					"m": map[string]interface{}{"hide_name": 0, "bits": []interface{}{9, 10}},
+					"$auto$1": map[string]interface{}{"hide_name": 1, "bits": []interface{}{9}},
+				},
+			},
+		},
+	}
+	src, err := json.Marshal(netlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// map keys are sorted by json, so the port order is a, b, s
+	ast, err := Import(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checks.TypeCheckFile(ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top := ast.Blocks["top"]
+	if _, ok := top.Vars["m"]; !ok {
+		t.Errorf("expected conn 'm', got %v", top.Vars)
+	}
+
+	sim, err := elab.Simulate(top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v := simulator.PortType(0); v < 32; v++ {
+		a, b, s := v&3, (v>>2)&3, v>>4
+		sim.Write("a", a)
+		sim.Write("b", b)
+		sim.Write("s", s)
+
+		m := a
+		if s == 1 {
+			m = b
+		}
+		y := (m & 2) | (m & 1 &^ (a >> 1))
+		if got, _ := sim.Read("y"); got != y {
+			t.Errorf("a=%v b=%v s=%v: expected y=%v, got %v", a, b, s, y, got)
+		}
+		if got, _ := sim.Read("z"); got != s|2 {
+			t.Errorf("a=%v b=%v s=%v: expected z=%v, got %v", a, b, s, s|2, got)
+		}
+	}
+
+	// written as PHDL, it compiles to an equivalent block
+	var source bytes.Buffer
+	err = format.FprintFile(&source, ast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := checks.MustCompile(source.String()).Blocks["top"]
+	ref, err := elab.BlockReference(written, top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := elab.Exhaustive(written, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, src := range []string{
+		`{"modules": {"m": {"ports": {"a": {"direction": "inout", "bits": [2]}}}}}`,
+		`{"modules": {"m": {"cells": {"c": {"type": "$dff", "connections": {"Y": [2]}}}}}}`,
+		`{"modules": {"m": {"cells": {"c": {"type": "m", "connections": {}}}}}}`,
+		`{"modules": {"m": {"memories": {"mem": {}}}}}`,
+		`{"modules": {"m": {"attributes": {"blackbox": "00000000000000000000000000000001"}}}}`,
+		`{"modules": {"m": {"cells": {"c": {"type": "$dff", "parameters": {"CLK_POLARITY": 0},
+			"connections": {"CLK": [2], "D": [3], "Q": [4]}}}}}}`,
+		`{"modules": [`,
+	} {
+		_, err := Import(strings.NewReader(src))
+		if err == nil {
+			t.Errorf("expected error importing '%s'", src)
+		}
+	}
+}
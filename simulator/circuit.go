@@ -0,0 +1,228 @@
+package simulator
+
+import (
+	"strings"
+)
+
+// Net holds the value of a connection between components in a Circuit
+type Net struct {
+	Name    string
+	Width   int
+	value   PortType
+	readers []func()
+	subs    []func()
+}
+
+// Value gets the current value of the net
+func (n *Net) Value() PortType {
+	return n.value
+}
+
+// Slice is the bits [Lo..Hi] of a Net, all of the Net if Lo is -1, or
+// Literal if Net is nil
+type Slice struct {
+	Net     *Net
+	Lo      int
+	Hi      int
+	Literal PortType
+}
+
+func mask(width int) PortType {
+	if width >= 64 {
+		return ^PortType(0)
+	}
+	return (PortType(1) << uint(width)) - 1
+}
+
+// Read gets the value of the slice
+func (s Slice) Read() PortType {
+	if s.Net == nil {
+		return s.Literal
+	} else if s.Lo == -1 {
+		return s.Net.value
+	}
+	return (s.Net.value >> uint(s.Lo)) & mask(s.Hi-s.Lo+1)
+}
+
+// write sets the bits of the slice, and notifies the readers of the net if
+// it changed
+func (s Slice) write(value PortType) {
+	if s.Net == nil {
+		return
+	}
+
+	var newval PortType
+	if s.Lo == -1 {
+		newval = value & mask(s.Net.Width)
+	} else {
+		m := mask(s.Hi-s.Lo+1) << uint(s.Lo)
+		newval = (s.Net.value &^ m) | ((value << uint(s.Lo)) & m)
+	}
+
+	if newval == s.Net.value {
+		return
+	}
+	s.Net.value = newval
+
+	for _, reader := range s.Net.readers {
+		reader()
+	}
+	for _, sub := range s.Net.subs {
+		sub()
+	}
+}
+
+// Circuit is an AttachableComponent made of named child components whose
+// ports are connected by nets
+type Circuit struct {
+	in       []func()PortType
+	inputs   []*Net
+	outputs  []*Net
+	nets     map[string]*Net
+	children map[string]Component
+	order    []Component
+	started  bool
+}
+
+func NewCircuit() *Circuit {
+	return &Circuit{
+		in:       make([]func()PortType, 0),
+		inputs:   make([]*Net, 0),
+		outputs:  make([]*Net, 0),
+		nets:     make(map[string]*Net),
+		children: make(map[string]Component),
+		order:    make([]Component, 0),
+	}
+}
+
+// Net gets the net with name, creating it if it does not exist
+func (c *Circuit) Net(name string, width int) *Net {
+	net, ok := c.nets[name]
+	if !ok {
+		net = &Net{Name: name, Width: width}
+		c.nets[name] = net
+	}
+	return net
+}
+
+// AddInput adds an input port driving net
+func (c *Circuit) AddInput(net *Net) {
+	c.in = append(c.in, nil)
+	c.inputs = append(c.inputs, net)
+}
+
+// AddOutput adds an output port reading net
+func (c *Circuit) AddOutput(net *Net) {
+	c.outputs = append(c.outputs, net)
+}
+
+// Add instantiates comp as name, with its inputs read from ins and its outputs
+// written to outs
+func (c *Circuit) Add(name string, comp AttachableComponent, ins []Slice, outs []Slice) {
+	c.children[name] = comp
+	c.order = append(c.order, comp)
+
+	for port, slice := range ins {
+		comp.Attach(port, slice.Read)
+		if slice.Net != nil {
+			slice.Net.readers = append(slice.Net.readers, comp.Update)
+		}
+	}
+
+	for port, slice := range outs {
+		// closure over value, not variable
+		p, s := port, slice
+		comp.Subscribe(p, func() {
+			s.write(comp.Read(p))
+		})
+	}
+}
+
+// Child gets the component instantiated as name, or nil
+func (c *Circuit) Child(name string) Component {
+	return c.children[name]
+}
+
+// Lookup gets the net at a '.' seperated instance path followed by the name
+// of the net, or nil
+func (c *Circuit) Lookup(path string) *Net {
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) == 1 {
+		return c.nets[parts[0]]
+	} else if child, ok := c.children[parts[0]].(*Circuit); ok {
+		return child.Lookup(parts[1])
+	}
+	return nil
+}
+
+// Memory gets the memory at a '.' seperated instance path, or nil
+func (c *Circuit) Memory(path string) *Memory {
+	parts := strings.SplitN(path, ".", 2)
+	switch child := c.children[parts[0]].(type) {
+	case *Memory:
+		if len(parts) == 1 {
+			return child
+		}
+	case *Circuit:
+		if len(parts) == 2 {
+			return child.Memory(parts[1])
+		}
+	}
+	return nil
+}
+
+func (c *Circuit) Read(port int) PortType {
+	return c.outputs[port].value
+}
+
+func (c *Circuit) Ports() int {
+	return len(c.outputs)
+}
+
+func (c *Circuit) Subscribe(port int, fun func()) {
+	c.outputs[port].subs = append(c.outputs[port].subs, fun)
+	fun()
+}
+
+// Update reads the inputs of the circuit. The first Update also updates
+// every child, so that literal inputs take effect.
+func (c *Circuit) Update() {
+	if !c.started {
+		c.started = true
+		for _, child := range c.order {
+			child.Update()
+		}
+	}
+
+	for port, fun := range c.in {
+		if fun != nil {
+			Slice{Net: c.inputs[port], Lo: -1}.write(fun())
+		}
+	}
+}
+
+func (c *Circuit) InPorts() int {
+	return len(c.in)
+}
+
+func (c *Circuit) Attach(inport int, fun func()PortType) {
+	c.in[inport] = fun
+}
+
+// Sample samples every clocked child
+func (c *Circuit) Sample() {
+	for _, child := range c.order {
+		if clocked, ok := child.(Clocked); ok {
+			clocked.Sample()
+		}
+	}
+}
+
+// Tick ticks every clocked child
+func (c *Circuit) Tick() {
+	for _, child := range c.order {
+		if clocked, ok := child.(Clocked); ok {
+			clocked.Tick()
+		}
+	}
+}
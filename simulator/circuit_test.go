@@ -0,0 +1,80 @@
+package simulator
+
+import (
+	"testing"
+)
+
+func TestCircuit(t *testing.T) {
+	// out = (a + b) with the low and high nibbles swapped
+	c := NewCircuit()
+	a := c.Net("a", 8)
+	b := c.Net("b", 8)
+	sum := c.Net("sum", 8)
+	out := c.Net("out", 8)
+	c.AddInput(a)
+	c.AddInput(b)
+	c.AddOutput(out)
+
+	c.Add("add0", NewFuncComponent(add, 2, 1),
+		[]Slice{{Net: a, Lo: -1}, {Net: b, Lo: -1}},
+		[]Slice{{Net: sum, Lo: -1}})
+	c.Add("pass0", NewFuncComponent(passthrough, 2, 2),
+		[]Slice{{Net: sum, Lo: 0, Hi: 3}, {Net: sum, Lo: 4, Hi: 7}},
+		[]Slice{{Net: out, Lo: 4, Hi: 7}, {Net: out, Lo: 0, Hi: 3}})
+
+	sim := NewSim(c)
+	sim.Write(0, 0x10)
+	sim.Write(1, 0x02)
+	expect(t, PortType(0x21), sim.Read(0))
+
+	// overflow is truncated to the width of the net
+	sim.Write(0, 0xff)
+	expect(t, PortType(0x10), sim.Read(0))
+
+	expect(t, 1, c.Ports())
+	expect(t, 2, c.InPorts())
+	expect(t, PortType(0x01), sum.Value())
+}
+
+func TestCircuitLiteral(t *testing.T) {
+	c := NewCircuit()
+	out := c.Net("out", 4)
+	c.AddOutput(out)
+
+	c.Add("pass0", NewFuncComponent(passthrough, 1, 1),
+		[]Slice{{Literal: 5}},
+		[]Slice{{Net: out, Lo: -1}})
+
+	sim := NewSim(c)
+	expect(t, PortType(5), sim.Read(0))
+}
+
+func TestCircuitNested(t *testing.T) {
+	inner := NewCircuit()
+	ia := inner.Net("a", 4)
+	io := inner.Net("o", 4)
+	inner.AddInput(ia)
+	inner.AddOutput(io)
+	inner.Add("pass0", NewFuncComponent(passthrough, 1, 1),
+		[]Slice{{Net: ia, Lo: -1}},
+		[]Slice{{Net: io, Lo: -1}})
+
+	outer := NewCircuit()
+	oa := outer.Net("a", 4)
+	oo := outer.Net("o", 4)
+	outer.AddInput(oa)
+	outer.AddOutput(oo)
+	outer.Add("inner0", inner,
+		[]Slice{{Net: oa, Lo: -1}},
+		[]Slice{{Net: oo, Lo: -1}})
+
+	sim := NewSim(outer)
+	sim.Write(0, 9)
+	expect(t, PortType(9), sim.Read(0))
+
+	expect(t, Component(inner), outer.Child("inner0"))
+	expect(t, io, outer.Lookup("inner0.o"))
+	expect(t, oa, outer.Lookup("a"))
+	expect(t, (*Net)(nil), outer.Lookup("inner0.x"))
+	expect(t, (*Net)(nil), outer.Lookup("inner0.pass0.a"))
+}
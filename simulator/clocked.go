@@ -0,0 +1,12 @@
+package simulator
+
+// Clocked components hold state that changes on the rising edge of the clock.
+// Every Clocked component is sampled before any is ticked, so that all state
+// changes on an edge are simultaneous.
+type Clocked interface {
+	// Sample latches the current inputs
+	Sample()
+
+	// Tick updates the state and outputs from the latched inputs
+	Tick()
+}
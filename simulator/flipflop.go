@@ -0,0 +1,32 @@
+package simulator
+
+// FlipFlop is a clocked component with one input and one output of the same
+// width. On each clock tick the output is set to the input.
+type FlipFlop struct {
+	*FuncComponent
+	width int
+	d     PortType
+	q     PortType
+}
+
+// NewFlipFlop creates a flip-flop of width bits, with an initial output of init
+func NewFlipFlop(width int, init PortType) *FlipFlop {
+	r := &FlipFlop{width: width, q: init & mask(width)}
+	r.FuncComponent = NewFuncComponent(func(in []PortType, out []PortType) {
+		out[0] = r.q
+	}, 1, 1)
+	return r
+}
+
+func (r *FlipFlop) Sample() {
+	if r.in[0] == nil {
+		r.d = 0
+		return
+	}
+	r.d = r.in[0]()
+}
+
+func (r *FlipFlop) Tick() {
+	r.q = r.d & mask(r.width)
+	r.Update()
+}
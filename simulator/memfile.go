@@ -0,0 +1,81 @@
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadMemH reads memory contents in the format of verilog's $readmemh: hex
+// words seperated by whitespace, with '//' and '/* */' comments, and '@addr'
+// to set the address of the next word
+func ReadMemH(r io.Reader) ([]PortType, error) {
+	return readMem(r, 16)
+}
+
+// ReadMemB reads memory contents in the format of verilog's $readmemb, which
+// is the same as ReadMemH with binary words
+func ReadMemB(r io.Reader) ([]PortType, error) {
+	return readMem(r, 2)
+}
+
+func readMem(r io.Reader, base int) ([]PortType, error) {
+	scanner := bufio.NewScanner(r)
+
+	data := make([]PortType, 0)
+	addr := 0
+	incomment := false
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+
+		// strip comments, which may span lines
+		text := ""
+		for line != "" {
+			if incomment {
+				idx := strings.Index(line, "*/")
+				if idx == -1 {
+					break
+				}
+				incomment = false
+				line = line[idx+2:]
+			} else if idx := strings.Index(line, "/*"); idx != -1 &&
+				!strings.Contains(line[:idx], "//") {
+				text += line[:idx] + " "
+				incomment = true
+				line = line[idx+2:]
+			} else {
+				if idx := strings.Index(line, "//"); idx != -1 {
+					line = line[:idx]
+				}
+				text += line
+				line = ""
+			}
+		}
+
+		for _, word := range strings.Fields(text) {
+			if word[0] == '@' {
+				a, err := strconv.ParseUint(word[1:], 16, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: bad address '%s'", lineno, word)
+				}
+				addr = int(a)
+				continue
+			}
+
+			value, err := strconv.ParseUint(strings.Replace(word, "_", "", -1), base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %v: bad word '%s'", lineno, word)
+			}
+
+			for len(data) <= addr {
+				data = append(data, 0)
+			}
+			data[addr] = PortType(value)
+			addr++
+		}
+	}
+
+	return data, scanner.Err()
+}
@@ -0,0 +1,95 @@
+package simulator
+
+// Memory is a ROM or RAM component. A ROM has an address input, and a data
+// output that is read combinationally. A RAM has address, data and write
+// enable inputs, and a data output. Reads and writes to a RAM are
+// synchronous: on each clock tick the output is set to the word at the
+// address, then the input data is written to it if write enable is set.
+type Memory struct {
+	*FuncComponent
+	data     []PortType
+	width    int
+	writable bool
+	addr     PortType
+	din      PortType
+	we       PortType
+	dout     PortType
+}
+
+func newMemory(addrWidth, dataWidth int, init []PortType, writable bool) *Memory {
+	m := &Memory{
+		data:     make([]PortType, 1<<uint(addrWidth)),
+		width:    dataWidth,
+		writable: writable,
+	}
+	for addr, value := range init {
+		if addr < len(m.data) {
+			m.data[addr] = value & mask(dataWidth)
+		}
+	}
+	return m
+}
+
+// NewROM creates a ROM with 2^addrWidth words of dataWidth bits, initialized
+// from init
+func NewROM(addrWidth, dataWidth int, init []PortType) *Memory {
+	m := newMemory(addrWidth, dataWidth, init, false)
+	m.FuncComponent = NewFuncComponent(func(in []PortType, out []PortType) {
+		out[0] = m.data[in[0]&mask(addrWidth)]
+	}, 1, 1)
+	return m
+}
+
+// NewRAM creates a RAM with 2^addrWidth words of dataWidth bits, initialized
+// from init
+func NewRAM(addrWidth, dataWidth int, init []PortType) *Memory {
+	m := newMemory(addrWidth, dataWidth, init, true)
+	m.FuncComponent = NewFuncComponent(func(in []PortType, out []PortType) {
+		out[0] = m.dout
+	}, 3, 1)
+	return m
+}
+
+// Size gets the number of words in the memory
+func (m *Memory) Size() int {
+	return len(m.data)
+}
+
+// Peek gets the word at addr
+func (m *Memory) Peek(addr int) PortType {
+	return m.data[addr]
+}
+
+// Poke sets the word at addr. The outputs of a ROM are updated immediately.
+func (m *Memory) Poke(addr int, value PortType) {
+	m.data[addr] = value & mask(m.width)
+	m.Update()
+}
+
+func (m *Memory) Sample() {
+	if !m.writable {
+		return
+	}
+
+	read := func(port int) PortType {
+		if m.in[port] == nil {
+			return 0
+		}
+		return m.in[port]()
+	}
+	m.addr = read(0) & PortType(len(m.data)-1)
+	m.din = read(1)
+	m.we = read(2)
+}
+
+func (m *Memory) Tick() {
+	if !m.writable {
+		return
+	}
+
+	m.dout = m.data[m.addr]
+	if m.we&1 != 0 {
+		m.data[m.addr] = m.din & mask(m.width)
+	}
+	m.Update()
+}
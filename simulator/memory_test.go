@@ -0,0 +1,122 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestROM(t *testing.T) {
+	rom := NewROM(2, 4, []PortType{1, 2, 0x1f})
+	sim := NewSim(rom)
+
+	expect(t, 4, rom.Size())
+	expect(t, PortType(1), sim.Read(0))
+
+	sim.Write(0, 2)
+	expect(t, PortType(0xf), sim.Read(0))
+
+	rom.Poke(2, 7)
+	expect(t, PortType(7), sim.Read(0))
+	expect(t, PortType(7), rom.Peek(2))
+
+	// ROMs ignore the clock
+	sim.Step()
+	expect(t, PortType(7), sim.Read(0))
+}
+
+func TestFlipFlop(t *testing.T) {
+	ff := NewFlipFlop(4, 0x13)
+	sim := NewSim(ff)
+
+	expect(t, PortType(3), sim.Read(0))
+	sim.Write(0, 9)
+	expect(t, PortType(3), sim.Read(0))
+	sim.Step()
+	expect(t, PortType(9), sim.Read(0))
+	sim.Step()
+	expect(t, PortType(9), sim.Read(0))
+}
+
+func TestRAM(t *testing.T) {
+	ram := NewRAM(2, 8, []PortType{5})
+	sim := NewSim(ram)
+
+	// reads are synchronous
+	expect(t, PortType(0), sim.Read(0))
+	sim.Step()
+	expect(t, PortType(5), sim.Read(0))
+
+	sim.Write(0, 1)
+	sim.Write(1, 42)
+	sim.Write(2, 1)
+	expect(t, PortType(0), ram.Peek(1))
+
+	// read before write
+	sim.Step()
+	expect(t, PortType(0), sim.Read(0))
+	expect(t, PortType(42), ram.Peek(1))
+
+	sim.Write(2, 0)
+	sim.Step()
+	expect(t, PortType(42), sim.Read(0))
+}
+
+func TestSimMemory(t *testing.T) {
+	c := NewCircuit()
+	addr := c.Net("addr", 2)
+	data := c.Net("data", 8)
+	c.AddInput(addr)
+	c.AddOutput(data)
+	c.Add("rom0", NewROM(2, 8, nil),
+		[]Slice{{Net: addr, Lo: -1}},
+		[]Slice{{Net: data, Lo: -1}})
+
+	sim := NewSim(c)
+	err := sim.Poke("rom0", 3, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Write(0, 3)
+	expect(t, PortType(99), sim.Read(0))
+
+	v, err := sim.Peek("rom0", 3)
+	expect(t, nil, err)
+	expect(t, PortType(99), v)
+
+	if _, err := sim.Peek("rom0", 4); err == nil {
+		t.Error("expected out of range error")
+	}
+	if _, err := sim.Peek("rom1", 0); err == nil {
+		t.Error("expected missing memory error")
+	}
+}
+
+func TestReadMem(t *testing.T) {
+	data, err := ReadMemH(strings.NewReader(`
+		// comment
+		01 ff /* block
+		comment */ 1_0
+		@8 a // trailing
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, []PortType{1, 0xff, 0x10, 0, 0, 0, 0, 0, 0xa}, data)
+
+	data, err = ReadMemB(strings.NewReader("101 1111_0000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, []PortType{5, 0xf0}, data)
+
+	_, err = ReadMemB(strings.NewReader("102"))
+	if err == nil {
+		t.Error("expected bad word error")
+	}
+
+	_, err = ReadMemH(strings.NewReader("@zz"))
+	if err == nil {
+		t.Error("expected bad address error")
+	}
+}
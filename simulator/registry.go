@@ -0,0 +1,73 @@
+package simulator
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Factory creates a component with input and output ports of the given
+// widths
+type Factory func(inWidths, outWidths []int) (AttachableComponent, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a factory available to extern blocks by name. It panics if
+// name is already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("simulator: Register factory is nil")
+	} else if _, ok := registry[name]; ok {
+		panic("simulator: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Unregister removes the factory registered as name, if there is one, so that
+// it can be registered again, e.g. by tests that are run more than once
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(registry, name)
+}
+
+// Registered gets the factory registered as name
+func Registered(name string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// NewRegistered creates a component from the factory registered as name, and
+// checks that it has the expected number of ports
+func NewRegistered(name string, inWidths, outWidths []int) (AttachableComponent, error) {
+	factory, ok := Registered(name)
+	if !ok {
+		return nil, fmt.Errorf("no component registered as '%s'", name)
+	}
+
+	comp, err := factory(inWidths, outWidths)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %s", name, err)
+	} else if comp.InPorts() != len(inWidths) || comp.Ports() != len(outWidths) {
+		return nil, fmt.Errorf(
+			"'%s': expected %v inputs and %v outputs, got %v and %v",
+			name, len(inWidths), len(outWidths), comp.InPorts(), comp.Ports())
+	}
+	return comp, nil
+}
+
+// FuncFactory creates a Factory for FuncComponents wrapping fun
+func FuncFactory(fun func([]PortType, []PortType)) Factory {
+	return func(inWidths, outWidths []int) (AttachableComponent, error) {
+		return NewFuncComponent(fun, len(inWidths), len(outWidths)), nil
+	}
+}
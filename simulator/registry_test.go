@@ -0,0 +1,56 @@
+package simulator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	for _, name := range []string{"test_add", "test_fixed", "test_fail"} {
+		defer Unregister(name)
+	}
+	Register("test_add", FuncFactory(add))
+	Register("test_fixed", func(in, out []int) (AttachableComponent, error) {
+		return NewFuncComponent(add, 2, 1), nil
+	})
+	Register("test_fail", func(in, out []int) (AttachableComponent, error) {
+		return nil, errors.New("fail")
+	})
+
+	comp, err := NewRegistered("test_add", []int{8, 8}, []int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSim(comp)
+	sim.Write(0, 2)
+	sim.Write(1, 3)
+	expect(t, PortType(5), sim.Read(0))
+
+	_, err = NewRegistered("test_fixed", []int{8}, []int{8})
+	if err == nil {
+		t.Error("expected port count error")
+	}
+
+	_, err = NewRegistered("test_fail", nil, nil)
+	if err == nil {
+		t.Error("expected factory error")
+	}
+
+	_, err = NewRegistered("test_missing", nil, nil)
+	if err == nil {
+		t.Error("expected unregistered error")
+	}
+
+	Unregister("test_fail")
+	_, err = NewRegistered("test_fail", nil, nil)
+	if err == nil || err.Error() != "no component registered as 'test_fail'" {
+		t.Errorf("expected unregistered error, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate Register")
+		}
+	}()
+	Register("test_add", FuncFactory(add))
+}
@@ -1,5 +1,9 @@
 package simulator
 
+import (
+	"fmt"
+)
+
 // Sim is a Component that wraps an AttachableComponent for easy simulating
 type Sim struct {
 	comp   AttachableComponent
@@ -46,3 +50,46 @@ func (s *Sim) Subscribe(port int, fun func()) {
 func (s *Sim) Update() {
 	s.comp.Update()
 }
+
+// Step simulates one rising edge of the clock
+func (s *Sim) Step() {
+	if clocked, ok := s.comp.(Clocked); ok {
+		clocked.Sample()
+		clocked.Tick()
+	}
+}
+
+// Memory gets the memory at a '.' seperated instance path
+func (s *Sim) Memory(path string) (*Memory, error) {
+	var mem *Memory
+	if circuit, ok := s.comp.(*Circuit); ok {
+		mem = circuit.Memory(path)
+	}
+	if mem == nil {
+		return nil, fmt.Errorf("no memory '%s'", path)
+	}
+	return mem, nil
+}
+
+// Peek gets the word at addr of the memory at path
+func (s *Sim) Peek(path string, addr int) (PortType, error) {
+	mem, err := s.Memory(path)
+	if err != nil {
+		return 0, err
+	} else if addr < 0 || addr >= mem.Size() {
+		return 0, fmt.Errorf("address %v out of range for '%s'", addr, path)
+	}
+	return mem.Peek(addr), nil
+}
+
+// Poke sets the word at addr of the memory at path
+func (s *Sim) Poke(path string, addr int, value PortType) error {
+	mem, err := s.Memory(path)
+	if err != nil {
+		return err
+	} else if addr < 0 || addr >= mem.Size() {
+		return fmt.Errorf("address %v out of range for '%s'", addr, path)
+	}
+	mem.Poke(addr, value)
+	return nil
+}